@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/cbosss/slow-proxy/pkg/slowproxy/attack"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// attackMain runs `slow-proxy attack`, printing the summary once the
+// duration is over or on interrupt.
+func attackMain(args []string) int {
+	fs := flag.NewFlagSet("attack", flag.ExitOnError)
+	c := attackFlags(fs)
+	fs.Parse(args)
+	opts := c.opts
+	if opts.Target == "" && fs.NArg() > 0 {
+		opts.Target = fs.Arg(0)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	report, err := attack.Run(ctx, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "attack:", err)
+		return 2
+	}
+	if c.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(report)
+	} else {
+		report.Print(os.Stdout)
+	}
+	return 0
+}
+
+type attackConfig struct {
+	opts attack.Options
+	json bool
+}
+
+// attackFlags registers the flags of attack on fs.
+func attackFlags(fs *flag.FlagSet) *attackConfig {
+	c := &attackConfig{}
+	c.opts.Header = http.Header{}
+	fs.StringVar(&c.opts.Target, "target", "", "URL to send requests to")
+	fs.StringVar(&c.opts.Method, "method", http.MethodGet, "request method")
+	fs.Float64Var(&c.opts.Rate, "rps", 0, "requests per second, as fast as the workers allow when 0")
+	fs.DurationVar(&c.opts.Duration, "duration", 10*time.Second, "how long to attack, until interrupted when 0")
+	fs.IntVar(&c.opts.Concurrency, "concurrency", 10, "requests in flight at most")
+	fs.IntVar(&c.opts.BodySize, "body-size", 0, "bytes of request body")
+	fs.DurationVar(&c.opts.Timeout, "timeout", 30*time.Second, "per-request timeout, none when 0")
+	fs.Func("header", "request header as 'Name: value' (repeatable)", func(v string) error {
+		k, val, ok := strings.Cut(v, ":")
+		if !ok {
+			return fmt.Errorf("header %q, want 'Name: value'", v)
+		}
+		c.opts.Header.Add(strings.TrimSpace(k), strings.TrimSpace(val))
+		return nil
+	})
+	fs.BoolVar(&c.json, "json", false, "print the report as JSON")
+	return c
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/cbosss/slow-proxy/pkg/slowproxy"
+	"github.com/cbosss/slow-proxy/pkg/slowproxy/attack"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// benchReport is what `slow-proxy bench` measured, stable enough in shape to
+// diff between versions.
+type benchReport struct {
+	GoVersion string       `json:"go_version"`
+	CPUs      int          `json:"cpus"`
+	Levels    []benchLevel `json:"levels"`
+	Held      benchHeld    `json:"held"`
+}
+
+type benchLevel struct {
+	Concurrency int     `json:"concurrency"`
+	Rate        float64 `json:"rate"`
+	P50Micros   int64   `json:"p50_us"`
+	P99Micros   int64   `json:"p99_us"`
+	Errors      int     `json:"errors"`
+}
+
+type benchHeld struct {
+	Conns          int     `json:"conns"`
+	BytesPerConn   int64   `json:"bytes_per_conn"`
+	GoroutinesEach float64 `json:"goroutines_per_conn"`
+}
+
+type benchConfig struct {
+	levels   string
+	duration time.Duration
+	hold     int
+	json     bool
+}
+
+// benchFlags registers the flags of bench on fs.
+func benchFlags(fs *flag.FlagSet) *benchConfig {
+	c := &benchConfig{}
+	fs.StringVar(&c.levels, "concurrency", "1,10,100", "comma separated concurrency levels to measure")
+	fs.DurationVar(&c.duration, "duration", 3*time.Second, "how long to measure each level")
+	fs.IntVar(&c.hold, "hold", 1000, "slow connections to hold open for the memory measurement, skipped when 0")
+	fs.BoolVar(&c.json, "json", false, "print the report as JSON")
+	return c
+}
+
+// benchMain runs `slow-proxy bench`: an in-process server measured at zero
+// injected delay and with connections held open, the floor of what any
+// measurement through slow-proxy can show.
+func benchMain(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	c := benchFlags(fs)
+	fs.Parse(args)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv, err := slowproxy.New(slowproxy.WithOptions(slowproxy.Options{Addr: "127.0.0.1:0", CaptureLimit: 1}))
+	if err == nil {
+		err = srv.Start(ctx)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "bench:", err)
+		return 1
+	}
+
+	report := benchReport{GoVersion: runtime.Version(), CPUs: runtime.GOMAXPROCS(0)}
+	for _, v := range strings.Split(c.levels, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || n <= 0 {
+			fmt.Fprintf(os.Stderr, "bench: invalid concurrency %q\n", v)
+			return 2
+		}
+		r, err := attack.Run(ctx, attack.Options{Target: srv.URL() + "/status/200", Concurrency: n, Duration: c.duration, Timeout: 10 * time.Second})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "bench:", err)
+			return 1
+		}
+		errs := 0
+		for _, c := range r.Errors {
+			errs += c
+		}
+		report.Levels = append(report.Levels, benchLevel{Concurrency: n, Rate: r.Rate, P50Micros: r.Latencies["p50"], P99Micros: r.Latencies["p99"], Errors: errs + r.Requests - r.Success})
+	}
+	if c.hold > 0 {
+		held, err := measureHeld(srv, c.hold)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "bench:", err)
+			return 1
+		}
+		report.Held = held
+	}
+
+	if c.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(report)
+		return 0
+	}
+	fmt.Printf("%s, %d CPUs\n", report.GoVersion, report.CPUs)
+	fmt.Printf("%12s %12s %10s %10s %8s\n", "concurrency", "req/s", "p50", "p99", "errors")
+	for _, l := range report.Levels {
+		fmt.Printf("%12d %12.0f %10s %10s %8d\n", l.Concurrency, l.Rate, time.Duration(l.P50Micros)*time.Microsecond, time.Duration(l.P99Micros)*time.Microsecond, l.Errors)
+	}
+	if h := report.Held; h.Conns > 0 {
+		fmt.Printf("held %d slow connections: %d bytes and %.1f goroutines each\n", h.Conns, h.BytesPerConn, h.GoroutinesEach)
+	}
+	return 0
+}
+
+// measureHeld opens n connections parked in /slow and reports how much heap
+// and stack the server spends on each.
+func measureHeld(srv *slowproxy.Server, n int) (benchHeld, error) {
+	before, goroutines := memInUse()
+	conns := make([]net.Conn, 0, n)
+	defer func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+	for range n {
+		c, err := net.Dial("tcp", srv.Addr())
+		if err != nil {
+			return benchHeld{}, err
+		}
+		conns = append(conns, c)
+		if _, err := fmt.Fprintf(c, "GET /slow/1m HTTP/1.1\r\nHost: bench\r\n\r\n"); err != nil {
+			return benchHeld{}, err
+		}
+	}
+	// wait for every request to be parked in its delay
+	for deadline := time.Now().Add(10 * time.Second); srv.Stats().InFlight < int64(n); {
+		if time.Now().After(deadline) {
+			return benchHeld{}, fmt.Errorf("only %d of %d connections in flight", srv.Stats().InFlight, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	after, goroutinesAfter := memInUse()
+	return benchHeld{
+		Conns:          n,
+		BytesPerConn:   int64(after-before) / int64(n),
+		GoroutinesEach: float64(goroutinesAfter-goroutines) / float64(n),
+	}, nil
+}
+
+func memInUse() (uint64, int) {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapInuse + ms.StackInuse, runtime.NumGoroutine()
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/cbosss/slow-proxy/pkg/slowproxy"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// flagSets registers the flags of every command, the completion scripts are
+// generated from them.
+var flagSets = map[string]func(fs *flag.FlagSet){
+	"serve":      func(fs *flag.FlagSet) { serveFlags(fs) },
+	"check":      func(fs *flag.FlagSet) { serveFlags(fs) },
+	"record":     func(fs *flag.FlagSet) { serveFlags(fs) },
+	"replay":     func(fs *flag.FlagSet) { serveFlags(fs) },
+	"attack":     func(fs *flag.FlagSet) { attackFlags(fs) },
+	"bench":      func(fs *flag.FlagSet) { benchFlags(fs) },
+	"version":    func(fs *flag.FlagSet) { versionFlags(fs) },
+	"completion": func(fs *flag.FlagSet) {},
+	"init":       func(fs *flag.FlagSet) { initFlags(fs) },
+	"fleet":      func(fs *flag.FlagSet) { fleetFlags(fs) },
+	"import":     func(fs *flag.FlagSet) { importFlags(fs) },
+}
+
+// commandArgs are the words a command takes as arguments.
+var commandArgs = map[string][]string{
+	"completion": {"bash", "zsh", "fish"},
+	"import":     slices.Sorted(maps.Keys(importers)),
+}
+
+// flagValues are the values of flags that take one of a few words.
+var flagValues = map[string][]string{
+	"hiccup-mode":     {slowproxy.HiccupWorld, slowproxy.HiccupSpin, slowproxy.HiccupGC},
+	"stall-action":    {slowproxy.StallLog, slowproxy.StallAbort, slowproxy.StallThrottle},
+	"accept-overflow": {slowproxy.OverflowQueue, slowproxy.RejectRefuse},
+	"per-ip-reject":   {slowproxy.RejectRefuse, slowproxy.Reject429},
+	"vcr-mode":        {slowproxy.VCRRecord, slowproxy.VCRReplay, slowproxy.VCRAuto},
+	"compat":          {slowproxy.CompatHTTPBin},
+	"default-format":  {"json", "text", "html", "xml"},
+	"flush":           {"always", "never"},
+	"method":          {"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"},
+	"preset":          presetNames(),
+	"sigusr1":         signalActions,
+	"sigusr2":         signalActions,
+}
+
+// fileFlags take a path, dirFlags a directory.
+var (
+	fileFlags = []string{"config", "lua", "js", "wasm", "cassette", "har", "openapi", "report", "ready-file", "pid-file", "log-file", "o", "tls-cert", "tls-key"}
+	dirFlags  = []string{"fixtures", "s3"}
+)
+
+type completionFlag struct {
+	name, usage string
+	bool        bool
+}
+
+// completionFlags lists the flags of command sorted by name.
+func completionFlags(command string) []completionFlag {
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	flagSets[command](fs)
+	var flags []completionFlag
+	fs.VisitAll(func(f *flag.Flag) {
+		usage, _, _ := strings.Cut(f.Usage, ",")
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		flags = append(flags, completionFlag{name: f.Name, usage: usage, bool: ok && b.IsBoolFlag()})
+	})
+	return flags
+}
+
+func completionCommands() []string {
+	names := make([]string, 0, len(flagSets))
+	for name := range flagSets {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// completionMain runs `slow-proxy completion bash|zsh|fish`, printing a
+// script to source:
+//
+//	source <(slow-proxy completion bash)
+func completionMain(args []string) int {
+	fs := flag.NewFlagSet("completion", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: slow-proxy completion bash|zsh|fish")
+	}
+	fs.Parse(args)
+	switch fs.Arg(0) {
+	case "bash":
+		writeBashCompletion(os.Stdout, false)
+	case "zsh":
+		writeBashCompletion(os.Stdout, true)
+	case "fish":
+		writeFishCompletion(os.Stdout)
+	default:
+		fs.Usage()
+		return 2
+	}
+	return 0
+}
+
+// writeBashCompletion writes a bash completion function, zsh loads it
+// through bashcompinit.
+func writeBashCompletion(w io.Writer, zsh bool) {
+	if zsh {
+		fmt.Fprintln(w, "autoload -U +X bashcompinit && bashcompinit")
+	}
+	commands := completionCommands()
+	fmt.Fprintf(w, `_slow_proxy() {
+	local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}" cmd=serve
+	case "${COMP_WORDS[1]}" in
+	%s) cmd="${COMP_WORDS[1]}" ;;
+	esac
+	case "$prev" in
+`, strings.Join(commands, "|"))
+	for _, name := range slices.Sorted(maps.Keys(flagValues)) {
+		fmt.Fprintf(w, "\t-%s) COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n", name, strings.Join(flagValues[name], " "))
+	}
+	fmt.Fprintf(w, "\t-%s) COMPREPLY=($(compgen -f -- \"$cur\")); return ;;\n", strings.Join(fileFlags, "|-"))
+	fmt.Fprintf(w, "\t-%s) COMPREPLY=($(compgen -d -- \"$cur\")); return ;;\n", strings.Join(dirFlags, "|-"))
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, "\tlocal flags words")
+	fmt.Fprintln(w, "\tcase \"$cmd\" in")
+	for _, command := range commands {
+		var flags []string
+		for _, f := range completionFlags(command) {
+			flags = append(flags, "-"+f.name)
+		}
+		fmt.Fprintf(w, "\t%s) flags=%q words=%q ;;\n", command, strings.Join(flags, " "), strings.Join(commandArgs[command], " "))
+	}
+	fmt.Fprintf(w, `	esac
+	if [[ "$cur" == -* ]]; then
+		COMPREPLY=($(compgen -W "$flags" -- "$cur"))
+	elif [[ $COMP_CWORD -eq 1 ]]; then
+		COMPREPLY=($(compgen -W %q -- "$cur"))
+	else
+		COMPREPLY=($(compgen -W "$words" -- "$cur"))
+	fi
+}
+complete -F _slow_proxy slow-proxy
+`, strings.Join(commands, " "))
+}
+
+// writeFishCompletion writes fish completions with flag descriptions. Go
+// flags are single dash, fish's old-style options.
+func writeFishCompletion(w io.Writer) {
+	commands := completionCommands()
+	fmt.Fprintln(w, "complete -c slow-proxy -f")
+	fmt.Fprintf(w, "complete -c slow-proxy -n '__fish_use_subcommand' -a '%s'\n", strings.Join(commands, " "))
+	for _, command := range commands {
+		cond := "__fish_seen_subcommand_from " + command
+		if command == "serve" {
+			others := slices.DeleteFunc(slices.Clone(commands), func(c string) bool { return c == "serve" })
+			cond = "not __fish_seen_subcommand_from " + strings.Join(others, " ")
+		}
+		if words := commandArgs[command]; len(words) > 0 {
+			fmt.Fprintf(w, "complete -c slow-proxy -n '%s' -a '%s'\n", cond, strings.Join(words, " "))
+		}
+		for _, f := range completionFlags(command) {
+			arg := ""
+			switch {
+			case f.bool:
+			case flagValues[f.name] != nil:
+				arg = fmt.Sprintf(" -x -a '%s'", strings.Join(flagValues[f.name], " "))
+			case slices.Contains(fileFlags, f.name):
+				arg = " -r -F"
+			case slices.Contains(dirFlags, f.name):
+				arg = " -x -a '(__fish_complete_directories)'"
+			default:
+				arg = " -x"
+			}
+			fmt.Fprintf(w, "complete -c slow-proxy -n '%s' -o %s -d %s%s\n", cond, f.name, fishQuote(f.usage), arg)
+		}
+	}
+}
+
+func fishQuote(s string) string {
+	return "'" + strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), "'", `\'`) + "'"
+}
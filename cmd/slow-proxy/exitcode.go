@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"github.com/cbosss/slow-proxy/pkg/slowproxy"
+	"net"
+)
+
+// Exit codes beyond 1 for any other failure and 2 for bad flags, so
+// supervisors and CI can branch on why the server did not start.
+const (
+	exitConfig = 3 // the config file or options are invalid
+	exitBind   = 4 // a listener could not bind its address
+	exitTLS    = 5 // the TLS certificate or key is missing or unusable
+	exitAdmin  = 6 // the fleet admin API could not bind its address
+)
+
+const exitCodesHelp = `exit codes:
+  1  any other failure
+  2  invalid flags or arguments
+  3  invalid config
+  4  a listener cannot bind its address
+  5  TLS certificate or key missing or unusable
+  6  fleet admin address cannot be bound
+`
+
+// newExitCode classifies an error of New, which validates the options.
+func newExitCode(err error) int {
+	if errors.Is(err, slowproxy.ErrTLSMaterial) {
+		return exitTLS
+	}
+	return exitConfig
+}
+
+// runExitCode classifies an error of Run, failing to listen is the only
+// startup failure left once New succeeded.
+func runExitCode(err error) int {
+	var opErr *net.OpError
+	switch {
+	case errors.Is(err, slowproxy.ErrFleetAdmin):
+		return exitAdmin
+	case errors.As(err, &opErr) && opErr.Op == "listen":
+		return exitBind
+	}
+	return 1
+}
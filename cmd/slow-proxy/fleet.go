@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/cbosss/slow-proxy/pkg/slowproxy"
+	"go.uber.org/zap"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+type fleetConfig struct {
+	config string
+	admin  string
+	logFlags
+}
+
+// fleetFlags registers the flags of fleet on fs.
+func fleetFlags(fs *flag.FlagSet) *fleetConfig {
+	c := &fleetConfig{}
+	fs.StringVar(&c.config, "config", "", "fleet file listing the instances, required")
+	fs.StringVar(&c.admin, "admin", "", "address of the shared admin API, overrides admin in the fleet file")
+	c.logFlags.register(fs)
+	return c
+}
+
+// fleetMain runs `slow-proxy fleet -config fleet.yaml`: every instance of the
+// fleet file in one process, restarted when its listener fails, with the
+// shared admin API listing them all.
+func fleetMain(args []string) int {
+	fs := flag.NewFlagSet("fleet", flag.ExitOnError)
+	c := fleetFlags(fs)
+	fs.Parse(args)
+	if c.config == "" || fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: slow-proxy fleet -config fleet.yaml [-admin addr]")
+		return 2
+	}
+	out, _, err := c.output()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fleet:", err)
+		return 1
+	}
+	logger := setupLogging(c.level(), out)
+	defer logger.Sync()
+
+	cfg, err := slowproxy.LoadFleetConfig(c.config)
+	if err != nil {
+		logger.Error("failed to load fleet", zap.Error(err))
+		return exitConfig
+	}
+	if c.admin != "" {
+		cfg.Admin = c.admin
+	}
+	fleet, err := slowproxy.NewFleet(cfg, logger)
+	if err != nil {
+		logger.Error("failed to create fleet", zap.Error(err))
+		return newExitCode(err)
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	if err := fleet.Run(ctx); err != nil {
+		logger.Error("starting failed", zap.Error(err))
+		return runExitCode(err)
+	}
+	logger.Info("fleet shutdown complete")
+	return 0
+}
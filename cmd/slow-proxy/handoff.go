@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cbosss/slow-proxy/pkg/slowproxy"
+	"go.uber.org/zap"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// handoffEnv carries the descriptors a successor inherits, numbered as in
+// its ExtraFiles, from 3.
+const handoffEnv = "SLOW_PROXY_HANDOFF"
+
+type handoffFDs struct {
+	Listeners map[string]int `json:"listeners"`
+	Locks     map[string]int `json:"locks,omitempty"`
+	Ready     int            `json:"ready"`
+}
+
+// handoff is what a process started by a predecessor inherited from it.
+type handoff struct {
+	listeners map[string]net.Listener
+	locks     map[string]*os.File
+	ready     *os.File
+}
+
+// inheritedHandoff reads the descriptors of handoffEnv, nil when the process
+// was not started by a predecessor.
+func inheritedHandoff() (*handoff, error) {
+	env := os.Getenv(handoffEnv)
+	if env == "" {
+		return nil, nil
+	}
+	os.Unsetenv(handoffEnv)
+	var fds handoffFDs
+	if err := json.Unmarshal([]byte(env), &fds); err != nil {
+		return nil, fmt.Errorf("%s: %w", handoffEnv, err)
+	}
+	h := &handoff{listeners: map[string]net.Listener{}, locks: map[string]*os.File{}}
+	for key, fd := range fds.Listeners {
+		f := os.NewFile(uintptr(fd), key)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inherited listener %s: %w", key, err)
+		}
+		h.listeners[key] = ln
+	}
+	for path, fd := range fds.Locks {
+		h.locks[path] = os.NewFile(uintptr(fd), path)
+	}
+	h.ready = os.NewFile(uintptr(fds.Ready), "handoff-ready")
+	return h, nil
+}
+
+// lock returns the inherited descriptor of the lock at path, if any.
+func (h *handoff) lock(path string) *os.File {
+	if h == nil {
+		return nil
+	}
+	f := h.locks[path]
+	delete(h.locks, path)
+	return f
+}
+
+// done tells the predecessor the listeners are served, it starts draining.
+func (h *handoff) done() {
+	fmt.Fprintln(h.ready, "ready")
+	h.ready.Close()
+}
+
+// handOffOnHangup re-executes the binary on SIGHUP, with the same arguments
+// so a new binary or config takes over, passing it the listening sockets
+// and the locks. Once the successor serves them, srv stops accepting and
+// drains: the slow connections it holds finish on the old process while new
+// ones reach the new one.
+func handOffOnHangup(ctx context.Context, srv *slowproxy.Server, logger *zap.Logger, locks []*pidFile, drain time.Duration) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	defer signal.Stop(ch)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ch:
+		}
+		pid, err := handOff(srv, locks)
+		if err != nil {
+			logger.Error("handoff failed, still serving", zap.Error(err))
+			continue
+		}
+		logger.Info("handed off, draining", zap.Int("successor", pid), zap.Duration("timeout", drain))
+		srv.Drain(drain)
+		return
+	}
+}
+
+// handOff starts the successor and waits until it serves.
+func handOff(srv *slowproxy.Server, locks []*pidFile) (int, error) {
+	files, err := srv.ListenerFiles()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	fds := handoffFDs{Listeners: map[string]int{}, Locks: map[string]int{}}
+	var extra []*os.File
+	add := func(f *os.File) int {
+		extra = append(extra, f)
+		return 2 + len(extra)
+	}
+	for key, f := range files {
+		fds.Listeners[key] = add(f)
+	}
+	for _, p := range locks {
+		fds.Locks[p.path] = add(p.f)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	fds.Ready = add(w)
+	env, err := json.Marshal(fds)
+	if err != nil {
+		w.Close()
+		return 0, err
+	}
+
+	exe, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		exe, _ = os.Executable()
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), handoffEnv+"="+string(env))
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = extra
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return 0, err
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	ready := make(chan bool, 1)
+	go func() {
+		line, _ := bufio.NewReader(r).ReadString('\n')
+		ready <- line == "ready\n"
+	}()
+	select {
+	case ok := <-ready:
+		if ok {
+			for _, p := range locks {
+				p.handedOff = true
+			}
+			return cmd.Process.Pid, nil
+		}
+		return 0, fmt.Errorf("successor %d failed: %v", cmd.Process.Pid, <-exited)
+	case <-time.After(30 * time.Second):
+		cmd.Process.Kill()
+		return 0, errors.New("successor not ready after 30s")
+	}
+}
@@ -0,0 +1,111 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/cbosss/slow-proxy/pkg/slowproxy"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// successorEnv makes the test binary, re-executed by a handoff, fail instead
+// of taking over.
+const successorEnv = "SLOW_PROXY_TEST_SUCCESSOR"
+
+// TestMain plays the successor when a handoff re-executes the test binary:
+// it adopts the inherited pid file and reports ready.
+func TestMain(m *testing.M) {
+	if os.Getenv(handoffEnv) == "" {
+		os.Exit(m.Run())
+	}
+	if os.Getenv(successorEnv) == "fail" {
+		os.Exit(1)
+	}
+	h, err := inheritedHandoff()
+	if err == nil && len(h.listeners) != 1 {
+		err = fmt.Errorf("inherited %d listeners, want 1", len(h.listeners))
+	}
+	for path, f := range h.locks {
+		if _, err = adoptPIDFile(path, f); err != nil {
+			break
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	h.done()
+	os.Exit(0)
+}
+
+func startServer(t *testing.T) *slowproxy.Server {
+	t.Helper()
+	srv, err := slowproxy.New(slowproxy.WithAddr("127.0.0.1:0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+func TestHandOff(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "slow-proxy.pid")
+	p, err := acquirePIDFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := handOff(startServer(t), []*pidFile{p})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(path); strings.TrimSpace(string(b)) != strconv.Itoa(pid) {
+		t.Errorf("pid file holds %q, want the successor %d", b, pid)
+	}
+	if !p.handedOff {
+		t.Error("pid file not marked as handed off")
+	}
+	p.release()
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("releasing a handed off pid file removed it: %v", err)
+	}
+}
+
+func TestHandOffFailed(t *testing.T) {
+	t.Setenv(successorEnv, "fail")
+	path := filepath.Join(t.TempDir(), "slow-proxy.pid")
+	p, err := acquirePIDFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.release()
+	if _, err := handOff(startServer(t), []*pidFile{p}); err == nil || !strings.Contains(err.Error(), "failed") {
+		t.Errorf("handOff() = %v, want the successor to have failed", err)
+	}
+	if p.handedOff {
+		t.Error("pid file marked as handed off")
+	}
+	if b, _ := os.ReadFile(path); strings.TrimSpace(string(b)) != strconv.Itoa(os.Getpid()) {
+		t.Errorf("pid file holds %q, want this process", b)
+	}
+}
+
+func TestInheritedHandoff(t *testing.T) {
+	if h, err := inheritedHandoff(); h != nil || err != nil {
+		t.Errorf("without %s = %v, %v, want nothing", handoffEnv, h, err)
+	}
+	t.Setenv(handoffEnv, "{")
+	if _, err := inheritedHandoff(); err == nil {
+		t.Error("invalid descriptors accepted")
+	}
+	if os.Getenv(handoffEnv) != "" {
+		t.Errorf("%s left for children to inherit", handoffEnv)
+	}
+}
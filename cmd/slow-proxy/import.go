@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/cbosss/slow-proxy/pkg/slowproxy"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// importers convert the fault configs and stubs of other tools at a path
+// into a slow-proxy config, with warnings for what has no equivalent.
+var importers = map[string]func(path string) (*slowproxy.Config, []string, error){
+	"mesh":     importFile(slowproxy.ImportMesh),
+	"wiremock": importWireMock,
+}
+
+// importFile adapts an importer reading one file.
+func importFile(importer func(io.Reader) (*slowproxy.Config, []string, error)) func(string) (*slowproxy.Config, []string, error) {
+	return func(path string) (*slowproxy.Config, []string, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer f.Close()
+		return importer(f)
+	}
+}
+
+// importWireMock imports a mapping file, a mappings directory or the
+// WireMock root holding mappings and __files.
+func importWireMock(path string) (*slowproxy.Config, []string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	root := path
+	if info, err := os.Stat(filepath.Join(path, "mappings")); err == nil && info.IsDir() {
+		path = filepath.Join(path, "mappings")
+	} else {
+		// the root is above the mappings directory holding path
+		for dir := path; dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+			if filepath.Base(dir) == "mappings" {
+				root = filepath.Dir(dir)
+				break
+			}
+		}
+		if info, err := os.Stat(path); root == path && (err != nil || !info.IsDir()) {
+			root = filepath.Dir(path)
+		}
+	}
+	name, err := filepath.Rel(root, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return slowproxy.ImportWireMock(os.DirFS(root), filepath.ToSlash(name))
+}
+
+// importMain runs `slow-proxy import mesh vs.yaml...` or `slow-proxy import
+// wiremock mappings/`, printing the config
+// to stdout, or to -o, so it can be checked or served.
+func importMain(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	out := importFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: slow-proxy import [-o file] mesh|wiremock <path>...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	importer, ok := importers[fs.Arg(0)]
+	if !ok || fs.NArg() < 2 {
+		fs.Usage()
+		return 2
+	}
+
+	var cfg slowproxy.Config
+	for _, path := range fs.Args()[1:] {
+		imported, warnings, err := importer(path)
+		for _, w := range warnings {
+			fmt.Fprintf(os.Stderr, "import: %s: %s\n", path, w)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "import: %s: %v\n", path, err)
+			return exitConfig
+		}
+		cfg.Rules = append(cfg.Rules, imported.Rules...)
+	}
+	if len(cfg.Rules) == 0 {
+		fmt.Fprintln(os.Stderr, "import: no faults or stubs found")
+		return 1
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# imported by slow-proxy import from %s\n", strings.Join(fs.Args()[1:], ", "))
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, "import:", err)
+		return 1
+	}
+	if *out == "" {
+		os.Stdout.Write(buf.Bytes())
+		return 0
+	}
+	if err := os.WriteFile(*out, buf.Bytes(), 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, "import:", err)
+		return 1
+	}
+	return 0
+}
+
+// importFlags registers the flags of import on fs.
+func importFlags(fs *flag.FlagSet) (out *string) {
+	return fs.String("o", "", "write the config to this file instead of stdout")
+}
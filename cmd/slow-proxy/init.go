@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/cbosss/slow-proxy/pkg/slowproxy"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+// presets are the configs init writes, example.yaml documents every field.
+//
+//go:embed presets/*.yaml
+var presets embed.FS
+
+func presetNames() []string {
+	entries, _ := presets.ReadDir("presets")
+	var names []string
+	for _, e := range entries {
+		names = append(names, strings.TrimSuffix(e.Name(), path.Ext(e.Name())))
+	}
+	slices.Sort(names)
+	return names
+}
+
+// initMain runs `slow-proxy init [-preset name] [file]`, writing a config to
+// start from, slow-proxy.yaml by default or stdout for -.
+func initMain(args []string) int {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	preset, force := initFlags(fs)
+	fs.Parse(args)
+	file := "slow-proxy.yaml"
+	if fs.NArg() > 0 {
+		file = fs.Arg(0)
+	}
+
+	data, err := presets.ReadFile("presets/" + *preset + ".yaml")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "init: unknown preset %q, want one of %s\n", *preset, strings.Join(presetNames(), ", "))
+		return 2
+	}
+	if _, err := slowproxy.ParseConfig(bytes.NewReader(data)); err != nil {
+		fmt.Fprintf(os.Stderr, "init: preset %s: %v\n", *preset, err)
+		return 1
+	}
+	if file == "-" {
+		os.Stdout.Write(data)
+		return 0
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if *force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(file, flags, 0o644)
+	if errors.Is(err, os.ErrExist) {
+		fmt.Fprintf(os.Stderr, "init: %s exists, use -force to overwrite it\n", file)
+		return 1
+	}
+	if err == nil {
+		_, err = f.Write(data)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "init:", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "wrote %s, start with: slow-proxy -config %s\n", file, file)
+	return 0
+}
+
+// initFlags registers the flags of init on fs.
+func initFlags(fs *flag.FlagSet) (preset *string, force *bool) {
+	preset = fs.String("preset", "example", "config to write: "+strings.Join(presetNames(), ", "))
+	force = fs.Bool("force", false, "overwrite an existing file")
+	return preset, force
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// lockFile is a no-op where flock is missing, the pid file is still written
+// but a second instance is not detected.
+func lockFile(f *os.File) error {
+	return nil
+}
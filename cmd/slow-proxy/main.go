@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/cbosss/slow-proxy/pkg/slowproxy"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"os"
+	_ "time/tzdata" // timezones of latency profiles where the system has no zoneinfo
+)
+
+// commands are the subcommands of slow-proxy. Anything else, like
+// `slow-proxy localhost:8080` or `slow-proxy -config x.yaml`, runs serve.
+var commands = map[string]func(args []string) int{
+	"serve":      func(args []string) int { return serveMain("serve", args) },
+	"check":      checkMain,
+	"attack":     attackMain,
+	"bench":      benchMain,
+	"record":     func(args []string) int { return serveMain("record", args) },
+	"replay":     func(args []string) int { return serveMain("replay", args) },
+	"version":    versionMain,
+	"completion": completionMain,
+	"init":       initMain,
+	"fleet":      fleetMain,
+	"import":     importMain,
+}
+
+const commandsHelp = `commands:
+  serve       run the server, the default
+  check       validate flags and -config without listening
+  attack      generate load against a target
+  bench       measure slow-proxy's own overhead
+  record      serve, recording -upstream answers to -cassette
+  replay      serve the answers recorded in -cassette
+  version     print the build version
+  completion  print a bash, zsh or fish completion script
+  init        write an example config, or a preset scenario
+  fleet       run several servers from a fleet file in one process
+  import      convert Envoy or Istio fault injection into a config
+
+Run slow-proxy <command> -h for the flags of a command.
+`
+
+func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			os.Exit(cmd(os.Args[2:]))
+		}
+	}
+	os.Exit(serveMain("serve", os.Args[1:]))
+}
+
+// announceReady prints a JSON line with the bound addresses on stdout, logs
+// going to stderr, and optionally to a file or inherited descriptor so test
+// harnesses don't have to race startup or parse log text.
+func announceReady(logger *zap.Logger, info slowproxy.ReadyInfo, file string, fd int) {
+	line, err := json.Marshal(info)
+	if err != nil {
+		logger.Error("failed to encode ready line", zap.Error(err))
+		return
+	}
+	fmt.Println(string(line))
+
+	if file != "" {
+		if err := slowproxy.WriteReadyFile(file, info); err != nil {
+			logger.Error("failed to write ready file", zap.String("path", file), zap.Error(err))
+		}
+	}
+	if fd >= 0 {
+		f := os.NewFile(uintptr(fd), "ready-fd")
+		if _, err := f.Write(append(line, '\n')); err != nil {
+			logger.Error("failed to write ready fd", zap.Int("fd", fd), zap.Error(err))
+		}
+		f.Close()
+	}
+}
+
+func setupLogging(level zapcore.Level, out zapcore.WriteSyncer) *zap.Logger {
+	encoder := zap.NewProductionEncoderConfig()
+	encoder.EncodeLevel = encodeLevel(zapcore.LowercaseLevelEncoder)
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(encoder), out, level)
+	return zap.New(core, zap.AddCaller(), zap.ErrorOutput(zapcore.Lock(os.Stderr)))
+}
+
+func encodeLevel(next zapcore.LevelEncoder) zapcore.LevelEncoder {
+	return func(l zapcore.Level, enc zapcore.PrimitiveArrayEncoder) {
+		if l == slowproxy.TraceLevel {
+			enc.AppendString("trace")
+			return
+		}
+		next(l, enc)
+	}
+}
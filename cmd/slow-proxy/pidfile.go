@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var errRunning = errors.New("already running")
+
+// pidFile holds the pid of the running instance and stays locked for as long
+// as it runs, so a file left behind by a process that died is told apart
+// from a live one by the lock, not by guessing from the pid.
+type pidFile struct {
+	path      string
+	f         *os.File
+	stale     string
+	handedOff bool
+}
+
+// acquirePIDFile locks path and writes the pid into it. It fails with
+// errRunning while another process holds the lock, a stale file is taken
+// over and its pid kept in stale.
+func acquirePIDFile(path string) (*pidFile, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	old, _ := io.ReadAll(f)
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w as pid %s", path, errRunning, strings.TrimSpace(string(old)))
+	}
+	if err := writePID(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &pidFile{path: path, f: f, stale: strings.TrimSpace(string(old))}, nil
+}
+
+// adoptPIDFile takes over a pid file a predecessor handed off, still locked
+// through the inherited descriptor.
+func adoptPIDFile(path string, f *os.File) (*pidFile, error) {
+	if err := writePID(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &pidFile{path: path, f: f}, nil
+}
+
+func writePID(f *os.File) error {
+	err := f.Truncate(0)
+	if err == nil {
+		_, err = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return err
+}
+
+// release removes the file, before unlocking it so no successor's file is
+// removed. A file handed off stays, the successor holds the lock.
+func (p *pidFile) release() {
+	if !p.handedOff {
+		os.Remove(p.path)
+	}
+	p.f.Close()
+}
+
+// instanceLock is the lock file of -single-instance, one per config file or,
+// without one, per listen address.
+func instanceLock(configPath, addr string) string {
+	key := "addr:" + addr
+	if configPath != "" {
+		if abs, err := filepath.Abs(configPath); err == nil {
+			configPath = abs
+		}
+		key = "config:" + configPath
+	}
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(os.TempDir(), "slow-proxy-"+hex.EncodeToString(sum[:8])+".lock")
+}
@@ -0,0 +1,81 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPIDFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "slow-proxy.pid")
+	p, err := acquirePIDFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(path); string(b) != strconv.Itoa(os.Getpid())+"\n" {
+		t.Errorf("pid file holds %q", b)
+	}
+	if p.stale != "" {
+		t.Errorf("stale = %q for a new file", p.stale)
+	}
+
+	_, err = acquirePIDFile(path)
+	if !errors.Is(err, errRunning) || !strings.Contains(err.Error(), strconv.Itoa(os.Getpid())) {
+		t.Errorf("second acquirePIDFile() = %v, want errRunning naming the pid", err)
+	}
+
+	p.release()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("pid file left after release: %v", err)
+	}
+}
+
+func TestPIDFileStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "slow-proxy.pid")
+	if err := os.WriteFile(path, []byte("4242\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := acquirePIDFile(path)
+	if err != nil {
+		t.Fatalf("a file nobody locks is taken over, got %v", err)
+	}
+	defer p.release()
+	if p.stale != "4242" {
+		t.Errorf("stale = %q, want 4242", p.stale)
+	}
+	if b, _ := os.ReadFile(path); string(b) != strconv.Itoa(os.Getpid())+"\n" {
+		t.Errorf("pid file holds %q", b)
+	}
+}
+
+func TestInstanceLock(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	tests := []struct {
+		name       string
+		a, b       [2]string // config path and address
+		wantShared bool
+	}{
+		{name: "same address", a: [2]string{"", ":8080"}, b: [2]string{"", ":8080"}, wantShared: true},
+		{name: "other address", a: [2]string{"", ":8080"}, b: [2]string{"", ":8081"}},
+		{name: "same config", a: [2]string{"slow.yaml", ":8080"}, b: [2]string{filepath.Join(dir, "slow.yaml"), ":8081"}, wantShared: true},
+		{name: "other config", a: [2]string{"a.yaml", ":8080"}, b: [2]string{"b.yaml", ":8080"}},
+		{name: "config or address", a: [2]string{"a.yaml", ":8080"}, b: [2]string{"", ":8080"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := instanceLock(tt.a[0], tt.a[1]), instanceLock(tt.b[0], tt.b[1])
+			if (a == b) != tt.wantShared {
+				t.Errorf("instanceLock() = %s and %s, want shared %v", a, b, tt.wantShared)
+			}
+			if filepath.Dir(a) != filepath.Clean(os.TempDir()) {
+				t.Errorf("lock %s is not in the temporary directory", a)
+			}
+		})
+	}
+}
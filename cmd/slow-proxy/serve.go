@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/cbosss/slow-proxy/pkg/slowproxy"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"golang.org/x/term"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"syscall"
+	"time"
+)
+
+// serveConfig holds the flags of serve and of the commands built on it.
+type serveConfig struct {
+	opts            slowproxy.Options
+	configPath      string
+	readyFile       string
+	readyFD         int
+	authFailPercent float64
+	authFailStatus  int
+	tui             bool
+	pidFile         string
+	singleInstance  bool
+	sigusr1         string
+	sigusr2         string
+	signalLatency   time.Duration
+	drainTimeout    time.Duration
+	gcPercent       int
+	logFlags
+}
+
+// serveFlags registers the server flags on fs.
+func serveFlags(fs *flag.FlagSet) *serveConfig {
+	c := &serveConfig{}
+	c.logFlags.register(fs)
+	fs.StringVar(&c.pidFile, "pid-file", "", "write the pid to this file, locked while running; refuse to start while another instance holds it")
+	fs.BoolVar(&c.singleInstance, "single-instance", false, "refuse to start while another instance serves the same -config (or address without one)")
+	fs.StringVar(&c.sigusr1, "sigusr1", signalLatency, "action on SIGUSR1: latency (toggle -signal-latency on every route), stats (log them), rotate-log (reopen -log-file) or none")
+	fs.StringVar(&c.sigusr2, "sigusr2", signalStats, "action on SIGUSR2, like -sigusr1")
+	fs.DurationVar(&c.signalLatency, "signal-latency", 2*time.Second, "latency the latency signal action toggles")
+	fs.DurationVar(&c.drainTimeout, "drain-timeout", 10*time.Minute, "how long the connections in flight may take to finish on the old process after a SIGHUP handoff")
+	fs.BoolVar(&c.tui, "tui", false, "show a live dashboard with toggles for common faults instead of logs")
+	fs.StringVar(&c.configPath, "config", "", "YAML or JSON config file with fault rules, groups and latency profiles")
+	fs.StringVar(&c.opts.LuaScript, "lua", "", "Lua script whose on_request(req) decides about every request")
+	fs.StringVar(&c.opts.JSScript, "js", "", "JavaScript scenario run for every request, answering with respond({...})")
+	fs.Func("wasm", "WebAssembly fault plugin to load, repeatable", func(v string) error {
+		c.opts.WASMPlugins = append(c.opts.WASMPlugins, v)
+		return nil
+	})
+	fs.StringVar(&c.opts.FixturesDir, "fixtures", "", "directory of fixture files served from memory under /fixture/{name}")
+	fs.StringVar(&c.opts.Upstream, "upstream", "", "URL that requests matching no route are forwarded to")
+	fs.StringVar(&c.opts.Cassette, "cassette", "", "file recording upstream answers, for replay when the upstream is gone")
+	fs.StringVar(&c.opts.VCRMode, "vcr-mode", "", "with -cassette: record, replay, or auto (record, replay on upstream errors)")
+	fs.StringVar(&c.opts.OpenAPI, "openapi", "", "serve the operations of an OpenAPI 3 spec with example responses")
+	fs.StringVar(&c.opts.S3, "s3", "", "serve the S3 object API from a directory of buckets, or memory to start empty")
+	fs.StringVar(&c.opts.HAR, "har", "", "replay the answers of a HAR file, matched by method and URL")
+	fs.Float64Var(&c.opts.VCRTiming, "vcr-timing", 1, "scale of the recorded upstream time on replay, 0 answers at once")
+	fs.Int64Var(&c.opts.Seed, "seed", 0, "seed of every random decision, reported in the logs and /admin/stats; random when 0")
+	fs.BoolVar(&c.opts.LogConns, "log-conns", false, "log every connection state change: new, active, idle, hijacked, closed")
+	fs.StringVar(&c.opts.ReportPath, "report", "", "write a per-phase soak report to this file at shutdown, HTML when it ends in .html, JSON otherwise")
+	fs.IntVar(&c.opts.ReportPhases, "report-phases", 10000, "phases the soak report keeps, the oldest are evicted beyond it")
+	fs.IntVar(&c.opts.CaptureLimit, "capture-limit", 1000, "requests kept for /admin/captures, none when negative")
+	fs.DurationVar(&c.opts.CaptureTTL, "capture-ttl", 0, "forget captured requests older than this, kept until evicted by the limits when 0")
+	fs.IntVar(&c.opts.CaptureBytes, "capture-bytes", 0, "approximate memory captured requests may hold, unlimited when 0")
+	fs.StringVar(&c.readyFile, "ready-file", "", "write the ready line to this file once listening")
+	fs.IntVar(&c.readyFD, "ready-fd", -1, "write the ready line to this inherited file descriptor once listening")
+	fs.DurationVar(&c.opts.DefaultDelay, "default-delay", 10*time.Second, "delay used by /slow when none is requested")
+	fs.DurationVar(&c.opts.MaxDelay, "max-delay", time.Hour, "longest delay a request may ask for")
+	fs.DurationVar(&c.opts.StallTimeout, "stall-timeout", 0, "how long a response write may block on a client that does not read, unwatched when 0")
+	fs.StringVar(&c.opts.StallAction, "stall-action", slowproxy.StallLog, "reaction to a stalled client: log, abort (drop the connection) or throttle (slow further writes)")
+	fs.DurationVar(&c.opts.HiccupEvery, "hiccup-every", 0, "mean interval between process-wide hiccups, none when 0")
+	fs.DurationVar(&c.opts.HiccupLength, "hiccup-length", 100*time.Millisecond, "length of each hiccup")
+	fs.StringVar(&c.opts.HiccupMode, "hiccup-mode", slowproxy.HiccupWorld, "hiccup kind: world (hold every request), spin (saturate the CPUs) or gc (collector pressure)")
+	fs.IntVar(&c.gcPercent, "gc-percent", 0, "GOGC percentage to run with, negative disables the collector, 0 keeps the default")
+	fs.Func("flush", "which handler flushes reach the client: always, never, every N bytes (16384) or at most once per interval (500ms)", func(v string) (err error) {
+		c.opts.Flush, err = slowproxy.ParseFlushPolicy(v)
+		return err
+	})
+	fs.Func("default-format", "body format when the Accept header has no preference: json, text, html or xml", func(v string) (err error) {
+		c.opts.DefaultFormat, err = slowproxy.ParseFormat(v)
+		return err
+	})
+	fs.StringVar(&c.opts.GRPCAddr, "grpc-addr", "", "address for the gRPC service, disabled when empty")
+	fs.IntVar(&c.opts.MaxInFlight, "max-in-flight", 0, "concurrent requests served before queueing or answering 503, unlimited when 0")
+	fs.IntVar(&c.opts.MaxQueue, "max-queue", 0, "requests allowed to wait for an in-flight slot")
+	fs.DurationVar(&c.opts.QueueTimeout, "queue-timeout", 0, "longest a queued request waits before a 503, unlimited when 0")
+	fs.Float64Var(&c.opts.AcceptRate, "accept-rate", 0, "connections accepted per second, unlimited when 0")
+	fs.IntVar(&c.opts.MaxConns, "max-conns", 0, "connections served at once, each one a goroutine, unlimited when 0")
+	fs.StringVar(&c.opts.AcceptOverflow, "accept-overflow", slowproxy.OverflowQueue, "what happens over -accept-rate or -max-conns: queue (leave in the listen backlog) or refuse (reset)")
+	fs.IntVar(&c.opts.PerIPConns, "per-ip-conns", 0, "open connections allowed per client IP, unlimited when 0")
+	fs.IntVar(&c.opts.PerIPRequests, "per-ip-requests", 0, "concurrent requests allowed per client IP, unlimited when 0")
+	fs.StringVar(&c.opts.PerIPReject, "per-ip-reject", slowproxy.RejectRefuse, "what happens over a per-ip limit: refuse (reset the connection) or 429")
+	fs.Func("client-bandwidth", "combined response rate per client, e.g. 1mbps or 64KB/s, unlimited when unset", func(v string) (err error) {
+		c.opts.ClientBandwidth, err = slowproxy.ParseRate(v)
+		return err
+	})
+	fs.IntVar(&c.opts.ShedCapacity, "shed-capacity", 0, "in-flight requests at which every request is shed, load shedding is off when 0")
+	fs.Float64Var(&c.opts.ShedThreshold, "shed-threshold", 0.8, "share of -shed-capacity above which requests start being shed")
+	fs.Func("bearer-token", "token accepted by /auth/bearer, any token when none are given (repeatable)", func(v string) error {
+		c.opts.BearerTokens = append(c.opts.BearerTokens, v)
+		return nil
+	})
+	fs.DurationVar(&c.opts.DigestNonceTTL, "digest-nonce-ttl", 5*time.Minute, "lifetime of /auth/digest nonces before they are challenged as stale")
+	fs.DurationVar(&c.opts.APIKeyRotate, "api-key-rotate", time.Hour, "interval at which the accepted X-API-Key rotates")
+	fs.DurationVar(&c.opts.APIKeyOverlap, "api-key-overlap", time.Minute, "how long the previous X-API-Key stays valid after a rotation")
+	fs.Func("api-key-path", "path prefix that requires the current X-API-Key (repeatable)", func(v string) error {
+		c.opts.APIKeyPaths = append(c.opts.APIKeyPaths, v)
+		return nil
+	})
+	fs.DurationVar(&c.opts.OAuthTokenTTL, "oauth-token-ttl", time.Hour, "lifetime of access tokens issued by /oauth/token")
+	fs.DurationVar(&c.opts.OAuthRefreshTTL, "oauth-refresh-ttl", 24*time.Hour, "lifetime of refresh tokens issued by /oauth/token")
+	fs.DurationVar(&c.opts.OAuthDelay, "oauth-delay", 0, "delay before /oauth/token answers")
+	fs.Float64Var(&c.opts.OAuthFailPercent, "oauth-fail-percent", 0, "percentage of token requests failed with invalid_grant")
+	fs.DurationVar(&c.opts.SessionTTL, "session-ttl", 30*time.Minute, "lifetime of session cookies issued by /session/login")
+	fs.DurationVar(&c.opts.SessionUnauthDelay, "session-unauth-delay", 0, "delay before rejecting requests without a valid session")
+	fs.Func("session-path", "path prefix that requires a session cookie (repeatable)", func(v string) error {
+		c.opts.SessionPaths = append(c.opts.SessionPaths, v)
+		return nil
+	})
+	fs.StringVar(&c.opts.JWTIssuer, "jwt-issuer", "slow-proxy", "iss of minted JWTs, required by /jwt/protected")
+	fs.DurationVar(&c.opts.JWTTTL, "jwt-ttl", time.Hour, "default lifetime of minted JWTs")
+	fs.DurationVar(&c.opts.JWTRotate, "jwt-rotate", 0, "rotate the JWT signing key at this interval, disabled when 0")
+	fs.Float64Var(&c.authFailPercent, "auth-fail-percent", 0, "percentage of successful responses replaced with -auth-fail-status")
+	fs.IntVar(&c.authFailStatus, "auth-fail-status", http.StatusUnauthorized, "status used by -auth-fail-percent, 401 or 403")
+	fs.IntVar(&c.opts.HeadMismatch, "head-mismatch", 0, "bytes added to the Content-Length of HEAD responses, so HEAD and GET disagree")
+	fs.Float64Var(&c.opts.ClockSpeed, "clock-speed", 1, "how much faster than the wall clock schedules, ramps, bursts and groups run, e.g. 60 for an hour a minute")
+	fs.DurationVar(&c.opts.ClockSkew, "clock-skew", 0, "offset applied to Date, Expires, cookie expiry and minted JWT times")
+	fs.BoolVar(&c.opts.HTTP10, "http10", false, "respond as HTTP/1.0 and close every connection")
+	fs.Float64Var(&c.opts.ClosePercent, "close-percent", 0, "percentage of responses sent with Connection: close")
+	fs.IntVar(&c.opts.MaxKeepAliveRequests, "max-keepalive-requests", 0, "close connections after this many requests, unlimited when 0")
+	fs.DurationVar(&c.opts.IdleClose, "idle-close", 0, "close keep-alive connections idle for this long, disabled when 0")
+	fs.BoolVar(&c.opts.IdleCloseSilent, "idle-close-silent", false, "with -idle-close, send no FIN and reset the connection on the next client write instead")
+	fs.StringVar(&c.opts.TLSCert, "tls-cert", "", "serve TLS with this certificate, reloaded when the file changes")
+	fs.StringVar(&c.opts.TLSKey, "tls-key", "", "private key for -tls-cert")
+	fs.BoolVar(&c.opts.TLSSelfSigned, "tls-self-signed", false, "serve TLS with a generated CA, see /admin/tls/ca.pem")
+	fs.DurationVar(&c.opts.TLSRotateCA, "tls-rotate-ca", 0, "rotate to a newly generated CA on this interval, implies -tls-self-signed")
+	fs.BoolVar(&c.opts.TLSNoTickets, "tls-no-session-tickets", false, "disable TLS session tickets")
+	fs.BoolVar(&c.opts.TLSRejectResumption, "tls-reject-resumption", false, "issue session tickets but refuse to resume with them")
+	fs.DurationVar(&c.opts.TLSTicketRotate, "tls-ticket-rotate", 0, "rotate the session ticket key on this interval, keeping the previous key")
+	fs.StringVar(&c.opts.Compat, "compat", "", "answer the endpoints of another service with its response shapes: httpbin")
+	fs.StringVar(&c.opts.ToxiproxyAddr, "toxiproxy-addr", "", "serve the Toxiproxy API on this address, usually localhost:8474, disabled when empty")
+	fs.Var(&c.opts.TCPFaults, "tcp-fault", "raw tcp fault listener as mode=addr, mode one of hang, close, reset, blackhole, noaccept (repeatable)")
+	return c
+}
+
+// parse reads args, the address being the only positional argument.
+func (c *serveConfig) parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	c.opts.Addr = "localhost:8080"
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		c.opts.Addr = fs.Arg(0)
+	default:
+		return fmt.Errorf("unexpected arguments %q after the address", fs.Args()[1:])
+	}
+	if err := validSignalAction("sigusr1", c.sigusr1, c.file); err != nil {
+		return err
+	}
+	return validSignalAction("sigusr2", c.sigusr2, c.file)
+}
+
+// logFlags are -q, -v, -vv and -log-file.
+type logFlags struct {
+	quiet, verbose, trace bool
+	file                  string
+}
+
+func (l *logFlags) register(fs *flag.FlagSet) {
+	fs.BoolVar(&l.quiet, "q", false, "log errors only")
+	fs.BoolVar(&l.verbose, "v", false, "log at debug level, every request included")
+	fs.BoolVar(&l.trace, "vv", false, "log at trace level, every tick of a delay included")
+	fs.StringVar(&l.file, "log-file", "", "append logs to this file instead of stderr, reopened by the rotate-log signal action")
+}
+
+// output is where the logs go, the file is nil without -log-file.
+func (l *logFlags) output() (zapcore.WriteSyncer, *logFile, error) {
+	if l.file == "" {
+		return zapcore.Lock(os.Stderr), nil, nil
+	}
+	f, err := openLogFile(l.file)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, f, nil
+}
+
+// level is the log level -q, -v and -vv ask for, the most verbose wins.
+func (l *logFlags) level() zapcore.Level {
+	switch {
+	case l.trace:
+		return slowproxy.TraceLevel
+	case l.verbose:
+		return zapcore.DebugLevel
+	case l.quiet:
+		return zapcore.ErrorLevel
+	}
+	return zapcore.InfoLevel
+}
+
+// options turns the flags and the config file into server options.
+func (c *serveConfig) options(logger *zap.Logger) ([]slowproxy.Option, error) {
+	options := []slowproxy.Option{
+		slowproxy.WithLogger(logger),
+		slowproxy.WithOptions(c.opts),
+		slowproxy.WithReadyFunc(func(info slowproxy.ReadyInfo) {
+			announceReady(logger, info, c.readyFile, c.readyFD)
+		}),
+	}
+	if c.authFailPercent > 0 {
+		options = append(options, slowproxy.WithRules(slowproxy.AuthFailureRule(c.authFailPercent, c.authFailStatus)))
+	}
+	if c.configPath != "" {
+		cfg, err := slowproxy.LoadConfig(c.configPath)
+		if err != nil {
+			return nil, fmt.Errorf("load config: %w", err)
+		}
+		options = append(options, cfg.Options()...)
+	}
+	return options, nil
+}
+
+// serveMain runs `slow-proxy serve [flags] [addr]` until interrupted. record
+// and replay are serve with the VCR mode preset and a cassette required.
+func serveMain(name string, args []string) int {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: slow-proxy %s [flags] [addr]\n\n", name)
+		if name == "serve" {
+			fmt.Fprint(fs.Output(), commandsHelp, "\n", exitCodesHelp, "\n")
+		}
+		fs.PrintDefaults()
+	}
+	c := serveFlags(fs)
+	switch name {
+	case "record":
+		c.opts.VCRMode = slowproxy.VCRRecord
+	case "replay":
+		c.opts.VCRMode = slowproxy.VCRReplay
+	}
+	if err := c.parse(fs, args); err != nil {
+		fmt.Fprintln(os.Stderr, name+":", err)
+		return 2
+	}
+	switch {
+	case name == "record" && (c.opts.Cassette == "" || c.opts.Upstream == ""):
+		fmt.Fprintln(os.Stderr, "record: -upstream and -cassette are required")
+		return 2
+	case name == "replay" && c.opts.Cassette == "":
+		fmt.Fprintln(os.Stderr, "replay: -cassette is required")
+		return 2
+	case c.tui && !term.IsTerminal(int(os.Stdin.Fd())):
+		fmt.Fprintln(os.Stderr, name+": -tui needs a terminal")
+		return 2
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	out, logFile, err := c.output()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, name+":", err)
+		return 1
+	}
+	logger := setupLogging(c.level(), out)
+	var logs *tuiLog
+	if c.tui {
+		logs = &tuiLog{}
+		logger = logs.logger(c.level())
+	}
+	defer logger.Sync()
+
+	var locks []string
+	if c.singleInstance {
+		locks = append(locks, instanceLock(c.configPath, c.opts.Addr))
+	}
+	if c.pidFile != "" {
+		locks = append(locks, c.pidFile)
+	}
+	inherited, err := inheritedHandoff()
+	if err != nil {
+		logger.Error("failed to take over", zap.Error(err))
+		return 1
+	}
+	var held []*pidFile
+	for _, path := range locks {
+		var p *pidFile
+		if f := inherited.lock(path); f != nil {
+			p, err = adoptPIDFile(path, f)
+		} else {
+			p, err = acquirePIDFile(path)
+		}
+		if err != nil {
+			logger.Error("refusing to start", zap.Error(err))
+			return 1
+		}
+		defer p.release()
+		held = append(held, p)
+		if p.stale != "" {
+			logger.Warn("took over stale pid file", zap.String("path", path), zap.String("pid", p.stale))
+		}
+	}
+
+	if inherited != nil {
+		// the predecessor's -ready-fd is not ours
+		c.readyFD = -1
+	}
+	options, err := c.options(logger)
+	if err != nil {
+		logger.Error("failed to load config", zap.Error(err))
+		return exitConfig
+	}
+	if inherited != nil {
+		options = append(options, slowproxy.WithInheritedListeners(inherited.listeners), slowproxy.WithReadyFunc(func(slowproxy.ReadyInfo) { inherited.done() }))
+	}
+	srv, err := slowproxy.New(options...)
+	if err != nil {
+		logger.Error("failed to create server", zap.Error(err))
+		return newExitCode(err)
+	}
+	signals := &signalHandler{srv: srv, logger: logger, latency: c.signalLatency, log: logFile}
+	go signals.run(ctx, userSignals(c.sigusr1, c.sigusr2))
+	if !c.tui {
+		go handOffOnHangup(ctx, srv, logger, held, c.drainTimeout)
+	}
+	if logs != nil {
+		tuiDone := make(chan struct{})
+		defer func() { <-tuiDone }()
+		go func() {
+			defer close(tuiDone)
+			if err := runTUI(ctx, srv, logs, cancel); err != nil {
+				fmt.Fprintln(os.Stderr, "tui:", err)
+				cancel()
+			}
+		}()
+	}
+	if c.gcPercent != 0 {
+		// process-wide, so up to the command rather than the library
+		defer debug.SetGCPercent(debug.SetGCPercent(c.gcPercent))
+	}
+	if err := srv.Run(ctx); err != nil {
+		logger.Error("starting failed", zap.Error(err))
+		return runExitCode(err)
+	}
+	logger.Info("server shutdown complete")
+	return 0
+}
+
+// checkMain runs `slow-proxy check [flags]`: it builds the server the same
+// flags and config would serve, without listening, and reports what is wrong.
+func checkMain(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	c := serveFlags(fs)
+	if err := c.parse(fs, args); err != nil {
+		fmt.Fprintln(os.Stderr, "check:", err)
+		return 2
+	}
+	options, err := c.options(zap.NewNop())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "check:", err)
+		return exitConfig
+	}
+	srv, err := slowproxy.New(options...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "check:", err)
+		return newExitCode(err)
+	}
+	srv.Close()
+	fmt.Println("ok")
+	return 0
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/cbosss/slow-proxy/pkg/slowproxy"
+	"go.uber.org/zap"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Signal actions, see -sigusr1 and -sigusr2.
+const (
+	signalLatency   = "latency"
+	signalStats     = "stats"
+	signalRotateLog = "rotate-log"
+	signalNone      = "none"
+)
+
+var signalActions = []string{signalLatency, signalStats, signalRotateLog, signalNone}
+
+func validSignalAction(flag, action string, logFile string) error {
+	switch {
+	case !slices.Contains(signalActions, action):
+		return fmt.Errorf("-%s: unknown action %q, want one of %s", flag, action, strings.Join(signalActions, ", "))
+	case action == signalRotateLog && logFile == "":
+		return fmt.Errorf("-%s: rotate-log needs -log-file", flag)
+	}
+	return nil
+}
+
+// signalHandler pokes a running server from kill -USR1 and -USR2, for
+// incidents and demos where nothing but a shell is at hand.
+type signalHandler struct {
+	srv     *slowproxy.Server
+	logger  *zap.Logger
+	latency time.Duration
+	log     *logFile
+}
+
+// run handles the signals of actions until ctx is done. Signals mapped to
+// none keep their default behaviour.
+func (h *signalHandler) run(ctx context.Context, actions map[os.Signal]string) {
+	ch := make(chan os.Signal, 1)
+	for sig, action := range actions {
+		if action != signalNone {
+			signal.Notify(ch, sig)
+		}
+	}
+	defer signal.Stop(ch)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-ch:
+			h.do(actions[sig], sig)
+		}
+	}
+}
+
+func (h *signalHandler) do(action string, sig os.Signal) {
+	c := h.srv.Controller()
+	switch action {
+	case signalLatency:
+		if _, on := c.State().Latency["/"]; on {
+			c.SetLatency("/", 0)
+			h.logger.Info("latency off", zap.Stringer("signal", sig))
+		} else {
+			c.SetLatency("/", h.latency)
+			h.logger.Info("latency on every route", zap.Stringer("signal", sig), zap.Duration("delay", h.latency))
+		}
+	case signalStats:
+		h.logger.Info("stats", zap.Stringer("signal", sig), zap.Any("stats", h.srv.Stats()), zap.Any("faults", c.State()), zap.Int("open_conns", len(h.srv.Conns().Open)))
+	case signalRotateLog:
+		if err := h.log.reopen(); err != nil {
+			h.logger.Error("failed to reopen log file", zap.String("path", h.log.path), zap.Error(err))
+			return
+		}
+		h.logger.Info("reopened log file", zap.Stringer("signal", sig), zap.String("path", h.log.path))
+	}
+}
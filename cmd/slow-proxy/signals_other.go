@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// userSignals is empty where SIGUSR1 and SIGUSR2 do not exist.
+func userSignals(usr1, usr2 string) map[os.Signal]string {
+	return nil
+}
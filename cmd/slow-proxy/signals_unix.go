@@ -0,0 +1,13 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// userSignals maps SIGUSR1 and SIGUSR2 to their actions.
+func userSignals(usr1, usr2 string) map[os.Signal]string {
+	return map[os.Signal]string{syscall.SIGUSR1: usr1, syscall.SIGUSR2: usr2}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/cbosss/slow-proxy/pkg/slowproxy"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"golang.org/x/term"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tuiErrorRule is the rule the e key adds and removes.
+const tuiErrorRule = "tui-errors"
+
+// tuiLog keeps the last log lines for the dashboard, logs would otherwise
+// scroll over it.
+type tuiLog struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *tuiLog) Write(b []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for line := range strings.SplitSeq(strings.TrimRight(string(b), "\n"), "\n") {
+		l.lines = append(l.lines, line)
+	}
+	if len(l.lines) > 100 {
+		l.lines = l.lines[len(l.lines)-100:]
+	}
+	return len(b), nil
+}
+
+func (l *tuiLog) Sync() error { return nil }
+
+// logger writes human readable lines to the dashboard.
+func (l *tuiLog) logger(level zapcore.Level) *zap.Logger {
+	conf := zap.NewDevelopmentEncoderConfig()
+	conf.EncodeLevel = encodeLevel(zapcore.CapitalLevelEncoder)
+	return zap.New(zapcore.NewCore(zapcore.NewConsoleEncoder(conf), l, level))
+}
+
+func (l *tuiLog) tail(n int) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string{}, l.lines[max(len(l.lines)-n, 0):]...)
+}
+
+// tui is the -tui dashboard: stats, faults, connections, recent requests and
+// logs redrawn twice a second, with keys toggling faults.
+type tui struct {
+	srv     *slowproxy.Server
+	logs    *tuiLog
+	started time.Time
+	status  string
+}
+
+// runTUI draws the dashboard on the terminal until ctx is done or q is
+// pressed, which calls quit.
+func runTUI(ctx context.Context, srv *slowproxy.Server, logs *tuiLog, quit func()) error {
+	fd := int(os.Stdin.Fd())
+	old, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer func() {
+		fmt.Print("\x1b[?25h\x1b[?1049l")
+		term.Restore(fd, old)
+	}()
+
+	keys := make(chan byte)
+	go func() {
+		r := bufio.NewReader(os.Stdin)
+		for {
+			b, err := r.ReadByte()
+			if err != nil {
+				return
+			}
+			keys <- b
+		}
+	}()
+
+	t := &tui{srv: srv, logs: logs, started: time.Now()}
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		t.draw()
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case k := <-keys:
+			if k == 'q' || k == 3 { // 3 is ^C, raw mode delivers no SIGINT
+				quit()
+				return nil
+			}
+			t.key(ctx, k)
+		}
+	}
+}
+
+func (t *tui) key(ctx context.Context, k byte) {
+	c := t.srv.Controller()
+	switch k {
+	case 'l':
+		if _, on := c.State().Latency["/"]; on {
+			c.SetLatency("/", 0)
+			t.status = "latency off"
+		} else {
+			c.SetLatency("/", 2*time.Second)
+			t.status = "2s latency on every route"
+		}
+	case 'e':
+		var rules []slowproxy.Rule
+		for _, r := range c.Rules() {
+			if r.Name != tuiErrorRule {
+				rules = append(rules, r)
+			}
+		}
+		if len(rules) == len(c.Rules()) {
+			rules = append(rules, slowproxy.Rule{Name: tuiErrorRule, Fault: slowproxy.Fault{Status: http.StatusServiceUnavailable, Percent: 50}})
+			t.status = "503 for half the requests"
+		} else {
+			t.status = "errors off"
+		}
+		if err := c.SetRules(rules...); err != nil {
+			t.status = err.Error()
+		}
+	case 'f':
+		_ = c.FailNext(10, http.StatusServiceUnavailable)
+		t.status = "failing the next 10 requests"
+	case 'h':
+		go t.srv.Hiccup(ctx, slowproxy.HiccupWorld, 500*time.Millisecond)
+		t.status = "500ms hiccup"
+	case 'c':
+		t.srv.ClearCaptures()
+		t.status = "captures cleared"
+	case 'r':
+		c.Reset()
+		t.status = "faults reset"
+	}
+}
+
+func (t *tui) draw() {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 100, 40
+	}
+	var lines []string
+	add := func(format string, args ...any) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+
+	st := t.srv.Stats()
+	add("\x1b[1mslow-proxy\x1b[0m %s  %s  up %s  seed %d", slowproxy.Build().Version, t.srv.URL(), time.Since(t.started).Round(time.Second), st.Seed)
+	add("requests %d  in flight %d  aborted %d  client gone %d  shed %d  refused %d  slow consumers %d  hiccups %d",
+		st.Requests, st.InFlight, st.Aborted, st.ClientDisconnects, st.Shed, st.RefusedConns, st.SlowConsumers, st.Hiccups)
+	add("")
+
+	cs := t.srv.Controller().State()
+	add("\x1b[1mfaults\x1b[0m")
+	for route, d := range cs.Latency {
+		add("  latency %s on %s", d, route)
+	}
+	if cs.FailNext > 0 {
+		add("  failing the next %d with %d", cs.FailNext, cs.FailStatus)
+	}
+	for _, r := range cs.Rules {
+		add("  rule %s", ruleSummary(r))
+	}
+	if len(cs.Latency) == 0 && cs.FailNext == 0 && len(cs.Rules) == 0 {
+		add("  none")
+	}
+	add("")
+
+	conns := t.srv.Conns()
+	add("\x1b[1mconnections\x1b[0m %d open, %d since start", len(conns.Open), conns.Opened)
+	for _, r := range conns.Open[max(len(conns.Open)-8, 0):] {
+		add("  #%-5d %-22s %-7s %3d requests  %s", r.ID, r.Remote, r.State, r.Requests, time.Since(r.Opened).Round(time.Second))
+	}
+	add("")
+
+	captures := t.srv.Captures()
+	add("\x1b[1mrecent requests\x1b[0m")
+	for _, c := range captures[max(len(captures)-10, 0):] {
+		mark := ""
+		switch {
+		case c.Aborted:
+			mark = " aborted"
+		case c.ClientGone:
+			mark = " client gone"
+		case c.Fault:
+			mark = " fault"
+		}
+		add("  %s %-6s %-40s %3d %8s%s", c.Time.Format("15:04:05"), c.Method, c.Path, c.Status, c.Duration.Round(time.Millisecond), mark)
+	}
+	add("")
+
+	add("\x1b[1mlog\x1b[0m")
+	for _, l := range t.logs.tail(5) {
+		add("  %s", l)
+	}
+	add("")
+	add("\x1b[7m l latency  e errors  f fail next 10  h hiccup  r reset  c clear captures  q quit \x1b[0m %s", t.status)
+
+	var buf bytes.Buffer
+	buf.WriteString("\x1b[H")
+	for i, l := range lines {
+		if i >= height {
+			break
+		}
+		buf.WriteString(truncate(l, width))
+		buf.WriteString("\x1b[K\r\n")
+	}
+	buf.WriteString("\x1b[J")
+	os.Stdout.Write(buf.Bytes())
+}
+
+func ruleSummary(r slowproxy.Rule) string {
+	var parts []string
+	if r.Name != "" {
+		parts = append(parts, r.Name+":")
+	}
+	if r.Match.Method != "" {
+		parts = append(parts, r.Match.Method)
+	}
+	path := r.Match.Path
+	if path == "" {
+		path = "*"
+	}
+	parts = append(parts, path)
+	f := r.Fault
+	if !f.Delay.IsZero() {
+		parts = append(parts, "delay "+f.Delay.Fixed.String())
+	}
+	if f.Status != 0 {
+		parts = append(parts, fmt.Sprintf("status %d", f.Status))
+	}
+	if f.Abort {
+		parts = append(parts, "abort")
+	}
+	if f.Percent > 0 {
+		parts = append(parts, fmt.Sprintf("%g%%", f.Percent))
+	}
+	return strings.Join(parts, " ")
+}
+
+// truncate cuts l to width visible characters, escape sequences are free.
+func truncate(l string, width int) string {
+	var b strings.Builder
+	visible, escape := 0, false
+	for _, r := range l {
+		switch {
+		case r == '\x1b':
+			escape = true
+		case escape:
+			escape = r < '@' || r > '~' || r == '['
+		default:
+			if visible >= width {
+				continue
+			}
+			visible++
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
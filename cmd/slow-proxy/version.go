@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/cbosss/slow-proxy/pkg/slowproxy"
+	"os"
+)
+
+// versionMain runs `slow-proxy version`.
+func versionMain(args []string) int {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	asJSON := versionFlags(fs)
+	fs.Parse(args)
+
+	b := slowproxy.Build()
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(b)
+		return 0
+	}
+	fmt.Println("slow-proxy", b.Version)
+	if b.Commit != "" {
+		modified := ""
+		if b.Modified {
+			modified = " (modified)"
+		}
+		fmt.Printf("commit     %s%s\n", b.Commit, modified)
+	}
+	if b.BuildDate != "" {
+		fmt.Println("built     ", b.BuildDate)
+	}
+	fmt.Println("go        ", b.GoVersion, b.Platform)
+	return 0
+}
+
+// versionFlags registers the flags of version on fs.
+func versionFlags(fs *flag.FlagSet) (asJSON *bool) {
+	return fs.Bool("json", false, "print the build info as JSON")
+}
@@ -0,0 +1,143 @@
+package slowproxy
+
+import (
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// OverflowQueue leaves connections over AcceptRate or MaxConns in the listen
+// backlog until they can be served, see Options.AcceptOverflow.
+const OverflowQueue = "queue"
+
+// acceptLimiter bounds how fast connections are accepted and how many are
+// served at once, which bounds the goroutines net/http runs for them.
+type acceptLimiter struct {
+	net.Listener
+	s      *Server
+	every  time.Duration
+	slots  chan struct{}
+	refuse bool
+
+	mu     sync.Mutex
+	next   time.Time
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (s *Server) limitAccepts(ln net.Listener) net.Listener {
+	if s.opts.AcceptRate <= 0 && s.opts.MaxConns <= 0 {
+		return ln
+	}
+	l := &acceptLimiter{Listener: ln, s: s, refuse: s.opts.AcceptOverflow == RejectRefuse, closed: make(chan struct{})}
+	if s.opts.AcceptRate > 0 {
+		l.every = time.Duration(float64(time.Second) / s.opts.AcceptRate)
+	}
+	if s.opts.MaxConns > 0 {
+		l.slots = make(chan struct{}, s.opts.MaxConns)
+		s.connSlots = l.slots
+	}
+	return l
+}
+
+func (l *acceptLimiter) Accept() (net.Conn, error) {
+	for {
+		if !l.refuse {
+			if err := l.wait(); err != nil {
+				return nil, err
+			}
+		}
+		c, err := l.Listener.Accept()
+		if err != nil {
+			if l.slots != nil && !l.refuse {
+				<-l.slots
+			}
+			return nil, err
+		}
+		if !l.refuse {
+			l.takeToken(time.Now())
+			return c, nil
+		}
+		switch {
+		case !l.takeToken(time.Now()):
+			l.reject(c, "accept rate")
+		case l.slots != nil && !l.takeSlot():
+			l.reject(c, "connection limit")
+		default:
+			return c, nil
+		}
+	}
+}
+
+// wait blocks until a connection may be accepted, holding a slot when
+// MaxConns is set.
+func (l *acceptLimiter) wait() error {
+	if l.every > 0 {
+		l.mu.Lock()
+		d := time.Until(l.next)
+		l.mu.Unlock()
+		if d > 0 {
+			timer := getTimer(d)
+			select {
+			case <-timer.C:
+			case <-l.closed:
+				putTimer(timer)
+				return net.ErrClosed
+			}
+			putTimer(timer)
+		}
+	}
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-l.closed:
+			return net.ErrClosed
+		}
+	}
+	return nil
+}
+
+// takeToken reports whether the accept rate allows a connection at now and
+// starts the wait for the next one if so.
+func (l *acceptLimiter) takeToken(now time.Time) bool {
+	if l.every <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Before(l.next) {
+		return false
+	}
+	l.next = now.Add(l.every)
+	return true
+}
+
+func (l *acceptLimiter) takeSlot() bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *acceptLimiter) reject(c net.Conn, limit string) {
+	l.s.stats.refusedConns.Add(1)
+	l.s.logger.Info("refusing connection over "+limit, zap.String("remote", c.RemoteAddr().String()))
+	resetConn(c)
+}
+
+func (l *acceptLimiter) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return l.Listener.Close()
+}
+
+// releaseConnSlot frees the MaxConns slot of a connection that is no longer
+// served by net/http.
+func (s *Server) releaseConnSlot(state http.ConnState) {
+	if s.connSlots != nil && (state == http.StateClosed || state == http.StateHijacked) {
+		<-s.connSlots
+	}
+}
@@ -0,0 +1,160 @@
+package slowproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func (s *Server) adminRoutes(r *mux.Router) {
+	r.HandleFunc("/captures", s.getCaptures).Methods(http.MethodGet)
+	r.HandleFunc("/captures", s.clearCaptures).Methods(http.MethodDelete)
+	r.HandleFunc("/stats", s.getStats).Methods(http.MethodGet)
+	r.HandleFunc("/control", s.getControl).Methods(http.MethodGet)
+	r.HandleFunc("/latency", s.setLatency).Methods(http.MethodPut, http.MethodPost)
+	r.HandleFunc("/ramp", s.setRamp).Methods(http.MethodPut, http.MethodPost)
+	r.HandleFunc("/ramp", s.clearRamp).Methods(http.MethodDelete)
+	r.HandleFunc("/fail-next", s.failNext).Methods(http.MethodPost)
+	r.HandleFunc("/reset", s.reset).Methods(http.MethodPost)
+	r.HandleFunc("/rules", s.getRules).Methods(http.MethodGet)
+	r.HandleFunc("/rules", s.putRules).Methods(http.MethodPut)
+	r.HandleFunc("/rules", s.addRule).Methods(http.MethodPost)
+	r.HandleFunc("/rules", s.clearRules).Methods(http.MethodDelete)
+	r.HandleFunc("/rules/{name}", s.deleteRule).Methods(http.MethodDelete)
+	r.HandleFunc("/schedules", s.getSchedules).Methods(http.MethodGet)
+	r.HandleFunc("/groups", s.getGroups).Methods(http.MethodGet)
+	r.HandleFunc("/groups/{name}/down", s.groupDown).Methods(http.MethodPost)
+	r.HandleFunc("/groups/{name}/up", s.groupUp).Methods(http.MethodPost)
+	r.HandleFunc("/profiles", s.getProfiles).Methods(http.MethodGet)
+	r.HandleFunc("/probes", s.getProbes).Methods(http.MethodGet)
+	r.HandleFunc("/probes/{name}", s.setProbe).Methods(http.MethodPut, http.MethodPost)
+	r.HandleFunc("/conns", s.getConns).Methods(http.MethodGet)
+	r.HandleFunc("/hiccup", s.hiccup).Methods(http.MethodPost)
+	r.HandleFunc("/version", s.getVersion).Methods(http.MethodGet)
+	r.HandleFunc("/report", s.getReport).Methods(http.MethodGet)
+	r.HandleFunc("/report/phase", s.setPhase).Methods(http.MethodPut, http.MethodPost)
+	r.HandleFunc("/markov", s.getMarkov).Methods(http.MethodGet)
+	r.HandleFunc("/markov", s.putMarkov).Methods(http.MethodPut, http.MethodPost)
+	r.HandleFunc("/markov", s.deleteMarkov).Methods(http.MethodDelete)
+	r.HandleFunc("/grpc/health", s.getGRPCHealth).Methods(http.MethodGet)
+	r.HandleFunc("/grpc/health", s.setGRPCHealth).Methods(http.MethodPut, http.MethodPost)
+	r.HandleFunc("/apikeys", s.getAPIKeys).Methods(http.MethodGet)
+	r.HandleFunc("/sessions", s.clearSessions).Methods(http.MethodDelete)
+	r.HandleFunc("/jwt", s.getJWT).Methods(http.MethodGet)
+	r.HandleFunc("/jwt", s.setJWT).Methods(http.MethodPut, http.MethodPost)
+	r.HandleFunc("/jwt/rotate", s.rotateJWT).Methods(http.MethodPost)
+	r.HandleFunc("/clock", s.getClock).Methods(http.MethodGet)
+	r.HandleFunc("/clock", s.setClock).Methods(http.MethodPut, http.MethodPost)
+	if s.certs != nil {
+		r.HandleFunc("/tls", s.getTLS).Methods(http.MethodGet)
+		r.HandleFunc("/tls/ca.pem", s.getTLSCA).Methods(http.MethodGet)
+		r.HandleFunc("/tls/reload", s.reloadTLS).Methods(http.MethodPost)
+		r.HandleFunc("/tls/rotate-ca", s.rotateTLSCA).Methods(http.MethodPost)
+		r.HandleFunc("/tls/sessions", s.getTLSSessions).Methods(http.MethodGet)
+		r.HandleFunc("/tls/sessions", s.setTLSSessions).Methods(http.MethodPut, http.MethodPost)
+	}
+}
+
+func (s *Server) getRules(rw http.ResponseWriter, req *http.Request) {
+	writeJSON(rw, http.StatusOK, s.rules.list())
+}
+
+// putRules replaces every rule with the JSON list in the body.
+func (s *Server) putRules(rw http.ResponseWriter, req *http.Request) {
+	var rules []Rule
+	if err := json.NewDecoder(req.Body).Decode(&rules); err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.rules.replace(rules); err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	s.logger.Info("replaced rules", zap.Int("rules", len(rules)))
+	s.getRules(rw, req)
+}
+
+// addRule appends the JSON rule in the body, it matches after existing rules.
+func (s *Server) addRule(rw http.ResponseWriter, req *http.Request) {
+	var rule Rule
+	if err := json.NewDecoder(req.Body).Decode(&rule); err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.rules.add(rule); err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	s.logger.Info("added rule", zap.String("rule", rule.Name))
+	s.getRules(rw, req)
+}
+
+func (s *Server) clearRules(rw http.ResponseWriter, req *http.Request) {
+	_ = s.rules.replace(nil)
+	s.logger.Info("cleared rules")
+	s.getRules(rw, req)
+}
+
+func (s *Server) deleteRule(rw http.ResponseWriter, req *http.Request) {
+	name := mux.Vars(req)["name"]
+	if err := s.rules.remove(name); err != nil {
+		writeError(rw, http.StatusNotFound, fmt.Errorf("%w: %q", err, name))
+		return
+	}
+	s.logger.Info("deleted rule", zap.String("rule", name))
+	s.getRules(rw, req)
+}
+
+type grpcHealthState struct {
+	Services map[string]string `json:"services"`
+	Delay    string            `json:"delay"`
+}
+
+func (s *Server) getGRPCHealth(rw http.ResponseWriter, req *http.Request) {
+	writeJSON(rw, http.StatusOK, grpcHealthState{
+		Services: s.health.snapshot(),
+		Delay:    time.Duration(s.health.delay.Load()).String(),
+	})
+}
+
+// setGRPCHealth updates the health service from query parameters:
+//
+//	PUT /admin/grpc/health?service=slowproxy.v1.SlowProxy&status=NOT_SERVING&delay=2s
+func (s *Server) setGRPCHealth(rw http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	if v := q.Get("status"); v != "" {
+		status, ok := healthpb.HealthCheckResponse_ServingStatus_value[strings.ToUpper(v)]
+		if !ok {
+			writeError(rw, http.StatusBadRequest, fmt.Errorf("unknown status %q", v))
+			return
+		}
+		service := q.Get("service")
+		s.logger.Info("setting grpc health status", zap.String("service", service), zap.String("status", v))
+		s.health.setStatus(service, healthpb.HealthCheckResponse_ServingStatus(status))
+	}
+	if q.Has("delay") {
+		delay, err := durationQuery(req, "delay", 0)
+		if err != nil {
+			writeError(rw, http.StatusBadRequest, err)
+			return
+		}
+		s.logger.Info("setting grpc health delay", zap.Duration("delay", delay))
+		s.health.delay.Store(int64(delay))
+	}
+	s.getGRPCHealth(rw, req)
+}
+
+func writeJSON(rw http.ResponseWriter, status int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	_ = json.NewEncoder(rw).Encode(v)
+}
+
+func writeError(rw http.ResponseWriter, status int, err error) {
+	writeJSON(rw, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,111 @@
+package slowproxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// apiKeyAt derives the API key of a rotation generation, so no key list
+// needs to be stored.
+func (s *Server) apiKeyAt(gen int64) string {
+	mac := hmac.New(sha256.New, s.digestKey)
+	mac.Write(binary.BigEndian.AppendUint64([]byte("api-key"), uint64(gen)))
+	return "sk_" + hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// apiKeyGeneration returns the current generation and when it started.
+func (s *Server) apiKeyGeneration(now time.Time) (int64, time.Time) {
+	every := s.opts.APIKeyRotate
+	gen := int64(now.Sub(s.started) / every)
+	return gen, s.started.Add(time.Duration(gen) * every)
+}
+
+// APIKeyState lists the accepted API keys, see /admin/apikeys.
+type APIKeyState struct {
+	Current   string    `json:"current"`
+	Previous  string    `json:"previous,omitempty"` // still accepted during the overlap window
+	RotatesAt time.Time `json:"rotates_at"`
+}
+
+func (s *Server) apiKeyState(now time.Time) APIKeyState {
+	gen, start := s.apiKeyGeneration(now)
+	st := APIKeyState{Current: s.apiKeyAt(gen), RotatesAt: start.Add(s.opts.APIKeyRotate)}
+	if gen > 0 && now.Sub(start) < s.opts.APIKeyOverlap {
+		st.Previous = s.apiKeyAt(gen - 1)
+	}
+	return st
+}
+
+// checkAPIKey reports whether key is accepted now and, if not, whether it is
+// a recently rotated out one.
+func (s *Server) checkAPIKey(key string, now time.Time) (ok, rotated bool) {
+	st := s.apiKeyState(now)
+	if key != "" && (secureEqual(key, st.Current) || st.Previous != "" && secureEqual(key, st.Previous)) {
+		return true, false
+	}
+	gen, _ := s.apiKeyGeneration(now)
+	for g := gen - 1; g >= max(gen-3, 0); g-- {
+		if secureEqual(key, s.apiKeyAt(g)) {
+			return false, true
+		}
+	}
+	return false, false
+}
+
+func (s *Server) rejectAPIKey(rw http.ResponseWriter, key string) bool {
+	ok, rotated := s.checkAPIKey(key, time.Now())
+	switch {
+	case ok:
+		return false
+	case rotated:
+		writeAPIError(rw, http.StatusUnauthorized, &apiError{Code: "api_key_rotated", Message: "api key was rotated out"})
+	default:
+		writeAPIError(rw, http.StatusUnauthorized, &apiError{Code: "invalid_api_key", Message: "missing or unknown X-API-Key"})
+	}
+	return true
+}
+
+// apiKeyAuth accepts the X-API-Key values of the current rotation, the
+// previous one during the APIKeyOverlap window:
+//
+//	/auth/apikey?delay=1s
+func (s *Server) apiKeyAuth(rw http.ResponseWriter, req *http.Request) {
+	if !s.pauseQuery(rw, req) {
+		return
+	}
+	if s.rejectAPIKey(rw, req.Header.Get("X-API-Key")) {
+		return
+	}
+	writeJSON(rw, http.StatusOK, map[string]any{"authenticated": true})
+}
+
+// apiKeyGate requires an API key on the APIKeyPaths prefixes.
+func (s *Server) apiKeyGate(next http.Handler) http.Handler {
+	if len(s.opts.APIKeyPaths) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/admin/") {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		for _, prefix := range s.opts.APIKeyPaths {
+			if strings.HasPrefix(req.URL.Path, prefix) {
+				if s.rejectAPIKey(rw, req.Header.Get("X-API-Key")) {
+					return
+				}
+				break
+			}
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
+
+func (s *Server) getAPIKeys(rw http.ResponseWriter, req *http.Request) {
+	writeJSON(rw, http.StatusOK, s.apiKeyState(time.Now()))
+}
@@ -0,0 +1,85 @@
+package slowproxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckAPIKey(t *testing.T) {
+	s := newTestServer(t, WithOptions(Options{APIKeyRotate: time.Hour, APIKeyOverlap: time.Minute}))
+	at := func(d time.Duration) time.Time { return s.started.Add(d) }
+	tests := []struct {
+		name        string
+		key         string
+		now         time.Time
+		wantOK      bool
+		wantRotated bool
+	}{
+		{name: "current", key: s.apiKeyAt(0), now: at(time.Minute), wantOK: true},
+		{name: "next rotation", key: s.apiKeyAt(1), now: at(time.Hour), wantOK: true},
+		{name: "previous in the overlap", key: s.apiKeyAt(0), now: at(time.Hour + 30*time.Second), wantOK: true},
+		{name: "previous after the overlap", key: s.apiKeyAt(0), now: at(time.Hour + 2*time.Minute), wantRotated: true},
+		{name: "three rotations ago", key: s.apiKeyAt(0), now: at(3*time.Hour + 2*time.Minute), wantRotated: true},
+		{name: "long gone", key: s.apiKeyAt(0), now: at(4*time.Hour + 2*time.Minute)},
+		{name: "not yet", key: s.apiKeyAt(1), now: at(time.Minute)},
+		{name: "unknown", key: "sk_nope", now: at(time.Minute)},
+		{name: "missing", now: at(time.Minute)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, rotated := s.checkAPIKey(tt.key, tt.now)
+			if ok != tt.wantOK || rotated != tt.wantRotated {
+				t.Errorf("checkAPIKey() = %v, %v, want %v, %v", ok, rotated, tt.wantOK, tt.wantRotated)
+			}
+		})
+	}
+}
+
+func TestAPIKeyState(t *testing.T) {
+	s := newTestServer(t)
+	st := s.apiKeyState(s.started.Add(time.Hour + 30*time.Second))
+	if st.Current != s.apiKeyAt(1) || st.Previous != s.apiKeyAt(0) || !st.RotatesAt.Equal(s.started.Add(2*time.Hour)) {
+		t.Errorf("in the overlap = %+v", st)
+	}
+	if st := s.apiKeyState(s.started.Add(time.Hour + 2*time.Minute)); st.Previous != "" {
+		t.Errorf("after the overlap previous = %q, want none", st.Previous)
+	}
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	s := newTestServer(t, WithOptions(Options{APIKeyPaths: []string{"/echo"}}))
+	rec := serve(s, httptest.NewRequest("GET", "/admin/apikeys", nil))
+	var st APIKeyState
+	if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil || st.Current == "" {
+		t.Fatalf("/admin/apikeys = %d %s", rec.Code, rec.Body)
+	}
+	tests := []struct {
+		name     string
+		path     string
+		key      string
+		want     int
+		wantCode string
+	}{
+		{name: "route", path: "/auth/apikey", key: st.Current, want: http.StatusOK},
+		{name: "route without a key", path: "/auth/apikey", want: http.StatusUnauthorized, wantCode: "invalid_api_key"},
+		{name: "gated path", path: "/echo", key: st.Current, want: http.StatusOK},
+		{name: "gated path with a wrong key", path: "/echo", key: "sk_nope", want: http.StatusUnauthorized, wantCode: "invalid_api_key"},
+		{name: "ungated path", path: "/status/200", want: http.StatusOK},
+		{name: "admin", path: "/admin/apikeys", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.key != "" {
+				req.Header.Set("X-API-Key", tt.key)
+			}
+			rec := serve(s, req)
+			if rec.Code != tt.want || errorCode(rec) != tt.wantCode {
+				t.Errorf("= %d %q, want %d %q", rec.Code, errorCode(rec), tt.want, tt.wantCode)
+			}
+		})
+	}
+}
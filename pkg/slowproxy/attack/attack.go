@@ -0,0 +1,247 @@
+// Package attack generates HTTP load and summarizes how the target coped,
+// for resilience demos against slow-proxy from the same binary.
+package attack
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options describes the load. With Rate zero every worker sends its next
+// request as soon as the previous one is done.
+type Options struct {
+	Target      string
+	Method      string
+	Header      http.Header
+	BodySize    int
+	Rate        float64
+	Duration    time.Duration
+	Concurrency int
+	Timeout     time.Duration
+	Client      *http.Client
+}
+
+// Result is the outcome of one request.
+type Result struct {
+	Status  int
+	Latency time.Duration
+	Bytes   int64
+	Err     error
+}
+
+// Report summarizes an attack.
+type Report struct {
+	Requests  int              `json:"requests"`
+	Success   int              `json:"success"`
+	Errors    map[string]int   `json:"errors,omitempty"`
+	Statuses  map[int]int      `json:"statuses"`
+	Skipped   int              `json:"skipped"`
+	Bytes     int64            `json:"bytes"`
+	Elapsed   time.Duration    `json:"elapsed_ns"`
+	Rate      float64          `json:"rate"`
+	Latencies map[string]int64 `json:"latency_us"`
+}
+
+// Run sends requests until the duration is over or ctx is done, and waits
+// for the last ones to finish.
+func Run(ctx context.Context, opts Options) (*Report, error) {
+	if opts.Target == "" {
+		return nil, errors.New("no target")
+	}
+	if opts.Method == "" {
+		opts.Method = http.MethodGet
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 10
+	}
+	if opts.Rate < 0 || opts.BodySize < 0 {
+		return nil, errors.New("rate and body size must not be negative")
+	}
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{
+			Timeout: opts.Timeout,
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost: opts.Concurrency,
+				DisableCompression:  true,
+			},
+		}
+	}
+	body := bytes.Repeat([]byte("x"), opts.BodySize)
+	if opts.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Duration)
+		defer cancel()
+	}
+
+	results := make(chan Result, opts.Concurrency)
+	report := &Report{Errors: map[string]int{}, Statuses: map[int]int{}}
+	var latencies []time.Duration
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		for r := range results {
+			report.add(r)
+			latencies = append(latencies, r.Latency)
+		}
+	}()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	if opts.Rate == 0 {
+		for range opts.Concurrency {
+			wg.Go(func() {
+				for ctx.Err() == nil {
+					send(ctx, client, opts, body, results)
+				}
+			})
+		}
+	} else {
+		slots := make(chan struct{}, opts.Concurrency)
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.Rate))
+	loop:
+		for {
+			select {
+			case <-ctx.Done():
+				break loop
+			case <-ticker.C:
+			}
+			select {
+			case slots <- struct{}{}:
+			default:
+				// every worker is busy, the target has fallen behind the rate
+				report.Skipped++
+				continue
+			}
+			wg.Go(func() {
+				send(ctx, client, opts, body, results)
+				<-slots
+			})
+		}
+		ticker.Stop()
+	}
+	wg.Wait()
+	close(results)
+	<-collected
+	report.Elapsed = time.Since(start)
+	report.Rate = float64(report.Requests) / report.Elapsed.Seconds()
+	report.Latencies = percentiles(latencies)
+	return report, nil
+}
+
+// send issues one request and reports it unless the end of the attack cut
+// it short.
+func send(ctx context.Context, client *http.Client, opts Options, body []byte, results chan<- Result) {
+	if res := do(ctx, client, opts, body); res.Err == nil || ctx.Err() == nil {
+		results <- res
+	}
+}
+
+func do(ctx context.Context, client *http.Client, opts Options, body []byte) Result {
+	req, err := http.NewRequestWithContext(ctx, opts.Method, opts.Target, bytes.NewReader(body))
+	if err != nil {
+		return Result{Err: err}
+	}
+	for k, v := range opts.Header {
+		req.Header[k] = v
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return Result{Latency: time.Since(start), Err: err}
+	}
+	n, err := io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return Result{Status: resp.StatusCode, Latency: time.Since(start), Bytes: n, Err: err}
+}
+
+func (r *Report) add(res Result) {
+	r.Requests++
+	r.Bytes += res.Bytes
+	if res.Status != 0 {
+		r.Statuses[res.Status]++
+	}
+	if res.Err != nil {
+		r.Errors[errorKind(res.Err)]++
+		return
+	}
+	if res.Status < 400 {
+		r.Success++
+	}
+}
+
+// errorKind groups transport errors into a few classes worth counting.
+func errorKind(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case strings.Contains(err.Error(), "connection refused"):
+		return "refused"
+	case strings.Contains(err.Error(), "connection reset"):
+		return "reset"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "eof"
+	}
+	return "other"
+}
+
+func percentiles(latencies []time.Duration) map[string]int64 {
+	out := map[string]int64{}
+	if len(latencies) == 0 {
+		return out
+	}
+	slices.Sort(latencies)
+	for _, p := range []float64{50, 90, 99} {
+		i := int(math.Ceil(p/100*float64(len(latencies)))) - 1
+		out[fmt.Sprintf("p%g", p)] = latencies[max(i, 0)].Microseconds()
+	}
+	out["min"] = latencies[0].Microseconds()
+	out["max"] = latencies[len(latencies)-1].Microseconds()
+	return out
+}
+
+// Print writes the report in a human readable form.
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "requests  %d in %s, %.1f/s\n", r.Requests, r.Elapsed.Round(time.Millisecond), r.Rate)
+	fmt.Fprintf(w, "success   %d (%.1f%%)\n", r.Success, 100*float64(r.Success)/float64(max(r.Requests, 1)))
+	if r.Skipped > 0 {
+		fmt.Fprintf(w, "skipped   %d, every worker was busy\n", r.Skipped)
+	}
+	fmt.Fprintf(w, "bytes     %d\n", r.Bytes)
+	if len(r.Latencies) > 0 {
+		fmt.Fprintf(w, "latency   min %s  p50 %s  p90 %s  p99 %s  max %s\n",
+			us(r.Latencies["min"]), us(r.Latencies["p50"]), us(r.Latencies["p90"]), us(r.Latencies["p99"]), us(r.Latencies["max"]))
+	}
+	var statuses []int
+	for s := range r.Statuses {
+		statuses = append(statuses, s)
+	}
+	sort.Ints(statuses)
+	for _, s := range statuses {
+		fmt.Fprintf(w, "status    %d  %d\n", s, r.Statuses[s])
+	}
+	var kinds []string
+	for k := range r.Errors {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	for _, k := range kinds {
+		fmt.Fprintf(w, "error     %-8s  %d\n", k, r.Errors[k])
+	}
+}
+
+func us(v int64) time.Duration {
+	return (time.Duration(v) * time.Microsecond).Round(10 * time.Microsecond)
+}
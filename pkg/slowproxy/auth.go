@@ -0,0 +1,60 @@
+package slowproxy
+
+import (
+	"crypto/subtle"
+	"github.com/gorilla/mux"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const authRealm = "slow-proxy"
+
+// basicAuth accepts only the credentials named in the path, after an
+// optional delay:
+//
+//	/auth/basic/{user}/{pass}?delay=2s
+func (s *Server) basicAuth(rw http.ResponseWriter, req *http.Request) {
+	if !s.pauseQuery(rw, req) {
+		return
+	}
+	vars := mux.Vars(req)
+	user, pass, ok := req.BasicAuth()
+	if !ok || !secureEqual(user, vars["user"]) || !secureEqual(pass, vars["pass"]) {
+		rw.Header().Set("WWW-Authenticate", `Basic realm="`+authRealm+`", charset="UTF-8"`)
+		writeAPIError(rw, http.StatusUnauthorized, &apiError{Code: "unauthorized", Message: "invalid basic credentials"})
+		return
+	}
+	writeJSON(rw, http.StatusOK, map[string]any{"authenticated": true, "user": user})
+}
+
+// bearerAuth accepts the configured BearerTokens and unexpired tokens issued
+// by /oauth/token, or any token when no BearerTokens are configured:
+//
+//	/auth/bearer?delay=2s
+func (s *Server) bearerAuth(rw http.ResponseWriter, req *http.Request) {
+	if !s.pauseQuery(rw, req) {
+		return
+	}
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	issued, valid := s.oauth.lookup(token)
+	switch {
+	case !ok || token == "":
+		rw.Header().Set("WWW-Authenticate", `Bearer realm="`+authRealm+`"`)
+		writeAPIError(rw, http.StatusUnauthorized, &apiError{Code: "unauthorized", Message: "missing bearer token"})
+		return
+	case issued && !valid:
+		rw.Header().Set("WWW-Authenticate", `Bearer realm="`+authRealm+`", error="invalid_token", error_description="token expired"`)
+		writeAPIError(rw, http.StatusUnauthorized, &apiError{Code: "invalid_token", Message: "bearer token expired"})
+		return
+	case !issued && len(s.opts.BearerTokens) > 0 && !slices.ContainsFunc(s.opts.BearerTokens, func(t string) bool { return secureEqual(t, token) }):
+		rw.Header().Set("WWW-Authenticate", `Bearer realm="`+authRealm+`", error="invalid_token", error_description="unknown token"`)
+		writeAPIError(rw, http.StatusUnauthorized, &apiError{Code: "invalid_token", Message: "unknown bearer token"})
+		return
+	}
+	writeJSON(rw, http.StatusOK, map[string]any{"authenticated": true, "token": token})
+}
+
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,75 @@
+package slowproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBasicAuth(t *testing.T) {
+	tests := []struct {
+		name       string
+		user, pass string
+		noAuth     bool
+		want       int
+	}{
+		{name: "valid", user: "alice", pass: "secret", want: http.StatusOK},
+		{name: "wrong password", user: "alice", pass: "guess", want: http.StatusUnauthorized},
+		{name: "wrong user", user: "bob", pass: "secret", want: http.StatusUnauthorized},
+		{name: "missing", noAuth: true, want: http.StatusUnauthorized},
+	}
+	s := newTestServer(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/auth/basic/alice/secret", nil)
+			if !tt.noAuth {
+				req.SetBasicAuth(tt.user, tt.pass)
+			}
+			rec := serve(s, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			challenge := rec.Header().Get("WWW-Authenticate")
+			if tt.want == http.StatusUnauthorized && !strings.HasPrefix(challenge, `Basic realm="slow-proxy"`) {
+				t.Errorf("WWW-Authenticate = %q, want a Basic challenge", challenge)
+			}
+		})
+	}
+}
+
+func TestBearerAuth(t *testing.T) {
+	tests := []struct {
+		name          string
+		tokens        []string
+		authorization string
+		want          int
+		wantCode      string
+	}{
+		{name: "any token", authorization: "Bearer abc", want: http.StatusOK},
+		{name: "configured token", tokens: []string{"t1", "t2"}, authorization: "Bearer t2", want: http.StatusOK},
+		{name: "unknown token", tokens: []string{"t1"}, authorization: "Bearer t3", want: http.StatusUnauthorized, wantCode: "invalid_token"},
+		{name: "missing", want: http.StatusUnauthorized, wantCode: "unauthorized"},
+		{name: "empty", authorization: "Bearer ", want: http.StatusUnauthorized, wantCode: "unauthorized"},
+		{name: "basic", authorization: "Basic YTpi", want: http.StatusUnauthorized, wantCode: "unauthorized"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, WithOptions(Options{BearerTokens: tt.tokens}))
+			req := httptest.NewRequest("GET", "/auth/bearer", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := serve(s, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if got := errorCode(rec); got != tt.wantCode {
+				t.Errorf("error code = %q, want %q", got, tt.wantCode)
+			}
+			if tt.want == http.StatusUnauthorized && !strings.HasPrefix(rec.Header().Get("WWW-Authenticate"), "Bearer ") {
+				t.Errorf("WWW-Authenticate = %q, want a Bearer challenge", rec.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
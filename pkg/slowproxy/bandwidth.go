@@ -0,0 +1,126 @@
+package slowproxy
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// leakyBucket paces the bytes sent to one client, shared by all of its
+// concurrent responses like a saturated egress link.
+type leakyBucket struct {
+	mu   sync.Mutex
+	next time.Time
+}
+
+// reserve books n bytes at rate and returns when they have drained.
+func (b *leakyBucket) reserve(n int, rate float64, now time.Time) time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.next.Before(now) {
+		b.next = now
+	}
+	b.next = b.next.Add(time.Duration(float64(n) / rate * float64(time.Second)))
+	return b.next
+}
+
+type bandwidthShaper struct {
+	rate    float64
+	mu      sync.Mutex
+	buckets map[string]*leakyBucket
+}
+
+func newBandwidthShaper(rate float64) *bandwidthShaper {
+	return &bandwidthShaper{rate: rate, buckets: map[string]*leakyBucket{}}
+}
+
+func (bs *bandwidthShaper) bucket(key string, now time.Time) *leakyBucket {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	b, ok := bs.buckets[key]
+	if !ok {
+		if len(bs.buckets) >= maxBuckets {
+			for k, old := range bs.buckets {
+				old.mu.Lock()
+				if old.next.Before(now) {
+					delete(bs.buckets, k)
+				}
+				old.mu.Unlock()
+			}
+		}
+		b = &leakyBucket{}
+		bs.buckets[key] = b
+	}
+	return b
+}
+
+// clientBandwidth limits the combined response throughput of each client to
+// ClientBandwidth, on every route but the admin API.
+func (s *Server) clientBandwidth(next http.Handler) http.Handler {
+	if s.shaper == nil {
+		return next
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/admin/") {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		next.ServeHTTP(&shapedWriter{
+			ResponseWriter: rw,
+			ctx:            req.Context(),
+			done:           s.ctx.Done(),
+			rate:           s.shaper.rate,
+			bucket:         s.shaper.bucket(clientKey(req), time.Now()),
+		}, req)
+	})
+}
+
+type shapedWriter struct {
+	http.ResponseWriter
+	ctx    context.Context
+	done   <-chan struct{}
+	rate   float64
+	bucket *leakyBucket
+}
+
+// Write sends b in slices of about 50ms worth of the rate, so concurrent
+// responses of the client interleave.
+func (w *shapedWriter) Write(b []byte) (int, error) {
+	chunk := min(max(int(w.rate/20), 1), 16<<10)
+	written := 0
+	for len(b) > 0 {
+		n := min(len(b), chunk)
+		timer := time.NewTimer(time.Until(w.bucket.reserve(n, w.rate, time.Now())))
+		select {
+		case <-w.ctx.Done():
+			timer.Stop()
+			return written, w.ctx.Err()
+		case <-w.done:
+			timer.Stop()
+			return written, ErrShuttingDown
+		case <-timer.C:
+		}
+		m, err := w.ResponseWriter.Write(b[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		if f, ok := w.ResponseWriter.(http.Flusher); ok {
+			f.Flush()
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+func (w *shapedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *shapedWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
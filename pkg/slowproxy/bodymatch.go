@@ -0,0 +1,334 @@
+package slowproxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"google.golang.org/protobuf/encoding/protowire"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxBodyRegexps bounds the cache of compiled body_regex patterns, which
+// come from the admin API, it starts over once full.
+const maxBodyRegexps = 1000
+
+var (
+	bodyRegexpsMu sync.Mutex
+	bodyRegexps   = map[string]*regexp.Regexp{}
+)
+
+// bodyRegexp compiles a body_regex once.
+func bodyRegexp(pattern string) (*regexp.Regexp, error) {
+	bodyRegexpsMu.Lock()
+	re, ok := bodyRegexps[pattern]
+	bodyRegexpsMu.Unlock()
+	if ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid body_regex: %w", err)
+	}
+	bodyRegexpsMu.Lock()
+	defer bodyRegexpsMu.Unlock()
+	if len(bodyRegexps) >= maxBodyRegexps {
+		clear(bodyRegexps)
+	}
+	bodyRegexps[pattern] = re
+	return re, nil
+}
+
+// peekBody reads up to maxEchoBody of the body of req and puts it back for
+// the handler.
+func peekBody(req *http.Request) []byte {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+	b, _ := io.ReadAll(io.LimitReader(req.Body, maxEchoBody))
+	req.Body = readCloser{io.MultiReader(bytes.NewReader(b), req.Body), req.Body}
+	return b
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// jsonPath splits a path like $.items[0].name into keys and indexes.
+func jsonPath(path string) ([]any, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	var steps []any
+	for _, part := range strings.Split(path, ".") {
+		name, rest, _ := strings.Cut(part, "[")
+		if name != "" {
+			steps = append(steps, name)
+		}
+		for rest != "" {
+			idx, after, ok := strings.Cut(rest, "]")
+			n, err := strconv.Atoi(idx)
+			if !ok || err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid json path %q", path)
+			}
+			steps = append(steps, n)
+			rest = strings.TrimPrefix(after, "[")
+		}
+		if name == "" && len(steps) == 0 {
+			return nil, fmt.Errorf("invalid json path %q", path)
+		}
+	}
+	return steps, nil
+}
+
+func lookupJSON(doc any, steps []any) (any, bool) {
+	for _, step := range steps {
+		switch s := step.(type) {
+		case string:
+			obj, ok := doc.(map[string]any)
+			if !ok {
+				return nil, false
+			}
+			if doc, ok = obj[s]; !ok {
+				return nil, false
+			}
+		case int:
+			arr, ok := doc.([]any)
+			if !ok || s >= len(arr) {
+				return nil, false
+			}
+			doc = arr[s]
+		}
+	}
+	return doc, true
+}
+
+// grpcPath splits a field number path like 3.2 into field numbers.
+func grpcPath(path string) ([]protowire.Number, error) {
+	var nums []protowire.Number
+	for _, part := range strings.Split(path, ".") {
+		n, err := strconv.ParseInt(part, 10, 32)
+		if err != nil || !protowire.Number(n).IsValid() {
+			return nil, fmt.Errorf("invalid grpc field path %q", path)
+		}
+		nums = append(nums, protowire.Number(n))
+	}
+	return nums, nil
+}
+
+// isGRPC reports whether req is a gRPC call.
+func isGRPC(req *http.Request) bool {
+	return strings.HasPrefix(req.Header.Get("Content-Type"), "application/grpc")
+}
+
+// peekGRPC reads the first message of a gRPC call and puts it back for the
+// handler, without waiting for the rest of a client stream. It returns nil
+// for compressed messages, which are never inspected.
+func peekGRPC(req *http.Request) []byte {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+	var read []byte
+	defer func() {
+		req.Body = readCloser{io.MultiReader(bytes.NewReader(read), req.Body), req.Body}
+	}()
+	prefix := make([]byte, 5)
+	n, err := io.ReadFull(req.Body, prefix)
+	read = prefix[:n]
+	if err != nil {
+		return nil
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > maxEchoBody {
+		return nil
+	}
+	msg := make([]byte, size)
+	n, err = io.ReadFull(req.Body, msg)
+	read = append(read, msg[:n]...)
+	if err != nil || prefix[0] != 0 {
+		return nil
+	}
+	return msg
+}
+
+// grpcValue is a field of a message decoded without its schema.
+type grpcValue struct {
+	typ   protowire.Type
+	num   uint64
+	bytes []byte
+}
+
+// grpcFields returns every value of the field at path in msg, where all but
+// the last field number name embedded messages.
+func grpcFields(msg []byte, path []protowire.Number) []grpcValue {
+	var found []grpcValue
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		if n < 0 {
+			return found
+		}
+		msg = msg[n:]
+		v := grpcValue{typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			v.num, n = protowire.ConsumeVarint(msg)
+		case protowire.Fixed32Type:
+			var u uint32
+			u, n = protowire.ConsumeFixed32(msg)
+			v.num = uint64(u)
+		case protowire.Fixed64Type:
+			v.num, n = protowire.ConsumeFixed64(msg)
+		case protowire.BytesType:
+			v.bytes, n = protowire.ConsumeBytes(msg)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, msg)
+		}
+		if n < 0 {
+			return found
+		}
+		msg = msg[n:]
+		if num != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			found = append(found, v)
+		} else if typ == protowire.BytesType {
+			found = append(found, grpcFields(v.bytes, path[1:])...)
+		}
+	}
+	return found
+}
+
+// matches compares v with a condition: strings match bytes fields, booleans
+// and integers varints, and numbers fixed width fields as integers or floats.
+func (v grpcValue) matches(want any) bool {
+	var f float64
+	switch w := want.(type) {
+	case string:
+		return v.typ == protowire.BytesType && string(v.bytes) == w
+	case bool:
+		return v.typ == protowire.VarintType && (v.num != 0) == w
+	case float64:
+		f = w
+	case int:
+		f = float64(w)
+	case int64:
+		f = float64(w)
+	case uint64:
+		f = float64(w)
+	default:
+		return false
+	}
+	switch v.typ {
+	case protowire.VarintType:
+		return float64(int64(v.num)) == f || float64(v.num) == f
+	case protowire.Fixed32Type:
+		return float64(int32(v.num)) == f || float64(uint32(v.num)) == f || float64(math.Float32frombits(uint32(v.num))) == f
+	case protowire.Fixed64Type:
+		return float64(int64(v.num)) == f || float64(v.num) == f || math.Float64frombits(v.num) == f
+	}
+	return false
+}
+
+// validateBody reports body conditions that can never match.
+func (m Matcher) validateBody() error {
+	if m.BodyRegex != "" {
+		if _, err := bodyRegexp(m.BodyRegex); err != nil {
+			return err
+		}
+	}
+	for path := range m.JSON {
+		if _, err := jsonPath(path); err != nil {
+			return err
+		}
+	}
+	for path, want := range m.GRPC {
+		if _, err := grpcPath(path); err != nil {
+			return err
+		}
+		switch want.(type) {
+		case string, bool, float64, int, int64, uint64:
+		default:
+			return fmt.Errorf("grpc field %s: want a string, a number or a boolean, not %T", path, want)
+		}
+	}
+	return nil
+}
+
+// inspectsBody reports whether m has conditions on the request body.
+func (m Matcher) inspectsBody() bool {
+	return m.BodyRegex != "" || len(m.JSON) > 0 || len(m.GRPC) > 0
+}
+
+// matchBody checks body_regex and the json and grpc conditions against req.
+// Only the first message of a gRPC call is inspected.
+func (m Matcher) matchBody(req *http.Request) bool {
+	if !m.inspectsBody() {
+		return true
+	}
+	grpc := isGRPC(req)
+	var body []byte
+	if grpc {
+		body = peekGRPC(req)
+	} else {
+		body = peekBody(req)
+	}
+	if m.BodyRegex != "" {
+		re, err := bodyRegexp(m.BodyRegex)
+		if err != nil || !re.Match(body) {
+			return false
+		}
+	}
+	if len(m.JSON) > 0 && (grpc || !m.matchJSON(body)) {
+		return false
+	}
+	if len(m.GRPC) > 0 && (!grpc || !m.matchGRPC(body)) {
+		return false
+	}
+	return true
+}
+
+func (m Matcher) matchJSON(body []byte) bool {
+	var doc any
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return false
+	}
+	for path, want := range m.JSON {
+		steps, err := jsonPath(path)
+		if err != nil {
+			return false
+		}
+		got, ok := lookupJSON(doc, steps)
+		if !ok {
+			return false
+		}
+		gotJSON, _ := json.Marshal(got)
+		wantJSON, _ := json.Marshal(want)
+		if !bytes.Equal(gotJSON, wantJSON) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m Matcher) matchGRPC(msg []byte) bool {
+	if msg == nil {
+		return false
+	}
+	for path, want := range m.GRPC {
+		nums, err := grpcPath(path)
+		if err != nil {
+			return false
+		}
+		if !slices.ContainsFunc(grpcFields(msg, nums), func(v grpcValue) bool { return v.matches(want) }) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,186 @@
+package slowproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	slowproxyv1 "github.com/cbosss/slow-proxy/proto/slowproxy/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// grpcFrame wraps m in the length prefixed framing of a gRPC request.
+func grpcFrame(t *testing.T, m proto.Message) []byte {
+	t.Helper()
+	b, err := proto.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(binary.BigEndian.AppendUint32([]byte{0}, uint32(len(b))), b...)
+}
+
+func TestMatchBody(t *testing.T) {
+	fail := &slowproxyv1.FailRequest{Code: 5, Message: "export", Delay: durationpb.New(2 * time.Second)}
+	tests := []struct {
+		name        string
+		match       Matcher
+		contentType string
+		body        string
+		want        bool
+	}{
+		{name: "regex", match: Matcher{BodyRegex: `"operation":\s*"export"`}, body: `{"operation": "export"}`, want: true},
+		{name: "regex miss", match: Matcher{BodyRegex: `export`}, body: `{"operation": "import"}`},
+		{name: "json", match: Matcher{JSON: map[string]any{"operation": "export"}}, body: `{"operation": "export"}`, want: true},
+		{name: "json path", match: Matcher{JSON: map[string]any{"$.items[1].n": 2.0}}, body: `{"items": [{"n": 1}, {"n": 2}]}`, want: true},
+		{name: "json object", match: Matcher{JSON: map[string]any{"a": map[string]any{"b": true}}}, body: `{"a": {"b": true}}`, want: true},
+		{name: "json miss", match: Matcher{JSON: map[string]any{"$.items[5].n": 2.0}}, body: `{"items": []}`},
+		{name: "json invalid", match: Matcher{JSON: map[string]any{"operation": "export"}}, body: `operation=export`},
+		{name: "no body", match: Matcher{BodyRegex: `.`}},
+		{name: "grpc string", match: Matcher{GRPC: map[string]any{"2": "export"}}, contentType: "application/grpc", want: true},
+		{name: "grpc number", match: Matcher{GRPC: map[string]any{"1": 5}}, contentType: "application/grpc+proto", want: true},
+		{name: "grpc nested", match: Matcher{GRPC: map[string]any{"3.1": 2.0, "2": "export"}}, contentType: "application/grpc", want: true},
+		{name: "grpc miss", match: Matcher{GRPC: map[string]any{"1": 4}}, contentType: "application/grpc"},
+		{name: "grpc absent field", match: Matcher{GRPC: map[string]any{"4": "x"}}, contentType: "application/grpc"},
+		{name: "grpc regex", match: Matcher{BodyRegex: `exp.rt`}, contentType: "application/grpc", want: true},
+		{name: "grpc json", match: Matcher{JSON: map[string]any{"operation": "export"}}, contentType: "application/grpc"},
+		{name: "grpc fields of plain request", match: Matcher{GRPC: map[string]any{"2": "export"}}, body: `{"2": "export"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.match.validateBody(); err != nil {
+				t.Fatal(err)
+			}
+			body := []byte(tt.body)
+			if tt.contentType != "" {
+				body = grpcFrame(t, fail)
+			}
+			req := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			if got := tt.match.Matches(req); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+			if rest, _ := io.ReadAll(req.Body); !bytes.Equal(rest, body) {
+				t.Errorf("handler reads %q after matching, want %q", rest, body)
+			}
+		})
+	}
+}
+
+func TestMatchBodyValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		match Matcher
+		want  string
+	}{
+		{name: "regex", match: Matcher{BodyRegex: "("}, want: "invalid body_regex"},
+		{name: "json path", match: Matcher{JSON: map[string]any{"$.items[x]": 1}}, want: "invalid json path"},
+		{name: "grpc path", match: Matcher{GRPC: map[string]any{"1.x": 1}}, want: "invalid grpc field path"},
+		{name: "grpc field zero", match: Matcher{GRPC: map[string]any{"0": 1}}, want: "invalid grpc field path"},
+		{name: "grpc value", match: Matcher{GRPC: map[string]any{"1": []any{1}}}, want: "want a string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.match.validateBody()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("validateBody() = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestMatchBodyFirstGRPCMessage matches a client stream on its first message
+// without waiting for the stream to end.
+func TestMatchBodyFirstGRPCMessage(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go pw.Write(grpcFrame(t, &slowproxyv1.FailRequest{Message: "export"}))
+	req := httptest.NewRequest("POST", "/", pr)
+	req.Header.Set("Content-Type", "application/grpc")
+	matched := make(chan bool)
+	go func() { matched <- Matcher{GRPC: map[string]any{"2": "export"}}.Matches(req) }()
+	select {
+	case got := <-matched:
+		if !got {
+			t.Error("Matches() = false, want true")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Matches() waited for the end of the stream")
+	}
+}
+
+func TestTransportLeavesRequestBody(t *testing.T) {
+	var sent []byte
+	base := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		sent, _ = io.ReadAll(req.Body)
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+	})
+	rt := NewTransport(base, Rule{Match: Matcher{JSON: map[string]any{"operation": "export"}}, Fault: Fault{Status: 503}})
+	const body = `{"operation": "import"}`
+	req := httptest.NewRequest("POST", "http://service.test/api", strings.NewReader(body))
+	req.RequestURI = ""
+	orig := req.Body
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if req.Body != orig {
+		t.Error("RoundTrip() replaced the body of the caller's request")
+	}
+	if string(sent) != body {
+		t.Errorf("sent %q, want %q", sent, body)
+	}
+}
+
+// TestProxyMatchesGRPCFields sends gRPC calls through a proxy whose upstream
+// is the gRPC service of another server.
+func TestProxyMatchesGRPCFields(t *testing.T) {
+	ctx := context.Background()
+	upstream := newTestServer(t, WithAddr("127.0.0.1:0"), WithGRPCAddr("127.0.0.1:0"))
+	if err := upstream.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	proxy := newTestServer(t, WithOptions(Options{Addr: "127.0.0.1:0", Upstream: "http://" + upstream.GRPCAddr()}), WithRules(Rule{
+		Match: Matcher{GRPC: map[string]any{"2": "export"}},
+		Fault: Fault{Status: http.StatusServiceUnavailable},
+	}))
+	if err := proxy.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := grpc.NewClient(proxy.Addr(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := slowproxyv1.NewSlowProxyClient(conn)
+
+	tests := []struct {
+		message string
+		want    codes.Code
+	}{
+		{message: "export", want: codes.Unavailable},
+		{message: "import", want: codes.NotFound}, // answered by the upstream
+	}
+	for _, tt := range tests {
+		t.Run(tt.message, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+			_, err := client.Fail(ctx, &slowproxyv1.FailRequest{Code: uint32(codes.NotFound), Message: tt.message})
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("Fail() = %v, want code %v", err, tt.want)
+			}
+		})
+	}
+}
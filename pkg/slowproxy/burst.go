@@ -0,0 +1,97 @@
+package slowproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"sync"
+	"time"
+)
+
+// Burst limits a rule to clusters of failures: the first Requests matching
+// requests of every Every window, aligned to the Unix epoch on the virtual
+// clock, get the fault and the rest of the window is left alone. Written as
+// {"requests": 30, "every": "5m"}.
+type Burst struct {
+	Requests int
+	Every    time.Duration
+
+	state *burstState
+}
+
+type burstState struct {
+	mu     sync.Mutex
+	window int64
+	used   int
+}
+
+// take reports whether the request at now is part of the current burst.
+func (b *Burst) take(now time.Time) bool {
+	if b.state == nil {
+		return false
+	}
+	window := now.UnixNano() / int64(b.Every)
+	b.state.mu.Lock()
+	defer b.state.mu.Unlock()
+	if window != b.state.window {
+		b.state.window, b.state.used = window, 0
+	}
+	if b.state.used >= b.Requests {
+		return false
+	}
+	b.state.used++
+	return true
+}
+
+// NewBurst returns a Burst of n requests every interval.
+func NewBurst(n int, every time.Duration) *Burst {
+	return &Burst{Requests: n, Every: every, state: &burstState{}}
+}
+
+func (b *Burst) validate() error {
+	if b.Requests <= 0 || b.Every <= 0 {
+		return fmt.Errorf("burst needs positive requests and every")
+	}
+	if b.state == nil {
+		return fmt.Errorf("burst not created with NewBurst")
+	}
+	return nil
+}
+
+type burstJSON struct {
+	Requests int    `json:"requests" yaml:"requests"`
+	Every    string `json:"every" yaml:"every"`
+}
+
+func (b *Burst) decode(v burstJSON) error {
+	every, err := time.ParseDuration(v.Every)
+	if err != nil {
+		return fmt.Errorf("invalid burst every: %w", err)
+	}
+	*b = *NewBurst(v.Requests, every)
+	return nil
+}
+
+func (b Burst) MarshalJSON() ([]byte, error) {
+	return json.Marshal(burstJSON{Requests: b.Requests, Every: b.Every.String()})
+}
+
+func (b *Burst) UnmarshalJSON(data []byte) error {
+	var v burstJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	return b.decode(v)
+}
+
+func (b Burst) MarshalYAML() (interface{}, error) {
+	return burstJSON{Requests: b.Requests, Every: b.Every.String()}, nil
+}
+
+func (b *Burst) UnmarshalYAML(node *yaml.Node) error {
+	var v burstJSON
+	if err := node.Decode(&v); err != nil {
+		return err
+	}
+	return b.decode(v)
+}
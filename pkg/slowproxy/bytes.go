@@ -0,0 +1,275 @@
+package slowproxy
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxBytes bounds the size /bytes generates.
+const maxBytes = 1 << 36
+
+// sharedBlockSize is the size of the read-only blocks fill=zero and
+// fill=repeat serve from, shared by every request.
+const sharedBlockSize = 1 << 20
+
+var (
+	zeroBlock   = make([]byte, sharedBlockSize)
+	repeatBlock = sync.OnceValue(func() []byte {
+		b := make([]byte, sharedBlockSize)
+		byteSource{}.fill(b, 0)
+		return b
+	})
+	byteBufs = sync.Pool{New: func() any { return new([32 << 10]byte) }}
+)
+
+// byteRange is an inclusive range of a resource.
+type byteRange struct {
+	start, end int64
+}
+
+func (r byteRange) length() int64 {
+	return r.end - r.start + 1
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.end, size)
+}
+
+var errUnsatisfiable = errors.New("range not satisfiable")
+
+// parseRanges parses a Range header against a resource of size bytes.
+// Ranges outside the resource are dropped, none left is errUnsatisfiable.
+func parseRanges(header string, size int64) ([]byteRange, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return nil, fmt.Errorf("unsupported range unit in %q", header)
+	}
+	var ranges []byteRange
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		first, last, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid range %q", part)
+		}
+		var r byteRange
+		switch {
+		case first == "":
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid suffix range %q", part)
+			}
+			r = byteRange{start: max(size-n, 0), end: size - 1}
+		default:
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, fmt.Errorf("invalid range %q", part)
+			}
+			end := size - 1
+			if last != "" {
+				if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+					return nil, fmt.Errorf("invalid range %q", part)
+				}
+			}
+			r = byteRange{start: start, end: min(end, size-1)}
+		}
+		if r.start < size {
+			ranges = append(ranges, r)
+		}
+	}
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiable
+	}
+	return ranges, nil
+}
+
+// byteSource generates the deterministic content of /bytes, so every
+// request and range of the same resource agrees, with an optional corrupted
+// stretch. A shared block, when set, is repeated instead of generating
+// anything, so large transfers cost no allocation or CPU per byte.
+type byteSource struct {
+	seed    uint64
+	corrupt *byteRange
+	shared  []byte
+}
+
+func splitmix(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// fill writes the bytes at offset off into b, a block of eight at a time.
+func (src byteSource) fill(b []byte, off int64) {
+	var block [8]byte
+	for i := 0; i < len(b); {
+		pos := off + int64(i)
+		binary.LittleEndian.PutUint64(block[:], splitmix(src.seed^uint64(pos/8)))
+		i += copy(b[i:], block[pos%8:])
+	}
+	src.flip(b, off)
+}
+
+// flip corrupts the part of b, at offset off, inside the corrupt range.
+func (src byteSource) flip(b []byte, off int64) {
+	c := src.corrupt
+	if c == nil || c.end < off || c.start >= off+int64(len(b)) {
+		return
+	}
+	for pos := max(c.start, off); pos <= min(c.end, off+int64(len(b))-1); pos++ {
+		b[pos-off] ^= 0xff
+	}
+}
+
+// writeRange copies r of the resource to w.
+func (src byteSource) writeRange(w io.Writer, r byteRange) error {
+	buf := byteBufs.Get().(*[32 << 10]byte)
+	defer byteBufs.Put(buf)
+	for off := r.start; off <= r.end; {
+		var chunk []byte
+		if src.shared != nil {
+			at := off % int64(len(src.shared))
+			chunk = src.shared[at : at+min(int64(len(src.shared))-at, r.end-off+1)]
+			if src.corrupt != nil && src.corrupt.start <= off+int64(len(chunk))-1 && src.corrupt.end >= off {
+				// corrupted stretches need a private copy
+				chunk = buf[:copy(buf[:], chunk)]
+				src.flip(chunk, off)
+			}
+		} else {
+			chunk = buf[:min(int64(len(buf)), r.end-off+1)]
+			src.fill(chunk, off)
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+		off += int64(len(chunk))
+	}
+	return nil
+}
+
+// serveBytes serves n deterministic bytes with Range support: single ranges as
+// 206, several as multipart/byteranges and unsatisfiable ones as 416.
+//
+//	/bytes/1048576?seed=7&range_delay=2s&corrupt=1000-1999
+//	/bytes/8589934592?fill=zero
+//
+// range_delay slows down only ranged responses, corrupt flips the bytes of
+// an absolute stretch of the resource wherever it is served. fill=zero and
+// fill=repeat (the first MiB of seed 0, repeated) serve from shared buffers
+// for multi-gigabyte transfers.
+func (s *Server) serveBytes(rw http.ResponseWriter, req *http.Request) {
+	size, err := strconv.ParseInt(mux.Vars(req)["n"], 10, 64)
+	if err != nil || size < 0 || size > maxBytes {
+		writeError(rw, http.StatusBadRequest, fmt.Errorf("size must be between 0 and %d", maxBytes))
+		return
+	}
+	seed, err := intQuery(req, "seed", 0)
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	rangeDelay, err := durationQuery(req, "range_delay", 0)
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	src := byteSource{seed: uint64(seed)}
+	switch fill := req.URL.Query().Get("fill"); fill {
+	case "":
+	case "zero":
+		src.shared = zeroBlock
+	case "repeat":
+		src.shared = repeatBlock()
+	default:
+		writeError(rw, http.StatusBadRequest, fmt.Errorf("unknown fill %q, want zero or repeat", fill))
+		return
+	}
+	if v := req.URL.Query().Get("corrupt"); v != "" {
+		ranges, err := parseRanges("bytes="+v, size)
+		if err != nil || len(ranges) != 1 {
+			writeError(rw, http.StatusBadRequest, fmt.Errorf("invalid corrupt range %q", v))
+			return
+		}
+		src.corrupt = &ranges[0]
+	}
+	if !s.pauseQuery(rw, req) {
+		return
+	}
+
+	h := rw.Header()
+	h.Set("Accept-Ranges", "bytes")
+	etag := fmt.Sprintf("bytes-%d-%d", size, seed)
+	if fill := req.URL.Query().Get("fill"); fill != "" {
+		etag += "-" + fill
+	}
+	h.Set("ETag", `"`+etag+`"`)
+	full := byteRange{start: 0, end: size - 1}
+
+	header := req.Header.Get("Range")
+	if header == "" || (req.Header.Get("If-Range") != "" && req.Header.Get("If-Range") != h.Get("ETag")) {
+		h.Set("Content-Type", "application/octet-stream")
+		h.Set("Content-Length", strconv.FormatInt(size, 10))
+		rw.WriteHeader(http.StatusOK)
+		if req.Method != http.MethodHead && size > 0 {
+			_ = src.writeRange(rw, full)
+		}
+		return
+	}
+
+	ranges, err := parseRanges(header, size)
+	if errors.Is(err, errUnsatisfiable) {
+		h.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		writeError(rw, http.StatusRequestedRangeNotSatisfiable, err)
+		return
+	}
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	if rangeDelay > 0 {
+		if err := s.Pause(req.Context(), rangeDelay, 0, nil); err != nil {
+			return
+		}
+	}
+
+	if len(ranges) == 1 {
+		r := ranges[0]
+		h.Set("Content-Type", "application/octet-stream")
+		h.Set("Content-Range", r.contentRange(size))
+		h.Set("Content-Length", strconv.FormatInt(r.length(), 10))
+		rw.WriteHeader(http.StatusPartialContent)
+		if req.Method != http.MethodHead {
+			_ = src.writeRange(rw, r)
+		}
+		return
+	}
+
+	mw := multipart.NewWriter(rw)
+	h.Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	rw.WriteHeader(http.StatusPartialContent)
+	if req.Method == http.MethodHead {
+		return
+	}
+	for _, r := range ranges {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":  {"application/octet-stream"},
+			"Content-Range": {r.contentRange(size)},
+		})
+		if err != nil {
+			return
+		}
+		if err := src.writeRange(part, r); err != nil {
+			return
+		}
+	}
+	_ = mw.Close()
+}
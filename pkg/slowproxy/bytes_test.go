@@ -0,0 +1,50 @@
+package slowproxy
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseRanges(t *testing.T) {
+	tests := []struct {
+		header  string
+		size    int64
+		want    []byteRange
+		wantErr error // errUnsatisfiable, or any error when errInvalid
+	}{
+		{header: "bytes=0-99", size: 1000, want: []byteRange{{0, 99}}},
+		{header: "bytes=500-", size: 1000, want: []byteRange{{500, 999}}},
+		{header: "bytes=-100", size: 1000, want: []byteRange{{900, 999}}},
+		{header: "bytes=-5000", size: 1000, want: []byteRange{{0, 999}}},
+		{header: "bytes=900-5000", size: 1000, want: []byteRange{{900, 999}}},
+		{header: "bytes=0-0, 10-19 ,-1", size: 1000, want: []byteRange{{0, 0}, {10, 19}, {999, 999}}},
+		{header: "bytes=0-9,2000-2999", size: 1000, want: []byteRange{{0, 9}}},
+		{header: "bytes=1000-", size: 1000, wantErr: errUnsatisfiable},
+		{header: "bytes=0-", size: 0, wantErr: errUnsatisfiable},
+		{header: "items=0-9", size: 1000, wantErr: errInvalid},
+		{header: "bytes=9-0", size: 1000, wantErr: errInvalid},
+		{header: "bytes=-0", size: 1000, wantErr: errInvalid},
+		{header: "bytes=a-b", size: 1000, wantErr: errInvalid},
+		{header: "bytes=10", size: 1000, wantErr: errInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			got, err := parseRanges(tt.header, tt.size)
+			switch {
+			case tt.wantErr == errInvalid:
+				if err == nil || errors.Is(err, errUnsatisfiable) {
+					t.Fatalf("parseRanges() error = %v, want a syntax error", err)
+				}
+			case !errors.Is(err, tt.wantErr):
+				t.Fatalf("parseRanges() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseRanges() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// errInvalid stands for any error but errUnsatisfiable in the tests.
+var errInvalid = errors.New("invalid")
@@ -0,0 +1,194 @@
+package slowproxy
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// originHits counts how often each cache URL reached the origin.
+type originHits struct {
+	mu   sync.Mutex
+	hits map[string]int64
+}
+
+func (h *originHits) add(key string) int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.hits == nil || len(h.hits) >= maxBuckets {
+		h.hits = map[string]int64{}
+	}
+	h.hits[key]++
+	return h.hits[key]
+}
+
+// cacheDirectives are the Cache-Control directives /cache copies from its
+// query, the first valuedDirectives take a number of seconds.
+const valuedDirectives = 4
+
+var cacheDirectives = []string{
+	"max-age", "s-maxage", "stale-while-revalidate", "stale-if-error",
+	"no-store", "no-cache", "private", "public", "must-revalidate", "proxy-revalidate", "no-transform", "immutable",
+}
+
+// cacheControl builds a Cache-Control value from the query of req.
+func cacheControl(req *http.Request) (string, error) {
+	q := req.URL.Query()
+	if raw := q.Get("cache_control"); raw != "" {
+		return raw, nil
+	}
+	var parts []string
+	for i, d := range cacheDirectives {
+		if !q.Has(d) {
+			continue
+		}
+		v := q.Get(d)
+		if i < valuedDirectives {
+			if _, err := strconv.Atoi(v); err != nil {
+				return "", fmt.Errorf("invalid %s: %q is not a number of seconds", d, v)
+			}
+			parts = append(parts, d+"="+v)
+			continue
+		}
+		parts = append(parts, d)
+	}
+	return strings.Join(parts, ", "), nil
+}
+
+// etagMatches implements the weak comparison If-None-Match uses.
+func etagMatches(header, etag string) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	bare := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == bare {
+			return true
+		}
+	}
+	return false
+}
+
+// etag serves a body tagged with the ETag from the path and answers matching
+// conditional requests with a 304:
+//
+//	/cache/etag/{tag}?weak=true&delay=1s&delay_304=2s&flip_percent=20
+//
+// delay_304 slows down only revalidations, flip_percent changes the tag of
+// that share of responses as if the resource had changed.
+func (s *Server) etag(rw http.ResponseWriter, req *http.Request) {
+	weak, err := boolQuery(req, "weak", false)
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	delay304, err := durationQuery(req, "delay_304", 0)
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	flip, err := intQuery(req, "flip_percent", 0)
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	if !s.pauseQuery(rw, req) {
+		return
+	}
+
+	tag := mux.Vars(req)["tag"]
+	if flip > 0 && s.rand.Intn(100) < flip {
+		tag = fmt.Sprintf("%s-%d", tag, s.rand.Int63())
+	}
+	etag := `"` + tag + `"`
+	if weak {
+		etag = "W/" + etag
+	}
+	rw.Header().Set("ETag", etag)
+
+	if inm := req.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, etag) {
+		if delay304 > 0 {
+			if err := s.Pause(req.Context(), delay304, 0, nil); err != nil {
+				return
+			}
+		}
+		rw.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(rw, http.StatusOK, map[string]string{"etag": etag})
+}
+
+// cache answers, after an optional delay, with the caching headers described
+// by its query and counts the requests that actually reached it:
+//
+//	/cache?max-age=60&s-maxage=300&public&delay=2s
+//	/cache?no-store
+//	/cache?cache_control=private,max-age=5&expires=Wed,%2021%20Oct%202015%2007:28:00%20GMT
+//
+// X-Origin-Hits is the number of hits for the same URL, so a test can tell
+// whether a cache in front answered or forwarded.
+func (s *Server) cache(rw http.ResponseWriter, req *http.Request) {
+	cc, err := cacheControl(req)
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	hits := s.originHits.add(req.URL.RequestURI())
+	if !s.pauseQuery(rw, req) {
+		return
+	}
+	h := rw.Header()
+	if cc != "" {
+		h.Set("Cache-Control", cc)
+	}
+	if v := req.URL.Query().Get("expires"); v != "" {
+		h.Set("Expires", v)
+	}
+	h.Set("X-Origin-Hits", strconv.FormatInt(hits, 10))
+	writeJSON(rw, http.StatusOK, map[string]any{"cache_control": cc, "origin_hits": hits})
+}
+
+// modified serves a resource that last changed at the start of the current
+// every= interval of the virtual clock, or at the fixed modified= time, and
+// answers If-Modified-Since with a 304 while it has not changed since:
+//
+//	/cache/modified?every=10m&delay=1s
+//	/cache/modified?modified=2026-01-01T00:00:00Z
+//
+// Date is taken from the same clock, so skewing or freezing it via
+// /admin/clock drives revalidation deterministically.
+func (s *Server) modified(rw http.ResponseWriter, req *http.Request) {
+	every, err := durationQuery(req, "every", time.Hour)
+	if err == nil && every <= 0 {
+		err = fmt.Errorf("invalid every: must be positive")
+	}
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	now := s.clock.now()
+	lastModified := now.Truncate(every)
+	if v := req.URL.Query().Get("modified"); v != "" {
+		if lastModified, err = time.Parse(time.RFC3339, v); err != nil {
+			writeError(rw, http.StatusBadRequest, fmt.Errorf("invalid modified: %w", err))
+			return
+		}
+	}
+	if !s.pauseQuery(rw, req) {
+		return
+	}
+
+	lastModified = lastModified.UTC().Truncate(time.Second)
+	h := rw.Header()
+	h.Set("Date", now.UTC().Format(http.TimeFormat))
+	h.Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	if ims, err := http.ParseTime(req.Header.Get("If-Modified-Since")); err == nil && !lastModified.After(ims) {
+		rw.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(rw, http.StatusOK, map[string]any{"now": now.UTC(), "last_modified": lastModified})
+}
@@ -0,0 +1,205 @@
+package slowproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestEtagMatches(t *testing.T) {
+	tests := []struct {
+		header string
+		etag   string
+		want   bool
+	}{
+		{header: `"v1"`, etag: `"v1"`, want: true},
+		{header: `"v0", "v1"`, etag: `"v1"`, want: true},
+		{header: `W/"v1"`, etag: `"v1"`, want: true},
+		{header: `"v1"`, etag: `W/"v1"`, want: true},
+		{header: `*`, etag: `"v1"`, want: true},
+		{header: `"v2"`, etag: `"v1"`},
+		{header: `v1`, etag: `"v1"`},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, tt.etag); got != tt.want {
+			t.Errorf("etagMatches(%q, %q) = %v, want %v", tt.header, tt.etag, got, tt.want)
+		}
+	}
+}
+
+func TestETag(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		ifNoneMatch string
+		want        int
+		wantETag    string
+		wantSlow    bool
+	}{
+		{name: "strong", want: http.StatusOK, wantETag: `"v1"`},
+		{name: "weak", query: "weak=true", want: http.StatusOK, wantETag: `W/"v1"`},
+		{name: "revalidated", ifNoneMatch: `"v1"`, want: http.StatusNotModified, wantETag: `"v1"`},
+		{name: "revalidated weakly", query: "weak=true", ifNoneMatch: `"v1"`, want: http.StatusNotModified, wantETag: `W/"v1"`},
+		{name: "changed", ifNoneMatch: `"v0"`, want: http.StatusOK, wantETag: `"v1"`},
+		{name: "slow revalidation", query: "delay_304=50ms", ifNoneMatch: `"v1"`, want: http.StatusNotModified, wantETag: `"v1"`, wantSlow: true},
+		{name: "fast full response", query: "delay_304=50ms", want: http.StatusOK, wantETag: `"v1"`},
+		{name: "flipped", query: "flip_percent=100", ifNoneMatch: `"v1"`, want: http.StatusOK},
+		{name: "invalid", query: "weak=maybe", want: http.StatusBadRequest},
+	}
+	s := newTestServer(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/cache/etag/v1?"+tt.query, nil)
+			if tt.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			start := time.Now()
+			rec := serve(s, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if got := rec.Header().Get("ETag"); tt.wantETag != "" && got != tt.wantETag {
+				t.Errorf("ETag = %s, want %s", got, tt.wantETag)
+			}
+			if slow := time.Since(start) >= 50*time.Millisecond; slow != tt.wantSlow {
+				t.Errorf("took %s", time.Since(start))
+			}
+		})
+	}
+}
+
+func TestCacheControl(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    string
+		wantErr bool
+	}{
+		{query: "max-age=60&public", want: "max-age=60, public"},
+		{query: "public&s-maxage=300&max-age=60&no-transform", want: "max-age=60, s-maxage=300, public, no-transform"},
+		{query: "no-store", want: "no-store"},
+		{query: "cache_control=private,max-age=5&public", want: "private,max-age=5"},
+		{query: "unknown=1"},
+		{query: "max-age=soon", wantErr: true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/cache?"+tt.query, nil)
+		got, err := cacheControl(req)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("cacheControl(%q) = %q, %v, want %q", tt.query, got, err, tt.want)
+		}
+	}
+}
+
+func TestCacheOriginHits(t *testing.T) {
+	s := newTestServer(t)
+	get := func(path string) *httptest.ResponseRecorder {
+		return serve(s, httptest.NewRequest("GET", path, nil))
+	}
+	for i, want := range []string{"1", "2", "3"} {
+		rec := get("/cache?max-age=60&expires=" + url.QueryEscape("Wed, 21 Oct 2015 07:28:00 GMT"))
+		if got := rec.Header().Get("X-Origin-Hits"); got != want {
+			t.Errorf("hit %d: X-Origin-Hits = %s, want %s", i, got, want)
+		}
+		if got := rec.Header().Get("Cache-Control"); got != "max-age=60" {
+			t.Errorf("Cache-Control = %q", got)
+		}
+		if got := rec.Header().Get("Expires"); got != "Wed, 21 Oct 2015 07:28:00 GMT" {
+			t.Errorf("Expires = %q", got)
+		}
+	}
+	if got := get("/cache?max-age=61").Header().Get("X-Origin-Hits"); got != "1" {
+		t.Errorf("another URL: X-Origin-Hits = %s, want 1", got)
+	}
+	if rec := get("/cache?max-age=x"); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid max-age = %d, want 400", rec.Code)
+	}
+}
+
+func TestModified(t *testing.T) {
+	s := newTestServer(t)
+	clock := func(query string) {
+		t.Helper()
+		if rec := serve(s, httptest.NewRequest("PUT", "/admin/clock?"+query, nil)); rec.Code != http.StatusOK {
+			t.Fatalf("PUT /admin/clock?%s = %d %s", query, rec.Code, rec.Body)
+		}
+	}
+	get := func(query, ims string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/cache/modified?"+query, nil)
+		if ims != "" {
+			req.Header.Set("If-Modified-Since", ims)
+		}
+		return serve(s, req)
+	}
+
+	clock("at=2026-01-01T00:30:00Z")
+	rec := get("every=1h", "")
+	if got := rec.Header().Get("Last-Modified"); got != "Thu, 01 Jan 2026 00:00:00 GMT" {
+		t.Errorf("Last-Modified = %s", got)
+	}
+	if got := rec.Header().Get("Date"); got != "Thu, 01 Jan 2026 00:30:00 GMT" {
+		t.Errorf("Date = %s", got)
+	}
+	lastModified := rec.Header().Get("Last-Modified")
+	if rec := get("every=1h", lastModified); rec.Code != http.StatusNotModified {
+		t.Errorf("unchanged = %d, want 304", rec.Code)
+	}
+	clock("advance=1h")
+	if rec := get("every=1h", lastModified); rec.Code != http.StatusOK || rec.Header().Get("Last-Modified") != "Thu, 01 Jan 2026 01:00:00 GMT" {
+		t.Errorf("changed = %d %s, want 200 modified at 01:00", rec.Code, rec.Header().Get("Last-Modified"))
+	}
+
+	if rec := get("modified=2025-06-01T12:00:00Z", "Sun, 01 Jun 2025 12:00:00 GMT"); rec.Code != http.StatusNotModified {
+		t.Errorf("fixed modified = %d, want 304", rec.Code)
+	}
+	for _, query := range []string{"every=0s", "every=x", "modified=yesterday"} {
+		if rec := get(query, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s = %d, want 400", query, rec.Code)
+		}
+	}
+}
+
+func TestStale(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		now       string
+		want      int
+		wantPhase string
+		wantCC    string
+		wantSlow  bool
+	}{
+		{name: "ok", now: "00:00:05", want: http.StatusOK, wantPhase: "ok", wantCC: "max-age=1, stale-while-revalidate=30, stale-if-error=300"},
+		{name: "slow", query: "slow=50ms", now: "00:00:15", want: http.StatusOK, wantPhase: "slow", wantCC: "max-age=1, stale-while-revalidate=30, stale-if-error=300", wantSlow: true},
+		{name: "error", now: "00:00:25", want: http.StatusInternalServerError, wantPhase: "error", wantCC: "no-store"},
+		{name: "next cycle", now: "00:00:35", want: http.StatusOK, wantPhase: "ok", wantCC: "max-age=1, stale-while-revalidate=30, stale-if-error=300"},
+		{name: "preset", query: "preset=swr", now: "00:00:05", want: http.StatusOK, wantPhase: "ok", wantCC: "max-age=1, stale-while-revalidate=30"},
+		{name: "cache control", query: "preset=sie&cache_control=max-age=2", now: "00:00:05", want: http.StatusOK, wantPhase: "ok", wantCC: "max-age=2"},
+		{name: "schedule", query: "schedule=error:1m,ok:1m", now: "00:00:35", want: http.StatusInternalServerError, wantPhase: "error", wantCC: "no-store"},
+		{name: "invalid preset", query: "preset=never", now: "00:00:05", want: http.StatusBadRequest},
+		{name: "invalid schedule", query: "schedule=ok:10s,down:10s", now: "00:00:05", want: http.StatusBadRequest},
+	}
+	s := newTestServer(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := serve(s, httptest.NewRequest("PUT", "/admin/clock?at=2026-01-01T"+tt.now+"Z", nil)); rec.Code != http.StatusOK {
+				t.Fatalf("PUT /admin/clock = %d", rec.Code)
+			}
+			start := time.Now()
+			rec := serve(s, httptest.NewRequest("GET", "/cache/stale?"+tt.query, nil))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if got := rec.Header().Get("X-Origin-Phase"); got != tt.wantPhase {
+				t.Errorf("X-Origin-Phase = %q, want %q", got, tt.wantPhase)
+			}
+			if got := rec.Header().Get("Cache-Control"); got != tt.wantCC {
+				t.Errorf("Cache-Control = %q, want %q", got, tt.wantCC)
+			}
+			if slow := time.Since(start) >= 50*time.Millisecond; slow != tt.wantSlow {
+				t.Errorf("took %s", time.Since(start))
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package slowproxy
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Capture records one request served by the server, so tests can assert on
+// what a client actually sent, e.g. how many times it retried.
+//
+// Delay is the total delay the request was configured to wait. When the client
+// went away before the response was done ClientGone is set and DelayProgress
+// tells how far through the delay it got, from 0 to 1.
+type Capture struct {
+	ID            int64         `json:"id"`
+	Time          time.Time     `json:"time"`
+	Method        string        `json:"method"`
+	Path          string        `json:"path"`
+	Query         string        `json:"query,omitempty"`
+	Header        http.Header   `json:"header"`
+	RemoteAddr    string        `json:"remote_addr"`
+	Status        int           `json:"status"`
+	Aborted       bool          `json:"aborted,omitempty"`
+	Duration      time.Duration `json:"duration"`
+	Delay         time.Duration `json:"delay,omitempty"`
+	Fault         bool          `json:"fault,omitempty"`
+	ClientGone    bool          `json:"client_gone,omitempty"`
+	DelayProgress float64       `json:"delay_progress,omitempty"`
+}
+
+type captureKey struct{}
+
+// noteDelay adds d to the delay recorded for the request behind ctx.
+func noteDelay(ctx context.Context, d time.Duration) {
+	if c, ok := ctx.Value(captureKey{}).(*Capture); ok {
+		c.Delay += d
+	}
+}
+
+// noteFault marks the request behind ctx as hit by an injected fault.
+func noteFault(ctx context.Context) {
+	if c, ok := ctx.Value(captureKey{}).(*Capture); ok {
+		c.Fault = true
+	}
+}
+
+// captureBuffer keeps the most recent captures in a fixed size ring, also
+// bounded by age and by their approximate size in bytes when set.
+type captureBuffer struct {
+	mu       sync.Mutex
+	nextID   int64
+	buf      []Capture
+	start    int
+	size     int
+	ttl      time.Duration
+	maxBytes int
+	bytes    int
+	evicted  *atomic.Int64
+}
+
+func newCaptureBuffer(limit int, ttl time.Duration, maxBytes int, evicted *atomic.Int64) *captureBuffer {
+	return &captureBuffer{buf: make([]Capture, limit), ttl: ttl, maxBytes: maxBytes, evicted: evicted}
+}
+
+// captureSize estimates the memory a capture holds on to.
+func captureSize(c *Capture) int {
+	n := 256 + len(c.Method) + len(c.Path) + len(c.Query) + len(c.RemoteAddr)
+	for k, vs := range c.Header {
+		n += len(k) + 16
+		for _, v := range vs {
+			n += len(v) + 16
+		}
+	}
+	return n
+}
+
+// evictLocked drops the oldest capture.
+func (b *captureBuffer) evictLocked() {
+	b.bytes -= captureSize(&b.buf[b.start])
+	b.buf[b.start] = Capture{}
+	b.start = (b.start + 1) % len(b.buf)
+	b.size--
+	b.evicted.Add(1)
+}
+
+// expireLocked drops the captures older than the TTL.
+func (b *captureBuffer) expireLocked(now time.Time) {
+	for b.ttl > 0 && b.size > 0 && now.Sub(b.buf[b.start].Time) > b.ttl {
+		b.evictLocked()
+	}
+}
+
+func (b *captureBuffer) add(c Capture) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.buf) == 0 {
+		return
+	}
+	b.nextID++
+	c.ID = b.nextID
+	b.expireLocked(time.Now())
+	n := captureSize(&c)
+	for b.size > 0 && (b.size == len(b.buf) || b.maxBytes > 0 && b.bytes+n > b.maxBytes) {
+		b.evictLocked()
+	}
+	b.buf[(b.start+b.size)%len(b.buf)] = c
+	b.size++
+	b.bytes += n
+}
+
+func (b *captureBuffer) list() []Capture {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expireLocked(time.Now())
+	out := make([]Capture, 0, b.size)
+	for i := 0; i < b.size; i++ {
+		out = append(out, b.buf[(b.start+i)%len(b.buf)])
+	}
+	return out
+}
+
+// usage reports the captures held and their approximate size.
+func (b *captureBuffer) usage() (int, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.size, b.bytes
+}
+
+func (b *captureBuffer) clear() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	clear(b.buf)
+	b.start, b.size, b.bytes = 0, 0, 0
+}
+
+// Captures returns the recorded requests, oldest first.
+func (s *Server) Captures() []Capture {
+	return s.captures.list()
+}
+
+// ClearCaptures forgets every recorded request.
+func (s *Server) ClearCaptures() {
+	s.captures.clear()
+}
+
+// statusRecorder remembers the final status written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 && status >= 200 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *Server) captureMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/admin/") {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		c := Capture{
+			Time:       time.Now(),
+			Method:     req.Method,
+			Path:       req.URL.Path,
+			Query:      req.URL.RawQuery,
+			Header:     req.Header.Clone(),
+			RemoteAddr: req.RemoteAddr,
+		}
+		w := &statusRecorder{ResponseWriter: rw}
+		now := s.clock.now()
+		phase := s.phase(now)
+		s.stats.requests.Add(1)
+		s.stats.inFlight.Add(1)
+		defer func() {
+			s.stats.inFlight.Add(-1)
+			c.Duration = time.Since(c.Time)
+			c.Status = w.status
+			if v := recover(); v != nil {
+				c.Aborted = true
+				s.stats.aborted.Add(1)
+				s.captures.add(c)
+				s.report.record(phase, now, &c)
+				panic(v)
+			}
+			// the server only cancels the context once the handler returned
+			if req.Context().Err() != nil {
+				s.clientGone(&c)
+			}
+			if c.Status == 0 {
+				c.Status = http.StatusOK
+			}
+			s.captures.add(c)
+			s.report.record(phase, now, &c)
+			if ce := s.logger.Check(zap.DebugLevel, "request"); ce != nil {
+				ce.Write(zap.String("method", c.Method), zap.String("path", c.Path), zap.Int("status", c.Status), zap.Duration("duration", c.Duration), zap.Duration("delay", c.Delay), zap.Bool("fault", c.Fault))
+			}
+		}()
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), captureKey{}, &c)))
+	})
+}
+
+func (s *Server) clientGone(c *Capture) {
+	c.ClientGone = true
+	if c.Delay > 0 {
+		c.DelayProgress = min(float64(c.Duration)/float64(c.Delay), 1)
+	}
+	s.stats.clientDisconnects.Add(1)
+	s.logger.Info("client disconnected",
+		zap.String("method", c.Method),
+		zap.String("path", c.Path),
+		zap.Duration("elapsed", c.Duration),
+		zap.Duration("delay", c.Delay),
+		zap.Float64("delay_progress", c.DelayProgress),
+	)
+}
+
+func (s *Server) getCaptures(rw http.ResponseWriter, req *http.Request) {
+	writeJSON(rw, http.StatusOK, s.captures.list())
+}
+
+func (s *Server) clearCaptures(rw http.ResponseWriter, req *http.Request) {
+	s.captures.clear()
+	rw.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,137 @@
+package slowproxy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// virtualClock is the time time-based caching endpoints, schedules and
+// scenarios see, it runs offset from the wall clock, speed times as fast
+// since anchor, or stands still while frozen.
+type virtualClock struct {
+	mu     sync.Mutex
+	offset time.Duration
+	frozen time.Time
+	speed  float64
+	anchor time.Time
+}
+
+func (c *virtualClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nowLocked()
+}
+
+func (c *virtualClock) nowLocked() time.Time {
+	if !c.frozen.IsZero() {
+		return c.frozen
+	}
+	now := time.Now()
+	if c.speed > 0 && c.speed != 1 {
+		now = c.anchor.Add(time.Duration(float64(now.Sub(c.anchor)) * c.speed))
+	}
+	return now.Add(c.offset)
+}
+
+// setSpeedLocked changes the speed without a jump, folding the time gained
+// so far into the offset.
+func (c *virtualClock) setSpeedLocked(speed float64) {
+	now := time.Now()
+	if c.frozen.IsZero() {
+		c.offset = c.nowLocked().Sub(now)
+	}
+	c.speed, c.anchor = speed, now
+}
+
+// real returns how long d of virtual time takes on the wall clock.
+func (c *virtualClock) real(d time.Duration) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.speed <= 0 {
+		return d
+	}
+	return time.Duration(float64(d) / c.speed)
+}
+
+// ClockState is reported and changed by /admin/clock.
+type ClockState struct {
+	Now    time.Time `json:"now"`
+	Offset string    `json:"offset"`
+	Frozen bool      `json:"frozen"`
+	Speed  float64   `json:"speed"`
+}
+
+func (c *virtualClock) state() ClockState {
+	now := c.now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := ClockState{Now: now, Offset: c.offset.String(), Frozen: !c.frozen.IsZero(), Speed: c.speed}
+	if st.Speed == 0 {
+		st.Speed = 1
+	}
+	return st
+}
+
+func (s *Server) getClock(rw http.ResponseWriter, req *http.Request) {
+	writeJSON(rw, http.StatusOK, s.clock.state())
+}
+
+// setClock handles PUT /admin/clock?offset=-90s, ?freeze=true, ?at=<RFC 3339>,
+// ?advance=1h and ?speed=60. Freezing keeps the current virtual time, at sets
+// it and freezes, advance moves it and keeps it frozen if it was, speed makes
+// it run faster than the wall clock from now on.
+func (s *Server) setClock(rw http.ResponseWriter, req *http.Request) {
+	c := &s.clock
+	now := c.now()
+	c.mu.Lock()
+	err := func() error {
+		if v := req.URL.Query().Get("speed"); v != "" {
+			speed, err := strconv.ParseFloat(v, 64)
+			if err != nil || speed <= 0 {
+				return fmt.Errorf("speed %q, want a positive factor", v)
+			}
+			c.setSpeedLocked(speed)
+		}
+		offset, err := durationQuery(req, "offset", c.offset)
+		if err != nil {
+			return err
+		}
+		freeze, err := boolQuery(req, "freeze", !c.frozen.IsZero())
+		if err != nil {
+			return err
+		}
+		advance, err := durationQuery(req, "advance", 0)
+		if err != nil {
+			return err
+		}
+		if v := req.URL.Query().Get("at"); v != "" {
+			at, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return err
+			}
+			now, freeze = at, true
+		}
+		c.offset = offset
+		switch {
+		case freeze && c.frozen.IsZero(), freeze && req.URL.Query().Has("at"):
+			c.frozen = now
+		case !freeze:
+			c.frozen = time.Time{}
+		}
+		if !c.frozen.IsZero() {
+			c.frozen = c.frozen.Add(advance)
+		} else {
+			c.offset += advance
+		}
+		return nil
+	}()
+	c.mu.Unlock()
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	s.getClock(rw, req)
+}
@@ -0,0 +1,299 @@
+package slowproxy
+
+import (
+	"context"
+	"fmt"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// encodings are the supported content codings in order of preference.
+var encodings = []string{"br", "zstd", "gzip"}
+
+// Content-Encoding mismatches of ?encoding_mismatch=.
+const (
+	mismatchLie  = "lie"
+	mismatchHide = "hide"
+)
+
+// encoder is what the codings have in common.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+}
+
+func newEncoder(encoding string, w io.Writer) (encoder, error) {
+	switch encoding {
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "br":
+		return brotli.NewWriter(w), nil
+	case "zstd":
+		return zstd.NewWriter(w)
+	}
+	return nil, fmt.Errorf("unsupported encoding %q", encoding)
+}
+
+// negotiateEncoding picks the coding with the highest q-value in an
+// Accept-Encoding header, ties go to the order of encodings. It returns
+// "identity" when none is acceptable.
+func negotiateEncoding(header string) string {
+	q := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		weight := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				weight = f
+			}
+		}
+		q[coding] = weight
+	}
+	best, bestQ := "identity", 0.0
+	for _, e := range encodings {
+		w, ok := q[e]
+		if !ok {
+			w, ok = q["*"]
+		}
+		if ok && w > bestQ {
+			best, bestQ = e, w
+		}
+	}
+	return best
+}
+
+// compressed wraps a payload handler to encode its body according to
+// Accept-Encoding, or ?compress=gzip|br|zstd|identity:
+//
+//	/bytes/1048576?compress=br&compress_delay=50ms&compress_chunk=1024
+//
+// compress_delay waits between writes of compress_chunk encoded bytes
+// (default 4096), and the X-Uncompressed-Bytes and X-Compressed-Bytes
+// trailers report the sizes once the body is done.
+//
+// encoding_mismatch=lie declares the coding but sends identity bytes,
+// encoding_mismatch=hide encodes without declaring it, either from the start
+// or after mismatch_after body bytes:
+//
+//	/bytes/65536?encoding_mismatch=lie&mismatch_after=4096
+func (s *Server) compressed(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		mismatch := q.Get("encoding_mismatch")
+		if mismatch != "" && mismatch != mismatchLie && mismatch != mismatchHide {
+			writeError(rw, http.StatusBadRequest, fmt.Errorf("invalid encoding_mismatch %q: want lie or hide", mismatch))
+			return
+		}
+		encoding := q.Get("compress")
+		if encoding == "" {
+			encoding = negotiateEncoding(req.Header.Get("Accept-Encoding"))
+		}
+		if encoding == "identity" && mismatch != "" {
+			encoding = "gzip"
+		}
+		if encoding == "identity" || req.Method == http.MethodHead {
+			next(rw, req)
+			return
+		}
+		if !slices.Contains(encodings, encoding) {
+			writeError(rw, http.StatusBadRequest, fmt.Errorf("unsupported encoding %q", encoding))
+			return
+		}
+		delay, err := durationQuery(req, "compress_delay", 0)
+		if err != nil {
+			writeError(rw, http.StatusBadRequest, err)
+			return
+		}
+		after, err := intQuery(req, "mismatch_after", 0)
+		if err == nil && after < 0 {
+			err = fmt.Errorf("invalid mismatch_after: must not be negative")
+		}
+		if err != nil {
+			writeError(rw, http.StatusBadRequest, err)
+			return
+		}
+		chunk, err := intQuery(req, "compress_chunk", 4096)
+		if err == nil && chunk <= 0 {
+			err = fmt.Errorf("invalid compress_chunk: must be positive")
+		}
+		if err != nil {
+			writeError(rw, http.StatusBadRequest, err)
+			return
+		}
+
+		w := &compressWriter{
+			ResponseWriter: rw,
+			encoding:       encoding,
+			mismatch:       mismatch,
+			after:          int64(after),
+			wire:           &pacedWriter{rw: rw, ctx: req.Context(), pause: s.Pause, delay: delay, chunk: chunk},
+		}
+		next(w, req)
+		w.finish()
+	}
+}
+
+// pacedWriter sends the encoded stream in chunks, pausing between them.
+type pacedWriter struct {
+	rw    http.ResponseWriter
+	ctx   context.Context
+	pause func(ctx context.Context, d, interval time.Duration, tick func(time.Time) error) error
+	delay time.Duration
+	chunk int
+	n     int64
+}
+
+func (w *pacedWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		if w.n > 0 && w.delay > 0 {
+			if err := w.pause(w.ctx, w.delay, 0, nil); err != nil {
+				return written, err
+			}
+		}
+		n := min(len(b), w.chunk)
+		m, err := w.rw.Write(b[:n])
+		written += m
+		w.n += int64(m)
+		if err != nil {
+			return written, err
+		}
+		if w.delay > 0 {
+			if f, ok := w.rw.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// compressWriter encodes the body of a response. Partial, empty and already
+// encoded responses go out unchanged.
+//
+// With a mismatch the first after bytes of the body agree with the headers,
+// then it flips: a lie declares the coding but stops encoding, a hidden
+// coding is not declared but starts encoding.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	mismatch    string
+	after       int64
+	wire        *pacedWriter
+	enc         encoder
+	wroteHeader bool
+	passthrough bool
+	in          int64
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if status < 200 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	h := w.Header()
+	switch {
+	case status == http.StatusNoContent, status == http.StatusNotModified, status == http.StatusPartialContent:
+		w.passthrough = true
+	case h.Get("Content-Encoding") != "":
+		w.passthrough = true
+	default:
+		if w.mismatch != mismatchHide {
+			h.Set("Content-Encoding", w.encoding)
+		}
+		if w.mismatch == "" || w.mismatch == mismatchLie && w.after > 0 {
+			w.enc, _ = newEncoder(w.encoding, w.wire)
+		}
+		h.Add("Vary", "Accept-Encoding")
+		h.Del("Content-Length")
+		h.Set("Trailer", "X-Uncompressed-Bytes, X-Compressed-Bytes")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.passthrough {
+		return w.ResponseWriter.Write(b)
+	}
+	written := 0
+	if w.mismatch != "" && w.in < w.after && w.in+int64(len(b)) > w.after {
+		n, err := w.write(b[:w.after-w.in])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	if w.mismatch != "" && w.in == w.after && (w.after > 0 || w.enc == nil) {
+		w.flip()
+	}
+	n, err := w.write(b)
+	return written + n, err
+}
+
+func (w *compressWriter) write(b []byte) (int, error) {
+	w.in += int64(len(b))
+	if w.enc == nil {
+		return w.wire.Write(b)
+	}
+	return w.enc.Write(b)
+}
+
+// flip switches a mismatch over, once.
+func (w *compressWriter) flip() {
+	switch w.mismatch {
+	case mismatchLie:
+		if w.enc != nil {
+			_ = w.enc.Flush()
+		}
+		w.enc = nil
+	case mismatchHide:
+		w.enc, _ = newEncoder(w.encoding, w.wire)
+	}
+	w.mismatch = ""
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressWriter) Flush() {
+	if w.enc != nil {
+		_ = w.enc.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *compressWriter) finish() {
+	if w.passthrough || !w.wroteHeader {
+		return
+	}
+	if w.enc != nil {
+		if err := w.enc.Close(); err != nil {
+			return
+		}
+	}
+	h := w.Header()
+	h.Set("X-Uncompressed-Bytes", strconv.FormatInt(w.in, 10))
+	h.Set("X-Compressed-Bytes", strconv.FormatInt(w.wire.n, 10))
+}
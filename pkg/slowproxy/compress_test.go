@@ -0,0 +1,149 @@
+package slowproxy
+
+import (
+	"bytes"
+	"github.com/klauspost/compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{header: "", want: "identity"},
+		{header: "gzip", want: "gzip"},
+		{header: "gzip, deflate, br, zstd", want: "br"},
+		{header: "gzip;q=1.0, br;q=0.5", want: "gzip"},
+		{header: "GZIP, ZSTD", want: "zstd"},
+		{header: "*", want: "br"},
+		{header: "*;q=0.5, gzip", want: "gzip"},
+		{header: "br;q=0, gzip;q=0", want: "identity"},
+		{header: "deflate, compress", want: "identity"},
+		{header: "gzip;q=bogus, br;q=0.9", want: "gzip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			if got := negotiateEncoding(tt.header); got != tt.want {
+				t.Errorf("negotiateEncoding(%q) = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func gunzip(t *testing.T, b []byte, n int) []byte {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make([]byte, n)
+	if _, err := io.ReadFull(zr, out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestCompressMismatch(t *testing.T) {
+	payload := bytes.Repeat([]byte("slow-proxy "), 1000)
+	tests := []struct {
+		name         string
+		query        string
+		wantEncoding string
+		check        func(t *testing.T, body []byte)
+	}{
+		{
+			name:         "encoded",
+			query:        "compress=gzip",
+			wantEncoding: "gzip",
+			check: func(t *testing.T, body []byte) {
+				if got := gunzip(t, body, len(payload)); !bytes.Equal(got, payload) {
+					t.Error("body does not decode to the payload")
+				}
+			},
+		},
+		{
+			name:         "lie from the start",
+			query:        "compress=gzip&encoding_mismatch=lie",
+			wantEncoding: "gzip",
+			check: func(t *testing.T, body []byte) {
+				if !bytes.Equal(body, payload) {
+					t.Error("body is not the identity payload")
+				}
+			},
+		},
+		{
+			name:         "lie after 4096 bytes",
+			query:        "compress=gzip&encoding_mismatch=lie&mismatch_after=4096",
+			wantEncoding: "gzip",
+			check: func(t *testing.T, body []byte) {
+				tail := payload[4096:]
+				if !bytes.HasSuffix(body, tail) {
+					t.Fatal("body does not end with the identity rest of the payload")
+				}
+				if got := gunzip(t, body[:len(body)-len(tail)], 4096); !bytes.Equal(got, payload[:4096]) {
+					t.Error("head does not decode to the start of the payload")
+				}
+			},
+		},
+		{
+			name:  "hide from the start",
+			query: "compress=gzip&encoding_mismatch=hide",
+			check: func(t *testing.T, body []byte) {
+				if got := gunzip(t, body, len(payload)); !bytes.Equal(got, payload) {
+					t.Error("body does not decode to the payload")
+				}
+			},
+		},
+		{
+			name:  "hide after 4096 bytes",
+			query: "compress=gzip&encoding_mismatch=hide&mismatch_after=4096",
+			check: func(t *testing.T, body []byte) {
+				if !bytes.HasPrefix(body, payload[:4096]) {
+					t.Fatal("body does not start with the identity payload")
+				}
+				if got := gunzip(t, body[4096:], len(payload)-4096); !bytes.Equal(got, payload[4096:]) {
+					t.Error("rest does not decode to the rest of the payload")
+				}
+			},
+		},
+	}
+	s := newTestServer(t)
+	h := s.compressed(func(rw http.ResponseWriter, req *http.Request) {
+		// odd writes straddle the flip
+		for b := payload; len(b) > 0; b = b[min(len(b), 1000):] {
+			rw.Write(b[:min(len(b), 1000)])
+		}
+	})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h(rec, httptest.NewRequest("GET", "/bytes?"+tt.query, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			if got := rec.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Errorf("Content-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+			tt.check(t, rec.Body.Bytes())
+			if got := rec.Header().Get("X-Uncompressed-Bytes"); got != "11000" {
+				t.Errorf("X-Uncompressed-Bytes = %q, want 11000", got)
+			}
+		})
+	}
+}
+
+func TestCompressRejectsUnknownEncoding(t *testing.T) {
+	s := newTestServer(t)
+	h := s.compressed(func(rw http.ResponseWriter, req *http.Request) {
+		t.Error("handler called for an unsupported encoding")
+	})
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest("GET", "/bytes?compress=lzma", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+}
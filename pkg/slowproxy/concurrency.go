@@ -0,0 +1,72 @@
+package slowproxy
+
+import (
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// inFlightLimit caps concurrent requests. Beyond the cap up to queue requests
+// wait for a slot, at most timeout when it is set, and the rest get a 503.
+type inFlightLimit struct {
+	slots   chan struct{}
+	queue   int64
+	queued  atomic.Int64
+	timeout time.Duration
+}
+
+func newInFlightLimit(limit, queue int, timeout time.Duration) *inFlightLimit {
+	return &inFlightLimit{slots: make(chan struct{}, limit), queue: int64(queue), timeout: timeout}
+}
+
+// concurrencyLimit enforces MaxInFlight on every route but the admin API.
+// Requests that had to queue report the wait in X-Queue-Time.
+func (s *Server) concurrencyLimit(next http.Handler) http.Handler {
+	l := s.inFlight
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/admin/") {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			if l.queued.Add(1) > l.queue {
+				l.queued.Add(-1)
+				s.overloaded(rw, "in-flight limit reached")
+				return
+			}
+			start := time.Now()
+			var timeout <-chan time.Time
+			if l.timeout > 0 {
+				timer := time.NewTimer(l.timeout)
+				defer timer.Stop()
+				timeout = timer.C
+			}
+			select {
+			case l.slots <- struct{}{}:
+				l.queued.Add(-1)
+			case <-timeout:
+				l.queued.Add(-1)
+				s.overloaded(rw, "queue timeout")
+				return
+			case <-req.Context().Done():
+				l.queued.Add(-1)
+				return
+			}
+			rw.Header().Set("X-Queue-Time", time.Since(start).String())
+		}
+		defer func() { <-l.slots }()
+		next.ServeHTTP(rw, req)
+	})
+}
+
+func (s *Server) overloaded(rw http.ResponseWriter, reason string) {
+	s.logger.Info("rejecting request", zap.String("reason", reason))
+	writeAPIError(rw, http.StatusServiceUnavailable, &apiError{Code: "overloaded", Message: reason})
+}
@@ -0,0 +1,119 @@
+package slowproxy
+
+import (
+	"bytes"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+)
+
+// Config is the declarative form of a server loaded from a YAML (or JSON)
+// file, see LoadConfig.
+type Config struct {
+	Rules    []Rule           `json:"rules,omitempty" yaml:"rules,omitempty"`
+	Markov   *MarkovModel     `json:"markov,omitempty" yaml:"markov,omitempty"`
+	Groups   []FailureGroup   `json:"groups,omitempty" yaml:"groups,omitempty"`
+	Profiles []LatencyProfile `json:"profiles,omitempty" yaml:"profiles,omitempty"`
+	Probes   []Probe          `json:"probes,omitempty" yaml:"probes,omitempty"`
+	OpenAPI  *OpenAPIMock     `json:"openapi,omitempty" yaml:"openapi,omitempty"`
+	S3       *S3Mock          `json:"s3,omitempty" yaml:"s3,omitempty"`
+}
+
+// LoadConfig reads and validates a config file. Unknown fields are rejected
+// so typos don't silently disable a fault.
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cfg, err := ParseConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// ParseConfig decodes a config document.
+func ParseConfig(r io.Reader) (*Config, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if len(bytes.TrimSpace(data)) > 0 {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&cfg); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func (c *Config) validate() error {
+	for _, r := range c.Rules {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.Markov != nil {
+		if err := c.Markov.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, g := range c.Groups {
+		if err := g.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, p := range c.Profiles {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, p := range c.Probes {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.OpenAPI != nil {
+		if err := c.OpenAPI.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.S3 != nil {
+		if err := c.S3.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Options returns the server options described by the config.
+func (c *Config) Options() []Option {
+	opts := []Option{WithRules(c.Rules...)}
+	if c.Markov != nil {
+		opts = append(opts, WithMarkov(*c.Markov))
+	}
+	if len(c.Groups) > 0 {
+		opts = append(opts, WithGroups(c.Groups...))
+	}
+	if len(c.Profiles) > 0 {
+		opts = append(opts, WithProfiles(c.Profiles...))
+	}
+	if len(c.Probes) > 0 {
+		opts = append(opts, WithProbes(c.Probes...))
+	}
+	if c.OpenAPI != nil {
+		opts = append(opts, WithOpenAPI(*c.OpenAPI))
+	}
+	if c.S3 != nil {
+		opts = append(opts, WithS3(*c.S3))
+	}
+	return opts
+}
@@ -0,0 +1,216 @@
+package slowproxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type connInfoKey struct{}
+
+// connInfo is attached to the context of every request served on a connection.
+type connInfo struct {
+	requests    atomic.Int64
+	overIPLimit bool
+}
+
+func withConnInfo(ctx context.Context, _ net.Conn) context.Context {
+	return context.WithValue(ctx, connInfoKey{}, &connInfo{})
+}
+
+func connInfoFrom(ctx context.Context) *connInfo {
+	info, _ := ctx.Value(connInfoKey{}).(*connInfo)
+	return info
+}
+
+// connectionClose asks net/http to close the connection after the response
+// when requested with ?connection=close, for a percentage of responses, or
+// once a connection has served its maximum number of keep-alive requests.
+func (s *Server) connectionClose(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var served int64
+		if info := connInfoFrom(req.Context()); info != nil {
+			served = info.requests.Add(1)
+		}
+
+		switch {
+		case req.URL.Query().Get("connection") == "close":
+		case s.opts.ClosePercent > 0 && s.rand.Float64()*100 < s.opts.ClosePercent:
+		case s.opts.MaxKeepAliveRequests > 0 && served >= int64(s.opts.MaxKeepAliveRequests):
+		default:
+			next.ServeHTTP(rw, req)
+			return
+		}
+		rw.Header().Set("Connection", "close")
+		next.ServeHTTP(rw, req)
+	})
+}
+
+// http10 answers with an HTTP/1.0 status line when started with -http10 or
+// requested with ?proto=1.0. The connection is hijacked so the body is
+// delimited by closing it, as an HTTP/1.0 origin would.
+func (s *Server) http10(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if !s.opts.HTTP10 && req.URL.Query().Get("proto") != "1.0" {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		w := &http10Writer{rw: rw}
+		next.ServeHTTP(w, req)
+		w.close()
+	})
+}
+
+type http10Writer struct {
+	rw          http.ResponseWriter
+	wroteHeader bool
+	conn        net.Conn
+	brw         *bufio.ReadWriter
+}
+
+func (w *http10Writer) Header() http.Header {
+	return w.rw.Header()
+}
+
+func (w *http10Writer) WriteHeader(status int) {
+	// interim responses do not exist in HTTP/1.0
+	if w.wroteHeader || status < 200 {
+		return
+	}
+	w.wroteHeader = true
+
+	conn, brw, err := http.NewResponseController(w.rw).Hijack()
+	if err != nil {
+		// not hijackable (e.g. HTTP/2), answer normally
+		w.rw.WriteHeader(status)
+		return
+	}
+	w.conn, w.brw = conn, brw
+
+	h := w.rw.Header().Clone()
+	h.Del("Transfer-Encoding")
+	h.Set("Connection", "close")
+	if h.Get("Date") == "" {
+		h.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+	fmt.Fprintf(brw, "HTTP/1.0 %d %s\r\n", status, http.StatusText(status))
+	_ = h.Write(brw)
+	_, _ = brw.WriteString("\r\n")
+	_ = brw.Flush()
+}
+
+func (w *http10Writer) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.brw == nil {
+		return w.rw.Write(b)
+	}
+	return w.brw.Write(b)
+}
+
+func (w *http10Writer) Flush() {
+	if w.brw == nil {
+		if f, ok := w.rw.(http.Flusher); ok {
+			f.Flush()
+		}
+		return
+	}
+	_ = w.brw.Flush()
+}
+
+func (w *http10Writer) close() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.conn != nil {
+		_ = w.brw.Flush()
+		_ = w.conn.Close()
+	}
+}
+
+// idleListener tracks accepted connections so idle keep-alive connections can
+// be closed behind the client's back, see Server.connState.
+type idleListener struct {
+	net.Listener
+}
+
+func (l idleListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &idleConn{Conn: c}, nil
+}
+
+type idleConn struct {
+	net.Conn
+	stale atomic.Bool
+	mu    sync.Mutex
+	timer *time.Timer
+}
+
+// Read resets a stale connection as soon as the client writes to it again,
+// which is what a client reusing it after a silent close would observe.
+func (c *idleConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 && c.stale.Load() {
+		if tc, ok := c.Conn.(*net.TCPConn); ok {
+			_ = tc.SetLinger(0)
+		}
+		_ = c.Conn.Close()
+		return 0, net.ErrClosed
+	}
+	return n, err
+}
+
+func (s *Server) listener(ln net.Listener) net.Listener {
+	ln = s.limitAccepts(ln)
+	if s.opts.IdleClose > 0 {
+		ln = idleListener{Listener: ln}
+	}
+	if s.certs != nil {
+		ln = tls.NewListener(ln, s.tlsConfig())
+	}
+	return ln
+}
+
+func (s *Server) connState(c net.Conn, state http.ConnState) {
+	s.trackConn(c, state)
+	s.releaseConnSlot(state)
+	if state == http.StateClosed || state == http.StateHijacked {
+		s.ipLimits.releaseConn(c)
+	}
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	ic, ok := c.(*idleConn)
+	if !ok {
+		return
+	}
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	if ic.timer != nil {
+		ic.timer.Stop()
+		ic.timer = nil
+	}
+	if state != http.StateIdle {
+		return
+	}
+	ic.timer = time.AfterFunc(s.opts.IdleClose, func() {
+		if s.opts.IdleCloseSilent {
+			s.logger.Info("marking idle connection stale", zap.String("remote", c.RemoteAddr().String()))
+			ic.stale.Store(true)
+			return
+		}
+		s.logger.Info("closing idle connection", zap.String("remote", c.RemoteAddr().String()))
+		_ = ic.Conn.Close()
+	})
+}
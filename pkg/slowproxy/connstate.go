@@ -0,0 +1,133 @@
+package slowproxy
+
+import (
+	"cmp"
+	"crypto/tls"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// closedConnHistory is how many closed connections /admin/conns remembers.
+const closedConnHistory = 100
+
+// ConnRecord is the lifecycle of one client connection, with the time spent
+// in each state so far.
+type ConnRecord struct {
+	ID       int64            `json:"id"`
+	Remote   string           `json:"remote"`
+	Opened   time.Time        `json:"opened"`
+	State    string           `json:"state"`
+	Since    time.Time        `json:"since"`
+	Requests int              `json:"requests"`
+	Time     map[string]int64 `json:"time_ms"`
+	Closed   *time.Time       `json:"closed,omitempty"`
+}
+
+func (r *ConnRecord) enter(state string, now time.Time) time.Duration {
+	spent := now.Sub(r.Since)
+	r.Time[r.State] += spent.Milliseconds()
+	r.State, r.Since = state, now
+	return spent
+}
+
+// connTracker follows connections from http.Server.ConnState.
+type connTracker struct {
+	mu     sync.Mutex
+	nextID int64
+	open   map[net.Conn]*ConnRecord
+	closed []ConnRecord
+	opened int64
+}
+
+// track records a state change of c and returns the record along with how
+// long c spent in its previous state.
+func (t *connTracker) track(c net.Conn, state http.ConnState, now time.Time) (ConnRecord, string, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.open == nil {
+		t.open = map[net.Conn]*ConnRecord{}
+	}
+	r, ok := t.open[c]
+	if !ok {
+		t.nextID++
+		t.opened++
+		r = &ConnRecord{ID: t.nextID, Remote: c.RemoteAddr().String(), Opened: now, State: state.String(), Since: now, Time: map[string]int64{}}
+		t.open[c] = r
+		return *r, "", 0
+	}
+	prev := r.State
+	spent := r.enter(state.String(), now)
+	if state == http.StateActive {
+		r.Requests++
+	}
+	if state == http.StateClosed || state == http.StateHijacked {
+		delete(t.open, c)
+		closed := now
+		r.Closed = &closed
+		t.closed = append(t.closed, *r)
+		if len(t.closed) > closedConnHistory {
+			t.closed = t.closed[len(t.closed)-closedConnHistory:]
+		}
+	}
+	return *r, prev, spent
+}
+
+// ConnsState is reported by /admin/conns.
+type ConnsState struct {
+	Opened int64        `json:"opened"`
+	Open   []ConnRecord `json:"open"`
+	Closed []ConnRecord `json:"closed"`
+}
+
+func (t *connTracker) state(now time.Time) ConnsState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st := ConnsState{Opened: t.opened, Open: []ConnRecord{}, Closed: append([]ConnRecord{}, t.closed...)}
+	for _, r := range t.open {
+		cp := *r
+		cp.Time = map[string]int64{}
+		for k, v := range r.Time {
+			cp.Time[k] = v
+		}
+		cp.Time[cp.State] += now.Sub(cp.Since).Milliseconds()
+		st.Open = append(st.Open, cp)
+	}
+	return st
+}
+
+// trackConn follows the lifecycle of c, logging each change with -log-conns.
+func (s *Server) trackConn(c net.Conn, state http.ConnState) {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	r, prev, spent := s.conns.track(c, state, time.Now())
+	if !s.opts.LogConns {
+		return
+	}
+	fields := []zap.Field{zap.Int64("conn", r.ID), zap.String("remote", r.Remote), zap.String("state", state.String())}
+	if prev != "" {
+		fields = append(fields, zap.String("from", prev), zap.Duration("after", spent))
+	}
+	if r.Closed != nil {
+		fields = append(fields, zap.Duration("lifetime", r.Closed.Sub(r.Opened)), zap.Int("requests", r.Requests))
+	}
+	s.logger.Info("connection state", fields...)
+}
+
+// Conns returns the open connections, oldest first, and the recently closed
+// ones.
+func (s *Server) Conns() ConnsState {
+	st := s.conns.state(time.Now())
+	slices.SortFunc(st.Open, func(a, b ConnRecord) int { return cmp.Compare(a.ID, b.ID) })
+	return st
+}
+
+// getConns handles GET /admin/conns, the open and recently closed
+// connections.
+func (s *Server) getConns(rw http.ResponseWriter, req *http.Request) {
+	writeJSON(rw, http.StatusOK, s.Conns())
+}
@@ -0,0 +1,203 @@
+package slowproxy
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Controller changes a running server's faults without HTTP round-trips. It
+// backs the admin API and is safe for concurrent use from test goroutines.
+type Controller struct {
+	s *Server
+
+	mu         sync.Mutex
+	latency    map[string]time.Duration
+	ramps      map[string]*activeRamp
+	failNext   int
+	failStatus int
+}
+
+func newController(s *Server) *Controller {
+	return &Controller{s: s, latency: map[string]time.Duration{}, ramps: map[string]*activeRamp{}}
+}
+
+// Controller returns the server's fault controller.
+func (s *Server) Controller() *Controller {
+	return s.control
+}
+
+// SetLatency adds d to every request whose path starts with route, the
+// longest matching route wins. A zero duration removes the latency, and it
+// replaces a ramp of the route either way.
+func (c *Controller) SetLatency(route string, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clearRouteLocked(route)
+	if d > 0 {
+		c.latency[route] = d
+	}
+	c.s.logger.Info("set latency", zap.String("route", route), zap.Duration("latency", d))
+}
+
+// FailNext answers the next n requests with status, 503 when zero.
+func (c *Controller) FailNext(n, status int) error {
+	if status == 0 {
+		status = http.StatusServiceUnavailable
+	}
+	if status < 200 || status > 599 {
+		return fmt.Errorf("status %d out of range 200-599", status)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failNext, c.failStatus = n, status
+	c.s.logger.Info("failing next requests", zap.Int("requests", n), zap.Int("status", status))
+	return nil
+}
+
+// SetRules replaces the runtime rules.
+func (c *Controller) SetRules(rules ...Rule) error {
+	return c.s.rules.replace(rules)
+}
+
+// AddRule appends a runtime rule, matched after the existing ones.
+func (c *Controller) AddRule(rule Rule) error {
+	return c.s.rules.add(rule)
+}
+
+// Rules returns a copy of the runtime rules.
+func (c *Controller) Rules() []Rule {
+	return c.s.rules.list()
+}
+
+// Reset drops latencies, ramps and pending failures and restores the rules and
+// Markov model the server was started with.
+func (c *Controller) Reset() {
+	c.mu.Lock()
+	for route := range c.ramps {
+		c.clearRouteLocked(route)
+	}
+	c.latency = map[string]time.Duration{}
+	c.failNext, c.failStatus = 0, 0
+	c.mu.Unlock()
+	_ = c.s.rules.replace(c.s.initialRules)
+	_ = c.s.markov.set(c.s.initialMarkov, c.s.clock.now())
+	c.s.probes.mu.Lock()
+	for _, p := range c.s.probes.probes {
+		p.override = ProbeAuto
+	}
+	c.s.probes.mu.Unlock()
+	c.s.logger.Info("reset faults")
+}
+
+// ControlState is the controller state reported by the admin API.
+type ControlState struct {
+	Latency    map[string]string    `json:"latency"`
+	Ramps      map[string]RampState `json:"ramps,omitempty"`
+	FailNext   int                  `json:"fail_next"`
+	FailStatus int                  `json:"fail_status,omitempty"`
+	Rules      []Rule               `json:"rules"`
+}
+
+// State returns a snapshot of the controller state.
+func (c *Controller) State() ControlState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	latency := make(map[string]string, len(c.latency))
+	for route, d := range c.latency {
+		latency[route] = d.String()
+	}
+	var ramps map[string]RampState
+	if len(c.ramps) > 0 {
+		now := c.s.clock.now()
+		ramps = make(map[string]RampState, len(c.ramps))
+		for route, r := range c.ramps {
+			ramps[route] = r.state(now)
+		}
+	}
+	return ControlState{Latency: latency, Ramps: ramps, FailNext: c.failNext, FailStatus: c.failStatus, Rules: c.s.rules.list()}
+}
+
+// next consumes a pending failure and looks up the latency for path.
+func (c *Controller) next(path string) (status int, latency time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failNext > 0 {
+		c.failNext--
+		status = c.failStatus
+	}
+	best := -1
+	for route, d := range c.latency {
+		if strings.HasPrefix(path, route) && len(route) > best {
+			best, latency = len(route), d
+		}
+	}
+	for route, r := range c.ramps {
+		if strings.HasPrefix(path, route) && len(route) > best {
+			best, latency = len(route), r.at(r.progress(c.s.clock.now().Sub(r.start)))
+		}
+	}
+	return status, latency
+}
+
+func (s *Server) controlMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/admin/") {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		status, latency := s.control.next(req.URL.Path)
+		if latency > 0 {
+			if err := s.Pause(req.Context(), latency, 0, nil); err != nil {
+				return
+			}
+		}
+		if status != 0 {
+			http.Error(rw, http.StatusText(status), status)
+			return
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
+
+func (s *Server) getControl(rw http.ResponseWriter, req *http.Request) {
+	writeJSON(rw, http.StatusOK, s.control.State())
+}
+
+// setLatency handles PUT /admin/latency?route=/slow&delay=2s.
+func (s *Server) setLatency(rw http.ResponseWriter, req *http.Request) {
+	d, err := durationQuery(req, "delay", 0)
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	s.control.SetLatency(req.URL.Query().Get("route"), d)
+	s.getControl(rw, req)
+}
+
+// failNext handles POST /admin/fail-next?n=3&status=503.
+func (s *Server) failNext(rw http.ResponseWriter, req *http.Request) {
+	n, err := intQuery(req, "n", 1)
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	status, err := intQuery(req, "status", 0)
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.control.FailNext(n, status); err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	s.getControl(rw, req)
+}
+
+func (s *Server) reset(rw http.ResponseWriter, req *http.Request) {
+	s.control.Reset()
+	s.getControl(rw, req)
+}
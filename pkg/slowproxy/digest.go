@@ -0,0 +1,148 @@
+package slowproxy
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/gorilla/mux"
+	"hash"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var digestHashes = map[string]func() hash.Hash{
+	"MD5":     md5.New,
+	"SHA-256": sha256.New,
+}
+
+func digestHash(newHash func() hash.Hash, parts ...string) string {
+	h := newHash()
+	h.Write([]byte(strings.Join(parts, ":")))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// newNonce returns a stateless nonce: its issue time signed by the server.
+func (s *Server) newNonce(now time.Time) string {
+	b := binary.BigEndian.AppendUint64(nil, uint64(now.UnixNano()))
+	mac := hmac.New(sha256.New, s.digestKey)
+	mac.Write(b)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(b))
+}
+
+// checkNonce reports whether nonce was issued by this server and is still
+// within the DigestNonceTTL.
+func (s *Server) checkNonce(nonce string, now time.Time) (valid, stale bool) {
+	b, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(b) != 8+sha256.Size {
+		return false, false
+	}
+	mac := hmac.New(sha256.New, s.digestKey)
+	mac.Write(b[:8])
+	if !hmac.Equal(mac.Sum(nil), b[8:]) {
+		return false, false
+	}
+	issued := time.Unix(0, int64(binary.BigEndian.Uint64(b[:8])))
+	if now.Sub(issued) > s.opts.DigestNonceTTL {
+		return false, true
+	}
+	return true, false
+}
+
+// parseDigest splits the parameters of a Digest Authorization header.
+func parseDigest(header string) (map[string]string, bool) {
+	rest, ok := strings.CutPrefix(header, "Digest ")
+	if !ok {
+		return nil, false
+	}
+	params := map[string]string{}
+	for rest = strings.TrimSpace(rest); rest != ""; {
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			return nil, false
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimLeft(value, " ")
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				return nil, false
+			}
+			params[key], rest = value[1:end+1], value[end+2:]
+		} else {
+			v, r, _ := strings.Cut(value, ",")
+			params[key], rest = strings.TrimSpace(v), ","+r
+		}
+		rest = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(rest), ","))
+	}
+	return params, true
+}
+
+// digestAuth implements RFC 7616 Digest authentication for the credentials
+// in the path. Nonces expire after DigestNonceTTL and are then challenged
+// again with stale=true:
+//
+//	/auth/digest/{user}/{pass}?algorithm=SHA-256
+func (s *Server) digestAuth(rw http.ResponseWriter, req *http.Request) {
+	if !s.pauseQuery(rw, req) {
+		return
+	}
+	algorithm := req.URL.Query().Get("algorithm")
+	if algorithm == "" {
+		algorithm = "MD5"
+	}
+	newHash, ok := digestHashes[algorithm]
+	if !ok {
+		writeError(rw, http.StatusBadRequest, fmt.Errorf("unsupported algorithm %q, want MD5 or SHA-256", algorithm))
+		return
+	}
+	vars := mux.Vars(req)
+	now := time.Now()
+
+	challenge := func(stale bool, reason string) {
+		rw.Header().Set("WWW-Authenticate", fmt.Sprintf(`Digest realm="%s", qop="auth", algorithm=%s, nonce="%s", opaque="%s", stale=%t`,
+			authRealm, algorithm, s.newNonce(now), digestHash(md5.New, authRealm), stale))
+		writeAPIError(rw, http.StatusUnauthorized, &apiError{Code: "unauthorized", Message: reason})
+	}
+
+	p, ok := parseDigest(req.Header.Get("Authorization"))
+	if !ok {
+		challenge(false, "missing digest credentials")
+		return
+	}
+	if alg := p["algorithm"]; alg != "" && !strings.EqualFold(alg, algorithm) {
+		challenge(false, "unexpected algorithm")
+		return
+	}
+	if p["uri"] != req.RequestURI {
+		challenge(false, "digest uri does not match the request")
+		return
+	}
+	valid, stale := s.checkNonce(p["nonce"], now)
+	if !valid {
+		challenge(stale, "invalid or expired nonce")
+		return
+	}
+
+	ha1 := digestHash(newHash, vars["user"], authRealm, vars["pass"])
+	ha2 := digestHash(newHash, req.Method, p["uri"])
+	var want string
+	switch p["qop"] {
+	case "auth":
+		want = digestHash(newHash, ha1, p["nonce"], p["nc"], p["cnonce"], p["qop"], ha2)
+	case "":
+		want = digestHash(newHash, ha1, p["nonce"], ha2)
+	default:
+		challenge(false, "unsupported qop")
+		return
+	}
+	if p["username"] != vars["user"] || !secureEqual(p["response"], want) {
+		challenge(false, "invalid digest credentials")
+		return
+	}
+	writeJSON(rw, http.StatusOK, map[string]any{"authenticated": true, "user": p["username"]})
+}
@@ -0,0 +1,113 @@
+package slowproxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDigest(t *testing.T) {
+	tests := []struct {
+		header string
+		want   map[string]string
+	}{
+		{
+			header: `Digest username="alice", realm="slow-proxy", nonce="abc", uri="/x?a=1,2", qop=auth, nc=00000001`,
+			want:   map[string]string{"username": "alice", "realm": "slow-proxy", "nonce": "abc", "uri": "/x?a=1,2", "qop": "auth", "nc": "00000001"},
+		},
+		{header: `Digest Username = "bob"`, want: map[string]string{"username": "bob"}},
+		{header: `Digest nonce="unterminated`},
+		{header: `Digest novalue`},
+		{header: `Basic YTpi`},
+	}
+	for _, tt := range tests {
+		got, ok := parseDigest(tt.header)
+		if ok != (tt.want != nil) || ok && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseDigest(%q) = %v, %v, want %v", tt.header, got, ok, tt.want)
+		}
+	}
+}
+
+// digestAuthorization answers the challenge of rec as a client would.
+func digestAuthorization(t *testing.T, rec *httptest.ResponseRecorder, method, uri, user, pass, qop string) string {
+	t.Helper()
+	c, ok := parseDigest(rec.Header().Get("WWW-Authenticate"))
+	if !ok {
+		t.Fatalf("no digest challenge in %q", rec.Header().Get("WWW-Authenticate"))
+	}
+	newHash := digestHashes[c["algorithm"]]
+	ha1 := digestHash(newHash, user, c["realm"], pass)
+	ha2 := digestHash(newHash, method, uri)
+	if qop == "" {
+		return fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", algorithm=%s, response="%s"`,
+			user, c["realm"], c["nonce"], uri, c["algorithm"], digestHash(newHash, ha1, c["nonce"], ha2))
+	}
+	response := digestHash(newHash, ha1, c["nonce"], "00000001", "0a4f113b", qop, ha2)
+	return fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", algorithm=%s, qop=%s, nc=00000001, cnonce="0a4f113b", response="%s", opaque="%s"`,
+		user, c["realm"], c["nonce"], uri, c["algorithm"], qop, response, c["opaque"])
+}
+
+func TestDigestAuth(t *testing.T) {
+	tests := []struct {
+		name string
+		uri  string
+		pass string
+		qop  string
+		want int
+	}{
+		{name: "md5", uri: "/auth/digest/alice/secret", pass: "secret", qop: "auth", want: http.StatusOK},
+		{name: "sha-256", uri: "/auth/digest/alice/secret?algorithm=SHA-256", pass: "secret", qop: "auth", want: http.StatusOK},
+		{name: "rfc 2069", uri: "/auth/digest/alice/secret", pass: "secret", want: http.StatusOK},
+		{name: "wrong password", uri: "/auth/digest/alice/secret", pass: "guess", qop: "auth", want: http.StatusUnauthorized},
+		{name: "qop", uri: "/auth/digest/alice/secret", pass: "secret", qop: "auth-int", want: http.StatusUnauthorized},
+	}
+	s := newTestServer(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(s, httptest.NewRequest("GET", tt.uri, nil))
+			if rec.Code != http.StatusUnauthorized {
+				t.Fatalf("challenge status = %d, want 401", rec.Code)
+			}
+			req := httptest.NewRequest("GET", tt.uri, nil)
+			req.Header.Set("Authorization", digestAuthorization(t, rec, "GET", tt.uri, "alice", tt.pass, tt.qop))
+			if rec := serve(s, req); rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
+
+func TestDigestAuthRejects(t *testing.T) {
+	s := newTestServer(t, WithOptions(Options{DigestNonceTTL: 20 * time.Millisecond}))
+	const uri = "/auth/digest/alice/secret"
+	challenge := serve(s, httptest.NewRequest("GET", uri, nil))
+
+	req := httptest.NewRequest("GET", uri+"?other=1", nil)
+	req.Header.Set("Authorization", digestAuthorization(t, challenge, "GET", uri, "alice", "secret", "auth"))
+	if rec := serve(s, req); rec.Code != http.StatusUnauthorized {
+		t.Errorf("credentials for another uri = %d, want 401", rec.Code)
+	}
+
+	forged := strings.Replace(digestAuthorization(t, challenge, "GET", uri, "alice", "secret", "auth"), `nonce="`, `nonce="x`, 1)
+	req = httptest.NewRequest("GET", uri, nil)
+	req.Header.Set("Authorization", forged)
+	if rec := serve(s, req); rec.Code != http.StatusUnauthorized || strings.Contains(rec.Header().Get("WWW-Authenticate"), "stale=true") {
+		t.Errorf("forged nonce = %d %q, want a 401 without stale=true", rec.Code, rec.Header().Get("WWW-Authenticate"))
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	req = httptest.NewRequest("GET", uri, nil)
+	req.Header.Set("Authorization", digestAuthorization(t, challenge, "GET", uri, "alice", "secret", "auth"))
+	rec := serve(s, req)
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Header().Get("WWW-Authenticate"), "stale=true") {
+		t.Errorf("expired nonce = %d %q, want a 401 with stale=true", rec.Code, rec.Header().Get("WWW-Authenticate"))
+	}
+
+	if rec := serve(s, httptest.NewRequest("GET", uri+"?algorithm=SHA-512", nil)); rec.Code != http.StatusBadRequest {
+		t.Errorf("unsupported algorithm = %d, want 400", rec.Code)
+	}
+}
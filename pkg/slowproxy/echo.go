@@ -0,0 +1,78 @@
+package slowproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxEchoBody bounds how much of a request body is read back.
+const maxEchoBody = 10 << 20
+
+type echoResponse struct {
+	Method  string              `json:"method"`
+	URL     string              `json:"url"`
+	Path    string              `json:"path"`
+	Proto   string              `json:"proto"`
+	Host    string              `json:"host"`
+	Origin  string              `json:"origin"`
+	Args    url.Values          `json:"args"`
+	Headers http.Header         `json:"headers"`
+	Data    string              `json:"data"`
+	JSON    json.RawMessage     `json:"json,omitempty"`
+	Form    map[string][]string `json:"form,omitempty"`
+}
+
+// echo reports what the client sent as JSON, optionally after a delay:
+//
+//	/echo?delay=2s
+//	/anything/any/path
+func (s *Server) echo(rw http.ResponseWriter, req *http.Request) {
+	logger := s.logger.With(
+		zap.String("method", req.Method),
+		zap.String("url", req.URL.String()),
+	)
+
+	if !s.pauseQuery(rw, req) {
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxEchoBody+1))
+	if err != nil {
+		logger.With(zap.Error(err)).Error("failed to read body")
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	if len(body) > maxEchoBody {
+		writeError(rw, http.StatusRequestEntityTooLarge, fmt.Errorf("body larger than %d bytes", maxEchoBody))
+		return
+	}
+
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	resp := echoResponse{
+		Method:  req.Method,
+		URL:     scheme + "://" + req.Host + req.URL.RequestURI(),
+		Path:    req.URL.Path,
+		Proto:   req.Proto,
+		Host:    req.Host,
+		Origin:  req.RemoteAddr,
+		Args:    req.URL.Query(),
+		Headers: req.Header,
+		Data:    string(body),
+	}
+	if json.Valid(body) {
+		resp.JSON = body
+	} else if strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		if form, err := url.ParseQuery(string(body)); err == nil {
+			resp.Form = form
+		}
+	}
+	writeEcho(rw, s.negotiate(req, FormatJSON), resp)
+}
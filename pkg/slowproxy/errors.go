@@ -0,0 +1,54 @@
+package slowproxy
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// apiError is the structured error body returned by the routes.
+type apiError struct {
+	Code            string   `json:"code"`
+	Message         string   `json:"message"`
+	AcceptedFormats []string `json:"accepted_formats,omitempty"`
+	Max             string   `json:"max,omitempty"`
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+func writeAPIError(rw http.ResponseWriter, status int, err *apiError) {
+	writeJSON(rw, status, map[string]*apiError{"error": err})
+}
+
+var durationFormats = []string{"300ms", "1.5s", "2m", "1h30m"}
+
+// parseDelay validates a requested delay: a Go duration, positive and no
+// longer than the configured maximum.
+func (s *Server) parseDelay(v string) (time.Duration, *apiError) {
+	d, err := time.ParseDuration(v)
+	switch {
+	case err != nil:
+		return 0, &apiError{
+			Code:            "invalid_duration",
+			Message:         fmt.Sprintf("cannot parse duration %q", v),
+			AcceptedFormats: durationFormats,
+			Max:             s.opts.MaxDelay.String(),
+		}
+	case d <= 0:
+		return 0, &apiError{
+			Code:            "non_positive_duration",
+			Message:         fmt.Sprintf("duration %s must be positive", d),
+			AcceptedFormats: durationFormats,
+			Max:             s.opts.MaxDelay.String(),
+		}
+	case d > s.opts.MaxDelay:
+		return 0, &apiError{
+			Code:    "duration_too_long",
+			Message: fmt.Sprintf("duration %s exceeds the maximum of %s", d, s.opts.MaxDelay),
+			Max:     s.opts.MaxDelay.String(),
+		}
+	}
+	return d, nil
+}
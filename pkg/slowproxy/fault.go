@@ -0,0 +1,103 @@
+package slowproxy
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrShuttingDown is returned by Pause when the server context is done.
+var ErrShuttingDown = errors.New("server shutting down")
+
+// TraceLevel is below zap's debug level, for the per-tick logs of Pause that
+// would drown out everything else.
+const TraceLevel = zapcore.DebugLevel - 1
+
+// Pause is the delay engine shared by the HTTP and gRPC routes. It blocks for
+// d, calling tick (when non-nil) every interval, and returns early with the
+// context error, ErrShuttingDown or the tick error. Load shedding may shorten d.
+//
+// A single pooled timer serves both the ticks and the deadline, so tens of
+// thousands of paused requests cost one runtime timer each.
+func (s *Server) Pause(ctx context.Context, d, interval time.Duration, tick func(time.Time) error) error {
+	d = scaleDelay(ctx, d)
+	noteDelay(ctx, d)
+	start := time.Now()
+	deadline := start.Add(d)
+	var next time.Time
+	if tick != nil && interval > 0 {
+		next = start.Add(interval)
+	}
+	wake := func() time.Time {
+		if !next.IsZero() && !next.After(deadline) {
+			return next
+		}
+		return deadline
+	}
+	timer := getTimer(time.Until(wake()))
+	defer putTimer(timer)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.ctx.Done():
+			return ErrShuttingDown
+		case now := <-timer.C:
+			if next.IsZero() || next.After(deadline) {
+				return nil
+			}
+			if ce := s.logger.Check(TraceLevel, "tick"); ce != nil {
+				ce.Write(zap.Duration("elapsed", now.Sub(start)), zap.Duration("delay", d))
+			}
+			if err := tick(now); err != nil {
+				return err
+			}
+			// like a ticker, ticks missed by a slow tick func are dropped
+			for !next.After(now) {
+				next = next.Add(interval)
+			}
+			timer.Reset(time.Until(wake()))
+		}
+	}
+}
+
+var timers sync.Pool
+
+func getTimer(d time.Duration) *time.Timer {
+	if t, ok := timers.Get().(*time.Timer); ok {
+		t.Reset(d)
+		return t
+	}
+	return time.NewTimer(d)
+}
+
+// putTimer returns a timer to the pool, stopping it first. Since Go 1.23 a
+// stopped timer has nothing left in its channel.
+func putTimer(t *time.Timer) {
+	t.Stop()
+	timers.Put(t)
+}
+
+// pauseQuery waits for the optional ?delay= of req. It reports false when the
+// request should not be answered, after writing any error itself.
+func (s *Server) pauseQuery(rw http.ResponseWriter, req *http.Request) bool {
+	v := req.URL.Query().Get("delay")
+	if v == "" {
+		return true
+	}
+	d, apiErr := s.parseDelay(v)
+	if apiErr != nil {
+		writeAPIError(rw, http.StatusBadRequest, apiErr)
+		return false
+	}
+	if err := s.Pause(req.Context(), d, 0, nil); err != nil {
+		s.logger.With(zap.Error(err)).Info("delay interrupted", zap.String("path", req.URL.Path))
+		return false
+	}
+	return true
+}
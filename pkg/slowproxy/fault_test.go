@@ -0,0 +1,80 @@
+package slowproxy
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPause(t *testing.T) {
+	errStop := errors.New("stop")
+	tests := []struct {
+		name      string
+		d         time.Duration
+		interval  time.Duration
+		stopAfter int // ticks before the tick func fails, never when 0
+		cancel    bool
+		close     bool
+		wantErr   error
+		wantTicks int // at most, a late timer drops ticks
+	}{
+		{name: "delay", d: 30 * time.Millisecond},
+		{name: "zero", d: 0},
+		{name: "ticks", d: 55 * time.Millisecond, interval: 10 * time.Millisecond, wantTicks: 5},
+		{name: "tick error", d: time.Second, interval: 5 * time.Millisecond, stopAfter: 2, wantErr: errStop, wantTicks: 2},
+		{name: "context cancelled", d: time.Second, cancel: true, wantErr: context.Canceled},
+		{name: "server closed", d: time.Second, close: true, wantErr: ErrShuttingDown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				time.AfterFunc(10*time.Millisecond, cancel)
+			}
+			if tt.close {
+				time.AfterFunc(10*time.Millisecond, func() { s.Close() })
+			}
+			ticks := 0
+			var tick func(time.Time) error
+			if tt.interval > 0 {
+				tick = func(time.Time) error {
+					ticks++
+					if tt.stopAfter > 0 && ticks == tt.stopAfter {
+						return errStop
+					}
+					return nil
+				}
+			}
+			start := time.Now()
+			err := s.Pause(ctx, tt.d, tt.interval, tick)
+			elapsed := time.Since(start)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Pause() = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && elapsed < tt.d {
+				t.Errorf("Pause() returned after %s, want at least %s", elapsed, tt.d)
+			}
+			if tt.wantErr != nil && elapsed >= tt.d {
+				t.Errorf("Pause() returned after %s, want early", elapsed)
+			}
+			if ticks > tt.wantTicks || tt.wantTicks > 0 && ticks == 0 {
+				t.Errorf("ticks = %d, want 1 to %d", ticks, tt.wantTicks)
+			}
+		})
+	}
+}
+
+func TestPauseScaledByLoadShedding(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.WithValue(context.Background(), delayScaleKey{}, 0.0)
+	start := time.Now()
+	if err := s.Pause(ctx, time.Second, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Pause() with a zero scale took %s", elapsed)
+	}
+}
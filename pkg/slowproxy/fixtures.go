@@ -0,0 +1,126 @@
+package slowproxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/gorilla/mux"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// fixtureTypes covers extensions the mime package does not know.
+var fixtureTypes = map[string]string{
+	".pb":       "application/x-protobuf",
+	".protobuf": "application/x-protobuf",
+	".ndjson":   "application/x-ndjson",
+	".yaml":     "application/yaml",
+	".yml":      "application/yaml",
+}
+
+// fixture is one file of the fixtures directory, held in memory.
+type fixture struct {
+	data        []byte
+	contentType string
+	modTime     time.Time
+	etag        string
+}
+
+// FixtureInfo describes a loaded fixture.
+type FixtureInfo struct {
+	Name        string `json:"name"`
+	Size        int    `json:"size"`
+	ContentType string `json:"content_type"`
+	ETag        string `json:"etag"`
+}
+
+// loadFixtures reads every regular file below dir, keyed by its slash
+// separated path relative to dir.
+func loadFixtures(dir string) (map[string]*fixture, error) {
+	fixtures := map[string]*fixture{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		sum := sha256.Sum256(data)
+		fixtures[name] = &fixture{
+			data:        data,
+			contentType: fixtureType(name, data),
+			modTime:     info.ModTime(),
+			etag:        `"` + hex.EncodeToString(sum[:8]) + `"`,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load fixtures: %w", err)
+	}
+	return fixtures, nil
+}
+
+func fixtureType(name string, data []byte) string {
+	ext := path.Ext(name)
+	if t, ok := fixtureTypes[ext]; ok {
+		return t
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	return http.DetectContentType(data)
+}
+
+// Fixtures lists the loaded fixtures sorted by name.
+func (s *Server) Fixtures() []FixtureInfo {
+	infos := make([]FixtureInfo, 0, len(s.fixtures))
+	for name, f := range s.fixtures {
+		infos = append(infos, FixtureInfo{Name: name, Size: len(f.data), ContentType: f.contentType, ETag: f.etag})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// fixture serves a file of the fixtures directory from memory, after the
+// optional delay. Ranges and conditional requests are answered as for a
+// static file.
+//
+//	/fixture/users.json?delay=2s
+func (s *Server) fixture(rw http.ResponseWriter, req *http.Request) {
+	name := mux.Vars(req)["name"]
+	f, ok := s.fixtures[name]
+	if !ok {
+		writeError(rw, http.StatusNotFound, fmt.Errorf("no fixture %q", name))
+		return
+	}
+	if !s.pauseQuery(rw, req) {
+		return
+	}
+	rw.Header().Set("Content-Type", f.contentType)
+	rw.Header().Set("ETag", f.etag)
+	http.ServeContent(rw, req, name, f.modTime, bytes.NewReader(f.data))
+}
+
+// listFixtures reports the loaded fixtures.
+//
+//	/fixtures
+func (s *Server) listFixtures(rw http.ResponseWriter, req *http.Request) {
+	writeJSON(rw, http.StatusOK, s.Fixtures())
+}
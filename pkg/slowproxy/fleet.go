@@ -0,0 +1,332 @@
+package slowproxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FleetConfig describes several servers run in one process, like the zones
+// of a backend where one is fast, one slow and one down.
+type FleetConfig struct {
+	Admin     string          `json:"admin,omitempty" yaml:"admin,omitempty"`
+	Instances []FleetInstance `json:"instances" yaml:"instances"`
+}
+
+// FleetInstance is one server of a fleet. Its faults come from the config
+// file at Config, relative to the fleet file, and from the inline sections.
+type FleetInstance struct {
+	Name     string `json:"name" yaml:"name"`
+	Addr     string `json:"addr" yaml:"addr"`
+	GRPCAddr string `json:"grpc_addr,omitempty" yaml:"grpc_addr,omitempty"`
+	Config   string `json:"config,omitempty" yaml:"config,omitempty"`
+
+	Faults Config `json:",inline" yaml:",inline"`
+}
+
+// LoadFleetConfig reads and validates a fleet file, with the config files of
+// its instances.
+func LoadFleetConfig(path string) (*FleetConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg FleetConfig
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(cfg.Instances) == 0 {
+		return nil, fmt.Errorf("%s: no instances", path)
+	}
+	names := map[string]bool{}
+	for i := range cfg.Instances {
+		in := &cfg.Instances[i]
+		switch {
+		case in.Name == "":
+			return nil, fmt.Errorf("%s: instance %d has no name", path, i)
+		case strings.ContainsAny(in.Name, "/ "):
+			return nil, fmt.Errorf("%s: instance name %q has a slash or space", path, in.Name)
+		case names[in.Name]:
+			return nil, fmt.Errorf("%s: duplicate instance %q", path, in.Name)
+		case in.Addr == "":
+			return nil, fmt.Errorf("%s: instance %q has no addr", path, in.Name)
+		}
+		names[in.Name] = true
+		if err := in.Faults.validate(); err != nil {
+			return nil, fmt.Errorf("%s: instance %q: %w", path, in.Name, err)
+		}
+		if in.Config != "" {
+			if !filepath.IsAbs(in.Config) {
+				in.Config = filepath.Join(filepath.Dir(path), in.Config)
+			}
+			file, err := LoadConfig(in.Config)
+			if err != nil {
+				return nil, fmt.Errorf("%s: instance %q: %w", path, in.Name, err)
+			}
+			in.Faults.Rules = append(file.Rules, in.Faults.Rules...)
+			in.Faults.Groups = append(file.Groups, in.Faults.Groups...)
+			in.Faults.Profiles = append(file.Profiles, in.Faults.Profiles...)
+			if in.Faults.Markov == nil {
+				in.Faults.Markov = file.Markov
+			}
+		}
+	}
+	return &cfg, nil
+}
+
+// ErrFleetAdmin is returned by Fleet.Run when the shared admin API cannot
+// listen.
+var ErrFleetAdmin = errors.New("fleet admin")
+
+// Fleet supervises the servers of a FleetConfig: an instance whose listener
+// fails is restarted with backoff while the others keep serving. The shared
+// admin API lists every instance under /fleet and reaches each one's own
+// admin API under /fleet/{name}/admin/.
+type Fleet struct {
+	cfg     FleetConfig
+	logger  *zap.Logger
+	options []Option
+
+	mu        sync.Mutex
+	instances []*fleetMember
+	adminAddr string
+}
+
+type fleetMember struct {
+	spec     FleetInstance
+	srv      *Server
+	handler  http.Handler
+	state    string
+	restarts int
+	lastErr  string
+}
+
+// FleetMemberState is reported by GET /fleet.
+type FleetMemberState struct {
+	Name     string `json:"name"`
+	URL      string `json:"url,omitempty"`
+	GRPCAddr string `json:"grpc_addr,omitempty"`
+	State    string `json:"state"`
+	Restarts int    `json:"restarts"`
+	Error    string `json:"error,omitempty"`
+	Stats    Stats  `json:"stats"`
+}
+
+// NewFleet builds the servers of cfg. options apply to every instance
+// before its own address and faults.
+func NewFleet(cfg *FleetConfig, logger *zap.Logger, options ...Option) (*Fleet, error) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	f := &Fleet{cfg: *cfg, logger: logger, options: options}
+	for _, spec := range cfg.Instances {
+		m := &fleetMember{spec: spec, state: "created"}
+		if err := f.build(m); err != nil {
+			return nil, fmt.Errorf("instance %q: %w", spec.Name, err)
+		}
+		f.instances = append(f.instances, m)
+	}
+	return f, nil
+}
+
+func (f *Fleet) build(m *fleetMember) error {
+	spec := m.spec
+	options := append([]Option{}, f.options...)
+	options = append(options, WithLogger(f.logger.With(zap.String("instance", spec.Name))), WithAddr(spec.Addr))
+	if spec.GRPCAddr != "" {
+		options = append(options, WithGRPCAddr(spec.GRPCAddr))
+	}
+	options = append(options, spec.Faults.Options()...)
+	srv, err := New(options...)
+	if err != nil {
+		return err
+	}
+	m.srv, m.handler = srv, srv.Handler()
+	return nil
+}
+
+// Run starts every instance and the shared admin API and serves until ctx is
+// done. It fails if an instance or the admin API cannot start at all.
+func (f *Fleet) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for _, m := range f.instances {
+		if err := m.srv.Start(ctx); err != nil {
+			return fmt.Errorf("instance %q: %w", m.spec.Name, err)
+		}
+		f.setState(m, "running", nil)
+	}
+
+	var admin *http.Server
+	if f.cfg.Admin != "" {
+		ln, err := net.Listen("tcp", f.cfg.Admin)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrFleetAdmin, err)
+		}
+		f.mu.Lock()
+		f.adminAddr = ln.Addr().String()
+		f.mu.Unlock()
+		admin = &http.Server{Handler: f.Handler()}
+		f.logger.Info("starting fleet admin", zap.String("addr", ln.Addr().String()))
+		go func() {
+			if err := admin.Serve(ln); err != nil && err != http.ErrServerClosed {
+				f.logger.Error("fleet admin failed", zap.Error(err))
+				cancel()
+			}
+		}()
+	}
+
+	var wg sync.WaitGroup
+	for _, m := range f.instances {
+		wg.Go(func() { f.supervise(ctx, m) })
+	}
+	wg.Wait()
+	if admin != nil {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer shutdownCancel()
+		admin.Shutdown(shutdownCtx)
+	}
+	return nil
+}
+
+// supervise waits for m to stop and restarts it, from a fresh server with
+// the same faults, until ctx is done.
+func (f *Fleet) supervise(ctx context.Context, m *fleetMember) {
+	backoff := time.Second
+	for {
+		err := m.srv.Wait()
+		if ctx.Err() != nil {
+			f.setState(m, "stopped", nil)
+			return
+		}
+		f.logger.Error("instance stopped, restarting", zap.String("instance", m.spec.Name), zap.Error(err), zap.Duration("backoff", backoff))
+		f.setState(m, "restarting", err)
+		for {
+			if sleep(ctx, backoff) != nil {
+				f.setState(m, "stopped", nil)
+				return
+			}
+			backoff = min(backoff*2, 30*time.Second)
+			f.mu.Lock()
+			m.restarts++
+			err = f.build(m)
+			f.mu.Unlock()
+			if err == nil {
+				err = m.srv.Start(ctx)
+			}
+			if err == nil {
+				break
+			}
+			f.logger.Error("instance restart failed", zap.String("instance", m.spec.Name), zap.Error(err), zap.Duration("backoff", backoff))
+			f.setState(m, "restarting", err)
+		}
+		f.setState(m, "running", nil)
+		backoff = time.Second
+	}
+}
+
+func (f *Fleet) setState(m *fleetMember, state string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m.state, m.lastErr = state, ""
+	if err != nil && !errors.Is(err, context.Canceled) {
+		m.lastErr = err.Error()
+	}
+}
+
+// Instance returns the running server of the named instance.
+func (f *Fleet) Instance(name string) *Server {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, m := range f.instances {
+		if m.spec.Name == name {
+			return m.srv
+		}
+	}
+	return nil
+}
+
+// AdminAddr returns the bound address of the shared admin API, empty until
+// Run started it.
+func (f *Fleet) AdminAddr() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.adminAddr
+}
+
+// State reports every instance in the order of the config.
+func (f *Fleet) State() []FleetMemberState {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	states := make([]FleetMemberState, 0, len(f.instances))
+	for _, m := range f.instances {
+		st := FleetMemberState{Name: m.spec.Name, State: m.state, Restarts: m.restarts, Error: m.lastErr, Stats: m.srv.Stats()}
+		if m.srv.Addr() != "" {
+			st.URL = m.srv.URL()
+		}
+		if m.state == "running" {
+			st.GRPCAddr = m.srv.GRPCAddr()
+		}
+		states = append(states, st)
+	}
+	return states
+}
+
+// Handler is the shared admin API:
+//
+//	GET /fleet                       every instance with its stats
+//	GET /fleet/stats                 the stats summed over the fleet
+//	    /fleet/{name}/admin/...      the admin API of one instance
+func (f *Fleet) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /fleet", func(rw http.ResponseWriter, req *http.Request) {
+		writeJSON(rw, http.StatusOK, f.State())
+	})
+	mux.HandleFunc("GET /fleet/stats", func(rw http.ResponseWriter, req *http.Request) {
+		var total Stats
+		for _, st := range f.State() {
+			total.Requests += st.Stats.Requests
+			total.InFlight += st.Stats.InFlight
+			total.Aborted += st.Stats.Aborted
+			total.ClientDisconnects += st.Stats.ClientDisconnects
+			total.Shed += st.Stats.Shed
+			total.RefusedConns += st.Stats.RefusedConns
+			total.SlowConsumers += st.Stats.SlowConsumers
+			total.Hiccups += st.Stats.Hiccups
+			total.CapturesStored += st.Stats.CapturesStored
+			total.CaptureBytes += st.Stats.CaptureBytes
+			total.CapturesEvicted += st.Stats.CapturesEvicted
+			total.PhasesEvicted += st.Stats.PhasesEvicted
+		}
+		writeJSON(rw, http.StatusOK, total)
+	})
+	mux.HandleFunc("/fleet/{name}/admin/", func(rw http.ResponseWriter, req *http.Request) {
+		name := req.PathValue("name")
+		var h http.Handler
+		f.mu.Lock()
+		for _, m := range f.instances {
+			if m.spec.Name == name {
+				h = m.handler
+			}
+		}
+		f.mu.Unlock()
+		if h == nil {
+			writeError(rw, http.StatusNotFound, fmt.Errorf("no instance %q", name))
+			return
+		}
+		http.StripPrefix("/fleet/"+name, h).ServeHTTP(rw, req)
+	})
+	return mux
+}
@@ -0,0 +1,105 @@
+package slowproxy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FlushPolicy decides which of a handler's flushes reach the client: all of
+// them when zero, none with Never, one per Bytes written or one per Interval.
+// Skipped flushes leave data in net/http's 4 KiB buffer until it fills or the
+// response ends, as behind a buffering proxy.
+type FlushPolicy struct {
+	Never    bool
+	Bytes    int
+	Interval time.Duration
+}
+
+// ParseFlushPolicy reads "always", "never", a byte count like "16384" or an
+// interval like "500ms".
+func ParseFlushPolicy(v string) (FlushPolicy, error) {
+	switch v {
+	case "", "always":
+		return FlushPolicy{}, nil
+	case "never":
+		return FlushPolicy{Never: true}, nil
+	}
+	if n, err := strconv.Atoi(v); err == nil && n > 0 {
+		return FlushPolicy{Bytes: n}, nil
+	}
+	if d, err := time.ParseDuration(v); err == nil && d > 0 {
+		return FlushPolicy{Interval: d}, nil
+	}
+	return FlushPolicy{}, fmt.Errorf("flush policy %q, want always, never, a byte count or an interval", v)
+}
+
+func (p FlushPolicy) String() string {
+	switch {
+	case p.Never:
+		return "never"
+	case p.Bytes > 0:
+		return strconv.Itoa(p.Bytes)
+	case p.Interval > 0:
+		return p.Interval.String()
+	}
+	return "always"
+}
+
+// flushPolicy applies ?flush= or -flush to the flushes of every route but the
+// admin API.
+func (s *Server) flushPolicy(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/admin/") {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		p := s.opts.Flush
+		if v := req.URL.Query().Get("flush"); v != "" {
+			var err error
+			if p, err = ParseFlushPolicy(v); err != nil {
+				writeError(rw, http.StatusBadRequest, err)
+				return
+			}
+		}
+		if p == (FlushPolicy{}) {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		next.ServeHTTP(&policyFlusher{ResponseWriter: rw, policy: p, last: time.Now()}, req)
+	})
+}
+
+type policyFlusher struct {
+	http.ResponseWriter
+	policy  FlushPolicy
+	pending int
+	last    time.Time
+}
+
+func (w *policyFlusher) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.pending += n
+	return n, err
+}
+
+func (w *policyFlusher) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *policyFlusher) Flush() {
+	switch p := w.policy; {
+	case p.Never:
+		return
+	case p.Bytes > 0 && w.pending < p.Bytes:
+		return
+	case p.Interval > 0 && time.Since(w.last) < p.Interval:
+		return
+	}
+	w.pending, w.last = 0, time.Now()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,188 @@
+package slowproxy
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FailureGroup makes several routes fail together, like services sharing a
+// dependency. While the group is down, taken down through the Controller or
+// the admin API or during a window of its Schedule, every request under one
+// of its Paths gets Fault, a 503 when empty.
+type FailureGroup struct {
+	Name     string    `json:"name" yaml:"name"`
+	Paths    []string  `json:"paths" yaml:"paths"`
+	Fault    Fault     `json:"fault,omitzero" yaml:"fault,omitempty"`
+	Schedule *Schedule `json:"schedule,omitempty" yaml:"schedule,omitempty"`
+}
+
+// Validate reports groups that cannot apply.
+func (g FailureGroup) Validate() error {
+	if g.Name == "" {
+		return fmt.Errorf("failure group without a name")
+	}
+	if len(g.Paths) == 0 {
+		return fmt.Errorf("failure group %q has no paths", g.Name)
+	}
+	return Rule{Name: "group " + g.Name, Fault: g.Fault, Schedule: g.Schedule}.Validate()
+}
+
+func (g FailureGroup) covers(path string) bool {
+	for _, p := range g.Paths {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+type groupState struct {
+	FailureGroup
+	downUntil time.Time
+}
+
+func (g *groupState) down(now time.Time) bool {
+	return now.Before(g.downUntil) || g.Schedule != nil && g.Schedule.Active(now)
+}
+
+// groupSet holds the failure groups of a server.
+type groupSet struct {
+	mu     sync.Mutex
+	groups []*groupState
+}
+
+func newGroupSet(groups []FailureGroup) (*groupSet, error) {
+	gs := &groupSet{}
+	seen := map[string]bool{}
+	for _, g := range groups {
+		if err := g.Validate(); err != nil {
+			return nil, err
+		}
+		if seen[g.Name] {
+			return nil, fmt.Errorf("failure group %q defined twice", g.Name)
+		}
+		seen[g.Name] = true
+		if !g.Fault.responds() {
+			g.Fault.Status = http.StatusServiceUnavailable
+		}
+		gs.groups = append(gs.groups, &groupState{FailureGroup: g})
+	}
+	return gs, nil
+}
+
+// fault returns the fault of the first down group covering req, rolling
+// its percentage on r.
+func (gs *groupSet) fault(req *http.Request, now time.Time, r *seededRand) *Fault {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	for _, g := range gs.groups {
+		if g.covers(req.URL.Path) && g.down(now) {
+			f := g.Fault
+			if f.Percent > 0 && r.Float64()*100 >= f.Percent {
+				return nil
+			}
+			return &f
+		}
+	}
+	return nil
+}
+
+func (gs *groupSet) setDownUntil(name string, until time.Time) error {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	for _, g := range gs.groups {
+		if g.Name == name {
+			g.downUntil = until
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown failure group %q", name)
+}
+
+// GroupState is a failure group as reported by /admin/groups.
+type GroupState struct {
+	FailureGroup
+	Down      bool       `json:"down"`
+	DownUntil *time.Time `json:"down_until,omitempty"`
+}
+
+func (gs *groupSet) states(now time.Time) []GroupState {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	states := []GroupState{}
+	for _, g := range gs.groups {
+		st := GroupState{FailureGroup: g.FailureGroup, Down: g.down(now)}
+		if now.Before(g.downUntil) {
+			until := g.downUntil
+			st.DownUntil = &until
+		}
+		states = append(states, st)
+	}
+	return states
+}
+
+// GroupDown fails every route of the named group for d.
+func (c *Controller) GroupDown(name string, d time.Duration) error {
+	if err := c.s.groups.setDownUntil(name, c.s.clock.now().Add(d)); err != nil {
+		return err
+	}
+	c.s.logger.Info("failure group down", zap.String("group", name), zap.Duration("for", d))
+	return nil
+}
+
+// GroupUp ends a failure of the named group started by GroupDown.
+func (c *Controller) GroupUp(name string) error {
+	if err := c.s.groups.setDownUntil(name, time.Time{}); err != nil {
+		return err
+	}
+	c.s.logger.Info("failure group up", zap.String("group", name))
+	return nil
+}
+
+// groupsMiddleware fails the routes of down groups, but never the admin API.
+func (s *Server) groupsMiddleware(next http.Handler) http.Handler {
+	if len(s.groups.groups) == 0 {
+		return next
+	}
+	faults := faultHandler(next, s.rand, func(req *http.Request) *Fault {
+		return s.groups.fault(req, s.clock.now(), s.rand)
+	})
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/admin/") {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		faults.ServeHTTP(rw, req)
+	})
+}
+
+func (s *Server) getGroups(rw http.ResponseWriter, req *http.Request) {
+	writeJSON(rw, http.StatusOK, s.groups.states(s.clock.now()))
+}
+
+// groupDown handles POST /admin/groups/{name}/down?for=30s, one minute by
+// default.
+func (s *Server) groupDown(rw http.ResponseWriter, req *http.Request) {
+	d, err := durationQuery(req, "for", time.Minute)
+	if err == nil {
+		err = s.control.GroupDown(mux.Vars(req)["name"], d)
+	}
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	s.getGroups(rw, req)
+}
+
+func (s *Server) groupUp(rw http.ResponseWriter, req *http.Request) {
+	if err := s.control.GroupUp(mux.Vars(req)["name"]); err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	s.getGroups(rw, req)
+}
@@ -0,0 +1,230 @@
+package slowproxy
+
+import (
+	"context"
+	"errors"
+	slowproxyv1 "github.com/cbosss/slow-proxy/proto/slowproxy/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//go:generate protoc -I ../../proto --go_out=../../proto --go_opt=paths=source_relative --go-grpc_out=../../proto --go-grpc_opt=paths=source_relative slowproxy/v1/slowproxy.proto
+
+type grpcService struct {
+	slowproxyv1.UnimplementedSlowProxyServer
+	srv *Server
+}
+
+// GRPCServer returns a gRPC server with the SlowProxy, health and reflection
+// services registered. SlowProxy calls get the controller and rule faults of
+// the HTTP routes.
+func (s *Server) GRPCServer() *grpc.Server {
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(s.unaryFaults),
+		grpc.ChainStreamInterceptor(s.streamFaults),
+	}
+	if s.certs != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(s.tlsConfig())))
+	}
+	gs := grpc.NewServer(opts...)
+	slowproxyv1.RegisterSlowProxyServer(gs, &grpcService{srv: s})
+	healthpb.RegisterHealthServer(gs, s.health)
+	reflection.Register(gs)
+	return gs
+}
+
+// positiveDuration reads a duration field, InvalidArgument unless it is set
+// and positive.
+func positiveDuration(name string, d *durationpb.Duration) (time.Duration, error) {
+	if d == nil {
+		return 0, status.Errorf(codes.InvalidArgument, "%s is required", name)
+	}
+	if err := d.CheckValid(); err != nil {
+		return 0, status.Errorf(codes.InvalidArgument, "%s: %v", name, err)
+	}
+	if d.AsDuration() <= 0 {
+		return 0, status.Errorf(codes.InvalidArgument, "%s must be positive", name)
+	}
+	return d.AsDuration(), nil
+}
+
+func (g *grpcService) Slow(ctx context.Context, req *slowproxyv1.SlowRequest) (*slowproxyv1.SlowResponse, error) {
+	pause, err := positiveDuration("duration", req.GetDuration())
+	if err != nil {
+		return nil, err
+	}
+	logger := g.srv.logger.With(zap.String("rpc", "Slow"), zap.Duration("duration", pause))
+	logger.Debug("starting request")
+	defer logger.Debug("finishing request")
+
+	start := time.Now()
+	if err := g.srv.Pause(ctx, pause, 0, nil); err != nil {
+		return nil, rpcError(logger, err)
+	}
+	return &slowproxyv1.SlowResponse{Elapsed: durationpb.New(time.Since(start))}, nil
+}
+
+func (g *grpcService) Fail(ctx context.Context, req *slowproxyv1.FailRequest) (*slowproxyv1.FailResponse, error) {
+	code := codes.Code(req.GetCode())
+	switch {
+	case code == codes.OK:
+		return nil, status.Error(codes.InvalidArgument, "code must be an error, not OK")
+	case code > codes.Unauthenticated:
+		return nil, status.Errorf(codes.InvalidArgument, "unknown status code %d", req.GetCode())
+	}
+	logger := g.srv.logger.With(zap.String("rpc", "Fail"), zap.Stringer("code", code))
+
+	if delay := req.GetDelay().AsDuration(); delay > 0 {
+		if err := g.srv.Pause(ctx, delay, 0, nil); err != nil {
+			return nil, rpcError(logger, err)
+		}
+	}
+
+	msg := req.GetMessage()
+	if msg == "" {
+		msg = "injected failure"
+	}
+	logger.Info("failing request")
+	return nil, status.Error(code, msg)
+}
+
+func (g *grpcService) Ticks(req *slowproxyv1.TicksRequest, stream slowproxyv1.SlowProxy_TicksServer) error {
+	pause, err := positiveDuration("duration", req.GetDuration())
+	if err != nil {
+		return err
+	}
+	interval := time.Second
+	if req.GetInterval() != nil {
+		if interval, err = positiveDuration("interval", req.GetInterval()); err != nil {
+			return err
+		}
+	}
+	logger := g.srv.logger.With(zap.String("rpc", "Ticks"), zap.Duration("duration", pause))
+	logger.Debug("starting request")
+	defer logger.Debug("finishing request")
+
+	var seq uint64
+	err = g.srv.Pause(stream.Context(), pause, interval, func(t time.Time) error {
+		seq++
+		return stream.Send(&slowproxyv1.Tick{Seq: seq, Time: timestamppb.New(t)})
+	})
+	if err != nil {
+		return rpcError(logger, err)
+	}
+	return nil
+}
+
+func (s *Server) unaryFaults(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := s.grpcFaults(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) streamFaults(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.grpcFaults(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// grpcFaults applies the controller latency and pending failures, then the
+// first matching rule, to a SlowProxy call before it runs. Rules see the call
+// as a POST of its full method name with the metadata as headers; its message
+// is not available to body matches. Faults applied after the handler are
+// applied before it, and statuses become codes as gRPC maps HTTP statuses, a
+// 2xx letting the call through.
+func (s *Server) grpcFaults(ctx context.Context, method string) error {
+	if !strings.HasPrefix(method, "/"+slowproxyv1.SlowProxy_ServiceDesc.ServiceName+"/") {
+		return nil
+	}
+	logger := s.logger.With(zap.String("rpc", method))
+	code, latency := s.control.next(method)
+	if latency > 0 {
+		if err := s.Pause(ctx, latency, 0, nil); err != nil {
+			return rpcError(logger, err)
+		}
+	}
+	if code != 0 {
+		return grpcStatus(code, http.StatusText(code))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, method, http.NoBody)
+	if err != nil {
+		return rpcError(logger, err)
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for k, v := range md {
+		if !strings.HasPrefix(k, ":") {
+			req.Header[http.CanonicalHeaderKey(k)] = v
+		}
+	}
+	f := s.rules.match(req, s.clock.now(), s.rand)
+	if f == nil {
+		return nil
+	}
+	if delay := f.Delay.pick(s.rand); delay > 0 {
+		if err := s.Pause(ctx, delay, 0, nil); err != nil {
+			return rpcError(logger, err)
+		}
+	}
+	switch {
+	case f.Abort:
+		return grpcStatus(http.StatusServiceUnavailable, "connection aborted")
+	case f.responds():
+		code, _, body := f.response(req, s.rand)
+		return grpcStatus(code, strings.TrimSuffix(body, "\n"))
+	}
+	return nil
+}
+
+// grpcStatus is the error a gRPC client sees for an HTTP response with code,
+// following the HTTP to gRPC status mapping of grpc-go.
+func grpcStatus(code int, msg string) error {
+	switch code {
+	case http.StatusBadRequest:
+		return status.Error(codes.Internal, msg)
+	case http.StatusUnauthorized:
+		return status.Error(codes.Unauthenticated, msg)
+	case http.StatusForbidden:
+		return status.Error(codes.PermissionDenied, msg)
+	case http.StatusNotFound:
+		return status.Error(codes.Unimplemented, msg)
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return status.Error(codes.Unavailable, msg)
+	}
+	if code < 300 {
+		return nil
+	}
+	return status.Error(codes.Unknown, msg)
+}
+
+// rpcError converts a pause error into the gRPC status returned to the client.
+func rpcError(logger *zap.Logger, err error) error {
+	switch {
+	case errors.Is(err, context.Canceled):
+		logger.Info("request context cancelled")
+		return status.FromContextError(err).Err()
+	case errors.Is(err, context.DeadlineExceeded):
+		logger.Info("request deadline exceeded")
+		return status.FromContextError(err).Err()
+	case errors.Is(err, ErrShuttingDown):
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	logger.With(zap.Error(err)).Error("request failed")
+	return status.Error(codes.Internal, err.Error())
+}
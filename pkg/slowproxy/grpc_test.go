@@ -0,0 +1,90 @@
+package slowproxy
+
+import (
+	"context"
+	slowproxyv1 "github.com/cbosss/slow-proxy/proto/slowproxy/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestGRPCFaults(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []Rule
+		control func(c *Controller)
+		md      metadata.MD
+		want    codes.Code
+		minTime time.Duration
+	}{
+		{name: "none", want: codes.OK},
+		{name: "fail next", control: func(c *Controller) { c.FailNext(1, http.StatusTooManyRequests) }, want: codes.Unavailable},
+		{name: "latency", control: func(c *Controller) { c.SetLatency("/slowproxy.v1.SlowProxy/", 50*time.Millisecond) }, want: codes.OK, minTime: 50 * time.Millisecond},
+		{name: "rule status", rules: []Rule{{Match: Matcher{Path: "/slowproxy.v1.SlowProxy/Slow"}, Fault: Fault{Status: http.StatusForbidden}}}, want: codes.PermissionDenied},
+		{name: "rule other method", rules: []Rule{{Match: Matcher{Path: "/slowproxy.v1.SlowProxy/Fail"}, Fault: Fault{Status: http.StatusForbidden}}}, want: codes.OK},
+		{name: "rule abort", rules: []Rule{{Fault: Fault{Abort: true}}}, want: codes.Unavailable},
+		{name: "rule delay", rules: []Rule{{Fault: Fault{Delay: Fixed(50 * time.Millisecond)}}}, want: codes.OK, minTime: 50 * time.Millisecond},
+		{name: "rule success", rules: []Rule{{Fault: Fault{Body: "ok"}}}, want: codes.OK},
+		{name: "rule metadata", rules: []Rule{{Match: Matcher{Headers: map[string]string{"X-Tenant": "acme"}}, Fault: Fault{Status: http.StatusInternalServerError}}}, md: metadata.Pairs("x-tenant", "acme"), want: codes.Unknown},
+		{name: "rule metadata miss", rules: []Rule{{Match: Matcher{Headers: map[string]string{"X-Tenant": "acme"}}, Fault: Fault{Status: http.StatusInternalServerError}}}, md: metadata.Pairs("x-tenant", "globex"), want: codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			s := newTestServer(t, WithAddr("127.0.0.1:0"), WithGRPCAddr("127.0.0.1:0"), WithRules(tt.rules...))
+			if err := s.Start(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if tt.control != nil {
+				tt.control(s.Controller())
+			}
+			conn, err := grpc.NewClient(s.GRPCAddr(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if tt.md != nil {
+				ctx = metadata.NewOutgoingContext(ctx, tt.md)
+			}
+			start := time.Now()
+			_, err = slowproxyv1.NewSlowProxyClient(conn).Slow(ctx, &slowproxyv1.SlowRequest{Duration: durationpb.New(time.Millisecond)})
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("Slow() = %v, want code %v", err, tt.want)
+			}
+			if elapsed := time.Since(start); elapsed < tt.minTime {
+				t.Errorf("Slow() took %s, want at least %s", elapsed, tt.minTime)
+			}
+		})
+	}
+}
+
+func TestGRPCFaultsStream(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s := newTestServer(t, WithAddr("127.0.0.1:0"), WithGRPCAddr("127.0.0.1:0"), WithRules(Rule{
+		Match: Matcher{Path: "/slowproxy.v1.SlowProxy/Ticks"},
+		Fault: Fault{Status: http.StatusServiceUnavailable},
+	}))
+	if err := s.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := grpc.NewClient(s.GRPCAddr(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	stream, err := slowproxyv1.NewSlowProxyClient(conn).Ticks(ctx, &slowproxyv1.TicksRequest{Duration: durationpb.New(time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.Unavailable {
+		t.Errorf("Recv() = %v, want code Unavailable", err)
+	}
+}
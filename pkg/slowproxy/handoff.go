@@ -0,0 +1,79 @@
+package slowproxy
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"net"
+	"os"
+	"time"
+)
+
+// WithInheritedListeners hands a new server the sockets of the one it takes
+// over, keyed like ListenerFiles returns them. A listener whose key the new
+// config still asks for is served as is, so clients never see a refused
+// connection, and the others are closed.
+func WithInheritedListeners(lns map[string]net.Listener) Option {
+	return func(s *Server) { s.inherited = lns }
+}
+
+// listenerKey names a listener by its kind and configured address, so a
+// config changing an address binds it fresh.
+func listenerKey(kind, addr string) string {
+	return kind + " " + addr
+}
+
+// listen takes the inherited listener for kind and addr, or binds one.
+func (s *Server) listen(bound map[string]net.Listener, kind, addr string) (net.Listener, error) {
+	key := listenerKey(kind, addr)
+	ln, ok := s.inherited[key]
+	if ok {
+		delete(s.inherited, key)
+		s.logger.Info("using inherited listener", zap.String("listener", key), zap.String("addr", ln.Addr().String()))
+	} else {
+		var err error
+		if ln, err = net.Listen("tcp", addr); err != nil {
+			return nil, err
+		}
+	}
+	bound[key] = ln
+	return ln, nil
+}
+
+// ListenerFiles returns duplicates of the bound listening sockets for a
+// successor process, keyed for WithInheritedListeners. Listeners passed with
+// WithListener or WithGRPCListener are the caller's and not included.
+func (s *Server) ListenerFiles() (_ map[string]*os.File, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	files := map[string]*os.File{}
+	defer func() {
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+		}
+	}()
+	for key, ln := range s.bound {
+		fl, ok := ln.(interface{ File() (*os.File, error) })
+		if !ok {
+			return nil, fmt.Errorf("listener %s cannot be passed on", key)
+		}
+		f, err := fl.File()
+		if err != nil {
+			return nil, fmt.Errorf("listener %s: %w", key, err)
+		}
+		files[key] = f
+	}
+	return files, nil
+}
+
+// Drain stops accepting and shuts down once the requests in flight are
+// done, slow ones included, or timeout passed, after which it is Close.
+// Wait returns when it is over. It is how a server hands over to the
+// successor that inherited its listeners.
+func (s *Server) Drain(timeout time.Duration) {
+	select {
+	case s.drain <- timeout:
+	default:
+	}
+}
@@ -0,0 +1,96 @@
+package slowproxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestHandOffListeners hands the listener of a server with a slow request in
+// flight to a successor: the request finishes on the old server while new
+// ones reach the successor.
+func TestHandOffListeners(t *testing.T) {
+	ctx := context.Background()
+	old := newTestServer(t, WithAddr("127.0.0.1:0"))
+	if err := old.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	slow := make(chan int, 1)
+	go func() {
+		resp, err := client.Get(old.URL() + "/slow/300ms")
+		if err != nil {
+			slow <- 0
+			return
+		}
+		resp.Body.Close()
+		slow <- resp.StatusCode
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	files, err := old.ListenerFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lns := map[string]net.Listener{}
+	for key, f := range files {
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		lns[key] = ln
+	}
+	if _, ok := lns["http 127.0.0.1:0"]; !ok || len(lns) != 1 {
+		t.Fatalf("ListenerFiles() = %v, want the http listener", files)
+	}
+	unused, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lns["grpc 127.0.0.1:0"] = unused
+
+	successor := newTestServer(t, WithAddr("127.0.0.1:0"), WithInheritedListeners(lns), WithRules(Rule{Fault: Fault{Status: http.StatusTeapot}}))
+	if err := successor.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if successor.Addr() != old.Addr() {
+		t.Errorf("successor listens on %s, want %s", successor.Addr(), old.Addr())
+	}
+	if _, err := unused.Accept(); err == nil {
+		t.Error("the inherited listener the successor does not use is open")
+	}
+
+	old.Drain(5 * time.Second)
+	if err := old.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if code := <-slow; code != http.StatusOK {
+		t.Errorf("request in flight = %d, want 200", code)
+	}
+	resp, err := client.Get(old.URL() + "/status/200")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTeapot {
+		t.Errorf("new request = %d, want the 418 of the successor", resp.StatusCode)
+	}
+}
+
+func TestListenerFilesSkipsInjected(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t, WithListener(ln))
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	files, err := s.ListenerFiles()
+	if err != nil || len(files) != 0 {
+		t.Errorf("ListenerFiles() = %v, %v, want none", files, err)
+	}
+}
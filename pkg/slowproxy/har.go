@@ -0,0 +1,114 @@
+package slowproxy
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// harReceiveChunks is how many writes spread the body over the recorded
+// receive time.
+const harReceiveChunks = 10
+
+// harFile is the part of a HTTP Archive that replays.
+type harFile struct {
+	Log struct {
+		Entries []struct {
+			Request struct {
+				Method string `json:"method"`
+				URL    string `json:"url"`
+			} `json:"request"`
+			Response struct {
+				Status  int         `json:"status"`
+				Headers []harHeader `json:"headers"`
+				Content struct {
+					Text     string `json:"text"`
+					Encoding string `json:"encoding"`
+				} `json:"content"`
+			} `json:"response"`
+			Time    float64 `json:"time"`
+			Timings struct {
+				Receive float64 `json:"receive"`
+			} `json:"timings"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+type harHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// harSkipHeaders describe the recorded connection rather than the answer,
+// the body of a HAR is already decoded.
+var harSkipHeaders = map[string]bool{
+	"Content-Length":    true,
+	"Content-Encoding":  true,
+	"Transfer-Encoding": true,
+	"Connection":        true,
+	"Keep-Alive":        true,
+}
+
+// loadHAR reads the entries of a HAR file into a cassette matching by
+// method and URL only, HAR bodies being often left out. Each entry waits
+// until its first byte, then spreads the body over its receive time.
+// Entries that got no answer are left out.
+func loadHAR(path string) (*cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var har harFile
+	if err := json.Unmarshal(data, &har); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	c := &cassette{path: path, next: map[string]int{}, anyBody: true}
+	for n, e := range har.Log.Entries {
+		if e.Response.Status == 0 {
+			continue
+		}
+		u, err := url.Parse(e.Request.URL)
+		if err != nil {
+			return nil, fmt.Errorf("%s: entry %d: %w", path, n, err)
+		}
+		body := []byte(e.Response.Content.Text)
+		if e.Response.Content.Encoding == "base64" {
+			if body, err = base64.StdEncoding.DecodeString(e.Response.Content.Text); err != nil {
+				return nil, fmt.Errorf("%s: entry %d: %w", path, n, err)
+			}
+		}
+		header := http.Header{}
+		for _, h := range e.Response.Headers {
+			name := http.CanonicalHeaderKey(h.Name)
+			// HTTP/2 pseudo headers
+			if strings.HasPrefix(name, ":") || harSkipHeaders[name] {
+				continue
+			}
+			header.Add(name, h.Value)
+		}
+		receive := max(e.Timings.Receive, 0)
+		c.interactions = append(c.interactions, Interaction{
+			Method:   e.Request.Method,
+			URL:      u.RequestURI(),
+			Status:   e.Response.Status,
+			Header:   header,
+			Body:     body,
+			Duration: harDuration(e.Time - receive).String(),
+			Receive:  harDuration(receive).String(),
+		})
+	}
+	if len(c.interactions) == 0 {
+		return nil, fmt.Errorf("%s: no answered entries", path)
+	}
+	return c, nil
+}
+
+// harDuration converts HAR milliseconds, -1 when unknown.
+func harDuration(ms float64) time.Duration {
+	return time.Duration(max(ms, 0) * float64(time.Millisecond))
+}
@@ -0,0 +1,76 @@
+package slowproxy
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// headRequests answers HEAD like the GET it mirrors: the handler runs in
+// full, delays included, and its body is only counted so Content-Length
+// matches. ?head_mismatch=n, or Options.HeadMismatch, skews that length by n
+// bytes to reproduce origins whose HEAD and GET disagree.
+func (s *Server) headRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodHead {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		delta, err := intQuery(req, "head_mismatch", s.opts.HeadMismatch)
+		if err != nil {
+			writeError(rw, http.StatusBadRequest, err)
+			return
+		}
+		w := &headWriter{ResponseWriter: rw}
+		next.ServeHTTP(w, req)
+		w.finish(delta)
+	})
+}
+
+// headWriter holds back the status until the handler is done and counts the
+// body it would have sent.
+type headWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+func (w *headWriter) WriteHeader(status int) {
+	if status < 200 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *headWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.n += int64(len(b))
+	return len(b), nil
+}
+
+func (w *headWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Flush is a no-op, flushing would commit the headers early.
+func (w *headWriter) Flush() {}
+
+func (w *headWriter) finish(delta int) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	h := w.Header()
+	bodyless := w.status == http.StatusNoContent || w.status == http.StatusNotModified
+	if !bodyless && h.Get("Transfer-Encoding") == "" {
+		n := w.n
+		if v, err := strconv.ParseInt(h.Get("Content-Length"), 10, 64); err == nil {
+			n = v
+		}
+		h.Set("Content-Length", strconv.FormatInt(max(n+int64(delta), 0), 10))
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
@@ -0,0 +1,60 @@
+package slowproxy
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// healthService is grpc.health.v1.Health with statuses and a response delay
+// that can be changed at runtime through the admin API.
+type healthService struct {
+	*health.Server
+	srv   *Server
+	delay atomic.Int64
+
+	mu       sync.Mutex
+	statuses map[string]healthpb.HealthCheckResponse_ServingStatus
+}
+
+func newHealthService(srv *Server) *healthService {
+	h := &healthService{
+		Server:   health.NewServer(),
+		srv:      srv,
+		statuses: map[string]healthpb.HealthCheckResponse_ServingStatus{},
+	}
+	h.setStatus("", healthpb.HealthCheckResponse_SERVING)
+	h.setStatus("slowproxy.v1.SlowProxy", healthpb.HealthCheckResponse_SERVING)
+	return h
+}
+
+func (h *healthService) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	if d := time.Duration(h.delay.Load()); d > 0 {
+		h.srv.logger.Debug("delaying health check", zap.String("service", req.GetService()), zap.Duration("delay", d))
+		if err := h.srv.Pause(ctx, d, 0, nil); err != nil {
+			return nil, rpcError(h.srv.logger, err)
+		}
+	}
+	return h.Server.Check(ctx, req)
+}
+
+func (h *healthService) setStatus(service string, status healthpb.HealthCheckResponse_ServingStatus) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.statuses[service] = status
+	h.SetServingStatus(service, status)
+}
+
+func (h *healthService) snapshot() map[string]string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make(map[string]string, len(h.statuses))
+	for service, status := range h.statuses {
+		out[service] = status.String()
+	}
+	return out
+}
@@ -0,0 +1,154 @@
+package slowproxy
+
+import (
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Hiccup modes, see Options.HiccupMode.
+const (
+	HiccupWorld = "world"
+	HiccupSpin  = "spin"
+	HiccupGC    = "gc"
+)
+
+// hiccups are process-wide pauses that hit whatever is in flight, unlike the
+// per-request delays. world holds a lock every request takes before it
+// starts and before each write, like a stop-the-world pause. spin keeps every
+// P busy so the scheduler has to share it with the handlers. gc drops the GC
+// target to 1% and churns the heap, so the pauses and assists come from the
+// real collector.
+type hiccups struct {
+	world   sync.RWMutex
+	running sync.Mutex
+}
+
+// wait returns once no world hiccup holds the lock.
+func (h *hiccups) wait() {
+	h.world.RLock()
+	h.world.RUnlock()
+}
+
+func validHiccupMode(mode string) error {
+	switch mode {
+	case HiccupWorld, HiccupSpin, HiccupGC:
+		return nil
+	}
+	return fmt.Errorf("unknown hiccup mode %q, want world, spin or gc", mode)
+}
+
+// Hiccup pauses the process for d in the given mode and returns once it is
+// over. Hiccups do not overlap, a second one starts after the first.
+func (s *Server) Hiccup(ctx context.Context, mode string, d time.Duration) error {
+	if err := validHiccupMode(mode); err != nil {
+		return err
+	}
+	h := &s.hiccups
+	h.running.Lock()
+	defer h.running.Unlock()
+	s.stats.hiccups.Add(1)
+	s.logger.Info("hiccup", zap.String("mode", mode), zap.Duration("duration", d))
+	ctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+	switch mode {
+	case HiccupWorld:
+		h.world.Lock()
+		<-ctx.Done()
+		h.world.Unlock()
+	case HiccupSpin:
+		var wg sync.WaitGroup
+		for range runtime.GOMAXPROCS(0) {
+			wg.Go(func() {
+				for ctx.Err() == nil {
+				}
+			})
+		}
+		wg.Wait()
+	case HiccupGC:
+		prev := debug.SetGCPercent(1)
+		defer debug.SetGCPercent(prev)
+		// a live ring of garbage keeps the collector busy marking
+		var ring [64][]byte
+		for i := 0; ctx.Err() == nil; i++ {
+			ring[i%len(ring)] = make([]byte, 256<<10)
+		}
+	}
+	return nil
+}
+
+// runHiccups injects hiccups of Options.HiccupLength every
+// Options.HiccupEvery, jittered by ±50% so they do not line up with the
+// client's own timers.
+func (s *Server) runHiccups(ctx context.Context) {
+	for {
+		every := time.Duration(float64(s.opts.HiccupEvery) * (0.5 + s.rand.Float64()))
+		if err := sleep(ctx, every); err != nil {
+			return
+		}
+		_ = s.Hiccup(ctx, s.opts.HiccupMode, s.opts.HiccupLength)
+	}
+}
+
+// hiccupGate holds every route but the admin API while a world hiccup
+// runs, at the start of the request and again before each write.
+func (s *Server) hiccupGate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/admin/") {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		s.hiccups.wait()
+		next.ServeHTTP(&hiccupWriter{ResponseWriter: rw, h: &s.hiccups}, req)
+	})
+}
+
+type hiccupWriter struct {
+	http.ResponseWriter
+	h *hiccups
+}
+
+func (w *hiccupWriter) Write(b []byte) (int, error) {
+	w.h.wait()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *hiccupWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *hiccupWriter) Flush() {
+	w.h.wait()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// hiccup injects one hiccup and answers once it is over, mode defaults to
+// -hiccup-mode:
+//
+//	POST /admin/hiccup?for=200ms&mode=spin
+func (s *Server) hiccup(rw http.ResponseWriter, req *http.Request) {
+	d, err := durationQuery(req, "for", 100*time.Millisecond)
+	if err == nil && d <= 0 {
+		err = fmt.Errorf("invalid for: must be positive")
+	}
+	mode := req.URL.Query().Get("mode")
+	if mode == "" {
+		mode = s.opts.HiccupMode
+	}
+	if err == nil {
+		err = s.Hiccup(req.Context(), mode, d)
+	}
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(rw, http.StatusOK, map[string]any{"mode": mode, "duration_ms": d.Milliseconds()})
+}
@@ -0,0 +1,56 @@
+package slowproxy
+
+import (
+	"net/http"
+	"time"
+)
+
+// hookDecision is what a script or plugin asked for about a request.
+type hookDecision struct {
+	delay          time.Duration
+	abort          bool
+	status         int
+	body           string
+	hasBody        bool
+	headers        map[string]string
+	requestHeaders map[string]string
+}
+
+// serveDecision applies d: the delay, then an abort, header changes and a
+// response of its own, or next when d does not answer the request.
+func (s *Server) serveDecision(rw http.ResponseWriter, req *http.Request, next http.Handler, d *hookDecision) {
+	if d.delay > 0 || d.abort || d.status != 0 || d.hasBody {
+		noteFault(req.Context())
+	}
+	if d.delay > 0 {
+		if err := s.Pause(req.Context(), d.delay, 0, nil); err != nil {
+			return
+		}
+	}
+	if d.abort {
+		panic(http.ErrAbortHandler)
+	}
+	for k, v := range d.requestHeaders {
+		req.Header.Set(k, v)
+	}
+	for k, v := range d.headers {
+		rw.Header().Set(k, v)
+	}
+	if d.status == 0 && !d.hasBody {
+		next.ServeHTTP(rw, req)
+		return
+	}
+	status := d.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if rw.Header().Get("Content-Type") == "" {
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	body := d.body
+	if !d.hasBody {
+		body = http.StatusText(status) + "\n"
+	}
+	rw.WriteHeader(status)
+	_, _ = rw.Write([]byte(body))
+}
@@ -0,0 +1,242 @@
+package slowproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Compatibility modes, see Options.Compat.
+const CompatHTTPBin = "httpbin"
+
+// httpbinMaxDelay and httpbinMaxStream cap /delay and /stream as httpbin
+// does.
+const (
+	httpbinMaxDelay  = 10 * time.Second
+	httpbinMaxStream = 100
+)
+
+// httpbinRoutes answers the most used httpbin endpoints with its response
+// shapes, ahead of the routes they shadow, so suites written against the
+// httpbin image keep passing while the faults apply underneath.
+func (s *Server) httpbinRoutes(r *mux.Router) {
+	r.HandleFunc("/get", s.httpbinGet).Methods(http.MethodGet, http.MethodHead)
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		r.HandleFunc("/"+strings.ToLower(method), s.httpbinBody).Methods(method)
+	}
+	r.HandleFunc("/anything", s.httpbinBody)
+	r.HandleFunc("/anything/{path:.*}", s.httpbinBody)
+	r.HandleFunc("/headers", s.httpbinHeaders)
+	r.HandleFunc("/ip", s.httpbinIP)
+	r.HandleFunc("/user-agent", s.httpbinUserAgent)
+	r.HandleFunc("/delay/{n}", s.httpbinDelay)
+	r.HandleFunc("/status/{codes}", s.httpbinStatus)
+	r.HandleFunc("/stream/{n}", s.httpbinStream)
+}
+
+// httpbinRequest describes req the way httpbin does: single values as
+// strings, repeated ones as lists, headers joined with commas. With body
+// it reads data, form, files and json as well.
+func httpbinRequest(req *http.Request, body bool) (map[string]any, error) {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	origin, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		origin = req.RemoteAddr
+	}
+	headers := map[string]string{"Host": req.Host}
+	for name, values := range req.Header {
+		headers[name] = strings.Join(values, ",")
+	}
+	resp := map[string]any{
+		"args":    httpbinValues(req.URL.Query()),
+		"headers": headers,
+		"origin":  origin,
+		"url":     scheme + "://" + req.Host + req.URL.RequestURI(),
+	}
+	if !body {
+		return resp, nil
+	}
+	resp["method"] = req.Method
+	form, files := map[string]any{}, map[string]any{}
+	var data []byte
+	switch ct := req.Header.Get("Content-Type"); {
+	case strings.HasPrefix(ct, "multipart/form-data"):
+		if err := req.ParseMultipartForm(maxEchoBody); err != nil {
+			return nil, err
+		}
+		form = httpbinValues(req.MultipartForm.Value)
+		for name, headers := range req.MultipartForm.File {
+			f, err := headers[0].Open()
+			if err != nil {
+				return nil, err
+			}
+			content, err := io.ReadAll(f)
+			f.Close()
+			if err != nil {
+				return nil, err
+			}
+			files[name] = string(content)
+		}
+	default:
+		if data, err = io.ReadAll(io.LimitReader(req.Body, maxEchoBody+1)); err != nil {
+			return nil, err
+		}
+		if len(data) > maxEchoBody {
+			return nil, fmt.Errorf("body larger than %d bytes", maxEchoBody)
+		}
+		if strings.HasPrefix(ct, "application/x-www-form-urlencoded") {
+			if values, err := url.ParseQuery(string(data)); err == nil {
+				form, data = httpbinValues(values), nil
+			}
+		}
+	}
+	resp["data"], resp["form"], resp["files"], resp["json"] = string(data), form, files, nil
+	if len(data) > 0 && json.Valid(data) {
+		resp["json"] = json.RawMessage(data)
+	}
+	return resp, nil
+}
+
+func httpbinValues(values map[string][]string) map[string]any {
+	flat := make(map[string]any, len(values))
+	for name, v := range values {
+		if len(v) == 1 {
+			flat[name] = v[0]
+		} else {
+			flat[name] = v
+		}
+	}
+	return flat
+}
+
+// httpbinGet answers GET /get with the args, headers, origin and url.
+func (s *Server) httpbinGet(rw http.ResponseWriter, req *http.Request) {
+	resp, _ := httpbinRequest(req, false)
+	writeJSON(rw, http.StatusOK, resp)
+}
+
+// httpbinBody answers /post, /put, /patch, /delete and /anything with
+// what the client sent, body included.
+func (s *Server) httpbinBody(rw http.ResponseWriter, req *http.Request) {
+	resp, err := httpbinRequest(req, true)
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(rw, http.StatusOK, resp)
+}
+
+func (s *Server) httpbinHeaders(rw http.ResponseWriter, req *http.Request) {
+	resp, _ := httpbinRequest(req, false)
+	writeJSON(rw, http.StatusOK, map[string]any{"headers": resp["headers"]})
+}
+
+func (s *Server) httpbinIP(rw http.ResponseWriter, req *http.Request) {
+	resp, _ := httpbinRequest(req, false)
+	writeJSON(rw, http.StatusOK, map[string]any{"origin": resp["origin"]})
+}
+
+func (s *Server) httpbinUserAgent(rw http.ResponseWriter, req *http.Request) {
+	writeJSON(rw, http.StatusOK, map[string]string{"user-agent": req.UserAgent()})
+}
+
+// httpbinDelay waits n seconds, at most 10, then answers like /anything:
+//
+//	/delay/3
+//	/delay/0.5
+func (s *Server) httpbinDelay(rw http.ResponseWriter, req *http.Request) {
+	n, err := strconv.ParseFloat(mux.Vars(req)["n"], 64)
+	if err != nil || n < 0 {
+		writeError(rw, http.StatusBadRequest, fmt.Errorf("invalid delay %q", mux.Vars(req)["n"]))
+		return
+	}
+	d := min(time.Duration(n*float64(time.Second)), httpbinMaxDelay)
+	if err := s.Pause(req.Context(), d, 0, nil); err != nil {
+		return
+	}
+	s.httpbinBody(rw, req)
+}
+
+// httpbinStatus answers with the status, or one picked from a list,
+// optionally weighted:
+//
+//	/status/503
+//	/status/200,500
+//	/status/200:0.9,500:0.1
+func (s *Server) httpbinStatus(rw http.ResponseWriter, req *http.Request) {
+	type choice struct {
+		code   int
+		weight float64
+	}
+	var choices []choice
+	var total float64
+	for _, part := range strings.Split(mux.Vars(req)["codes"], ",") {
+		code, weight, weighted := strings.Cut(part, ":")
+		c := choice{weight: 1}
+		var err error
+		if c.code, err = strconv.Atoi(code); err != nil || c.code < 100 || c.code > 999 {
+			writeError(rw, http.StatusBadRequest, fmt.Errorf("invalid status code %q", code))
+			return
+		}
+		if weighted {
+			if c.weight, err = strconv.ParseFloat(weight, 64); err != nil || c.weight < 0 {
+				writeError(rw, http.StatusBadRequest, fmt.Errorf("invalid weight %q", weight))
+				return
+			}
+		}
+		choices = append(choices, c)
+		total += c.weight
+	}
+	code := choices[len(choices)-1].code
+	roll := s.rand.Float64() * total
+	for _, c := range choices {
+		if roll < c.weight {
+			code = c.code
+			break
+		}
+		roll -= c.weight
+	}
+	switch {
+	case code == http.StatusUnauthorized:
+		rw.Header().Set("WWW-Authenticate", `Basic realm="Fake Realm"`)
+	case code == http.StatusProxyAuthRequired:
+		rw.Header().Set("Proxy-Authenticate", `Basic realm="Fake Realm"`)
+	case code >= 300 && code < 400 && code != http.StatusNotModified:
+		rw.Header().Set("Location", "/redirect/1")
+	}
+	rw.WriteHeader(code)
+}
+
+// httpbinStream writes n JSON lines, at most 100, the request with an id:
+//
+//	/stream/20
+func (s *Server) httpbinStream(rw http.ResponseWriter, req *http.Request) {
+	n, err := strconv.Atoi(mux.Vars(req)["n"])
+	if err != nil || n < 0 {
+		writeError(rw, http.StatusBadRequest, fmt.Errorf("invalid count %q", mux.Vars(req)["n"]))
+		return
+	}
+	resp, _ := httpbinRequest(req, false)
+	rw.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(rw)
+	flusher, _ := rw.(http.Flusher)
+	for id := range min(n, httpbinMaxStream) {
+		resp["id"] = id
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package slowproxy
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// earlyHints sends 103 Early Hints before handing the request to the route:
+//
+//	?early_hints=2&link=</style.css>; rel=preload; as=style&hint_delay=500ms
+//
+// Each hint carries every link parameter and is preceded by hint_delay.
+func (s *Server) earlyHints(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		if !q.Has("early_hints") {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		hints, err := intQuery(req, "early_hints", 1)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		delay, err := durationQuery(req, "hint_delay", 0)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		links := q["link"]
+		if len(links) == 0 {
+			links = []string{"</style.css>; rel=preload; as=style"}
+		}
+
+		for i := 0; i < hints; i++ {
+			if delay > 0 {
+				if err := s.Pause(req.Context(), delay, 0, nil); err != nil {
+					return
+				}
+			}
+			s.logger.Debug("sending early hints", zap.String("url", req.URL.String()), zap.Strings("link", links))
+			for _, link := range links {
+				rw.Header().Add("Link", link)
+			}
+			rw.WriteHeader(http.StatusEarlyHints)
+			rw.Header().Del("Link")
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
+
+// interimResponses sends unusual informational responses before the route
+// runs, pausing interim_delay after each one:
+//
+//	?interim=100,100,102&interim_delay=30s
+func (s *Server) interimResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		v := req.URL.Query().Get("interim")
+		if v == "" {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		var codes []int
+		for _, field := range strings.Split(v, ",") {
+			code, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil || code < 100 || code > 199 || code == http.StatusSwitchingProtocols {
+				http.Error(rw, fmt.Sprintf("invalid interim status %q, expected 1xx other than 101", field), http.StatusBadRequest)
+				return
+			}
+			codes = append(codes, code)
+		}
+		delay, err := durationQuery(req, "interim_delay", 0)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		for _, code := range codes {
+			s.logger.Debug("sending interim response", zap.String("url", req.URL.String()), zap.Int("status", code))
+			rw.WriteHeader(code)
+			if delay > 0 {
+				if err := s.Pause(req.Context(), delay, 0, nil); err != nil {
+					return
+				}
+			}
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
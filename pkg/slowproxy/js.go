@@ -0,0 +1,180 @@
+package slowproxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dop251/goja"
+	"go.uber.org/zap"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// jsTimeout bounds one run of a script, a loop that never ends answers 500
+// instead of holding the request until the client gives up.
+const jsTimeout = time.Second
+
+var errJSInterrupted = errors.New("request cancelled")
+
+// jsHook runs a JavaScript scenario for every request. The interpreter is
+// goja, which speaks ES2015 and later: arrow functions, let, const and
+// template literals. Every run gets a fresh runtime, so globals set by one
+// request are gone for the next.
+type jsHook struct {
+	name    string
+	program *goja.Program
+	rand    *seededRand
+}
+
+func newJSHook(path string, r *seededRand) (*jsHook, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	program, err := goja.Compile(path, string(src), false)
+	if err != nil {
+		return nil, err
+	}
+	return &jsHook{name: path, program: program, rand: r}, nil
+}
+
+// jsResponse is the object scripts pass to respond().
+type jsResponse struct {
+	Status         int               `json:"status"`
+	Delay          string            `json:"delay"`
+	DelayMS        float64           `json:"delayMs"`
+	Abort          bool              `json:"abort"`
+	Body           any               `json:"body"`
+	Headers        map[string]string `json:"headers"`
+	RequestHeaders map[string]string `json:"requestHeaders"`
+}
+
+func (h *jsHook) call(logger *zap.Logger, req *http.Request) (*hookDecision, error) {
+	vm := goja.New()
+	timer := time.AfterFunc(jsTimeout, func() {
+		vm.Interrupt(fmt.Errorf("script ran for more than %s", jsTimeout))
+	})
+	defer timer.Stop()
+	stop := context.AfterFunc(req.Context(), func() { vm.Interrupt(errJSInterrupted) })
+	defer stop()
+
+	query := map[string]string{}
+	for k, v := range req.URL.Query() {
+		query[k] = v[0]
+	}
+	headers := map[string]string{}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.Join(v, ", ")
+	}
+	vm.Set("request", map[string]any{
+		"method":  req.Method,
+		"path":    req.URL.Path,
+		"host":    req.Host,
+		"remote":  remoteIP(req.RemoteAddr),
+		"query":   query,
+		"headers": headers,
+	})
+	var resp goja.Value
+	vm.Set("respond", func(call goja.FunctionCall) goja.Value {
+		resp = call.Argument(0)
+		return goja.Undefined()
+	})
+	vm.Set("rand", func(min, max int64) int64 {
+		if max <= min {
+			return min
+		}
+		return min + h.rand.Int63n(max-min+1)
+	})
+	vm.Set("log", func(call goja.FunctionCall) goja.Value {
+		var parts []string
+		for _, a := range call.Arguments {
+			parts = append(parts, a.String())
+		}
+		logger.Info(strings.Join(parts, " "), zap.String("script", h.name))
+		return goja.Undefined()
+	})
+
+	if _, err := vm.RunProgram(h.program); err != nil {
+		var interrupted *goja.InterruptedError
+		if errors.As(err, &interrupted) {
+			if v, ok := interrupted.Value().(error); ok {
+				return nil, v
+			}
+		}
+		return nil, err
+	}
+	if _, ok := resp.(*goja.Object); !ok {
+		return &hookDecision{}, nil
+	}
+	return decodeJSResponse(resp)
+}
+
+func decodeJSResponse(v goja.Value) (*hookDecision, error) {
+	raw, err := json.Marshal(v.Export())
+	if err != nil {
+		return nil, err
+	}
+	var r jsResponse
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return nil, fmt.Errorf("invalid respond() argument: %w", err)
+	}
+	d := &hookDecision{
+		delay:          time.Duration(r.DelayMS * float64(time.Millisecond)),
+		abort:          r.Abort,
+		status:         r.Status,
+		headers:        r.Headers,
+		requestHeaders: r.RequestHeaders,
+	}
+	if r.Delay != "" {
+		if d.delay, err = time.ParseDuration(r.Delay); err != nil {
+			return nil, fmt.Errorf("invalid delay: %w", err)
+		}
+	}
+	if d.status != 0 && (d.status < 200 || d.status > 599) {
+		return nil, fmt.Errorf("status %d out of range 200-599", d.status)
+	}
+	switch body := r.Body.(type) {
+	case nil:
+	case string:
+		d.body, d.hasBody = body, true
+	default:
+		b, _ := json.Marshal(body)
+		d.body, d.hasBody = string(b)+"\n", true
+		if d.headers == nil {
+			d.headers = map[string]string{}
+		}
+		if _, ok := d.headers["Content-Type"]; !ok {
+			d.headers["Content-Type"] = "application/json"
+		}
+	}
+	return d, nil
+}
+
+// jsMiddleware runs the -js scenario for every request but the admin API.
+// The script sees a request object and calls respond({...}) to delay, abort
+// or answer the request, or nothing to let it through. Script errors answer
+// 500.
+func (s *Server) jsMiddleware(next http.Handler) http.Handler {
+	if s.js == nil {
+		return next
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/admin/") {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		d, err := s.js.call(s.logger, req)
+		if errors.Is(err, errJSInterrupted) {
+			return
+		}
+		if err != nil {
+			s.logger.With(zap.Error(err)).Error("js script failed", zap.String("path", req.URL.Path))
+			writeError(rw, http.StatusInternalServerError, fmt.Errorf("js: %w", err))
+			return
+		}
+		s.serveDecision(rw, req, next, d)
+	})
+}
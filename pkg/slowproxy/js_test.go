@@ -0,0 +1,98 @@
+package slowproxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSHook(t *testing.T) {
+	const script = `
+const mode = request.query.mode;
+if (mode === "status") {
+  respond({status: 503, body: ` + "`down for ${request.method}`" + `, headers: {"Retry-After": "5"}});
+} else if (mode === "json") {
+  respond({status: 429, body: {error: "slow down", path: request.path}});
+} else if (mode === "header") {
+  respond({requestHeaders: {"X-From-JS": request.headers["x-in"]}});
+} else if (mode === "delay") {
+  respond({delayMs: 50});
+} else if (mode === "bad status") {
+  respond({status: 99});
+} else if (mode === "throw") {
+  throw new Error("boom");
+} else if (mode === "global") {
+  respond({body: String(typeof counted)});
+  var counted = true;
+}
+`
+	tests := []struct {
+		name            string
+		path            string
+		want            int
+		wantBody        string
+		wantContentType string
+		wantSlow        bool
+	}{
+		{name: "no respond", path: "/status/201", want: http.StatusCreated},
+		{name: "response", path: "/echo?mode=status", want: http.StatusServiceUnavailable, wantBody: "down for GET", wantContentType: "text/plain; charset=utf-8"},
+		{name: "json body", path: "/echo?mode=json", want: http.StatusTooManyRequests, wantBody: `{"error":"slow down","path":"/echo"}`, wantContentType: "application/json"},
+		{name: "request headers", path: "/echo?mode=header", want: http.StatusOK, wantBody: `"X-From-Js":["in"]`},
+		{name: "delay", path: "/echo?mode=delay", want: http.StatusOK, wantSlow: true},
+		{name: "bad status", path: "/echo?mode=bad%20status", want: http.StatusInternalServerError, wantBody: "out of range"},
+		{name: "throw", path: "/echo?mode=throw", want: http.StatusInternalServerError, wantBody: "boom"},
+		{name: "fresh scope", path: "/echo?mode=global", want: http.StatusOK, wantBody: "undefined"},
+		{name: "fresh scope again", path: "/echo?mode=global", want: http.StatusOK, wantBody: "undefined"},
+		{name: "admin", path: "/admin/stats?mode=throw", want: http.StatusOK},
+	}
+	s := newTestServer(t, WithOptions(Options{JSScript: writeScript(t, "scenario.js", script)}))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			req.Header.Set("X-In", "in")
+			start := time.Now()
+			rec := serve(s, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body = %q, want it to contain %q", rec.Body, tt.wantBody)
+			}
+			if got := rec.Header().Get("Content-Type"); tt.wantContentType != "" && got != tt.wantContentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantContentType)
+			}
+			if slow := time.Since(start) >= 50*time.Millisecond; slow != tt.wantSlow {
+				t.Errorf("took %s", time.Since(start))
+			}
+		})
+	}
+}
+
+func TestJSHookInvalid(t *testing.T) {
+	_, err := New(WithOptions(Options{JSScript: writeScript(t, "scenario.js", "respond({")}))
+	if err == nil || !strings.Contains(err.Error(), "scenario.js") {
+		t.Errorf("New() = %v, want a syntax error", err)
+	}
+}
+
+func TestJSHookTimeout(t *testing.T) {
+	s := newTestServer(t, WithOptions(Options{JSScript: writeScript(t, "scenario.js", `if (request.query.loop) { for (;;) {} }`)}))
+	start := time.Now()
+	rec := serve(s, httptest.NewRequest("GET", "/status/200?loop=1", nil))
+	var body struct {
+		Error string `json:"error"`
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &body)
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(body.Error, "script ran for more than 1s") {
+		t.Errorf("endless script = %d %s, want a 500 for the timeout", rec.Code, rec.Body)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("endless script answered after %s", elapsed)
+	}
+	if rec := serve(s, httptest.NewRequest("GET", "/status/200", nil)); rec.Code != http.StatusOK {
+		t.Errorf("next request = %d, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,268 @@
+package slowproxy
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwtKeepKeys is how many signing keys stay valid for verification.
+const jwtKeepKeys = 3
+
+type jwtKey struct {
+	kid string
+	key *rsa.PrivateKey
+}
+
+// jwtKeys is the signing keyring behind /jwt and the JWKS endpoint. Keys are
+// generated on first use so servers that never mint a token pay nothing.
+type jwtKeys struct {
+	mu        sync.Mutex
+	keys      []*jwtKey // newest first
+	published []*jwtKey // the JWKS as of before the last rotation
+	stale     bool
+	delay     time.Duration
+	nextKid   int
+}
+
+// JWTState is reported and changed by /admin/jwt.
+type JWTState struct {
+	Kids      []string `json:"kids"`
+	StaleJWKS bool     `json:"stale_jwks"`
+	JWKSDelay string   `json:"jwks_delay"`
+}
+
+func (k *jwtKeys) rotateLocked() error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+	k.nextKid++
+	k.published = append([]*jwtKey(nil), k.keys...)
+	k.keys = append([]*jwtKey{{kid: fmt.Sprintf("key-%d", k.nextKid), key: key}}, k.keys...)
+	if len(k.keys) > jwtKeepKeys {
+		k.keys = k.keys[:jwtKeepKeys]
+	}
+	return nil
+}
+
+func (k *jwtKeys) rotate() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.rotateLocked()
+}
+
+func (k *jwtKeys) rotateEvery(done <-chan struct{}, logger *zap.Logger, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := k.rotate(); err != nil {
+				logger.With(zap.Error(err)).Error("failed to rotate jwt key")
+			}
+		}
+	}
+}
+
+func (k *jwtKeys) current() (*jwtKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if len(k.keys) == 0 {
+		if err := k.rotateLocked(); err != nil {
+			return nil, err
+		}
+	}
+	return k.keys[0], nil
+}
+
+func (k *jwtKeys) lookup(kid string) *rsa.PublicKey {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, key := range k.keys {
+		if key.kid == kid {
+			return &key.key.PublicKey
+		}
+	}
+	return nil
+}
+
+// jwks returns the keys to publish, the pre-rotation set when stale.
+func (k *jwtKeys) jwks() ([]*jwtKey, time.Duration) {
+	if _, err := k.current(); err != nil {
+		return nil, 0
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.stale {
+		return k.published, k.delay
+	}
+	return k.keys, k.delay
+}
+
+func (k *jwtKeys) state() JWTState {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	kids := []string{}
+	for _, key := range k.keys {
+		kids = append(kids, key.kid)
+	}
+	return JWTState{Kids: kids, StaleJWKS: k.stale, JWKSDelay: k.delay.String()}
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// jwksHandler publishes the verification keys, possibly late or stale:
+//
+//	/.well-known/jwks.json
+func (s *Server) jwksHandler(rw http.ResponseWriter, req *http.Request) {
+	keys, delay := s.jwt.jwks()
+	if delay > 0 {
+		if err := s.Pause(req.Context(), delay, 0, nil); err != nil {
+			return
+		}
+	}
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{Keys: []jwk{}}
+	for _, key := range keys {
+		pub := key.key.PublicKey
+		set.Keys = append(set.Keys, jwk{
+			Kty: "RSA",
+			Kid: key.kid,
+			Use: "sig",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		})
+	}
+	writeJSON(rw, http.StatusOK, set)
+}
+
+// mintJWT signs a token with the current key. Claims come from the JSON body
+// and claim=name:value parameters on top of iss, sub, iat and exp:
+//
+//	/jwt/mint?sub=alice&ttl=5m&claim=role:admin
+func (s *Server) mintJWT(rw http.ResponseWriter, req *http.Request) {
+	ttl, err := durationQuery(req, "ttl", s.opts.JWTTTL)
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	now := skewedNow(req.Context())
+	claims := jwt.MapClaims{}
+	if req.Method == http.MethodPost && req.ContentLength != 0 {
+		if err := json.NewDecoder(req.Body).Decode(&claims); err != nil {
+			writeError(rw, http.StatusBadRequest, fmt.Errorf("invalid claims: %w", err))
+			return
+		}
+	}
+	claims["iss"] = s.opts.JWTIssuer
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(ttl).Unix()
+	if sub := req.URL.Query().Get("sub"); sub != "" {
+		claims["sub"] = sub
+	}
+	for _, c := range req.URL.Query()["claim"] {
+		name, value, ok := strings.Cut(c, ":")
+		if !ok {
+			writeError(rw, http.StatusBadRequest, fmt.Errorf("invalid claim %q, want name:value", c))
+			return
+		}
+		claims[name] = value
+	}
+
+	key, err := s.jwt.current()
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = key.kid
+	signed, err := token.SignedString(key.key)
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(rw, http.StatusOK, map[string]any{"token": signed, "kid": key.kid, "expires_in": ceilSeconds(ttl)})
+}
+
+// protectedJWT answers with the claims of a valid bearer JWT signed by one
+// of the recent keys:
+//
+//	/jwt/protected
+func (s *Server) protectedJWT(rw http.ResponseWriter, req *http.Request) {
+	raw, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		rw.Header().Set("WWW-Authenticate", `Bearer realm="`+authRealm+`"`)
+		writeAPIError(rw, http.StatusUnauthorized, &apiError{Code: "unauthorized", Message: "missing bearer token"})
+		return
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		if key := s.jwt.lookup(kid); key != nil {
+			return key, nil
+		}
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}, jwt.WithValidMethods([]string{"RS256"}), jwt.WithIssuer(s.opts.JWTIssuer))
+	if err != nil {
+		rw.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s", error="invalid_token", error_description=%q`, authRealm, err.Error()))
+		writeAPIError(rw, http.StatusUnauthorized, &apiError{Code: "invalid_token", Message: err.Error()})
+		return
+	}
+	writeJSON(rw, http.StatusOK, map[string]any{"authenticated": true, "claims": claims})
+}
+
+func (s *Server) getJWT(rw http.ResponseWriter, req *http.Request) {
+	writeJSON(rw, http.StatusOK, s.jwt.state())
+}
+
+// setJWT handles PUT /admin/jwt?stale_jwks=true&jwks_delay=2s.
+func (s *Server) setJWT(rw http.ResponseWriter, req *http.Request) {
+	s.jwt.mu.Lock()
+	stale, err := boolQuery(req, "stale_jwks", s.jwt.stale)
+	if err == nil {
+		s.jwt.stale = stale
+	}
+	delay, derr := durationQuery(req, "jwks_delay", s.jwt.delay)
+	if derr == nil {
+		s.jwt.delay = delay
+	}
+	s.jwt.mu.Unlock()
+	if err == nil {
+		err = derr
+	}
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	s.getJWT(rw, req)
+}
+
+func (s *Server) rotateJWT(rw http.ResponseWriter, req *http.Request) {
+	if err := s.jwt.rotate(); err != nil {
+		writeError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	s.logger.Info("rotated jwt signing key")
+	s.getJWT(rw, req)
+}
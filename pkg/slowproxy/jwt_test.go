@@ -0,0 +1,126 @@
+package slowproxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// mint returns a token from /jwt/mint?query.
+func mint(t *testing.T, s *Server, query string) string {
+	t.Helper()
+	rec := serve(s, httptest.NewRequest("GET", "/jwt/mint?"+query, nil))
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("mint: %d %s", rec.Code, rec.Body)
+	}
+	return body.Token
+}
+
+// jwksKids returns the key ids published by the JWKS endpoint.
+func jwksKids(t *testing.T, s *Server) []string {
+	t.Helper()
+	rec := serve(s, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &set); err != nil {
+		t.Fatal(err)
+	}
+	kids := []string{}
+	for _, k := range set.Keys {
+		kids = append(kids, k.Kid)
+	}
+	return kids
+}
+
+// adminJWT calls /admin/jwt+path, which must succeed.
+func adminJWT(t *testing.T, s *Server, method, path string) {
+	t.Helper()
+	if rec := serve(s, httptest.NewRequest(method, "/admin/jwt"+path, nil)); rec.Code != http.StatusOK {
+		t.Fatalf("%s /admin/jwt%s: %d %s", method, path, rec.Code, rec.Body)
+	}
+}
+
+func TestJWT(t *testing.T) {
+	s := newTestServer(t)
+	valid := mint(t, s, "sub=alice&claim=role:admin")
+	expired := mint(t, s, "ttl=-1m")
+	tests := []struct {
+		name          string
+		authorization string
+		wantCode      string
+	}{
+		{name: "valid", authorization: "Bearer " + valid},
+		{name: "expired", authorization: "Bearer " + expired, wantCode: "invalid_token"},
+		{name: "tampered", authorization: "Bearer " + valid[:len(valid)-4] + "AAAA", wantCode: "invalid_token"},
+		{name: "garbage", authorization: "Bearer x.y.z", wantCode: "invalid_token"},
+		{name: "missing", wantCode: "unauthorized"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/jwt/protected", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := serve(s, req)
+			if got := errorCode(rec); got != tt.wantCode {
+				t.Fatalf("error code = %q, want %q: %s", got, tt.wantCode, rec.Body)
+			}
+			if tt.wantCode != "" {
+				if rec.Code != http.StatusUnauthorized || !strings.HasPrefix(rec.Header().Get("WWW-Authenticate"), "Bearer ") {
+					t.Errorf("status = %d, WWW-Authenticate = %q", rec.Code, rec.Header().Get("WWW-Authenticate"))
+				}
+				return
+			}
+			var body struct {
+				Claims map[string]any `json:"claims"`
+			}
+			_ = json.Unmarshal(rec.Body.Bytes(), &body)
+			if body.Claims["sub"] != "alice" || body.Claims["role"] != "admin" || body.Claims["iss"] != "slow-proxy" {
+				t.Errorf("claims = %v", body.Claims)
+			}
+		})
+	}
+}
+
+func TestJWTRotation(t *testing.T) {
+	s := newTestServer(t)
+	first := mint(t, s, "")
+	if got := jwksKids(t, s); !slices.Equal(got, []string{"key-1"}) {
+		t.Fatalf("jwks = %v, want [key-1]", got)
+	}
+
+	// a stale JWKS keeps publishing the keys from before the rotation
+	adminJWT(t, s, "PUT", "?stale_jwks=true")
+	adminJWT(t, s, "POST", "/rotate")
+	if got := jwksKids(t, s); !slices.Equal(got, []string{"key-1"}) {
+		t.Errorf("stale jwks = %v, want [key-1]", got)
+	}
+	adminJWT(t, s, "PUT", "?stale_jwks=false")
+	if got := jwksKids(t, s); !slices.Equal(got, []string{"key-2", "key-1"}) {
+		t.Errorf("jwks = %v, want [key-2 key-1]", got)
+	}
+
+	verify := func(token string) int {
+		req := httptest.NewRequest("GET", "/jwt/protected", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return serve(s, req).Code
+	}
+	if code := verify(first); code != http.StatusOK {
+		t.Errorf("token of the previous key answered %d", code)
+	}
+	adminJWT(t, s, "POST", "/rotate")
+	adminJWT(t, s, "POST", "/rotate")
+	if code := verify(first); code != http.StatusUnauthorized {
+		t.Errorf("token of a dropped key answered %d, want 401", code)
+	}
+	if code := verify(mint(t, s, "")); code != http.StatusOK {
+		t.Errorf("token of the current key answered %d", code)
+	}
+}
@@ -0,0 +1,222 @@
+package slowproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	lua "github.com/yuin/gopher-lua"
+	"go.uber.org/zap"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// luaStates is how many idle interpreters are kept for reuse. Every request
+// runs on one of its own, as they are not safe for concurrent use.
+const luaStates = 16
+
+// luaTimeout bounds one run of on_request, a loop that never ends answers 500
+// instead of holding the request until the client gives up.
+const luaTimeout = time.Second
+
+// luaHook runs the on_request function of a Lua script for every request.
+// Interpreters are reused, so globals set by one request are still there for
+// a later one: keep per-request state in locals.
+type luaHook struct {
+	name   string
+	source string
+	states chan *lua.LState
+	rand   *seededRand
+}
+
+func newLuaHook(path string, r *seededRand) (*luaHook, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	h := &luaHook{name: path, source: string(src), states: make(chan *lua.LState, luaStates), rand: r}
+	L, err := h.newState()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := L.GetGlobal("on_request").(*lua.LFunction); !ok {
+		L.Close()
+		return nil, fmt.Errorf("%s: no on_request function", path)
+	}
+	h.states <- L
+	return h, nil
+}
+
+func (h *luaHook) newState() (*lua.LState, error) {
+	L := lua.NewState()
+	L.SetField(L.GetGlobal("math"), "random", L.NewFunction(h.random))
+	fn, err := L.Load(strings.NewReader(h.source), h.name)
+	if err == nil {
+		L.Push(fn)
+		err = L.PCall(0, lua.MultRet, nil)
+	}
+	if err != nil {
+		L.Close()
+		return nil, err
+	}
+	return L, nil
+}
+
+func (h *luaHook) get() (*lua.LState, error) {
+	select {
+	case L := <-h.states:
+		return L, nil
+	default:
+		return h.newState()
+	}
+}
+
+func (h *luaHook) put(L *lua.LState) {
+	select {
+	case h.states <- L:
+	default:
+		L.Close()
+	}
+}
+
+func (h *luaHook) call(req *http.Request) (*hookDecision, error) {
+	L, err := h.get()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), luaTimeout)
+	defer cancel()
+	L.SetContext(ctx)
+	defer func() {
+		L.RemoveContext()
+		if ctx.Err() != nil {
+			// an interrupted script may have left the state half way
+			L.Close()
+			return
+		}
+		h.put(L)
+	}()
+
+	t := L.NewTable()
+	t.RawSetString("method", lua.LString(req.Method))
+	t.RawSetString("path", lua.LString(req.URL.Path))
+	t.RawSetString("host", lua.LString(req.Host))
+	t.RawSetString("remote", lua.LString(remoteIP(req.RemoteAddr)))
+	query := L.NewTable()
+	for k, v := range req.URL.Query() {
+		query.RawSetString(k, lua.LString(v[0]))
+	}
+	t.RawSetString("query", query)
+	headers := L.NewTable()
+	for k, v := range req.Header {
+		headers.RawSetString(strings.ToLower(k), lua.LString(strings.Join(v, ", ")))
+	}
+	t.RawSetString("headers", headers)
+
+	if err := L.CallByParam(lua.P{Fn: L.GetGlobal("on_request"), NRet: 1, Protect: true}, t); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) && req.Context().Err() == nil {
+			return nil, fmt.Errorf("script ran for more than %s", luaTimeout)
+		}
+		return nil, err
+	}
+	ret := L.Get(-1)
+	L.Pop(1)
+	tbl, ok := ret.(*lua.LTable)
+	if !ok {
+		if ret != lua.LNil {
+			return nil, fmt.Errorf("on_request returned a %s, want a table or nil", ret.Type())
+		}
+		return &hookDecision{}, nil
+	}
+	return decodeLuaDecision(tbl)
+}
+
+// decodeLuaDecision reads {delay = "2s" or delay_ms = 2000, abort = true,
+// status = 503, body = "...", headers = {...}, request_headers = {...}}.
+func decodeLuaDecision(t *lua.LTable) (*hookDecision, error) {
+	d := &hookDecision{}
+	switch v := t.RawGetString("delay").(type) {
+	case lua.LString:
+		delay, err := time.ParseDuration(string(v))
+		if err != nil {
+			return nil, fmt.Errorf("invalid delay: %w", err)
+		}
+		d.delay = delay
+	case lua.LNumber:
+		d.delay = time.Duration(float64(v) * float64(time.Second))
+	}
+	if v, ok := t.RawGetString("delay_ms").(lua.LNumber); ok {
+		d.delay = time.Duration(float64(v) * float64(time.Millisecond))
+	}
+	d.abort = lua.LVAsBool(t.RawGetString("abort"))
+	if v, ok := t.RawGetString("status").(lua.LNumber); ok {
+		d.status = int(v)
+		if d.status < 200 || d.status > 599 {
+			return nil, fmt.Errorf("status %d out of range 200-599", d.status)
+		}
+	}
+	if v, ok := t.RawGetString("body").(lua.LString); ok {
+		d.body, d.hasBody = string(v), true
+	}
+	d.headers = luaStrings(t.RawGetString("headers"))
+	d.requestHeaders = luaStrings(t.RawGetString("request_headers"))
+	return d, nil
+}
+
+func luaStrings(v lua.LValue) map[string]string {
+	t, ok := v.(*lua.LTable)
+	if !ok {
+		return nil
+	}
+	m := map[string]string{}
+	t.ForEach(func(k, v lua.LValue) {
+		m[k.String()] = v.String()
+	})
+	return m
+}
+
+// luaMiddleware lets the -lua script decide about every request but the
+// admin API. on_request(req) gets the method, path, host, remote address,
+// query and lower-cased headers, and returns nil to let the request through
+// or a table asking for a delay, an abort, a response and header changes.
+// Script errors answer 500.
+func (s *Server) luaMiddleware(next http.Handler) http.Handler {
+	if s.lua == nil {
+		return next
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/admin/") {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		d, err := s.lua.call(req)
+		if err != nil {
+			s.logger.With(zap.Error(err)).Error("lua script failed", zap.String("path", req.URL.Path))
+			writeError(rw, http.StatusInternalServerError, fmt.Errorf("lua: %w", err))
+			return
+		}
+		s.serveDecision(rw, req, next, d)
+	})
+}
+
+// random is math.random on the seeded source of the server.
+func (h *luaHook) random(L *lua.LState) int {
+	switch L.GetTop() {
+	case 0:
+		L.Push(lua.LNumber(h.rand.Float64()))
+	case 1:
+		m := L.CheckInt64(1)
+		if m < 1 {
+			L.ArgError(1, "interval is empty")
+		}
+		L.Push(lua.LNumber(1 + h.rand.Int63n(m)))
+	default:
+		m, n := L.CheckInt64(1), L.CheckInt64(2)
+		if m > n {
+			L.ArgError(2, "interval is empty")
+		}
+		L.Push(lua.LNumber(m + h.rand.Int63n(n-m+1)))
+	}
+	return 1
+}
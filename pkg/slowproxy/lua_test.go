@@ -0,0 +1,138 @@
+package slowproxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeScript saves src to a file named name in a temporary directory.
+func writeScript(t *testing.T, name, src string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLuaHook(t *testing.T) {
+	const script = `
+function on_request(req)
+  if req.path == "/status/200" then
+    return nil
+  elseif req.query.mode == "status" then
+    return {status = 503, body = "down for " .. req.method, headers = {["Retry-After"] = "5"}}
+  elseif req.query.mode == "header" then
+    return {request_headers = {["X-From-Lua"] = req.headers["x-in"]}}
+  elseif req.query.mode == "delay" then
+    return {delay_ms = 50}
+  elseif req.query.mode == "bad status" then
+    return {status = 99}
+  elseif req.query.mode == "error" then
+    error("boom")
+  elseif req.query.mode == "string" then
+    return "nope"
+  end
+end
+`
+	tests := []struct {
+		name       string
+		path       string
+		want       int
+		wantBody   string
+		wantHeader string
+		wantSlow   bool
+	}{
+		{name: "nil", path: "/status/200", want: http.StatusOK},
+		{name: "response", path: "/echo?mode=status", want: http.StatusServiceUnavailable, wantBody: "down for GET", wantHeader: "5"},
+		{name: "request headers", path: "/echo?mode=header", want: http.StatusOK, wantBody: `"X-From-Lua":["in"]`},
+		{name: "delay", path: "/echo?mode=delay", want: http.StatusOK, wantSlow: true},
+		{name: "bad status", path: "/echo?mode=bad%20status", want: http.StatusInternalServerError, wantBody: "out of range"},
+		{name: "error", path: "/echo?mode=error", want: http.StatusInternalServerError, wantBody: "boom"},
+		{name: "wrong type", path: "/echo?mode=string", want: http.StatusInternalServerError, wantBody: "returned a string"},
+		{name: "admin", path: "/admin/stats?mode=error", want: http.StatusOK},
+	}
+	s := newTestServer(t, WithOptions(Options{LuaScript: writeScript(t, "hook.lua", script)}))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			req.Header.Set("X-In", "in")
+			start := time.Now()
+			rec := serve(s, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body = %q, want it to contain %q", rec.Body, tt.wantBody)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tt.wantHeader {
+				t.Errorf("Retry-After = %q, want %q", got, tt.wantHeader)
+			}
+			if slow := time.Since(start) >= 50*time.Millisecond; slow != tt.wantSlow {
+				t.Errorf("took %s", time.Since(start))
+			}
+		})
+	}
+}
+
+func TestLuaHookAbort(t *testing.T) {
+	s := newTestServer(t, WithOptions(Options{LuaScript: writeScript(t, "hook.lua", `function on_request(req) return {abort = true} end`)}))
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+	resp, err := http.Get(ts.URL + "/status/200")
+	if err == nil {
+		resp.Body.Close()
+		t.Fatalf("got a %d, want the connection dropped", resp.StatusCode)
+	}
+}
+
+// TestLuaHookGlobals shows that interpreters, and so their globals, are
+// shared by the requests they serve in turn.
+func TestLuaHookGlobals(t *testing.T) {
+	const script = `
+function on_request(req)
+  count = (count or 0) + 1
+  return {body = tostring(count)}
+end
+`
+	s := newTestServer(t, WithOptions(Options{LuaScript: writeScript(t, "hook.lua", script)}))
+	for _, want := range []string{"1", "2", "3"} {
+		if got := serve(s, httptest.NewRequest("GET", "/status/200", nil)).Body.String(); got != want {
+			t.Errorf("count = %s, want %s", got, want)
+		}
+	}
+}
+
+func TestLuaHookTimeout(t *testing.T) {
+	const script = `
+function on_request(req)
+  if req.query.loop then
+    while true do end
+  end
+  return {body = "ok"}
+end
+`
+	s := newTestServer(t, WithOptions(Options{LuaScript: writeScript(t, "hook.lua", script)}))
+	start := time.Now()
+	rec := serve(s, httptest.NewRequest("GET", "/status/200?loop=1", nil))
+	var body struct {
+		Error string `json:"error"`
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &body)
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(body.Error, "script ran for more than 1s") {
+		t.Errorf("endless script = %d %s, want a 500 for the timeout", rec.Code, rec.Body)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("endless script answered after %s", elapsed)
+	}
+	// the interrupted interpreter is thrown away rather than reused
+	if rec := serve(s, httptest.NewRequest("GET", "/status/200", nil)); rec.Body.String() != "ok" {
+		t.Errorf("next request = %d %q, want ok", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,222 @@
+package slowproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxMarkovSteps bounds how many time slices are caught up at once after a
+// quiet period, the chain has long forgotten where it started by then.
+const maxMarkovSteps = 10000
+
+// MarkovModel is a fault model whose state, like healthy, degraded or down,
+// moves between States with the probabilities of their Next. Step is
+// "request" to move after every matching request, the default, or a
+// duration to move once per time slice of the virtual clock, which keeps a
+// state across requests the way real incidents do.
+type MarkovModel struct {
+	Initial string                 `json:"initial" yaml:"initial"`
+	Step    string                 `json:"step,omitempty" yaml:"step,omitempty"`
+	Match   Matcher                `json:"match,omitzero" yaml:"match,omitempty"`
+	States  map[string]MarkovState `json:"states" yaml:"states"`
+}
+
+// MarkovState is the fault applied to requests while in the state, and the
+// probabilities of moving to each state next. Probabilities that sum to
+// less than one leave the rest to staying put.
+type MarkovState struct {
+	Fault Fault              `json:"fault,omitzero" yaml:"fault,omitempty"`
+	Next  map[string]float64 `json:"next,omitempty" yaml:"next,omitempty"`
+}
+
+// step returns the time slice of the model, zero for per request.
+func (m MarkovModel) step() (time.Duration, error) {
+	if m.Step == "" || m.Step == "request" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(m.Step)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("markov: step %q is neither \"request\" nor a positive duration", m.Step)
+	}
+	return d, nil
+}
+
+// Validate reports models that cannot run.
+func (m MarkovModel) Validate() error {
+	if _, ok := m.States[m.Initial]; !ok {
+		return fmt.Errorf("markov: initial state %q is not defined", m.Initial)
+	}
+	if _, err := m.step(); err != nil {
+		return err
+	}
+	if err := m.Match.validateBody(); err != nil {
+		return fmt.Errorf("markov: %w", err)
+	}
+	for name, st := range m.States {
+		if err := (Rule{Name: "markov " + name, Fault: st.Fault}).Validate(); err != nil {
+			return err
+		}
+		var sum float64
+		for next, p := range st.Next {
+			if _, ok := m.States[next]; !ok {
+				return fmt.Errorf("markov: state %q moves to undefined state %q", name, next)
+			}
+			if p < 0 {
+				return fmt.Errorf("markov: state %q has a negative probability", name)
+			}
+			sum += p
+		}
+		if sum > 1+1e-9 {
+			return fmt.Errorf("markov: probabilities of state %q sum to %v, over 1", name, sum)
+		}
+	}
+	return nil
+}
+
+// markovChain is a running model.
+type markovChain struct {
+	mu          sync.Mutex
+	model       *MarkovModel
+	slice       time.Duration
+	state       string
+	since       time.Time
+	lastStep    time.Time
+	transitions int64
+	rand        *seededRand // of the server, rolls the moves and percentages
+}
+
+func (c *markovChain) set(m *MarkovModel, now time.Time) error {
+	var slice time.Duration
+	if m != nil {
+		if err := m.Validate(); err != nil {
+			return err
+		}
+		slice, _ = m.step()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.model, c.slice, c.transitions = m, slice, 0
+	c.since, c.lastStep = now, now
+	if m != nil {
+		c.state = m.Initial
+	}
+	return nil
+}
+
+// move picks the next state, staying with the probability left over.
+func (c *markovChain) move(now time.Time) {
+	next := c.model.States[c.state].Next
+	names := make([]string, 0, len(next))
+	for name := range next {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	roll := c.rand.Float64()
+	for _, name := range names {
+		if roll < next[name] {
+			if name != c.state {
+				c.state, c.since = name, now
+				c.transitions++
+			}
+			return
+		}
+		roll -= next[name]
+	}
+}
+
+// catchUp moves a time sliced chain once for every slice passed by now.
+func (c *markovChain) catchUp(now time.Time) {
+	if c.model == nil || c.slice == 0 {
+		return
+	}
+	steps := int(now.Sub(c.lastStep) / c.slice)
+	for i := 0; i < min(steps, maxMarkovSteps); i++ {
+		c.move(now)
+	}
+	c.lastStep = c.lastStep.Add(time.Duration(steps) * c.slice)
+}
+
+// fault returns the fault of the current state for req, nil when no model
+// is running or it does not match, and moves the chain along.
+func (c *markovChain) fault(req *http.Request, now time.Time) *Fault {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.model == nil || !c.model.Match.Matches(req) {
+		return nil
+	}
+	c.catchUp(now)
+	f := c.model.States[c.state].Fault
+	if c.slice == 0 {
+		c.move(now)
+	}
+	if f.Percent > 0 && c.rand.Float64()*100 >= f.Percent {
+		return nil
+	}
+	return &f
+}
+
+// MarkovStatus is reported by /admin/markov.
+type MarkovStatus struct {
+	Model       *MarkovModel `json:"model"`
+	State       string       `json:"state,omitempty"`
+	Since       *time.Time   `json:"since,omitempty"`
+	Transitions int64        `json:"transitions"`
+}
+
+func (c *markovChain) status(now time.Time) MarkovStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.catchUp(now)
+	st := MarkovStatus{Model: c.model, Transitions: c.transitions}
+	if c.model != nil {
+		since := c.since
+		st.State, st.Since = c.state, &since
+	}
+	return st
+}
+
+// markovMiddleware applies the fault of the running model's state to every
+// route but the admin API.
+func (s *Server) markovMiddleware(next http.Handler) http.Handler {
+	faults := faultHandler(next, s.rand, func(req *http.Request) *Fault {
+		return s.markov.fault(req, s.clock.now())
+	})
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/admin/") {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		faults.ServeHTTP(rw, req)
+	})
+}
+
+func (s *Server) getMarkov(rw http.ResponseWriter, req *http.Request) {
+	writeJSON(rw, http.StatusOK, s.markov.status(s.clock.now()))
+}
+
+// putMarkov starts the JSON model in the body from its initial state.
+func (s *Server) putMarkov(rw http.ResponseWriter, req *http.Request) {
+	var m MarkovModel
+	if err := json.NewDecoder(req.Body).Decode(&m); err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.markov.set(&m, s.clock.now()); err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	s.logger.Info("started markov model", zap.String("initial", m.Initial), zap.Int("states", len(m.States)))
+	s.getMarkov(rw, req)
+}
+
+func (s *Server) deleteMarkov(rw http.ResponseWriter, req *http.Request) {
+	_ = s.markov.set(nil, s.clock.now())
+	s.logger.Info("stopped markov model")
+	s.getMarkov(rw, req)
+}
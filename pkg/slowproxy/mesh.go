@@ -0,0 +1,337 @@
+package slowproxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"time"
+)
+
+// ImportMesh converts service mesh fault injection into rules: Istio
+// VirtualServices, with the faults of their http routes, and Envoy HTTP fault
+// filters, either the filter entry with its typed_config or the bare
+// HTTPFault message. Several YAML documents may follow each other.
+//
+// Both meshes roll the delay and the abort percentage independently, a rule
+// applies its fault as a whole. When both are set with different
+// percentages the rule uses the abort's and says so in the warnings, like
+// for anything else that has no equivalent.
+func ImportMesh(r io.Reader) (*Config, []string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	im := &meshImport{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	for n := 0; ; n++ {
+		var doc meshDocument
+		if err := dec.Decode(&doc); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+		switch {
+		case doc.Kind == "VirtualService":
+			im.virtualService(doc)
+		case doc.Kind != "":
+			im.warn("document %d: skipping %s, only VirtualService has faults", n, doc.Kind)
+		case doc.TypedConfig != nil:
+			im.envoyFault(fmt.Sprintf("envoy-%d", n), *doc.TypedConfig)
+		case doc.Delay != nil || doc.Abort != nil:
+			im.envoyFault(fmt.Sprintf("envoy-%d", n), doc.envoyFault)
+		default:
+			im.warn("document %d: neither a VirtualService nor an Envoy fault filter", n)
+		}
+	}
+	cfg := &Config{Rules: im.rules}
+	if err := cfg.validate(); err != nil {
+		return nil, im.warnings, err
+	}
+	return cfg, im.warnings, nil
+}
+
+type meshImport struct {
+	rules    []Rule
+	warnings []string
+}
+
+func (im *meshImport) warn(format string, args ...any) {
+	im.warnings = append(im.warnings, fmt.Sprintf(format, args...))
+}
+
+type meshDocument struct {
+	Kind     string `yaml:"kind"`
+	Metadata struct {
+		Name string `yaml:"name"`
+	} `yaml:"metadata"`
+	Spec struct {
+		HTTP []istioRoute `yaml:"http"`
+	} `yaml:"spec"`
+
+	TypedConfig *envoyFault `yaml:"typed_config"`
+	envoyFault  `yaml:",inline"`
+}
+
+type istioRoute struct {
+	Name  string `yaml:"name"`
+	Match []struct {
+		URI     istioString            `yaml:"uri"`
+		Method  istioString            `yaml:"method"`
+		Headers map[string]istioString `yaml:"headers"`
+	} `yaml:"match"`
+	Fault *struct {
+		Delay *struct {
+			FixedDelay string           `yaml:"fixedDelay"`
+			Percentage *istioPercentage `yaml:"percentage"`
+			Percent    *float64         `yaml:"percent"`
+		} `yaml:"delay"`
+		Abort *struct {
+			HTTPStatus int              `yaml:"httpStatus"`
+			GRPCStatus string           `yaml:"grpcStatus"`
+			Percentage *istioPercentage `yaml:"percentage"`
+		} `yaml:"abort"`
+	} `yaml:"fault"`
+}
+
+type istioString struct {
+	Exact  string `yaml:"exact"`
+	Prefix string `yaml:"prefix"`
+	Regex  string `yaml:"regex"`
+}
+
+type istioPercentage struct {
+	Value float64 `yaml:"value"`
+}
+
+// meshFault is a delay and an abort with their own percentages, 100 when
+// left out as in both meshes.
+type meshFault struct {
+	delay        time.Duration
+	delayPercent float64
+	status       int
+	abortPercent float64
+}
+
+func (im *meshImport) virtualService(doc meshDocument) {
+	vs := doc.Metadata.Name
+	for i, route := range doc.Spec.HTTP {
+		if route.Fault == nil {
+			continue
+		}
+		name := fmt.Sprintf("%s-%d", vs, i)
+		if route.Name != "" {
+			name = vs + "-" + route.Name
+		}
+		mf := meshFault{delayPercent: 100, abortPercent: 100}
+		if d := route.Fault.Delay; d != nil {
+			var err error
+			if mf.delay, err = time.ParseDuration(d.FixedDelay); err != nil {
+				im.warn("%s: skipping delay: invalid fixedDelay %q", name, d.FixedDelay)
+			}
+			switch {
+			case d.Percentage != nil:
+				mf.delayPercent = d.Percentage.Value
+			case d.Percent != nil:
+				mf.delayPercent = *d.Percent
+			}
+		}
+		if a := route.Fault.Abort; a != nil {
+			mf.status = a.HTTPStatus
+			if a.GRPCStatus != "" && a.HTTPStatus == 0 {
+				im.warn("%s: skipping abort: grpcStatus %s has no HTTP equivalent", name, a.GRPCStatus)
+			}
+			if a.Percentage != nil {
+				mf.abortPercent = a.Percentage.Value
+			}
+		}
+		fault, ok := im.fault(name, mf)
+		if !ok {
+			continue
+		}
+		if len(route.Match) == 0 {
+			im.rules = append(im.rules, Rule{Name: name, Fault: fault})
+			continue
+		}
+		for j, m := range route.Match {
+			rule := Rule{Name: name, Fault: fault}
+			if len(route.Match) > 1 {
+				rule.Name = fmt.Sprintf("%s-%d", name, j)
+			}
+			if !im.match(rule.Name, "uri", m.URI, &rule.Match.Path, true) ||
+				!im.match(rule.Name, "method", m.Method, &rule.Match.Method, false) {
+				continue
+			}
+			skip := false
+			for header, v := range m.Headers {
+				var value string
+				if !im.match(rule.Name, "header "+header, v, &value, false) {
+					skip = true
+					break
+				}
+				if rule.Match.Headers == nil {
+					rule.Match.Headers = map[string]string{}
+				}
+				rule.Match.Headers[header] = value
+			}
+			if !skip {
+				im.rules = append(im.rules, rule)
+			}
+		}
+	}
+}
+
+// match converts an Istio string match into an exact value, or a path prefix
+// for the uri. Regexes have no equivalent and skip the match.
+func (im *meshImport) match(name, field string, m istioString, dst *string, prefix bool) bool {
+	switch {
+	case m.Regex != "":
+		im.warn("%s: skipping match: %s regex %q is not supported", name, field, m.Regex)
+		return false
+	case m.Prefix != "" && !prefix:
+		im.warn("%s: skipping match: %s prefix %q is not supported", name, field, m.Prefix)
+		return false
+	case m.Prefix != "":
+		*dst = m.Prefix
+	case m.Exact != "" && prefix:
+		im.warn("%s: exact uri %q imported as a path prefix", name, m.Exact)
+		*dst = m.Exact
+	default:
+		*dst = m.Exact
+	}
+	return true
+}
+
+// fault merges the delay and the abort into one rule fault.
+func (im *meshImport) fault(name string, mf meshFault) (Fault, bool) {
+	var f Fault
+	var percents []float64
+	if mf.delay > 0 {
+		f.Delay = Fixed(mf.delay)
+		percents = append(percents, mf.delayPercent)
+	}
+	if mf.status != 0 {
+		f.Status = mf.status
+		percents = append(percents, mf.abortPercent)
+	}
+	switch len(percents) {
+	case 0:
+		im.warn("%s: skipping fault without a delay or an HTTP abort", name)
+		return f, false
+	case 2:
+		if percents[0] != percents[1] {
+			im.warn("%s: delay at %g%% and abort at %g%% imported as one fault at %g%%", name, percents[0], percents[1], percents[1])
+		}
+	}
+	if p := percents[len(percents)-1]; p < 100 {
+		f.Percent = p
+	}
+	return f, true
+}
+
+// envoyFault is envoy.extensions.filters.http.fault.v3.HTTPFault.
+type envoyFault struct {
+	Delay *struct {
+		FixedDelay  string           `yaml:"fixed_delay"`
+		HeaderDelay *struct{}        `yaml:"header_delay"`
+		Percentage  *envoyPercentage `yaml:"percentage"`
+	} `yaml:"delay"`
+	Abort *struct {
+		HTTPStatus  int              `yaml:"http_status"`
+		GRPCStatus  *int             `yaml:"grpc_status"`
+		HeaderAbort *struct{}        `yaml:"header_abort"`
+		Percentage  *envoyPercentage `yaml:"percentage"`
+	} `yaml:"abort"`
+	Headers []struct {
+		Name        string `yaml:"name"`
+		ExactMatch  string `yaml:"exact_match"`
+		StringMatch *struct {
+			Exact string `yaml:"exact"`
+		} `yaml:"string_match"`
+	} `yaml:"headers"`
+	UpstreamCluster   string    `yaml:"upstream_cluster"`
+	DownstreamNodes   []string  `yaml:"downstream_nodes"`
+	ResponseRateLimit *struct{} `yaml:"response_rate_limit"`
+}
+
+// envoyPercentage is a FractionalPercent, numerator over HUNDRED (the
+// default), TEN_THOUSAND or MILLION.
+type envoyPercentage struct {
+	Numerator   float64 `yaml:"numerator"`
+	Denominator string  `yaml:"denominator"`
+}
+
+func (p *envoyPercentage) percent() (float64, error) {
+	if p == nil {
+		return 100, nil
+	}
+	switch p.Denominator {
+	case "", "HUNDRED":
+		return p.Numerator, nil
+	case "TEN_THOUSAND":
+		return p.Numerator / 100, nil
+	case "MILLION":
+		return p.Numerator / 10000, nil
+	}
+	return 0, fmt.Errorf("unknown denominator %q", p.Denominator)
+}
+
+func (im *meshImport) envoyFault(name string, ef envoyFault) {
+	mf := meshFault{}
+	var err error
+	if d := ef.Delay; d != nil {
+		switch {
+		case d.HeaderDelay != nil:
+			im.warn("%s: skipping header_delay, delays come from the config", name)
+		default:
+			if mf.delay, err = time.ParseDuration(d.FixedDelay); err != nil {
+				im.warn("%s: skipping delay: invalid fixed_delay %q", name, d.FixedDelay)
+			}
+		}
+		if mf.delayPercent, err = d.Percentage.percent(); err != nil {
+			im.warn("%s: skipping delay: %v", name, err)
+			mf.delay = 0
+		}
+	}
+	if a := ef.Abort; a != nil {
+		switch {
+		case a.HeaderAbort != nil:
+			im.warn("%s: skipping header_abort, aborts come from the config", name)
+		case a.GRPCStatus != nil && a.HTTPStatus == 0:
+			im.warn("%s: skipping abort: grpc_status %d has no HTTP equivalent", name, *a.GRPCStatus)
+		default:
+			mf.status = a.HTTPStatus
+		}
+		if mf.abortPercent, err = a.Percentage.percent(); err != nil {
+			im.warn("%s: skipping abort: %v", name, err)
+			mf.status = 0
+		}
+	}
+	if ef.UpstreamCluster != "" || len(ef.DownstreamNodes) > 0 {
+		im.warn("%s: upstream_cluster and downstream_nodes are ignored, the fault applies to every request", name)
+	}
+	if ef.ResponseRateLimit != nil {
+		im.warn("%s: skipping response_rate_limit, use -client-bandwidth", name)
+	}
+	fault, ok := im.fault(name, mf)
+	if !ok {
+		return
+	}
+	rule := Rule{Name: name, Fault: fault}
+	for _, h := range ef.Headers {
+		value := h.ExactMatch
+		if h.StringMatch != nil {
+			value = h.StringMatch.Exact
+		}
+		if value == "" {
+			im.warn("%s: skipping fault: only exact header matches are supported, not for %s", name, h.Name)
+			return
+		}
+		if rule.Match.Headers == nil {
+			rule.Match.Headers = map[string]string{}
+		}
+		rule.Match.Headers[h.Name] = value
+	}
+	im.rules = append(im.rules, rule)
+}
@@ -0,0 +1,85 @@
+package slowproxy
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Middleware wraps any handler with rule faults, adding chaos to an existing
+// service without a separate proxy hop. Aborted requests have their
+// connection dropped. Its random decisions follow SetSeed.
+func Middleware(rules ...Rule) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return faultHandler(next, defaultRand, func(req *http.Request) *Fault {
+			return matchFault(rules, req, time.Now(), defaultRand)
+		})
+	}
+}
+
+// faultHandler applies the fault match returns for a request, its jitter
+// and body templates drawing from r.
+func faultHandler(next http.Handler, r *seededRand, match func(*http.Request) *Fault) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		f := match(req)
+		if f == nil {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		noteFault(req.Context())
+		delay := f.Delay.pick(r)
+
+		if f.After {
+			w := &lengthWriter{ResponseWriter: rw, status: http.StatusOK}
+			next.ServeHTTP(w, req)
+			if sleep(req.Context(), delay) != nil {
+				return
+			}
+			if f.responds() && f.replaces(w.status) {
+				for k := range rw.Header() {
+					delete(rw.Header(), k)
+				}
+				injectFault(rw, req, f, r)
+				return
+			}
+			w.finish()
+			return
+		}
+
+		if sleep(req.Context(), delay) != nil {
+			return
+		}
+		if f.responds() {
+			injectFault(rw, req, f, r)
+			return
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
+
+// rulesMiddleware applies the server's runtime rules to every route but the admin API.
+func (s *Server) rulesMiddleware(next http.Handler) http.Handler {
+	faults := faultHandler(next, s.rand, func(req *http.Request) *Fault {
+		return s.rules.match(req, s.clock.now(), s.rand)
+	})
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/admin/") {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		faults.ServeHTTP(rw, req)
+	})
+}
+
+func injectFault(rw http.ResponseWriter, req *http.Request, f *Fault, r *seededRand) {
+	if f.Abort {
+		panic(http.ErrAbortHandler)
+	}
+	status, header, body := f.response(req, r)
+	for k, v := range header {
+		rw.Header()[k] = v
+	}
+	rw.WriteHeader(status)
+	io.WriteString(rw, body)
+}
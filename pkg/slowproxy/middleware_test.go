@@ -0,0 +1,139 @@
+package slowproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name        string
+		rule        Rule
+		path        string
+		status      int // answered by the handler
+		wantStatus  int
+		wantBody    string
+		wantHandled bool
+		wantAbort   bool
+		minDelay    time.Duration
+	}{
+		{
+			name:        "no match",
+			rule:        Rule{Match: Matcher{Path: "/other"}, Fault: Fault{Status: 503}},
+			wantStatus:  200,
+			wantBody:    "ok",
+			wantHandled: true,
+		},
+		{
+			name:       "status",
+			rule:       Rule{Fault: Fault{Status: 503}},
+			wantStatus: 503,
+			wantBody:   "Service Unavailable\n",
+		},
+		{
+			name:       "templated body",
+			rule:       Rule{Fault: Fault{Status: 418, Body: `{{.Method}} {{.Path}}`}},
+			path:       "/tea",
+			wantStatus: 418,
+			wantBody:   "GET /tea",
+		},
+		{
+			name:        "delay",
+			rule:        Rule{Fault: Fault{Delay: Fixed(20 * time.Millisecond)}},
+			wantStatus:  200,
+			wantBody:    "ok",
+			wantHandled: true,
+			minDelay:    20 * time.Millisecond,
+		},
+		{
+			name:      "abort",
+			rule:      Rule{Fault: Fault{Abort: true}},
+			wantAbort: true,
+		},
+		{
+			name:        "after replaces",
+			rule:        Rule{Fault: Fault{Status: 503, After: true}},
+			wantStatus:  503,
+			wantBody:    "Service Unavailable\n",
+			wantHandled: true,
+		},
+		{
+			name:        "if_success keeps errors",
+			rule:        Rule{Fault: Fault{Status: 401, After: true, IfSuccess: true}},
+			status:      500,
+			wantStatus:  500,
+			wantBody:    "ok",
+			wantHandled: true,
+		},
+		{
+			name:        "if_success replaces 2xx",
+			rule:        Rule{Fault: Fault{Status: 401, After: true, IfSuccess: true}},
+			wantStatus:  401,
+			wantBody:    "Unauthorized\n",
+			wantHandled: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handled := false
+			h := Middleware(tt.rule)(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				handled = true
+				rw.Header().Set("X-Handler", "yes")
+				if tt.status != 0 {
+					rw.WriteHeader(tt.status)
+				}
+				rw.Write([]byte("ok"))
+			}))
+			path := tt.path
+			if path == "" {
+				path = "/"
+			}
+			rec := httptest.NewRecorder()
+			start := time.Now()
+			aborted := func() (aborted bool) {
+				defer func() {
+					if r := recover(); r != nil {
+						if r != http.ErrAbortHandler {
+							panic(r)
+						}
+						aborted = true
+					}
+				}()
+				h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+				return false
+			}()
+			if aborted != tt.wantAbort {
+				t.Fatalf("aborted = %v, want %v", aborted, tt.wantAbort)
+			}
+			if handled != tt.wantHandled {
+				t.Errorf("handler called = %v, want %v", handled, tt.wantHandled)
+			}
+			if tt.wantAbort {
+				return
+			}
+			if rec.Code != tt.wantStatus || rec.Body.String() != tt.wantBody {
+				t.Errorf("got %d %q, want %d %q", rec.Code, rec.Body, tt.wantStatus, tt.wantBody)
+			}
+			if elapsed := time.Since(start); elapsed < tt.minDelay {
+				t.Errorf("answered after %s, want at least %s", elapsed, tt.minDelay)
+			}
+		})
+	}
+}
+
+func TestMiddlewareAfterDropsHandlerHeaders(t *testing.T) {
+	h := Middleware(Rule{Fault: Fault{Status: 503, After: true, Headers: map[string]string{"Retry-After": "1"}}})(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("X-Handler", "yes")
+		rw.Write([]byte("ok"))
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if got := rec.Header().Get("X-Handler"); got != "" {
+		t.Errorf("X-Handler = %q, want it dropped with the replaced response", got)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want 1", got)
+	}
+}
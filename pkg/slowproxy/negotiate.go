@@ -0,0 +1,230 @@
+package slowproxy
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format is a response body shape picked from the Accept header.
+type Format string
+
+const (
+	FormatJSON Format = "json"
+	FormatText Format = "text"
+	FormatHTML Format = "html"
+	FormatXML  Format = "xml"
+)
+
+var mediaFormats = map[string]Format{
+	"application/json": FormatJSON,
+	"text/plain":       FormatText,
+	"text/html":        FormatHTML,
+	"application/xml":  FormatXML,
+	"text/xml":         FormatXML,
+}
+
+var formatTypes = map[Format]string{
+	FormatJSON: "application/json",
+	FormatText: "text/plain; charset=utf-8",
+	FormatHTML: "text/html; charset=utf-8",
+	FormatXML:  "application/xml",
+}
+
+// ParseFormat validates a format name as used by the -default-format flag.
+func ParseFormat(v string) (Format, error) {
+	f := Format(v)
+	if _, ok := formatTypes[f]; !ok {
+		return "", fmt.Errorf("unknown format %q, want json, text, html or xml", v)
+	}
+	return f, nil
+}
+
+// negotiate picks the supported format with the highest q value in the
+// Accept header. Without a usable preference it falls back to the configured
+// default format, and to the route's own shape when none is configured.
+func (s *Server) negotiate(req *http.Request, fallback Format) Format {
+	best, bestQ := Format(""), 0.0
+	for _, part := range strings.Split(req.Header.Get("Accept"), ",") {
+		media, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		f, ok := mediaFormats[strings.ToLower(strings.TrimSpace(media))]
+		if !ok {
+			continue
+		}
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			if k, v, ok := strings.Cut(strings.TrimSpace(p), "="); ok && k == "q" {
+				if n, err := strconv.ParseFloat(v, 64); err == nil {
+					q = n
+				}
+			}
+		}
+		if q > bestQ {
+			best, bestQ = f, q
+		}
+	}
+	switch {
+	case best != "":
+		return best
+	case s.opts.DefaultFormat != "":
+		return s.opts.DefaultFormat
+	}
+	return fallback
+}
+
+// tickFormat frames the ticks streamed by /slow so the complete body is a
+// valid document in the negotiated format.
+type tickFormat struct {
+	open, close string
+	line        func(i int, t time.Time) string
+}
+
+var tickFormats = map[Format]tickFormat{
+	FormatText: {
+		line: func(_ int, t time.Time) string { return fmt.Sprintf("tick: %s\n", t) },
+	},
+	FormatJSON: {
+		open:  "[\n",
+		close: "]\n",
+		line: func(i int, t time.Time) string {
+			b, _ := json.Marshal(map[string]time.Time{"tick": t})
+			if i > 0 {
+				return "," + string(b) + "\n"
+			}
+			return string(b) + "\n"
+		},
+	},
+	FormatHTML: {
+		open:  "<!DOCTYPE html>\n<html><body>\n",
+		close: "</body></html>\n",
+		line: func(_ int, t time.Time) string {
+			return fmt.Sprintf("<p>tick: %s</p>\n", html.EscapeString(t.String()))
+		},
+	},
+	FormatXML: {
+		open:  xml.Header + "<ticks>\n",
+		close: "</ticks>\n",
+		line:  func(_ int, t time.Time) string { return fmt.Sprintf("<tick>%s</tick>\n", t.Format(time.RFC3339Nano)) },
+	},
+}
+
+type xmlStatus struct {
+	XMLName xml.Name `xml:"status"`
+	Code    int      `xml:"code"`
+	Message string   `xml:"message"`
+}
+
+// writeStatus sends status with a short body describing it.
+func writeStatus(rw http.ResponseWriter, f Format, status int) {
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		rw.WriteHeader(status)
+		return
+	}
+	text := http.StatusText(status)
+	switch f {
+	case FormatJSON:
+		writeJSON(rw, status, map[string]any{"status": status, "message": text})
+		return
+	case FormatXML:
+		rw.Header().Set("Content-Type", formatTypes[f])
+		rw.WriteHeader(status)
+		fmt.Fprint(rw, xml.Header)
+		_ = xml.NewEncoder(rw).Encode(xmlStatus{Code: status, Message: text})
+		fmt.Fprintln(rw)
+		return
+	}
+	rw.Header().Set("Content-Type", formatTypes[f])
+	rw.WriteHeader(status)
+	if f == FormatHTML {
+		fmt.Fprintf(rw, "<!DOCTYPE html>\n<html><body><h1>%d %s</h1></body></html>\n", status, html.EscapeString(text))
+		return
+	}
+	fmt.Fprintln(rw, text)
+}
+
+type xmlPair struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:",chardata"`
+}
+
+type xmlEcho struct {
+	XMLName xml.Name  `xml:"request"`
+	Method  string    `xml:"method"`
+	URL     string    `xml:"url"`
+	Path    string    `xml:"path"`
+	Proto   string    `xml:"proto"`
+	Host    string    `xml:"host"`
+	Origin  string    `xml:"origin"`
+	Args    []xmlPair `xml:"args>arg"`
+	Headers []xmlPair `xml:"headers>header"`
+	Data    string    `xml:"data"`
+}
+
+func pairs(m map[string][]string) []xmlPair {
+	var out []xmlPair
+	for _, k := range sortedKeys(m) {
+		for _, v := range m[k] {
+			out = append(out, xmlPair{Name: k, Value: v})
+		}
+	}
+	return out
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// echoText renders an echo as the raw request it describes.
+func echoText(e echoResponse) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s %s\n", e.Method, e.URL, e.Proto)
+	for _, k := range sortedKeys(e.Headers) {
+		for _, v := range e.Headers[k] {
+			fmt.Fprintf(&b, "%s: %s\n", k, v)
+		}
+	}
+	b.WriteString("\n")
+	b.WriteString(e.Data)
+	return b.String()
+}
+
+func writeEcho(rw http.ResponseWriter, f Format, e echoResponse) {
+	switch f {
+	case FormatJSON:
+		writeJSON(rw, http.StatusOK, e)
+		return
+	case FormatXML:
+		rw.Header().Set("Content-Type", formatTypes[f])
+		fmt.Fprint(rw, xml.Header)
+		_ = xml.NewEncoder(rw).Encode(xmlEcho{
+			Method:  e.Method,
+			URL:     e.URL,
+			Path:    e.Path,
+			Proto:   e.Proto,
+			Host:    e.Host,
+			Origin:  e.Origin,
+			Args:    pairs(e.Args),
+			Headers: pairs(e.Headers),
+			Data:    e.Data,
+		})
+		fmt.Fprintln(rw)
+		return
+	}
+	rw.Header().Set("Content-Type", formatTypes[f])
+	if f == FormatHTML {
+		fmt.Fprintf(rw, "<!DOCTYPE html>\n<html><body><pre>%s</pre></body></html>\n", html.EscapeString(echoText(e)))
+		return
+	}
+	fmt.Fprint(rw, echoText(e))
+}
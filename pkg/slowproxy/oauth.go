@@ -0,0 +1,152 @@
+package slowproxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"go.uber.org/zap"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// oauthTokens remembers the tokens issued by /oauth/token.
+type oauthTokens struct {
+	mu      sync.Mutex
+	access  map[string]time.Time
+	refresh map[string]oauthGrant
+}
+
+type oauthGrant struct {
+	client  string
+	scope   string
+	expires time.Time
+}
+
+func newOAuthTokens() *oauthTokens {
+	return &oauthTokens{access: map[string]time.Time{}, refresh: map[string]oauthGrant{}}
+}
+
+func randomToken() string {
+	var b [24]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+const expiredGrace = time.Hour
+
+// issue mints an access and refresh token pair, forgetting expired ones.
+func (t *oauthTokens) issue(client, scope string, accessTTL, refreshTTL time.Duration) (access, refresh string) {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for k, exp := range t.access {
+		if now.Sub(exp) > expiredGrace {
+			delete(t.access, k)
+		}
+	}
+	for k, g := range t.refresh {
+		if now.After(g.expires) {
+			delete(t.refresh, k)
+		}
+	}
+	access, refresh = randomToken(), randomToken()
+	t.access[access] = now.Add(accessTTL)
+	t.refresh[refresh] = oauthGrant{client: client, scope: scope, expires: now.Add(refreshTTL)}
+	return access, refresh
+}
+
+// redeem consumes a refresh token, they rotate on every use.
+func (t *oauthTokens) redeem(refresh string) (oauthGrant, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	g, ok := t.refresh[refresh]
+	delete(t.refresh, refresh)
+	if !ok || time.Now().After(g.expires) {
+		return oauthGrant{}, false
+	}
+	return g, true
+}
+
+// lookup reports whether token was issued and whether it is still valid.
+// Expired tokens stay known for expiredGrace so they are rejected as such.
+func (t *oauthTokens) lookup(token string) (issued, valid bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	exp, ok := t.access[token]
+	return ok, ok && time.Now().Before(exp)
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+func oauthError(rw http.ResponseWriter, status int, code, description string) {
+	rw.Header().Set("Cache-Control", "no-store")
+	writeJSON(rw, status, map[string]string{"error": code, "error_description": description})
+}
+
+// oauthToken is a slow, flaky OAuth2 token endpoint supporting the
+// client_credentials and refresh_token grants. Any client is accepted, issued
+// access tokens are honoured by /auth/bearer until they expire.
+func (s *Server) oauthToken(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		rw.Header().Set("Allow", http.MethodPost)
+		oauthError(rw, http.StatusMethodNotAllowed, "invalid_request", "token requests must be POSTed")
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		oauthError(rw, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if d := s.opts.OAuthDelay; d > 0 {
+		if err := s.Pause(req.Context(), d, 0, nil); err != nil {
+			return
+		}
+	}
+
+	client, _, ok := req.BasicAuth()
+	if !ok {
+		client = req.PostForm.Get("client_id")
+	}
+	if client == "" {
+		rw.Header().Set("WWW-Authenticate", `Basic realm="`+authRealm+`"`)
+		oauthError(rw, http.StatusUnauthorized, "invalid_client", "missing client credentials")
+		return
+	}
+
+	scope := req.PostForm.Get("scope")
+	switch grant := req.PostForm.Get("grant_type"); grant {
+	case "client_credentials":
+	case "refresh_token":
+		g, ok := s.oauth.redeem(req.PostForm.Get("refresh_token"))
+		if !ok || g.client != client {
+			oauthError(rw, http.StatusBadRequest, "invalid_grant", "unknown, expired or already used refresh token")
+			return
+		}
+		if scope == "" {
+			scope = g.scope
+		}
+	default:
+		oauthError(rw, http.StatusBadRequest, "unsupported_grant_type", "grant_type must be client_credentials or refresh_token")
+		return
+	}
+	if p := s.opts.OAuthFailPercent; p > 0 && s.rand.Float64()*100 < p {
+		s.logger.Info("injecting invalid_grant", zap.String("client", client))
+		oauthError(rw, http.StatusBadRequest, "invalid_grant", "injected failure")
+		return
+	}
+
+	access, refresh := s.oauth.issue(client, scope, s.opts.OAuthTokenTTL, s.opts.OAuthRefreshTTL)
+	rw.Header().Set("Cache-Control", "no-store")
+	writeJSON(rw, http.StatusOK, tokenResponse{
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    ceilSeconds(s.opts.OAuthTokenTTL),
+		RefreshToken: refresh,
+		Scope:        scope,
+	})
+}
@@ -0,0 +1,115 @@
+package slowproxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// requestToken posts form to /oauth/token.
+func requestToken(s *Server, form url.Values) (*httptest.ResponseRecorder, tokenResponse) {
+	req := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := serve(s, req)
+	var tok tokenResponse
+	_ = json.Unmarshal(rec.Body.Bytes(), &tok)
+	return rec, tok
+}
+
+// oauthErrorCode returns the error of an OAuth2 error response.
+func oauthErrorCode(rec *httptest.ResponseRecorder) string {
+	var body struct {
+		Error string `json:"error"`
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &body)
+	return body.Error
+}
+
+// bearer calls /auth/bearer with token.
+func bearer(s *Server, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/auth/bearer", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return serve(s, req)
+}
+
+func TestOAuthToken(t *testing.T) {
+	s := newTestServer(t, WithOptions(Options{BearerTokens: []string{"static"}}))
+	rec, tok := requestToken(s, url.Values{"grant_type": {"client_credentials"}, "client_id": {"app"}, "scope": {"read"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if tok.TokenType != "Bearer" || tok.ExpiresIn != 3600 || tok.Scope != "read" || tok.AccessToken == "" || tok.RefreshToken == "" {
+		t.Errorf("token = %+v", tok)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("Cache-Control = %q, want no-store", got)
+	}
+	if rec := bearer(s, tok.AccessToken); rec.Code != http.StatusOK {
+		t.Errorf("issued token answered %d by /auth/bearer", rec.Code)
+	}
+
+	rec, refreshed := requestToken(s, url.Values{"grant_type": {"refresh_token"}, "client_id": {"app"}, "refresh_token": {tok.RefreshToken}})
+	if rec.Code != http.StatusOK || refreshed.Scope != "read" || refreshed.RefreshToken == tok.RefreshToken {
+		t.Errorf("refresh = %d %+v", rec.Code, refreshed)
+	}
+	// refresh tokens rotate, the first one is used up
+	rec, _ = requestToken(s, url.Values{"grant_type": {"refresh_token"}, "client_id": {"app"}, "refresh_token": {tok.RefreshToken}})
+	if got := oauthErrorCode(rec); rec.Code != http.StatusBadRequest || got != "invalid_grant" {
+		t.Errorf("second refresh = %d %s, want 400 invalid_grant", rec.Code, got)
+	}
+	rec, _ = requestToken(s, url.Values{"grant_type": {"refresh_token"}, "client_id": {"other"}, "refresh_token": {refreshed.RefreshToken}})
+	if got := oauthErrorCode(rec); got != "invalid_grant" {
+		t.Errorf("refresh by another client = %s, want invalid_grant", got)
+	}
+}
+
+func TestOAuthTokenErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		form       url.Values
+		opts       Options
+		wantStatus int
+		wantError  string
+	}{
+		{name: "get", method: "GET", wantStatus: http.StatusMethodNotAllowed, wantError: "invalid_request"},
+		{name: "no client", form: url.Values{"grant_type": {"client_credentials"}}, wantStatus: http.StatusUnauthorized, wantError: "invalid_client"},
+		{name: "grant type", form: url.Values{"grant_type": {"password"}, "client_id": {"app"}}, wantStatus: http.StatusBadRequest, wantError: "unsupported_grant_type"},
+		{name: "unknown refresh token", form: url.Values{"grant_type": {"refresh_token"}, "client_id": {"app"}, "refresh_token": {"x"}}, wantStatus: http.StatusBadRequest, wantError: "invalid_grant"},
+		{name: "injected failure", form: url.Values{"grant_type": {"client_credentials"}, "client_id": {"app"}}, opts: Options{OAuthFailPercent: 100}, wantStatus: http.StatusBadRequest, wantError: "invalid_grant"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, WithOptions(tt.opts))
+			var rec *httptest.ResponseRecorder
+			if tt.method == "GET" {
+				rec = serve(s, httptest.NewRequest("GET", "/oauth/token", nil))
+			} else {
+				rec, _ = requestToken(s, tt.form)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := oauthErrorCode(rec); got != tt.wantError {
+				t.Errorf("error = %q, want %q", got, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestOAuthTokenExpires(t *testing.T) {
+	s := newTestServer(t, WithOptions(Options{OAuthTokenTTL: 10 * time.Millisecond, BearerTokens: []string{"static"}}))
+	_, tok := requestToken(s, url.Values{"grant_type": {"client_credentials"}, "client_id": {"app"}})
+	time.Sleep(20 * time.Millisecond)
+	rec := bearer(s, tok.AccessToken)
+	if got := errorCode(rec); rec.Code != http.StatusUnauthorized || got != "invalid_token" {
+		t.Fatalf("expired token = %d %s, want 401 invalid_token", rec.Code, got)
+	}
+	if got := rec.Header().Get("WWW-Authenticate"); !strings.Contains(got, "token expired") {
+		t.Errorf("WWW-Authenticate = %q, want it to say the token expired", got)
+	}
+}
@@ -0,0 +1,322 @@
+package slowproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// OpenAPIMock serves the operations of an OpenAPI 3 spec, YAML or JSON, with
+// example responses: the first 2xx of each operation, its JSON example when
+// there is one, otherwise a value generated from its schema. Operations are
+// keyed by operationId, or "GET /pets/{id}" without one, and take a Fault
+// each: a delay, an error status for a percentage of the calls, answered
+// with the spec's example for that status when it documents one.
+type OpenAPIMock struct {
+	Spec       string           `json:"spec" yaml:"spec"`
+	Operations map[string]Fault `json:"operations,omitempty" yaml:"operations,omitempty"`
+}
+
+// Validate reports specs that cannot be served and faults that cannot
+// apply.
+func (m OpenAPIMock) Validate() error {
+	_, err := m.compile()
+	return err
+}
+
+// openAPIMaxDepth bounds the nesting of the values generated from schemas.
+const openAPIMaxDepth = 8
+
+var openAPIMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+type openAPIOperation struct {
+	key         string
+	method      string
+	path        string
+	status      int
+	contentType string
+	body        []byte
+	fault       *Fault
+}
+
+type openAPISpec struct {
+	doc        map[string]any
+	operations []*openAPIOperation
+}
+
+func (m OpenAPIMock) compile() (*openAPISpec, error) {
+	data, err := os.ReadFile(m.Spec)
+	if err != nil {
+		return nil, fmt.Errorf("openapi: %w", err)
+	}
+	spec := &openAPISpec{}
+	if err := yaml.Unmarshal(data, &spec.doc); err != nil {
+		return nil, fmt.Errorf("openapi: %s: %w", m.Spec, err)
+	}
+	if v, _ := spec.doc["openapi"].(string); !strings.HasPrefix(v, "3.") {
+		return nil, fmt.Errorf("openapi: %s: only OpenAPI 3 specs are supported", m.Spec)
+	}
+	// the path of the first server prefixes every operation
+	var base string
+	if servers, _ := spec.doc["servers"].([]any); len(servers) > 0 {
+		if u, err := url.Parse(str(obj(servers[0])["url"])); err == nil {
+			base = strings.TrimSuffix(u.Path, "/")
+		}
+	}
+	paths := obj(spec.doc["paths"])
+	for _, p := range slices.Sorted(maps.Keys(paths)) {
+		item := spec.resolve(paths[p])
+		for _, method := range openAPIMethods {
+			op := obj(item[method])
+			if op == nil {
+				continue
+			}
+			o := &openAPIOperation{method: strings.ToUpper(method), path: base + p}
+			o.key = str(op["operationId"])
+			if o.key == "" {
+				o.key = o.method + " " + p
+			}
+			responses := obj(op["responses"])
+			o.status, o.contentType, o.body, err = spec.response(responses, success)
+			if err != nil {
+				return nil, fmt.Errorf("openapi: %s: %w", o.key, err)
+			}
+			if f, ok := m.Operations[o.key]; ok {
+				if err := (Rule{Name: o.key, Fault: f}).Validate(); err != nil {
+					return nil, fmt.Errorf("openapi: %w", err)
+				}
+				if f.Status != 0 && f.Body == "" {
+					// answer injected errors like the spec documents them
+					if _, ct, body, err := spec.response(responses, strconv.Itoa(f.Status)); err == nil && body != nil {
+						f.Body = strings.ReplaceAll(string(body), "{{", "{{`{{`}}")
+						f.Headers = mergeHeaders(f.Headers, "Content-Type", ct)
+					}
+				}
+				o.fault = &f
+			}
+			spec.operations = append(spec.operations, o)
+		}
+	}
+	for key := range m.Operations {
+		if !slices.ContainsFunc(spec.operations, func(o *openAPIOperation) bool { return o.key == key }) {
+			return nil, fmt.Errorf("openapi: %s has no operation %q", m.Spec, key)
+		}
+	}
+	return spec, nil
+}
+
+func mergeHeaders(h map[string]string, k, v string) map[string]string {
+	merged := map[string]string{k: v}
+	for name, value := range h {
+		merged[name] = value
+	}
+	return merged
+}
+
+// success picks the first documented 2xx, or default, as the answer.
+const success = ""
+
+// response renders the response of responses for status, or the success
+// one, with its content type and example body, nil when it has no content.
+func (spec *openAPISpec) response(responses map[string]any, status string) (int, string, []byte, error) {
+	if status == success {
+		codes := slices.Sorted(maps.Keys(responses))
+		status = "default"
+		for _, code := range codes {
+			if strings.HasPrefix(code, "2") {
+				status = code
+				break
+			}
+		}
+	}
+	resp, ok := responses[status]
+	if !ok {
+		return 0, "", nil, fmt.Errorf("no %s response", status)
+	}
+	code := http.StatusOK
+	if n, err := strconv.Atoi(strings.ReplaceAll(status, "X", "0")); err == nil {
+		code = n
+	}
+	content := obj(spec.resolve(resp)["content"])
+	if len(content) == 0 {
+		return code, "", nil, nil
+	}
+	types := slices.Sorted(maps.Keys(content))
+	ct := types[0]
+	for _, t := range types {
+		if t == "application/json" || strings.HasSuffix(t, "+json") {
+			ct = t
+			break
+		}
+	}
+	media := obj(content[ct])
+	value, ok := media["example"]
+	if !ok {
+		if examples := obj(media["examples"]); len(examples) > 0 {
+			value, ok = spec.resolve(examples[slices.Sorted(maps.Keys(examples))[0]])["value"]
+		}
+	}
+	if !ok {
+		value = spec.generate(media["schema"], nil)
+	}
+	if s, isString := value.(string); isString && !strings.Contains(ct, "json") {
+		return code, ct, []byte(s), nil
+	}
+	body, err := json.Marshal(value)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	return code, ct, body, nil
+}
+
+// resolve follows a local $ref, like #/components/schemas/Pet.
+func (spec *openAPISpec) resolve(v any) map[string]any {
+	m := obj(v)
+	for range openAPIMaxDepth {
+		ref, ok := m["$ref"].(string)
+		if !ok {
+			return m
+		}
+		var target any = spec.doc
+		for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			part = strings.NewReplacer("~1", "/", "~0", "~").Replace(part)
+			target = obj(target)[part]
+		}
+		m = obj(target)
+	}
+	return m
+}
+
+// generate makes up a value valid for schema, preferring its example,
+// default and first enum value. refs are the schemas being generated, a
+// schema nested in itself is left out.
+func (spec *openAPISpec) generate(v any, refs []string) any {
+	if ref := str(obj(v)["$ref"]); ref != "" {
+		if slices.Contains(refs, ref) || len(refs) > openAPIMaxDepth {
+			return nil
+		}
+		refs = append(refs, ref)
+	}
+	schema := spec.resolve(v)
+	for _, key := range []string{"example", "default", "const"} {
+		if value, ok := schema[key]; ok {
+			return value
+		}
+	}
+	if enum, _ := schema["enum"].([]any); len(enum) > 0 {
+		return enum[0]
+	}
+	if all, _ := schema["allOf"].([]any); len(all) > 0 {
+		merged := map[string]any{}
+		for _, part := range all {
+			if m, ok := spec.generate(part, refs).(map[string]any); ok {
+				for k, v := range m {
+					merged[k] = v
+				}
+			}
+		}
+		return merged
+	}
+	for _, key := range []string{"oneOf", "anyOf"} {
+		if choices, _ := schema[key].([]any); len(choices) > 0 {
+			return spec.generate(choices[0], refs)
+		}
+	}
+	typ := schema["type"]
+	if types, ok := typ.([]any); ok && len(types) > 0 {
+		typ = types[0]
+	}
+	switch typ {
+	case "object":
+		value := map[string]any{}
+		required, _ := schema["required"].([]any)
+		for name, prop := range obj(schema["properties"]) {
+			if v := spec.generate(prop, refs); v != nil || slices.Contains(required, any(name)) {
+				value[name] = v
+			}
+		}
+		return value
+	case "array":
+		n := 1
+		if min, ok := schema["minItems"].(int); ok && min > 1 {
+			n = min
+		}
+		item := spec.generate(schema["items"], refs)
+		if item == nil {
+			return []any{}
+		}
+		items := make([]any, n)
+		for i := range items {
+			items[i] = item
+		}
+		return items
+	case "integer", "number":
+		if min, ok := schema["minimum"]; ok {
+			return min
+		}
+		return 0
+	case "boolean":
+		return true
+	case "string":
+		switch schema["format"] {
+		case "date-time":
+			return "2026-01-01T00:00:00Z"
+		case "date":
+			return "2026-01-01"
+		case "uuid":
+			return "00000000-0000-4000-8000-000000000000"
+		case "email":
+			return "user@example.com"
+		case "uri", "url":
+			return "https://example.com"
+		}
+		return "string"
+	}
+	if props := obj(schema["properties"]); props != nil {
+		return spec.generate(map[string]any{"type": "object", "properties": props, "required": schema["required"]}, refs)
+	}
+	return nil
+}
+
+// obj is v as a YAML mapping, nil when it is not one.
+func obj(v any) map[string]any {
+	m, _ := v.(map[string]any)
+	return m
+}
+
+func str(v any) string {
+	s, _ := v.(string)
+	return s
+}
+
+// openAPIRoutes registers the operations, their faults applied first.
+func (s *Server) openAPIRoutes(r *mux.Router) {
+	for _, o := range s.openapi.operations {
+		var h http.Handler = http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if o.contentType != "" {
+				rw.Header().Set("Content-Type", o.contentType)
+			}
+			rw.WriteHeader(o.status)
+			rw.Write(o.body)
+		})
+		if f := o.fault; f != nil {
+			h = faultHandler(h, s.rand, func(req *http.Request) *Fault {
+				if f.Percent > 0 && s.rand.Float64()*100 >= f.Percent {
+					return nil
+				}
+				s.logger.Debug("openapi fault", zap.String("operation", o.key))
+				return f
+			})
+		}
+		r.Handle(o.path, h).Methods(o.method)
+	}
+}
@@ -0,0 +1,103 @@
+package slowproxy
+
+import (
+	"go.uber.org/zap"
+	"net"
+	"time"
+)
+
+// Option configures a Server created by New.
+type Option func(*Server)
+
+// WithOptions replaces every setting with opts, typically parsed from flags.
+func WithOptions(opts Options) Option {
+	return func(s *Server) { s.opts = opts }
+}
+
+// WithLogger sets the logger, which defaults to a no-op logger.
+func WithLogger(logger *zap.Logger) Option {
+	return func(s *Server) { s.logger = logger }
+}
+
+// WithAddr sets the HTTP listen address.
+func WithAddr(addr string) Option {
+	return func(s *Server) { s.opts.Addr = addr }
+}
+
+// WithDefaultDelay sets the delay used by /slow when none is requested.
+func WithDefaultDelay(d time.Duration) Option {
+	return func(s *Server) { s.opts.DefaultDelay = d }
+}
+
+// WithMaxDelay caps the delay a request may ask for, one hour by default.
+func WithMaxDelay(d time.Duration) Option {
+	return func(s *Server) { s.opts.MaxDelay = d }
+}
+
+// WithRoutes serves only the given route groups instead of all of them.
+func WithRoutes(routes ...Route) Option {
+	return func(s *Server) { s.opts.Routes = routes }
+}
+
+// WithListener serves HTTP on a pre-bound ln instead of binding the
+// configured address. Its address is reported by Addr. Passing an address
+// ending in ":0" to WithAddr has the same effect with a random free port.
+func WithListener(ln net.Listener) Option {
+	return func(s *Server) { s.listenerOverride = ln }
+}
+
+// WithGRPCListener serves the gRPC service on a pre-bound ln.
+func WithGRPCListener(ln net.Listener) Option {
+	return func(s *Server) { s.grpcListenerOverride = ln }
+}
+
+// WithGRPCAddr starts the gRPC service on addr.
+func WithGRPCAddr(addr string) Option {
+	return func(s *Server) { s.opts.GRPCAddr = addr }
+}
+
+// WithCaptureLimit keeps the n most recent requests for Captures, capturing
+// nothing when negative.
+func WithCaptureLimit(n int) Option {
+	return func(s *Server) { s.opts.CaptureLimit = n }
+}
+
+// WithRules sets the fault rules applied to every route but the admin API.
+// They can be changed at runtime through /admin/rules.
+func WithRules(rules ...Rule) Option {
+	return func(s *Server) { s.initialRules = append(s.initialRules, rules...) }
+}
+
+// WithMarkov runs a Markov fault model from the start. It can be replaced
+// at runtime through /admin/markov.
+func WithMarkov(m MarkovModel) Option {
+	return func(s *Server) { s.initialMarkov = &m }
+}
+
+// WithGroups sets the failure groups, taken down through the Controller,
+// /admin/groups or their schedules.
+func WithGroups(groups ...FailureGroup) Option {
+	return func(s *Server) { s.initialGroups = append(s.initialGroups, groups...) }
+}
+
+// WithProfiles sets the time-of-day latency profiles.
+func WithProfiles(profiles ...LatencyProfile) Option {
+	return func(s *Server) { s.initialProfiles = append(s.initialProfiles, profiles...) }
+}
+
+// WithOpenAPI serves the operations of an OpenAPI spec with their faults,
+// a spec given in Options.OpenAPI replaces the one of m.
+func WithOpenAPI(m OpenAPIMock) Option {
+	return func(s *Server) { s.initialOpenAPI = &m }
+}
+
+// WithS3 serves the object API of S3 with its faults, a directory given
+// in Options.S3 replaces the one of m.
+func WithS3(m S3Mock) Option {
+	return func(s *Server) { s.initialS3 = &m }
+}
+
+// WithProbes scripts the Kubernetes probe endpoints under /probe.
+func WithProbes(probes ...Probe) Option {
+	return func(s *Server) { s.initialProbes = append(s.initialProbes, probes...) }
+}
@@ -0,0 +1,85 @@
+package slowproxy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func durationQuery(req *http.Request, name string, def time.Duration) (time.Duration, error) {
+	v := req.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return d, nil
+}
+
+func intQuery(req *http.Request, name string, def int) (int, error) {
+	v := req.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return n, nil
+}
+
+func boolQuery(req *http.Request, name string, def bool) (bool, error) {
+	v := req.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return b, nil
+}
+
+// rateUnits maps rate suffixes to bytes per second. Lower case bps units are
+// bits, as in networking, the B/s ones are bytes.
+var rateUnits = []struct {
+	suffix string
+	scale  float64
+}{
+	{"gbps", 1e9 / 8}, {"mbps", 1e6 / 8}, {"kbps", 1e3 / 8}, {"bps", 1.0 / 8},
+	{"GB/s", 1 << 30}, {"MB/s", 1 << 20}, {"KB/s", 1 << 10}, {"B/s", 1},
+}
+
+// rateQuery parses a transfer rate query parameter, see ParseRate.
+func rateQuery(req *http.Request, name string, def float64) (float64, error) {
+	v := req.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	r, err := ParseRate(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return r, nil
+}
+
+// ParseRate parses a transfer rate such as 10kbps, 512KB/s or a plain number
+// of bytes per second, returned in bytes per second.
+func ParseRate(v string) (float64, error) {
+	num, scale := v, 1.0
+	for _, u := range rateUnits {
+		if strings.HasSuffix(v, u.suffix) {
+			num, scale = strings.TrimSuffix(v, u.suffix), u.scale
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil || f <= 0 {
+		return 0, fmt.Errorf("%q is not a positive rate", v)
+	}
+	return f * scale, nil
+}
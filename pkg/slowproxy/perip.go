@@ -0,0 +1,148 @@
+package slowproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Per-IP rejection modes, see Options.PerIPReject.
+const (
+	RejectRefuse = "refuse"
+	Reject429    = "429"
+)
+
+// ipLimits counts open connections and in-flight requests per client IP.
+type ipLimits struct {
+	mu       sync.Mutex
+	conns    map[string]int
+	requests map[string]int
+	connIP   map[net.Conn]string
+}
+
+func newIPLimits() *ipLimits {
+	return &ipLimits{conns: map[string]int{}, requests: map[string]int{}, connIP: map[net.Conn]string{}}
+}
+
+func (l *ipLimits) acquire(counts map[string]int, ip string, limit int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if counts[ip] >= limit {
+		return false
+	}
+	counts[ip]++
+	return true
+}
+
+func (l *ipLimits) release(counts map[string]int, ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if counts[ip]--; counts[ip] <= 0 {
+		delete(counts, ip)
+	}
+}
+
+func (l *ipLimits) acquireConn(c net.Conn, ip string, limit int) bool {
+	if !l.acquire(l.conns, ip, limit) {
+		return false
+	}
+	l.mu.Lock()
+	l.connIP[c] = ip
+	l.mu.Unlock()
+	return true
+}
+
+func (l *ipLimits) releaseConn(c net.Conn) {
+	l.mu.Lock()
+	ip, ok := l.connIP[c]
+	delete(l.connIP, c)
+	l.mu.Unlock()
+	if ok {
+		l.release(l.conns, ip)
+	}
+}
+
+// remoteIP strips the port from a remote address.
+func remoteIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// connContext attaches the connection info and applies PerIPConns. Refused
+// connections are reset right after accept, in 429 mode they are served but
+// every request on them is rejected.
+func (s *Server) connContext(ctx context.Context, c net.Conn) context.Context {
+	ctx = withConnInfo(ctx, c)
+	if s.opts.PerIPConns <= 0 {
+		return ctx
+	}
+	ip := remoteIP(c.RemoteAddr().String())
+	if s.ipLimits.acquireConn(c, ip, s.opts.PerIPConns) {
+		return ctx
+	}
+	if s.opts.PerIPReject == Reject429 {
+		connInfoFrom(ctx).overIPLimit = true
+		return ctx
+	}
+	s.logger.Info("refusing connection over per-ip limit", zap.String("remote", c.RemoteAddr().String()))
+	resetConn(c)
+	return ctx
+}
+
+// resetConn closes the TCP connection under any wrapping with an RST.
+func resetConn(c net.Conn) {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	if ic, ok := c.(*idleConn); ok {
+		c = ic.Conn
+	}
+	if tc, ok := c.(*net.TCPConn); ok {
+		_ = tc.SetLinger(0)
+	}
+	_ = c.Close()
+}
+
+// perIPLimit rejects requests on connections over PerIPConns and requests over
+// PerIPRequests concurrent ones from the same IP, on every route but the admin API.
+func (s *Server) perIPLimit(next http.Handler) http.Handler {
+	if s.opts.PerIPConns <= 0 && s.opts.PerIPRequests <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/admin/") {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		if info := connInfoFrom(req.Context()); info != nil && info.overIPLimit {
+			rw.Header().Set("Connection", "close")
+			s.rejectIP(rw, req, "per-ip connection limit reached")
+			return
+		}
+		if s.opts.PerIPRequests > 0 {
+			ip := remoteIP(req.RemoteAddr)
+			if !s.ipLimits.acquire(s.ipLimits.requests, ip, s.opts.PerIPRequests) {
+				s.rejectIP(rw, req, "per-ip request limit reached")
+				return
+			}
+			defer s.ipLimits.release(s.ipLimits.requests, ip)
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
+
+func (s *Server) rejectIP(rw http.ResponseWriter, req *http.Request, reason string) {
+	s.logger.Info("rejecting request", zap.String("reason", reason), zap.String("remote", req.RemoteAddr))
+	if s.opts.PerIPReject != Reject429 {
+		panic(http.ErrAbortHandler)
+	}
+	rw.Header().Set("Retry-After", "1")
+	writeAPIError(rw, http.StatusTooManyRequests, &apiError{Code: "per_ip_limit", Message: reason})
+}
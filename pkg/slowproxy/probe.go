@@ -0,0 +1,201 @@
+package slowproxy
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Probe scripts a Kubernetes probe endpoint, /probe/{name}. It fails until
+// PassAfter has passed since start, like a slow startup, during the windows
+// of its Down schedule, like a pod that drops out of rotation, and for good
+// once FailAfter has passed, like a process that hung. Delay slows every
+// answer to trip timeoutSeconds, Status is the failing one, 503 when empty.
+// ready and live always exist, passing unless configured otherwise.
+type Probe struct {
+	Name      string    `json:"name" yaml:"name"`
+	PassAfter string    `json:"pass_after,omitempty" yaml:"pass_after,omitempty"`
+	FailAfter string    `json:"fail_after,omitempty" yaml:"fail_after,omitempty"`
+	Down      *Schedule `json:"down,omitempty" yaml:"down,omitempty"`
+	Delay     DelaySpec `json:"delay,omitzero" yaml:"delay,omitempty"`
+	Status    int       `json:"status,omitempty" yaml:"status,omitempty"`
+}
+
+// Probe overrides, see Controller.SetProbe.
+const (
+	ProbeAuto = "auto"
+	ProbePass = "pass"
+	ProbeFail = "fail"
+)
+
+// Validate reports probes that cannot apply.
+func (p Probe) Validate() error {
+	_, err := p.compile()
+	return err
+}
+
+type probe struct {
+	Probe
+	passAfter, failAfter time.Duration
+	override             string
+}
+
+func (p Probe) compile() (*probe, error) {
+	if p.Name == "" {
+		return nil, fmt.Errorf("probe without a name")
+	}
+	c := &probe{Probe: p, override: ProbeAuto}
+	var err error
+	if p.PassAfter != "" {
+		if c.passAfter, err = time.ParseDuration(p.PassAfter); err != nil {
+			return nil, fmt.Errorf("probe %q: invalid pass_after: %w", p.Name, err)
+		}
+	}
+	if p.FailAfter != "" {
+		if c.failAfter, err = time.ParseDuration(p.FailAfter); err != nil || c.failAfter <= 0 {
+			return nil, fmt.Errorf("probe %q: invalid fail_after %q", p.Name, p.FailAfter)
+		}
+	}
+	if p.Status != 0 && (p.Status < 100 || p.Status > 999) {
+		return nil, fmt.Errorf("probe %q: invalid status %d", p.Name, p.Status)
+	}
+	if c.Status == 0 {
+		c.Status = http.StatusServiceUnavailable
+	}
+	return c, nil
+}
+
+// ProbeState is a probe as reported by /admin/probes and its endpoint.
+type ProbeState struct {
+	Name     string `json:"name"`
+	Passing  bool   `json:"passing"`
+	Reason   string `json:"reason"`
+	Override string `json:"override"`
+}
+
+// state decides the probe at uptime, the reason is starting, down, hung,
+// forced or ok.
+func (p *probe) state(uptime time.Duration, now time.Time) ProbeState {
+	st := ProbeState{Name: p.Name, Override: p.override}
+	switch {
+	case p.override == ProbePass:
+		st.Passing, st.Reason = true, "forced"
+	case p.override == ProbeFail:
+		st.Reason = "forced"
+	case uptime < p.passAfter:
+		st.Reason = "starting"
+	case p.failAfter > 0 && uptime >= p.failAfter:
+		st.Reason = "hung"
+	case p.Down != nil && p.Down.Active(now):
+		st.Reason = "down"
+	default:
+		st.Passing, st.Reason = true, "ok"
+	}
+	return st
+}
+
+type probeSet struct {
+	start time.Time
+
+	mu     sync.Mutex
+	probes map[string]*probe
+	order  []string
+}
+
+func newProbeSet(probes []Probe, start time.Time) (*probeSet, error) {
+	ps := &probeSet{start: start, probes: map[string]*probe{}}
+	for _, p := range append([]Probe{{Name: "ready"}, {Name: "live"}}, probes...) {
+		c, err := p.compile()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := ps.probes[p.Name]; !ok {
+			ps.order = append(ps.order, p.Name)
+		}
+		ps.probes[p.Name] = c
+	}
+	return ps, nil
+}
+
+func (ps *probeSet) get(name string) (*probe, bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	p, ok := ps.probes[name]
+	return p, ok
+}
+
+func (ps *probeSet) states(now time.Time) []ProbeState {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	states := make([]ProbeState, 0, len(ps.order))
+	for _, name := range ps.order {
+		states = append(states, ps.probes[name].state(now.Sub(ps.start), now))
+	}
+	return states
+}
+
+// SetProbe forces the named probe to pass or fail, or with auto hands it
+// back to its schedule.
+func (c *Controller) SetProbe(name, override string) error {
+	switch override {
+	case ProbeAuto, ProbePass, ProbeFail:
+	default:
+		return fmt.Errorf("unknown probe override %q, want auto, pass or fail", override)
+	}
+	ps := c.s.probes
+	ps.mu.Lock()
+	p, ok := ps.probes[name]
+	if ok {
+		p.override = override
+	}
+	ps.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown probe %q", name)
+	}
+	c.s.logger.Info("probe override", zap.String("probe", name), zap.String("override", override))
+	return nil
+}
+
+// probe answers a Kubernetes probe, 200 while it passes and its Status
+// otherwise, after its Delay:
+//
+//	GET /probe/ready
+func (s *Server) probe(rw http.ResponseWriter, req *http.Request) {
+	name := mux.Vars(req)["name"]
+	p, ok := s.probes.get(name)
+	if !ok {
+		writeError(rw, http.StatusNotFound, fmt.Errorf("unknown probe %q", name))
+		return
+	}
+	if d := p.Delay.pick(s.rand); d > 0 {
+		if err := s.Pause(req.Context(), d, 0, nil); err != nil {
+			return
+		}
+	}
+	now := s.clock.now()
+	s.probes.mu.Lock()
+	st := p.state(now.Sub(s.probes.start), now)
+	s.probes.mu.Unlock()
+	status := http.StatusOK
+	if !st.Passing {
+		status = p.Status
+	}
+	s.logger.Debug("probe", zap.String("probe", name), zap.Bool("passing", st.Passing), zap.String("reason", st.Reason))
+	writeJSON(rw, status, st)
+}
+
+func (s *Server) getProbes(rw http.ResponseWriter, req *http.Request) {
+	writeJSON(rw, http.StatusOK, s.probes.states(s.clock.now()))
+}
+
+// setProbe handles PUT /admin/probes/{name}?state=fail, pass or auto.
+func (s *Server) setProbe(rw http.ResponseWriter, req *http.Request) {
+	if err := s.control.SetProbe(mux.Vars(req)["name"], req.URL.Query().Get("state")); err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	s.getProbes(rw, req)
+}
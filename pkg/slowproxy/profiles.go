@@ -0,0 +1,155 @@
+package slowproxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// LatencyProfile slows requests down during a daily window of wall-clock
+// time, like business hours or a nightly batch, read in Timezone (UTC when
+// empty) on the virtual clock. Hours is "09:00-17:00", a window ending
+// earlier than it starts runs past midnight and belongs to the day it
+// started on. Days ("mon", "tue", ...) restrict it to some days of the week,
+// Paths prefixes to some routes.
+type LatencyProfile struct {
+	Name     string    `json:"name" yaml:"name"`
+	Timezone string    `json:"timezone,omitempty" yaml:"timezone,omitempty"`
+	Days     []string  `json:"days,omitempty" yaml:"days,omitempty"`
+	Hours    string    `json:"hours" yaml:"hours"`
+	Paths    []string  `json:"paths,omitempty" yaml:"paths,omitempty"`
+	Delay    DelaySpec `json:"delay" yaml:"delay"`
+}
+
+var weekdays = map[string]time.Weekday{"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday,
+	"wed": time.Wednesday, "thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday}
+
+// Validate reports profiles that cannot apply.
+func (p LatencyProfile) Validate() error {
+	_, err := p.compile()
+	return err
+}
+
+type profile struct {
+	LatencyProfile
+	loc        *time.Location
+	days       map[time.Weekday]bool
+	start, end time.Duration
+}
+
+func (p LatencyProfile) compile() (*profile, error) {
+	c := &profile{LatencyProfile: p, loc: time.UTC}
+	if p.Timezone != "" {
+		loc, err := time.LoadLocation(p.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("profile %q: %w", p.Name, err)
+		}
+		c.loc = loc
+	}
+	if len(p.Days) > 0 {
+		c.days = map[time.Weekday]bool{}
+	}
+	for _, d := range p.Days {
+		wd, ok := weekdays[strings.ToLower(d)[:min(3, len(d))]]
+		if !ok {
+			return nil, fmt.Errorf("profile %q: unknown day %q", p.Name, d)
+		}
+		c.days[wd] = true
+	}
+	from, to, ok := strings.Cut(p.Hours, "-")
+	var err error
+	if ok {
+		if c.start, err = timeOfDay(from); err == nil {
+			c.end, err = timeOfDay(to)
+		}
+	}
+	if !ok || err != nil || c.start == c.end {
+		return nil, fmt.Errorf("profile %q: hours %q, want e.g. 09:00-17:00", p.Name, p.Hours)
+	}
+	if p.Delay.Fixed < 0 || p.Delay.Jitter < 0 {
+		return nil, fmt.Errorf("profile %q: negative delay", p.Name)
+	}
+	return c, nil
+}
+
+func timeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (p *profile) covers(path string) bool {
+	return len(p.Paths) == 0 || FailureGroup{Paths: p.Paths}.covers(path)
+}
+
+func (p *profile) active(now time.Time) bool {
+	now = now.In(p.loc)
+	y, m, d := now.Date()
+	since := now.Sub(time.Date(y, m, d, 0, 0, 0, 0, p.loc))
+	day := now.Weekday()
+	switch {
+	case p.start < p.end:
+		if since < p.start || since >= p.end {
+			return false
+		}
+	case since < p.end:
+		day = (day + 6) % 7
+	case since < p.start:
+		return false
+	}
+	return p.days == nil || p.days[day]
+}
+
+func compileProfiles(profiles []LatencyProfile) ([]*profile, error) {
+	var compiled []*profile
+	for _, p := range profiles {
+		c, err := p.compile()
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+// ProfileState is a latency profile as reported by /admin/profiles.
+type ProfileState struct {
+	LatencyProfile
+	Active bool `json:"active"`
+}
+
+func (s *Server) getProfiles(rw http.ResponseWriter, req *http.Request) {
+	now := s.clock.now()
+	states := []ProfileState{}
+	for _, p := range s.profiles {
+		states = append(states, ProfileState{LatencyProfile: p.LatencyProfile, Active: p.active(now)})
+	}
+	writeJSON(rw, http.StatusOK, states)
+}
+
+// profilesMiddleware delays requests by the first active latency profile,
+// but never the admin API.
+func (s *Server) profilesMiddleware(next http.Handler) http.Handler {
+	if len(s.profiles) == 0 {
+		return next
+	}
+	faults := faultHandler(next, s.rand, func(req *http.Request) *Fault {
+		now := s.clock.now()
+		for _, p := range s.profiles {
+			if p.covers(req.URL.Path) && p.active(now) {
+				return &Fault{Delay: p.Delay}
+			}
+		}
+		return nil
+	})
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/admin/") {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		faults.ServeHTTP(rw, req)
+	})
+}
@@ -0,0 +1,173 @@
+package slowproxy
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"math"
+	"net/http"
+	"time"
+)
+
+// Ramp curves.
+const (
+	RampLinear      = "linear"
+	RampExponential = "exponential"
+)
+
+// Ramp moves the latency of a route from From to To over Over, along a
+// linear or exponential curve, and with Back down to From again over the
+// same time. The final latency stays until the route is cleared.
+type Ramp struct {
+	From  time.Duration
+	To    time.Duration
+	Over  time.Duration
+	Curve string
+	Back  bool
+}
+
+func (r Ramp) validate() error {
+	switch {
+	case r.From < 0 || r.To < 0:
+		return fmt.Errorf("ramp: negative latency")
+	case r.Over <= 0:
+		return fmt.Errorf("ramp: duration must be positive")
+	case r.Curve != "" && r.Curve != RampLinear && r.Curve != RampExponential:
+		return fmt.Errorf("ramp: unknown curve %q", r.Curve)
+	}
+	return nil
+}
+
+// progress is how far along the ramp is at elapsed, 0 to 1, or to 2 when it
+// comes back.
+func (r Ramp) progress(elapsed time.Duration) float64 {
+	total := 1.0
+	if r.Back {
+		total = 2
+	}
+	return min(max(float64(elapsed)/float64(r.Over), 0), total)
+}
+
+// at returns the latency at the given progress.
+func (r Ramp) at(p float64) time.Duration {
+	if p > 1 {
+		p = 2 - p
+	}
+	if r.Curve != RampExponential {
+		return r.From + time.Duration(float64(r.To-r.From)*p)
+	}
+	// an exponential curve cannot start from zero, it starts from 1ms
+	from, to := float64(max(r.From, time.Millisecond)), float64(max(r.To, time.Millisecond))
+	return time.Duration(from * math.Pow(to/from, p))
+}
+
+// activeRamp is a ramp started at start.
+type activeRamp struct {
+	Ramp
+	start time.Time
+	done  chan struct{}
+}
+
+// RampState is a running ramp as reported by the admin API.
+type RampState struct {
+	From     string  `json:"from"`
+	To       string  `json:"to"`
+	Over     string  `json:"over"`
+	Curve    string  `json:"curve"`
+	Back     bool    `json:"back,omitempty"`
+	Progress float64 `json:"progress"`
+	Latency  string  `json:"latency"`
+}
+
+func (r *activeRamp) state(now time.Time) RampState {
+	p := r.progress(now.Sub(r.start))
+	curve := r.Curve
+	if curve == "" {
+		curve = RampLinear
+	}
+	return RampState{
+		From: r.From.String(), To: r.To.String(), Over: r.Over.String(), Curve: curve, Back: r.Back,
+		Progress: math.Round(p*1000) / 1000, Latency: r.at(p).String(),
+	}
+}
+
+// SetRamp starts ramping the latency of requests whose path starts with
+// route, replacing any latency or ramp set for it. Progress is logged every
+// tenth of the way, on the virtual clock.
+func (c *Controller) SetRamp(route string, r Ramp) error {
+	if err := r.validate(); err != nil {
+		return err
+	}
+	a := &activeRamp{Ramp: r, start: c.s.clock.now(), done: make(chan struct{})}
+	c.mu.Lock()
+	c.clearRouteLocked(route)
+	c.ramps[route] = a
+	c.mu.Unlock()
+	c.s.logger.Info("started latency ramp", zap.String("route", route), zap.Duration("from", r.From), zap.Duration("to", r.To), zap.Duration("over", r.Over))
+	go c.reportRamp(route, a)
+	return nil
+}
+
+// clearRouteLocked drops the latency and ramp of route.
+func (c *Controller) clearRouteLocked(route string) {
+	delete(c.latency, route)
+	if old, ok := c.ramps[route]; ok {
+		close(old.done)
+		delete(c.ramps, route)
+	}
+}
+
+// reportRamp logs the progress of a ramp until it is done or replaced.
+func (c *Controller) reportRamp(route string, a *activeRamp) {
+	ticker := time.NewTicker(max(c.s.clock.real(min(a.Over/100, time.Second)), 10*time.Millisecond))
+	defer ticker.Stop()
+	total := 10
+	if a.Back {
+		total = 20
+	}
+	reported := 0
+	for reported < total {
+		select {
+		case <-a.done:
+			return
+		case <-c.s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		p := a.progress(c.s.clock.now().Sub(a.start))
+		step := int(p * 10)
+		if step <= reported {
+			continue
+		}
+		reported = step
+		c.s.logger.Debug("latency ramp progress", zap.String("route", route), zap.Int("percent", step*100/total), zap.Duration("latency", a.at(p)))
+	}
+	c.s.logger.Info("latency ramp finished", zap.String("route", route))
+}
+
+// setRamp handles PUT /admin/ramp?route=/api&from=0s&to=2s&over=10m&curve=exponential&back=true.
+func (s *Server) setRamp(rw http.ResponseWriter, req *http.Request) {
+	var r Ramp
+	var err error
+	if r.From, err = durationQuery(req, "from", 0); err == nil {
+		if r.To, err = durationQuery(req, "to", 0); err == nil {
+			if r.Over, err = durationQuery(req, "over", 0); err == nil {
+				r.Back, err = boolQuery(req, "back", false)
+			}
+		}
+	}
+	r.Curve = req.URL.Query().Get("curve")
+	if err == nil {
+		err = s.control.SetRamp(req.URL.Query().Get("route"), r)
+	}
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	s.getControl(rw, req)
+}
+
+// clearRamp handles DELETE /admin/ramp?route=/api.
+func (s *Server) clearRamp(rw http.ResponseWriter, req *http.Request) {
+	s.control.SetLatency(req.URL.Query().Get("route"), 0)
+	s.getControl(rw, req)
+}
@@ -0,0 +1,118 @@
+package slowproxy
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// tokenBucket holds limit tokens and refills them evenly over window.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// bucketSet keeps one bucket per client key and limit.
+type bucketSet struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newBucketSet() *bucketSet {
+	return &bucketSet{buckets: map[string]*tokenBucket{}}
+}
+
+// maxBuckets bounds the set, full buckets are forgotten beyond it.
+const maxBuckets = 10000
+
+// take removes a token for key and reports whether one was available, the
+// tokens left and how long until the next token and a full bucket.
+func (bs *bucketSet) take(key string, limit int, window time.Duration, now time.Time) (ok bool, remaining int, retry, reset time.Duration) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	rate := float64(limit) / float64(window)
+	b, found := bs.buckets[key]
+	if !found {
+		if len(bs.buckets) >= maxBuckets {
+			bs.prune(rate, float64(limit), now)
+		}
+		b = &tokenBucket{tokens: float64(limit), last: now}
+		bs.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit), b.tokens+float64(now.Sub(b.last))*rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		ok = true
+	} else {
+		retry = time.Duration((1 - b.tokens) / rate)
+	}
+	reset = time.Duration((float64(limit) - b.tokens) / rate)
+	return ok, int(b.tokens), retry, reset
+}
+
+func (bs *bucketSet) prune(rate, limit float64, now time.Time) {
+	for k, b := range bs.buckets {
+		if b.tokens+float64(now.Sub(b.last))*rate >= limit {
+			delete(bs.buckets, k)
+		}
+	}
+}
+
+// clientKey identifies the caller by the X-Client-Key header, falling back to
+// the remote IP.
+func clientKey(req *http.Request) string {
+	if k := req.Header.Get("X-Client-Key"); k != "" {
+		return k
+	}
+	return remoteIP(req.RemoteAddr)
+}
+
+// ratelimited enforces a token bucket per client and answers 429 with the
+// usual rate limit headers once it is empty:
+//
+//	/ratelimited?limit=10&window=1m
+func (s *Server) ratelimited(rw http.ResponseWriter, req *http.Request) {
+	limit, err := intQuery(req, "limit", 10)
+	if err == nil && limit <= 0 {
+		err = fmt.Errorf("invalid limit: must be positive")
+	}
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	window, err := durationQuery(req, "window", time.Minute)
+	if err == nil && window <= 0 {
+		err = fmt.Errorf("invalid window: must be positive")
+	}
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	key := clientKey(req)
+	ok, remaining, retry, reset := s.buckets.take(fmt.Sprintf("%s|%d|%s", key, limit, window), limit, window, time.Now())
+	h := rw.Header()
+	h.Set("X-RateLimit-Limit", strconv.Itoa(limit))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	h.Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(reset)))
+	if !ok {
+		s.logger.Info("rate limited", zap.String("client", key), zap.Duration("retry_after", retry))
+		h.Set("Retry-After", strconv.Itoa(ceilSeconds(retry)))
+		writeAPIError(rw, http.StatusTooManyRequests, &apiError{
+			Code:    "rate_limited",
+			Message: fmt.Sprintf("limit of %d requests per %s exceeded", limit, window),
+		})
+		return
+	}
+	writeJSON(rw, http.StatusOK, map[string]any{"client": key, "remaining": remaining})
+}
+
+// ceilSeconds rounds up so clients never retry too early.
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,79 @@
+package slowproxy
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// ReadyInfo describes the bound listeners once Run is serving. Addresses are
+// the resolved ones, so a ":0" request reports the port actually chosen.
+type ReadyInfo struct {
+	Ready     bool     `json:"ready"`
+	PID       int      `json:"pid"`
+	Addr      string   `json:"addr"`
+	GRPCAddr  string   `json:"grpc_addr,omitempty"`
+	TCPFaults []string `json:"tcp_faults,omitempty"`
+
+	Listeners []ListenerInfo `json:"listeners"`
+}
+
+// ListenerInfo describes one bound listener. Kind is http, grpc or
+// tcp-fault, Protocol what a client speaks to it (http, https, grpc, grpcs or
+// tcp) and Family ipv4, ipv6 or dual-stack for a wildcard IPv6 address that
+// also accepts IPv4.
+type ListenerInfo struct {
+	Kind     string `json:"kind"`
+	Protocol string `json:"protocol"`
+	Addr     string `json:"addr"`
+	Family   string `json:"family"`
+	TLS      bool   `json:"tls"`
+	Mode     string `json:"mode,omitempty"`
+	URL      string `json:"url,omitempty"`
+}
+
+func listenerInfo(kind, protocol string, addr net.Addr, tls bool) ListenerInfo {
+	info := ListenerInfo{Kind: kind, Protocol: protocol, Addr: addr.String(), TLS: tls}
+	if ta, ok := addr.(*net.TCPAddr); ok {
+		switch {
+		case ta.IP.To4() != nil:
+			info.Family = "ipv4"
+		case ta.IP.IsUnspecified():
+			info.Family = "dual-stack"
+		default:
+			info.Family = "ipv6"
+		}
+	}
+	if protocol == "http" || protocol == "https" {
+		info.URL = protocol + "://" + info.Addr
+	}
+	return info
+}
+
+// WithReadyFunc calls fn once every listener is bound, before serving.
+func WithReadyFunc(fn func(ReadyInfo)) Option {
+	return func(s *Server) { s.onReady = append(s.onReady, fn) }
+}
+
+// WriteReadyFile writes info as a JSON line to path, atomically so a harness
+// polling for the file never reads it half written.
+func WriteReadyFile(path string, info ReadyInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".ready-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,182 @@
+package slowproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PhaseReport sums up the requests served during one phase of a scenario.
+// A phase is named with /admin/report/phase, or after what is active on the
+// virtual clock: the Markov state, scheduled rules, down failure groups,
+// latency profiles and ramps, "steady" when nothing is.
+type PhaseReport struct {
+	Phase             string    `json:"phase"`
+	Start             time.Time `json:"start"`
+	End               time.Time `json:"end"`
+	Requests          int64     `json:"requests"`
+	Faults            int64     `json:"faults"`
+	ServerErrors      int64     `json:"server_errors"`
+	Aborted           int64     `json:"aborted"`
+	ClientDisconnects int64     `json:"client_disconnects"`
+	DisconnectRate    float64   `json:"disconnect_rate"`
+}
+
+// SoakReport is the artifact of a long run, from /admin/report or written
+// to Options.ReportPath at shutdown.
+type SoakReport struct {
+	Start  time.Time     `json:"start"`
+	End    time.Time     `json:"end"`
+	Seed   int64         `json:"seed"`
+	Totals Stats         `json:"totals"`
+	Phases []PhaseReport `json:"phases"`
+}
+
+// phaseRecorder keeps at most max phases, the oldest go first.
+type phaseRecorder struct {
+	mu      sync.Mutex
+	start   time.Time
+	named   string
+	phases  []PhaseReport
+	max     int
+	evicted *atomic.Int64
+}
+
+func (r *phaseRecorder) record(phase string, at time.Time, c *Capture) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if n := len(r.phases); n == 0 || r.phases[n-1].Phase != phase {
+		if n >= r.max {
+			r.phases = slices.Delete(r.phases, 0, n-r.max+1)
+			r.evicted.Add(int64(n - r.max + 1))
+		}
+		r.phases = append(r.phases, PhaseReport{Phase: phase, Start: at})
+	}
+	p := &r.phases[len(r.phases)-1]
+	p.End = at
+	p.Requests++
+	if c.Fault {
+		p.Faults++
+	}
+	if c.Status >= 500 {
+		p.ServerErrors++
+	}
+	if c.Aborted {
+		p.Aborted++
+	}
+	if c.ClientGone {
+		p.ClientDisconnects++
+	}
+	p.DisconnectRate = float64(p.ClientDisconnects) / float64(p.Requests)
+}
+
+// phase names the current phase of the scenario.
+func (s *Server) phase(now time.Time) string {
+	s.report.mu.Lock()
+	named := s.report.named
+	s.report.mu.Unlock()
+	if named != "" {
+		return named
+	}
+	var parts []string
+	if st := s.markov.status(now); st.State != "" {
+		parts = append(parts, "markov:"+st.State)
+	}
+	for _, r := range s.rules.list() {
+		if r.Schedule != nil && r.Schedule.Active(now) {
+			parts = append(parts, "rule:"+r.Name)
+		}
+	}
+	for _, g := range s.groups.states(now) {
+		if g.Down {
+			parts = append(parts, "group:"+g.Name)
+		}
+	}
+	for _, p := range s.profiles {
+		if p.active(now) {
+			parts = append(parts, "profile:"+p.Name)
+		}
+	}
+	routes := []string{}
+	for route := range s.control.State().Ramps {
+		routes = append(routes, "ramp:"+route)
+	}
+	slices.Sort(routes)
+	parts = append(parts, routes...)
+	if len(parts) == 0 {
+		return "steady"
+	}
+	return strings.Join(parts, " ")
+}
+
+// Report returns the soak report so far.
+func (s *Server) Report() SoakReport {
+	s.report.mu.Lock()
+	defer s.report.mu.Unlock()
+	return SoakReport{
+		Start:  s.report.start,
+		End:    s.clock.now(),
+		Seed:   s.Seed(),
+		Totals: s.Stats(),
+		Phases: append([]PhaseReport{}, s.report.phases...),
+	}
+}
+
+// WriteReport writes the soak report to path, as HTML when it ends in .html
+// and JSON otherwise.
+func (s *Server) WriteReport(path string) error {
+	report := s.Report()
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(filepath.Ext(path), ".html") {
+		err = reportHTML.Execute(f, report)
+	} else {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+var reportHTML = template.Must(template.New("report").Funcs(template.FuncMap{
+	"pct": func(v float64) string { return fmt.Sprintf("%.1f%%", 100*v) },
+	"ts":  func(t time.Time) string { return t.UTC().Format(time.RFC3339) },
+}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>slow-proxy soak report</title>
+<style>body{font-family:sans-serif}table{border-collapse:collapse}td,th{border:1px solid #ccc;padding:4px 8px;text-align:right}td:first-child{text-align:left}</style>
+</head><body>
+<h1>slow-proxy soak report</h1>
+<p>{{ts .Start}} to {{ts .End}}, seed {{.Seed}}: {{.Totals.Requests}} requests, {{.Totals.Aborted}} aborted, {{.Totals.ClientDisconnects}} client disconnects.</p>
+<table>
+<tr><th>phase</th><th>start</th><th>end</th><th>requests</th><th>faults</th><th>5xx</th><th>aborted</th><th>client disconnects</th></tr>
+{{range .Phases}}<tr><td>{{.Phase}}</td><td>{{ts .Start}}</td><td>{{ts .End}}</td><td>{{.Requests}}</td><td>{{.Faults}}</td><td>{{.ServerErrors}}</td><td>{{.Aborted}}</td><td>{{.ClientDisconnects}} ({{pct .DisconnectRate}})</td></tr>
+{{end}}</table>
+</body></html>
+`))
+
+func (s *Server) getReport(rw http.ResponseWriter, req *http.Request) {
+	writeJSON(rw, http.StatusOK, s.Report())
+}
+
+// setPhase handles PUT /admin/report/phase?name=warmup, naming the phase
+// requests are counted in until it is renamed or, without a name, the
+// automatic naming resumes.
+func (s *Server) setPhase(rw http.ResponseWriter, req *http.Request) {
+	s.report.mu.Lock()
+	s.report.named = req.URL.Query().Get("name")
+	s.report.mu.Unlock()
+	rw.WriteHeader(http.StatusNoContent)
+}
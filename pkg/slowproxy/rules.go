@@ -0,0 +1,363 @@
+package slowproxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rule applies Fault to requests accepted by Match. The same structure is
+// read from config files, accepted by the admin API and used by the library.
+// A rule with a Schedule only applies during its windows, one with a Burst
+// only to the first requests of each burst window.
+type Rule struct {
+	Name     string    `json:"name,omitempty" yaml:"name,omitempty"`
+	Match    Matcher   `json:"match" yaml:"match"`
+	Fault    Fault     `json:"fault" yaml:"fault"`
+	Schedule *Schedule `json:"schedule,omitempty" yaml:"schedule,omitempty"`
+	Burst    *Burst    `json:"burst,omitempty" yaml:"burst,omitempty"`
+}
+
+// Matcher selects requests. Empty fields match everything, Path is a prefix
+// and Headers must all be present with the given values.
+//
+// BodyRegex must match somewhere in the request body, and each JSON path,
+// like operation or $.items[0].kind, must hold the given value in a JSON
+// body. Each GRPC field number path, like 1 or 3.2 for field 2 of the message
+// in field 3, must hold the given value in the first message of a gRPC call,
+// read without its schema as proxied calls come with none. Captures keep no
+// body, so body conditions never match them.
+type Matcher struct {
+	Method    string            `json:"method,omitempty" yaml:"method,omitempty"`
+	Path      string            `json:"path,omitempty" yaml:"path,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	BodyRegex string            `json:"body_regex,omitempty" yaml:"body_regex,omitempty"`
+	JSON      map[string]any    `json:"json,omitempty" yaml:"json,omitempty"`
+	GRPC      map[string]any    `json:"grpc,omitempty" yaml:"grpc,omitempty"`
+}
+
+// Fault is the misbehaviour injected into a matching request. A delay is
+// applied first, then the request is aborted, answered with Status, or let
+// through untouched. With After set the request is handled normally first and
+// the fault applies to its response, e.g. work done but the client sees a 503.
+//
+// IfSuccess, with After, only replaces responses that were 2xx, e.g. to turn a
+// share of successful calls into transient 401s.
+//
+// Body is a text/template rendered with TemplateData and sent, along with
+// Headers, in place of the default status text. A Body without a Status is
+// answered with a 200, which turns a rule into a dynamic stub.
+type Fault struct {
+	Delay     DelaySpec         `json:"delay,omitzero" yaml:"delay,omitempty"`
+	Abort     bool              `json:"abort,omitempty" yaml:"abort,omitempty"`
+	Status    int               `json:"status,omitempty" yaml:"status,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Body      string            `json:"body,omitempty" yaml:"body,omitempty"`
+	Percent   float64           `json:"percent,omitempty" yaml:"percent,omitempty"` // share of matching requests affected, all when zero
+	After     bool              `json:"after,omitempty" yaml:"after,omitempty"`
+	IfSuccess bool              `json:"if_success,omitempty" yaml:"if_success,omitempty"`
+}
+
+// AuthFailureRule replaces percent of the successful responses of every
+// route with status, 401 or 403, like an auth layer with transient errors.
+func AuthFailureRule(percent float64, status int) Rule {
+	f := Fault{Status: status, Percent: percent, After: true, IfSuccess: true}
+	if status == http.StatusUnauthorized {
+		f.Headers = map[string]string{"WWW-Authenticate": `Bearer realm="` + authRealm + `", error="invalid_token"`}
+	}
+	return Rule{Name: "auth-failures", Fault: f}
+}
+
+// replaces reports whether a fault applied after the handler overrides a
+// response with the given status.
+func (f *Fault) replaces(status int) bool {
+	return !f.IfSuccess || status >= 200 && status < 300
+}
+
+// responds reports whether the fault answers the request itself.
+func (f *Fault) responds() bool {
+	return f.Abort || f.Status != 0 || f.Body != ""
+}
+
+// response renders the status, headers and body sent for a responding fault,
+// the random helpers of the body drawing from r.
+func (f *Fault) response(req *http.Request, r *seededRand) (int, http.Header, string) {
+	status := f.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	header := http.Header{}
+	if f.Body == "" {
+		header.Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	for k, v := range f.Headers {
+		header.Set(k, v)
+	}
+	if f.Body == "" {
+		return status, header, http.StatusText(status) + "\n"
+	}
+	body, err := renderBody(f.Body, req, r)
+	if err != nil {
+		header = http.Header{"Content-Type": {"text/plain; charset=utf-8"}}
+		return http.StatusInternalServerError, header, "template: " + err.Error() + "\n"
+	}
+	return status, header, body
+}
+
+// DelaySpec is a fixed delay plus up to Jitter of uniformly random extra
+// delay. It is written as {"fixed": "2s", "jitter": "500ms"} or just "2s".
+type DelaySpec struct {
+	Fixed  time.Duration
+	Jitter time.Duration
+}
+
+// Fixed returns a DelaySpec without jitter.
+func Fixed(d time.Duration) DelaySpec {
+	return DelaySpec{Fixed: d}
+}
+
+// Duration picks the delay for one request, see SetSeed.
+func (d DelaySpec) Duration() time.Duration {
+	return d.pick(defaultRand)
+}
+
+// pick is Duration with the jitter drawn from r.
+func (d DelaySpec) pick(r *seededRand) time.Duration {
+	if d.Jitter <= 0 {
+		return d.Fixed
+	}
+	return d.Fixed + time.Duration(r.Int63n(int64(d.Jitter)))
+}
+
+// IsZero reports whether no delay is configured, for omitempty.
+func (d DelaySpec) IsZero() bool {
+	return d.Fixed == 0 && d.Jitter == 0
+}
+
+type delaySpecJSON struct {
+	Fixed  string `json:"fixed,omitempty" yaml:"fixed,omitempty"`
+	Jitter string `json:"jitter,omitempty" yaml:"jitter,omitempty"`
+}
+
+func (d DelaySpec) encoded() interface{} {
+	if d.Jitter == 0 {
+		return d.Fixed.String()
+	}
+	return delaySpecJSON{Fixed: d.Fixed.String(), Jitter: d.Jitter.String()}
+}
+
+func (d *DelaySpec) decode(short *string, long *delaySpecJSON) error {
+	var err error
+	if short != nil {
+		d.Fixed, err = time.ParseDuration(*short)
+		d.Jitter = 0
+		return err
+	}
+	*d = DelaySpec{}
+	if long.Fixed != "" {
+		if d.Fixed, err = time.ParseDuration(long.Fixed); err != nil {
+			return err
+		}
+	}
+	if long.Jitter != "" {
+		if d.Jitter, err = time.ParseDuration(long.Jitter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d DelaySpec) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.encoded())
+}
+
+func (d *DelaySpec) UnmarshalJSON(b []byte) error {
+	var short string
+	if err := json.Unmarshal(b, &short); err == nil {
+		return d.decode(&short, nil)
+	}
+	var long delaySpecJSON
+	if err := json.Unmarshal(b, &long); err != nil {
+		return err
+	}
+	return d.decode(nil, &long)
+}
+
+func (d DelaySpec) MarshalYAML() (interface{}, error) {
+	return d.encoded(), nil
+}
+
+func (d *DelaySpec) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return d.decode(&node.Value, nil)
+	}
+	var long delaySpecJSON
+	if err := node.Decode(&long); err != nil {
+		return err
+	}
+	return d.decode(nil, &long)
+}
+
+// Validate reports rules that can never be applied as written.
+func (r Rule) Validate() error {
+	f := r.Fault
+	switch {
+	case f.Status != 0 && (f.Status < 200 || f.Status > 599):
+		return fmt.Errorf("rule %q: status %d out of range 200-599", r.Name, f.Status)
+	case f.Percent < 0 || f.Percent > 100:
+		return fmt.Errorf("rule %q: percent %v out of range 0-100", r.Name, f.Percent)
+	case f.Delay.Fixed < 0 || f.Delay.Jitter < 0:
+		return fmt.Errorf("rule %q: negative delay", r.Name)
+	case f.Abort && (f.Status != 0 || f.Body != ""):
+		return fmt.Errorf("rule %q: abort and status are exclusive", r.Name)
+	case f.IfSuccess && !f.After:
+		return fmt.Errorf("rule %q: if_success needs after", r.Name)
+	case r.Schedule != nil && r.Schedule.every == 0 && r.Schedule.cron == nil:
+		return fmt.Errorf("rule %q: empty schedule, use ParseSchedule", r.Name)
+	}
+	if f.Body != "" {
+		if _, err := parseTemplate(f.Body); err != nil {
+			return fmt.Errorf("rule %q: %w", r.Name, err)
+		}
+	}
+	if err := r.Match.validateBody(); err != nil {
+		return fmt.Errorf("rule %q: %w", r.Name, err)
+	}
+	if r.Burst != nil {
+		if err := r.Burst.validate(); err != nil {
+			return fmt.Errorf("rule %q: %w", r.Name, err)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether req is selected by m.
+func (m Matcher) Matches(req *http.Request) bool {
+	return m.match(req.Method, req.URL.Path, req.Header) && m.matchBody(req)
+}
+
+// MatchesCapture reports whether the captured request is selected by m.
+func (m Matcher) MatchesCapture(c Capture) bool {
+	if m.inspectsBody() {
+		return false
+	}
+	return m.match(c.Method, c.Path, c.Header)
+}
+
+func (m Matcher) match(method, path string, header http.Header) bool {
+	if m.Method != "" && !strings.EqualFold(m.Method, method) {
+		return false
+	}
+	if !strings.HasPrefix(path, m.Path) {
+		return false
+	}
+	for k, v := range m.Headers {
+		if header.Get(k) != v {
+			return false
+		}
+	}
+	return true
+}
+
+// ruleSet is a list of rules safe to replace while requests are matched.
+type ruleSet struct {
+	mu    sync.RWMutex
+	rules []Rule
+}
+
+func newRuleSet(rules []Rule) *ruleSet {
+	return &ruleSet{rules: append([]Rule(nil), rules...)}
+}
+
+func (rs *ruleSet) list() []Rule {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return append([]Rule{}, rs.rules...)
+}
+
+func (rs *ruleSet) replace(rules []Rule) error {
+	for _, r := range rules {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.rules = append([]Rule(nil), rules...)
+	return nil
+}
+
+func (rs *ruleSet) add(r Rule) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.rules = append(rs.rules, r)
+	return nil
+}
+
+var errRuleNotFound = errors.New("rule not found")
+
+func (rs *ruleSet) remove(name string) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for i, r := range rs.rules {
+		if r.Name == name {
+			rs.rules = append(rs.rules[:i:i], rs.rules[i+1:]...)
+			return nil
+		}
+	}
+	return errRuleNotFound
+}
+
+func (rs *ruleSet) match(req *http.Request, now time.Time, r *seededRand) *Fault {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return matchFault(rs.rules, req, now, r)
+}
+
+// matchFault returns the fault of the first rule matching req at now, after
+// rolling its percentage on r, or nil when the request should be left alone.
+func matchFault(rules []Rule, req *http.Request, now time.Time, r *seededRand) *Fault {
+	for i := range rules {
+		if !rules[i].Match.Matches(req) {
+			continue
+		}
+		if rules[i].Schedule != nil && !rules[i].Schedule.Active(now) {
+			continue
+		}
+		if rules[i].Burst != nil && !rules[i].Burst.take(now) {
+			continue
+		}
+		f := rules[i].Fault
+		if f.Percent > 0 && r.Float64()*100 >= f.Percent {
+			return nil
+		}
+		return &f
+	}
+	return nil
+}
+
+// sleep waits for d unless ctx is done first.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	d = scaleDelay(ctx, d)
+	noteDelay(ctx, d)
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
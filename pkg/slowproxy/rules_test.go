@@ -0,0 +1,173 @@
+package slowproxy
+
+import (
+	"encoding/json"
+	"gopkg.in/yaml.v3"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDelaySpecRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     DelaySpec
+		wantJSON string
+		wantYAML string
+	}{
+		{name: "fixed", spec: Fixed(2 * time.Second), wantJSON: `"2s"`, wantYAML: "2s\n"},
+		{name: "zero", spec: DelaySpec{}, wantJSON: `"0s"`, wantYAML: "0s\n"},
+		{
+			name:     "jitter",
+			spec:     DelaySpec{Fixed: time.Second, Jitter: 500 * time.Millisecond},
+			wantJSON: `{"fixed":"1s","jitter":"500ms"}`,
+			wantYAML: "fixed: 1s\njitter: 500ms\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.wantJSON {
+				t.Errorf("json = %s, want %s", b, tt.wantJSON)
+			}
+			var fromJSON DelaySpec
+			if err := json.Unmarshal(b, &fromJSON); err != nil {
+				t.Fatal(err)
+			}
+			if fromJSON != tt.spec {
+				t.Errorf("json round trip = %+v, want %+v", fromJSON, tt.spec)
+			}
+
+			y, err := yaml.Marshal(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(y) != tt.wantYAML {
+				t.Errorf("yaml = %q, want %q", y, tt.wantYAML)
+			}
+			var fromYAML DelaySpec
+			if err := yaml.Unmarshal(y, &fromYAML); err != nil {
+				t.Fatal(err)
+			}
+			if fromYAML != tt.spec {
+				t.Errorf("yaml round trip = %+v, want %+v", fromYAML, tt.spec)
+			}
+		})
+	}
+}
+
+func TestDelaySpecDecodeErrors(t *testing.T) {
+	for _, in := range []string{`"soon"`, `{"fixed":"1s","jitter":"lots"}`, `42`} {
+		var d DelaySpec
+		if err := json.Unmarshal([]byte(in), &d); err == nil {
+			t.Errorf("Unmarshal(%s) = %+v, want an error", in, d)
+		}
+	}
+}
+
+func TestRuleRoundTrip(t *testing.T) {
+	schedule, err := ParseSchedule("*/15 9-17 * * 1-5 for 3m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := []Rule{
+		{Name: "slow-api", Match: Matcher{Method: "GET", Path: "/api"}, Fault: Fault{Delay: Fixed(time.Second)}},
+		{
+			Name:  "flaky",
+			Match: Matcher{Headers: map[string]string{"X-Tenant": "a"}, JSON: map[string]any{"operation": "pay"}},
+			Fault: Fault{
+				Delay:   DelaySpec{Fixed: 100 * time.Millisecond, Jitter: 50 * time.Millisecond},
+				Status:  503,
+				Headers: map[string]string{"Retry-After": "1"},
+				Body:    `{"n": {{randInt 1 6}}}`,
+				Percent: 25,
+			},
+			Schedule: schedule,
+		},
+		{Name: "drop", Fault: Fault{Abort: true}},
+		{Name: "after", Fault: Fault{Status: 401, After: true, IfSuccess: true}},
+	}
+	for _, r := range rules {
+		t.Run(r.Name, func(t *testing.T) {
+			if err := r.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			b, err := json.Marshal(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var fromJSON Rule
+			if err := json.Unmarshal(b, &fromJSON); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(fromJSON, r) {
+				t.Errorf("json round trip of %s = %+v, want %+v", b, fromJSON, r)
+			}
+
+			y, err := yaml.Marshal(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var fromYAML Rule
+			if err := yaml.Unmarshal(y, &fromYAML); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(fromYAML, r) {
+				t.Errorf("yaml round trip of %s = %+v, want %+v", y, fromYAML, r)
+			}
+		})
+	}
+}
+
+func TestRuleValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+		want string
+	}{
+		{name: "status", rule: Rule{Fault: Fault{Status: 99}}, want: "out of range"},
+		{name: "percent", rule: Rule{Fault: Fault{Percent: 101}}, want: "percent"},
+		{name: "negative delay", rule: Rule{Fault: Fault{Delay: Fixed(-time.Second)}}, want: "negative delay"},
+		{name: "abort and status", rule: Rule{Fault: Fault{Abort: true, Status: 500}}, want: "exclusive"},
+		{name: "if_success", rule: Rule{Fault: Fault{Status: 401, IfSuccess: true}}, want: "needs after"},
+		{name: "template", rule: Rule{Fault: Fault{Body: "{{"}}, want: "unclosed action"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rule.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate() = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchFaultPercentFollowsSeed(t *testing.T) {
+	rules := []Rule{{Fault: Fault{Status: 503, Percent: 50}}}
+	run := func(seed int64) []bool {
+		r := newSeededRand(seed)
+		var hits []bool
+		for range 64 {
+			hits = append(hits, matchFault(rules, httptest.NewRequest("GET", "/", nil), time.Now(), r) != nil)
+		}
+		return hits
+	}
+	a, b := run(42), run(42)
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("same seed, different decisions:\n%v\n%v", a, b)
+	}
+	n := 0
+	for _, hit := range a {
+		if hit {
+			n++
+		}
+	}
+	if n == 0 || n == len(a) {
+		t.Errorf("%d of %d requests hit by a 50%% rule", n, len(a))
+	}
+}
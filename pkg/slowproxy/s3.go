@@ -0,0 +1,760 @@
+package slowproxy
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// S3 operations, the keys of S3Mock.Operations.
+const (
+	S3GetObject               = "GetObject"
+	S3HeadObject              = "HeadObject"
+	S3PutObject               = "PutObject"
+	S3DeleteObject            = "DeleteObject"
+	S3CreateMultipartUpload   = "CreateMultipartUpload"
+	S3UploadPart              = "UploadPart"
+	S3CompleteMultipartUpload = "CompleteMultipartUpload"
+	S3AbortMultipartUpload    = "AbortMultipartUpload"
+)
+
+var s3Operations = []string{S3GetObject, S3HeadObject, S3PutObject, S3DeleteObject, S3CreateMultipartUpload, S3UploadPart, S3CompleteMultipartUpload, S3AbortMultipartUpload}
+
+// S3Memory as S3Mock.Dir starts the store empty.
+const S3Memory = "memory"
+
+const (
+	// s3MaxObject bounds what a single object or part may hold in memory.
+	s3MaxObject = 1 << 30
+	// s3MinPart is the smallest part S3 accepts but for the last one.
+	s3MinPart  = 5 << 20
+	s3MaxParts = 10000
+	// s3UploadTTL and s3MaxPending bound the parts of incomplete uploads:
+	// uploads are aborted once older than the TTL, and the oldest first
+	// when their parts add up to more than s3MaxPending bytes.
+	s3UploadTTL  = time.Hour
+	s3MaxPending = 2 << 30
+)
+
+// S3Mock serves the object API of S3, path style under Prefix (/s3):
+// objects are read, written and deleted, uploaded in parts, ranged and
+// conditionally requested as the SDKs expect. The store is held in memory,
+// starting from the files of Dir, a directory per bucket, which writes
+// leave untouched. Operations take a Fault each, keyed by the S3 name like
+// GetObject or UploadPart; injected statuses answer with the S3 error code
+// of the status.
+type S3Mock struct {
+	Dir        string           `json:"dir,omitempty" yaml:"dir,omitempty"`
+	Prefix     string           `json:"prefix,omitempty" yaml:"prefix,omitempty"`
+	Operations map[string]Fault `json:"operations,omitempty" yaml:"operations,omitempty"`
+}
+
+// Validate reports fixtures that cannot load and faults that cannot apply.
+func (m S3Mock) Validate() error {
+	_, err := m.compile()
+	return err
+}
+
+// s3ErrorCodes names the S3 error sent for an injected status.
+var s3ErrorCodes = map[int]string{
+	http.StatusBadRequest:          "InvalidRequest",
+	http.StatusForbidden:           "AccessDenied",
+	http.StatusNotFound:            "NoSuchKey",
+	http.StatusConflict:            "OperationAborted",
+	http.StatusPreconditionFailed:  "PreconditionFailed",
+	http.StatusInternalServerError: "InternalError",
+	http.StatusNotImplemented:      "NotImplemented",
+	http.StatusServiceUnavailable:  "SlowDown",
+}
+
+type s3Object struct {
+	data     []byte
+	etag     string
+	modified time.Time
+	header   http.Header
+	parts    []int // sizes of the parts of a multipart upload
+}
+
+type s3Part struct {
+	data []byte
+	sum  [md5.Size]byte
+}
+
+type s3Upload struct {
+	bucket, key string
+	header      http.Header
+	parts       map[int]s3Part
+	started     time.Time
+	size        int // bytes held by the parts
+}
+
+type s3Store struct {
+	prefix string
+	faults map[string]*Fault
+
+	mu      sync.Mutex
+	objects map[string]*s3Object // by bucket/key
+	uploads map[string]*s3Upload
+	pending int // bytes held by the parts of all uploads
+}
+
+func (m S3Mock) compile() (*s3Store, error) {
+	st := &s3Store{prefix: "/" + strings.Trim(m.Prefix, "/"), faults: map[string]*Fault{}, objects: map[string]*s3Object{}, uploads: map[string]*s3Upload{}}
+	if st.prefix == "/" {
+		st.prefix = "/s3"
+	}
+	for op, f := range m.Operations {
+		if !slices.Contains(s3Operations, op) {
+			return nil, fmt.Errorf("s3: unknown operation %q, want one of %s", op, strings.Join(s3Operations, ", "))
+		}
+		if err := (Rule{Name: op, Fault: f}).Validate(); err != nil {
+			return nil, fmt.Errorf("s3: %w", err)
+		}
+		if f.Status != 0 && f.Body == "" {
+			f.Body = string(s3ErrorXML(cmp.Or(s3ErrorCodes[f.Status], "InternalError"), http.StatusText(f.Status), ""))
+			f.Headers = mergeHeaders(f.Headers, "Content-Type", "application/xml")
+		}
+		st.faults[op] = &f
+	}
+	if m.Dir == "" || m.Dir == S3Memory {
+		return st, nil
+	}
+	err := filepath.WalkDir(m.Dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(m.Dir, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !strings.Contains(name, "/") {
+			return nil // files beside the buckets
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		header := http.Header{}
+		header.Set("Content-Type", fixtureType(name, data))
+		st.objects[name] = newS3Object(data, info.ModTime(), header)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("s3: %w", err)
+	}
+	return st, nil
+}
+
+func newS3Object(data []byte, modified time.Time, header http.Header) *s3Object {
+	sum := md5.Sum(data)
+	return &s3Object{data: data, etag: `"` + hex.EncodeToString(sum[:]) + `"`, modified: modified.UTC().Truncate(time.Second), header: header}
+}
+
+// s3Routes registers the object API under the prefix, each operation
+// behind its fault.
+func (s *Server) s3Routes(r *mux.Router) {
+	st := s.s3
+	handlers := map[string]http.HandlerFunc{
+		S3GetObject:               st.getObject,
+		S3HeadObject:              st.getObject,
+		S3PutObject:               st.putObject,
+		S3DeleteObject:            st.deleteObject,
+		S3CreateMultipartUpload:   st.createUpload,
+		S3UploadPart:              st.uploadPart,
+		S3CompleteMultipartUpload: st.completeUpload,
+		S3AbortMultipartUpload:    st.abortUpload,
+	}
+	ops := map[string]http.Handler{}
+	for op, h := range handlers {
+		ops[op] = h
+		if f := st.faults[op]; f != nil {
+			ops[op] = faultHandler(h, s.rand, func(req *http.Request) *Fault {
+				if f.Percent > 0 && s.rand.Float64()*100 >= f.Percent {
+					return nil
+				}
+				s.logger.Debug("s3 fault", zap.String("operation", op))
+				return f
+			})
+		}
+	}
+	sub := r.PathPrefix(st.prefix).Subrouter()
+	sub.HandleFunc("/{bucket}/{key:.+}", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("X-Amz-Request-Id", strings.ToUpper(randomToken()[:16]))
+		h, ok := ops[s3Operation(req)]
+		if !ok {
+			s3Error(rw, req, http.StatusNotImplemented, "NotImplemented", "only the object operations are implemented")
+			return
+		}
+		h.ServeHTTP(rw, req)
+	})
+	sub.HandleFunc("/{bucket}", func(rw http.ResponseWriter, req *http.Request) {
+		s3Error(rw, req, http.StatusNotImplemented, "NotImplemented", "bucket operations are not implemented")
+	})
+}
+
+// s3Operation names the operation of an object request, "" for the ones
+// left out.
+func s3Operation(req *http.Request) string {
+	q := req.URL.Query()
+	_, uploads := q["uploads"]
+	upload := q.Get("uploadId") != ""
+	switch req.Method {
+	case http.MethodGet:
+		if !upload {
+			return S3GetObject
+		}
+	case http.MethodHead:
+		return S3HeadObject
+	case http.MethodPut:
+		switch {
+		case req.Header.Get("X-Amz-Copy-Source") != "":
+		case upload:
+			return S3UploadPart
+		default:
+			return S3PutObject
+		}
+	case http.MethodPost:
+		switch {
+		case uploads:
+			return S3CreateMultipartUpload
+		case upload:
+			return S3CompleteMultipartUpload
+		}
+	case http.MethodDelete:
+		if upload {
+			return S3AbortMultipartUpload
+		}
+		return S3DeleteObject
+	}
+	return ""
+}
+
+type s3ErrorBody struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string
+	Message   string
+	Key       string `xml:",omitempty"`
+	RequestID string `xml:"RequestId,omitempty"`
+}
+
+func s3ErrorXML(code, message, key string) []byte {
+	body, _ := xml.Marshal(s3ErrorBody{Code: code, Message: message, Key: key})
+	return append([]byte(xml.Header), body...)
+}
+
+// s3Error answers with an S3 error document, headers only for HEAD.
+func s3Error(rw http.ResponseWriter, req *http.Request, status int, code, message string) {
+	rw.Header().Set("Content-Type", "application/xml")
+	rw.WriteHeader(status)
+	if req.Method == http.MethodHead {
+		return
+	}
+	body, _ := xml.Marshal(s3ErrorBody{Code: code, Message: message, Key: mux.Vars(req)["key"], RequestID: rw.Header().Get("X-Amz-Request-Id")})
+	io.WriteString(rw, xml.Header)
+	rw.Write(body)
+}
+
+func s3XML(rw http.ResponseWriter, v any) {
+	body, err := xml.Marshal(v)
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/xml")
+	io.WriteString(rw, xml.Header)
+	rw.Write(body)
+}
+
+func s3Key(req *http.Request) (bucket, key string) {
+	vars := mux.Vars(req)
+	return vars["bucket"], vars["key"]
+}
+
+func (st *s3Store) object(req *http.Request) *s3Object {
+	bucket, key := s3Key(req)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.objects[bucket+"/"+key]
+}
+
+// getObject answers GetObject and HeadObject: the object, a single byte
+// range of it or one of its parts, after the conditional headers.
+//
+//	GET /s3/{bucket}/{key}
+//	GET /s3/{bucket}/{key}?partNumber=2
+func (st *s3Store) getObject(rw http.ResponseWriter, req *http.Request) {
+	obj := st.object(req)
+	if obj == nil {
+		s3Error(rw, req, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	h := rw.Header()
+	switch s3Precondition(req, obj) {
+	case http.StatusPreconditionFailed:
+		s3Error(rw, req, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold")
+		return
+	case http.StatusNotModified:
+		h.Set("ETag", obj.etag)
+		h.Set("Last-Modified", obj.modified.Format(http.TimeFormat))
+		rw.WriteHeader(http.StatusNotModified)
+		return
+	}
+	size := int64(len(obj.data))
+	r, ranged := byteRange{start: 0, end: size - 1}, false
+	if v := req.URL.Query().Get("partNumber"); v != "" {
+		if req.Header.Get("Range") != "" {
+			s3Error(rw, req, http.StatusBadRequest, "InvalidRequest", "Cannot specify both Range header and partNumber query parameter")
+			return
+		}
+		n, err := strconv.Atoi(v)
+		parts := obj.partSizes()
+		if err != nil || n < 1 || n > len(parts) {
+			s3Error(rw, req, http.StatusRequestedRangeNotSatisfiable, "InvalidPartNumber", "The requested partnumber is not satisfiable")
+			return
+		}
+		// the only part of a plain object is still a part, 206 like S3
+		for _, p := range parts[:n-1] {
+			r.start += int64(p)
+		}
+		r.end, ranged = r.start+int64(parts[n-1])-1, size > 0
+		if obj.parts != nil {
+			h.Set("X-Amz-Mp-Parts-Count", strconv.Itoa(len(parts)))
+		}
+	} else if v := req.Header.Get("Range"); v != "" {
+		// like S3, serve the whole object for several ranges or an
+		// invalid header
+		ranges, err := parseRanges(v, size)
+		switch {
+		case errors.Is(err, errUnsatisfiable):
+			s3Error(rw, req, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable")
+			return
+		case err == nil && len(ranges) == 1:
+			r, ranged = ranges[0], true
+		}
+	}
+	for name, values := range obj.header {
+		h[name] = values
+	}
+	h.Set("ETag", obj.etag)
+	h.Set("Last-Modified", obj.modified.Format(http.TimeFormat))
+	h.Set("Accept-Ranges", "bytes")
+	h.Set("Content-Length", strconv.FormatInt(r.length(), 10))
+	status := http.StatusOK
+	if ranged {
+		h.Set("Content-Range", r.contentRange(size))
+		status = http.StatusPartialContent
+	}
+	rw.WriteHeader(status)
+	if req.Method != http.MethodHead {
+		rw.Write(obj.data[r.start : r.end+1])
+	}
+}
+
+// partSizes lists the part sizes of the object, a single part unless it
+// was uploaded in several.
+func (obj *s3Object) partSizes() []int {
+	if obj.parts != nil {
+		return obj.parts
+	}
+	return []int{len(obj.data)}
+}
+
+// s3Precondition checks the conditional headers as S3 does, If-Match and
+// If-None-Match taking precedence over the dates. It returns 412, 304, or
+// 0 to answer.
+func s3Precondition(req *http.Request, obj *s3Object) int {
+	if v := req.Header.Get("If-Match"); v != "" {
+		if !etagMatch(v, obj.etag) {
+			return http.StatusPreconditionFailed
+		}
+	} else if t, err := http.ParseTime(req.Header.Get("If-Unmodified-Since")); err == nil && obj.modified.After(t) {
+		return http.StatusPreconditionFailed
+	}
+	if v := req.Header.Get("If-None-Match"); v != "" {
+		if etagMatch(v, obj.etag) {
+			return http.StatusNotModified
+		}
+	} else if t, err := http.ParseTime(req.Header.Get("If-Modified-Since")); err == nil && !obj.modified.After(t) {
+		return http.StatusNotModified
+	}
+	return 0
+}
+
+// etagMatch reports whether an If-Match or If-None-Match list holds etag.
+func etagMatch(list, etag string) bool {
+	for _, tag := range strings.Split(list, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || strings.Trim(tag, `"`) == strings.Trim(etag, `"`) {
+			return true
+		}
+	}
+	return false
+}
+
+// s3StoredHeaders are kept with an object and sent back with it, besides
+// the x-amz-meta- ones.
+var s3StoredHeaders = []string{"Content-Type", "Content-Encoding", "Content-Disposition", "Content-Language", "Cache-Control", "Expires"}
+
+func s3Header(req *http.Request) http.Header {
+	header := http.Header{}
+	for _, name := range s3StoredHeaders {
+		if v := req.Header.Get(name); v != "" {
+			header.Set(name, v)
+		}
+	}
+	if v := header.Get("Content-Encoding"); v != "" {
+		// the framing of streaming uploads, not of the object
+		encodings := slices.DeleteFunc(strings.Split(v, ","), func(e string) bool { e = strings.TrimSpace(e); return e == "aws-chunked" || e == "" })
+		header.Del("Content-Encoding")
+		if len(encodings) > 0 {
+			header.Set("Content-Encoding", strings.Join(encodings, ","))
+		}
+	}
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "binary/octet-stream")
+	}
+	for name, values := range req.Header {
+		if strings.HasPrefix(name, "X-Amz-Meta-") {
+			header[name] = values
+		}
+	}
+	return header
+}
+
+// readS3Body reads an uploaded object or part, decoding the aws-chunked
+// framing of streaming uploads and checking Content-MD5. It answers the
+// error itself and returns false then.
+func readS3Body(rw http.ResponseWriter, req *http.Request) ([]byte, bool) {
+	var (
+		data []byte
+		err  error
+	)
+	if strings.HasPrefix(req.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") || strings.Contains(req.Header.Get("Content-Encoding"), "aws-chunked") {
+		data, err = readAWSChunked(req.Body, s3MaxObject)
+	} else {
+		data, err = io.ReadAll(io.LimitReader(req.Body, s3MaxObject+1))
+		if err == nil && len(data) > s3MaxObject {
+			err = errS3TooLarge
+		}
+	}
+	switch {
+	case errors.Is(err, errS3TooLarge):
+		s3Error(rw, req, http.StatusBadRequest, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed object size.")
+		return nil, false
+	case err != nil:
+		s3Error(rw, req, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return nil, false
+	}
+	if v := req.Header.Get("Content-MD5"); v != "" {
+		sum := md5.Sum(data)
+		if want, err := base64.StdEncoding.DecodeString(v); err != nil || !bytes.Equal(want, sum[:]) {
+			s3Error(rw, req, http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what we received.")
+			return nil, false
+		}
+	}
+	return data, true
+}
+
+var errS3TooLarge = errors.New("object too large")
+
+// readAWSChunked decodes the chunks of a streaming upload, their
+// signatures and the trailing checksums left unchecked.
+func readAWSChunked(r io.Reader, limit int) ([]byte, error) {
+	br := bufio.NewReader(r)
+	var out bytes.Buffer
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("aws-chunked: %w", err)
+		}
+		size, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		n, err := strconv.ParseInt(size, 16, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("aws-chunked: invalid chunk size %q", size)
+		}
+		if n == 0 {
+			return out.Bytes(), nil
+		}
+		if int64(out.Len())+n > int64(limit) {
+			return nil, errS3TooLarge
+		}
+		if _, err := io.CopyN(&out, br, n); err != nil {
+			return nil, fmt.Errorf("aws-chunked: %w", err)
+		}
+		if _, err := br.ReadString('\n'); err != nil {
+			return nil, fmt.Errorf("aws-chunked: %w", err)
+		}
+	}
+}
+
+// putObject stores an object, honouring If-None-Match: * and If-Match for
+// conditional writes.
+//
+//	PUT /s3/{bucket}/{key}
+func (st *s3Store) putObject(rw http.ResponseWriter, req *http.Request) {
+	data, ok := readS3Body(rw, req)
+	if !ok {
+		return
+	}
+	bucket, key := s3Key(req)
+	obj := newS3Object(data, time.Now(), s3Header(req))
+	st.mu.Lock()
+	old := st.objects[bucket+"/"+key]
+	switch {
+	case req.Header.Get("If-None-Match") == "*" && old != nil,
+		req.Header.Get("If-Match") != "" && old != nil && !etagMatch(req.Header.Get("If-Match"), old.etag):
+		st.mu.Unlock()
+		s3Error(rw, req, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold")
+		return
+	case req.Header.Get("If-Match") != "" && old == nil:
+		st.mu.Unlock()
+		s3Error(rw, req, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	st.objects[bucket+"/"+key] = obj
+	st.mu.Unlock()
+	rw.Header().Set("ETag", obj.etag)
+	rw.WriteHeader(http.StatusOK)
+}
+
+// deleteObject forgets an object, answering 204 whether it existed or not.
+//
+//	DELETE /s3/{bucket}/{key}
+func (st *s3Store) deleteObject(rw http.ResponseWriter, req *http.Request) {
+	bucket, key := s3Key(req)
+	st.mu.Lock()
+	delete(st.objects, bucket+"/"+key)
+	st.mu.Unlock()
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// createUpload starts a multipart upload.
+//
+//	POST /s3/{bucket}/{key}?uploads
+func (st *s3Store) createUpload(rw http.ResponseWriter, req *http.Request) {
+	bucket, key := s3Key(req)
+	id := randomToken()
+	st.mu.Lock()
+	st.expireLocked(time.Now())
+	st.uploads[id] = &s3Upload{bucket: bucket, key: key, header: s3Header(req), parts: map[int]s3Part{}, started: time.Now()}
+	st.mu.Unlock()
+	s3XML(rw, struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Bucket   string
+		Key      string
+		UploadID string `xml:"UploadId"`
+	}{Bucket: bucket, Key: key, UploadID: id})
+}
+
+// upload returns the multipart upload of the request, answering
+// NoSuchUpload when there is none.
+func (st *s3Store) upload(rw http.ResponseWriter, req *http.Request) (string, *s3Upload) {
+	bucket, key := s3Key(req)
+	id := req.URL.Query().Get("uploadId")
+	st.mu.Lock()
+	u, ok := st.uploads[id]
+	st.mu.Unlock()
+	if !ok || u.bucket != bucket || u.key != key {
+		s3Error(rw, req, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist. The upload ID may be invalid, or the upload may have been aborted or completed.")
+		return id, nil
+	}
+	return id, u
+}
+
+// uploadPart stores a part of a multipart upload, replacing one with the
+// same number.
+//
+//	PUT /s3/{bucket}/{key}?partNumber=1&uploadId=...
+func (st *s3Store) uploadPart(rw http.ResponseWriter, req *http.Request) {
+	n, err := strconv.Atoi(req.URL.Query().Get("partNumber"))
+	if err != nil || n < 1 || n > s3MaxParts {
+		s3Error(rw, req, http.StatusBadRequest, "InvalidArgument", fmt.Sprintf("Part number must be an integer between 1 and %d, inclusive", s3MaxParts))
+		return
+	}
+	id, u := st.upload(rw, req)
+	if u == nil {
+		return
+	}
+	data, ok := readS3Body(rw, req)
+	if !ok {
+		return
+	}
+	part := s3Part{data: data, sum: md5.Sum(data)}
+	st.mu.Lock()
+	st.expireLocked(time.Now())
+	switch {
+	case st.uploads[id] != u:
+		st.mu.Unlock()
+		s3Error(rw, req, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist. The upload ID may be invalid, or the upload may have been aborted or completed.")
+		return
+	case !st.evictLocked(len(data)-len(u.parts[n].data), u):
+		st.mu.Unlock()
+		s3Error(rw, req, http.StatusBadRequest, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed object size.")
+		return
+	}
+	st.addPartLocked(u, n, part)
+	st.mu.Unlock()
+	rw.Header().Set("ETag", `"`+hex.EncodeToString(part.sum[:])+`"`)
+	rw.WriteHeader(http.StatusOK)
+}
+
+// completeUpload assembles the listed parts into the object, its ETag the
+// MD5 of their MD5s suffixed with their count, as S3 computes it.
+//
+//	POST /s3/{bucket}/{key}?uploadId=...
+func (st *s3Store) completeUpload(rw http.ResponseWriter, req *http.Request) {
+	id, u := st.upload(rw, req)
+	if u == nil {
+		return
+	}
+	var body struct {
+		Parts []struct {
+			PartNumber int
+			ETag       string
+		} `xml:"Part"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(req.Body, maxEchoBody)).Decode(&body); err != nil || len(body.Parts) == 0 {
+		s3Error(rw, req, http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema.")
+		return
+	}
+	for i := 1; i < len(body.Parts); i++ {
+		if body.Parts[i].PartNumber <= body.Parts[i-1].PartNumber {
+			s3Error(rw, req, http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order. The parts list must be specified in order by part number.")
+			return
+		}
+	}
+	// the parts are only looked up under the lock, assembling up to
+	// s3MaxObject bytes and answering a slow client happen outside it
+	st.mu.Lock()
+	parts, size := make([]s3Part, 0, len(body.Parts)), 0
+	for i, p := range body.Parts {
+		part, ok := u.parts[p.PartNumber]
+		switch {
+		case !ok || strings.Trim(p.ETag, `"`) != hex.EncodeToString(part.sum[:]):
+			st.mu.Unlock()
+			s3Error(rw, req, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("One or more of the specified parts could not be found, part %d.", p.PartNumber))
+			return
+		case i < len(body.Parts)-1 && len(part.data) < s3MinPart:
+			st.mu.Unlock()
+			s3Error(rw, req, http.StatusBadRequest, "EntityTooSmall", fmt.Sprintf("Your proposed upload is smaller than the minimum allowed size, part %d.", p.PartNumber))
+			return
+		}
+		parts = append(parts, part)
+		size += len(part.data)
+	}
+	st.mu.Unlock()
+	if size > s3MaxObject {
+		s3Error(rw, req, http.StatusBadRequest, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed object size.")
+		return
+	}
+
+	data, sums := make([]byte, 0, size), make([]byte, 0, len(parts)*md5.Size)
+	sizes := make([]int, 0, len(parts))
+	for _, part := range parts {
+		data = append(data, part.data...)
+		sums = append(sums, part.sum[:]...)
+		sizes = append(sizes, len(part.data))
+	}
+	sum := md5.Sum(sums)
+	obj := &s3Object{data: data, etag: fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(sum[:]), len(sizes)), modified: time.Now().UTC().Truncate(time.Second), header: u.header, parts: sizes}
+
+	st.mu.Lock()
+	current := st.uploads[id] == u
+	if current {
+		st.objects[u.bucket+"/"+u.key] = obj
+		st.removeLocked(id)
+	}
+	st.mu.Unlock()
+	if !current {
+		// aborted or completed by another request meanwhile
+		s3Error(rw, req, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist. The upload ID may be invalid, or the upload may have been aborted or completed.")
+		return
+	}
+	s3XML(rw, struct {
+		XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+		Location string
+		Bucket   string
+		Key      string
+		ETag     string
+	}{Location: req.URL.Path, Bucket: u.bucket, Key: u.key, ETag: obj.etag})
+}
+
+// abortUpload drops a multipart upload and its parts.
+//
+//	DELETE /s3/{bucket}/{key}?uploadId=...
+func (st *s3Store) abortUpload(rw http.ResponseWriter, req *http.Request) {
+	id, u := st.upload(rw, req)
+	if u == nil {
+		return
+	}
+	st.mu.Lock()
+	if st.uploads[id] == u {
+		st.removeLocked(id)
+	}
+	st.mu.Unlock()
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// addPartLocked stores part n of u, replacing the one it had.
+func (st *s3Store) addPartLocked(u *s3Upload, n int, part s3Part) {
+	delta := len(part.data) - len(u.parts[n].data)
+	u.parts[n] = part
+	u.size += delta
+	st.pending += delta
+}
+
+// removeLocked forgets the upload id and its parts.
+func (st *s3Store) removeLocked(id string) {
+	st.pending -= st.uploads[id].size
+	delete(st.uploads, id)
+}
+
+// expireLocked aborts the uploads started more than s3UploadTTL before now.
+func (st *s3Store) expireLocked(now time.Time) {
+	for id, u := range st.uploads {
+		if now.Sub(u.started) > s3UploadTTL {
+			st.removeLocked(id)
+		}
+	}
+}
+
+// evictLocked aborts the oldest uploads but keep until n more bytes fit
+// under s3MaxPending, false when they don't with keep alone.
+func (st *s3Store) evictLocked(n int, keep *s3Upload) bool {
+	for st.pending+n > s3MaxPending {
+		var oldest string
+		for id, u := range st.uploads {
+			if u != keep && (oldest == "" || u.started.Before(st.uploads[oldest].started)) {
+				oldest = id
+			}
+		}
+		if oldest == "" {
+			return false
+		}
+		st.removeLocked(oldest)
+	}
+	return true
+}
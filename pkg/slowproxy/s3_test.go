@@ -0,0 +1,321 @@
+package slowproxy
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func s3Server(t *testing.T, m S3Mock) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(newTestServer(t, WithS3(m)).Handler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func s3Do(t *testing.T, method, url string, body []byte, header map[string]string) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp, b
+}
+
+// s3Code is the code of an S3 error document, "" for other bodies.
+func s3Code(b []byte) string {
+	var e s3ErrorBody
+	xml.Unmarshal(b, &e)
+	return e.Code
+}
+
+func TestS3Objects(t *testing.T) {
+	ts := s3Server(t, S3Mock{Dir: S3Memory})
+	url := ts.URL + "/s3/bucket/dir/object.txt"
+	data := []byte("0123456789")
+	sum := md5.Sum(data)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+
+	resp, _ := s3Do(t, "PUT", url, data, map[string]string{"Content-Type": "text/plain", "X-Amz-Meta-Owner": "tests"})
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") != etag {
+		t.Fatalf("PutObject: %d, ETag %s, want %s", resp.StatusCode, resp.Header.Get("ETag"), etag)
+	}
+
+	tests := []struct {
+		name         string
+		method       string
+		query        string
+		header       map[string]string
+		wantStatus   int
+		wantBody     string
+		wantCode     string
+		wantRange    string
+		wantMetadata bool
+	}{
+		{name: "get", method: "GET", wantStatus: 200, wantBody: "0123456789", wantMetadata: true},
+		{name: "head", method: "HEAD", wantStatus: 200, wantMetadata: true},
+		{name: "range", method: "GET", header: map[string]string{"Range": "bytes=2-4"}, wantStatus: 206, wantBody: "234", wantRange: "bytes 2-4/10"},
+		{name: "several ranges", method: "GET", header: map[string]string{"Range": "bytes=0-1,4-5"}, wantStatus: 200, wantBody: "0123456789"},
+		{name: "unsatisfiable range", method: "GET", header: map[string]string{"Range": "bytes=20-"}, wantStatus: 416, wantCode: "InvalidRange"},
+		{name: "only part", method: "GET", query: "?partNumber=1", wantStatus: 206, wantBody: "0123456789", wantRange: "bytes 0-9/10"},
+		{name: "missing part", method: "GET", query: "?partNumber=2", wantStatus: 416, wantCode: "InvalidPartNumber"},
+		{name: "part and range", method: "GET", query: "?partNumber=1", header: map[string]string{"Range": "bytes=0-1"}, wantStatus: 400, wantCode: "InvalidRequest"},
+		{name: "if-none-match", method: "GET", header: map[string]string{"If-None-Match": etag}, wantStatus: 304},
+		{name: "if-match", method: "GET", header: map[string]string{"If-Match": `"other"`}, wantStatus: 412, wantCode: "PreconditionFailed"},
+		{name: "if-modified-since", method: "GET", header: map[string]string{"If-Modified-Since": time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}, wantStatus: 304},
+		{name: "if-unmodified-since", method: "GET", header: map[string]string{"If-Unmodified-Since": time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)}, wantStatus: 412, wantCode: "PreconditionFailed"},
+		{name: "create only", method: "PUT", header: map[string]string{"If-None-Match": "*"}, wantStatus: 412, wantCode: "PreconditionFailed"},
+		{name: "bad digest", method: "PUT", header: map[string]string{"Content-MD5": "AAAAAAAAAAAAAAAAAAAAAA=="}, wantStatus: 400, wantCode: "BadDigest"},
+		{name: "copy", method: "PUT", header: map[string]string{"X-Amz-Copy-Source": "/bucket/other"}, wantStatus: 501, wantCode: "NotImplemented"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body []byte
+			if tt.method == "PUT" {
+				body = data
+			}
+			resp, b := s3Do(t, tt.method, url+tt.query, body, tt.header)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", resp.StatusCode, tt.wantStatus, b)
+			}
+			if tt.wantCode != "" {
+				if got := s3Code(b); got != tt.wantCode && tt.method != "HEAD" {
+					t.Errorf("error code = %q, want %q", got, tt.wantCode)
+				}
+				return
+			}
+			if string(b) != tt.wantBody {
+				t.Errorf("body = %q, want %q", b, tt.wantBody)
+			}
+			if got := resp.Header.Get("Content-Range"); got != tt.wantRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.wantRange)
+			}
+			if got := resp.Header.Get("X-Amz-Mp-Parts-Count"); got != "" {
+				t.Errorf("X-Amz-Mp-Parts-Count = %q on a plain object", got)
+			}
+			if tt.wantMetadata {
+				if resp.Header.Get("ETag") != etag || resp.Header.Get("Content-Type") != "text/plain" || resp.Header.Get("X-Amz-Meta-Owner") != "tests" {
+					t.Errorf("headers = %v, want the ETag, type and metadata of the object", resp.Header)
+				}
+			}
+		})
+	}
+
+	if resp, _ := s3Do(t, "DELETE", url, nil, nil); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DeleteObject: %d", resp.StatusCode)
+	}
+	if resp, b := s3Do(t, "GET", url, nil, nil); resp.StatusCode != http.StatusNotFound || s3Code(b) != "NoSuchKey" {
+		t.Errorf("GetObject after delete: %d %s", resp.StatusCode, b)
+	}
+}
+
+// s3CreateUpload starts a multipart upload of url and returns its id.
+func s3CreateUpload(t *testing.T, url string) string {
+	t.Helper()
+	resp, b := s3Do(t, "POST", url+"?uploads", nil, nil)
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(b, &result); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("CreateMultipartUpload: %d %s", resp.StatusCode, b)
+	}
+	return result.UploadID
+}
+
+func s3CompleteBody(etags ...string) []byte {
+	var b strings.Builder
+	b.WriteString("<CompleteMultipartUpload>")
+	for i, etag := range etags {
+		fmt.Fprintf(&b, "<Part><PartNumber>%d</PartNumber><ETag>%s</ETag></Part>", i+1, etag)
+	}
+	b.WriteString("</CompleteMultipartUpload>")
+	return []byte(b.String())
+}
+
+func TestS3Multipart(t *testing.T) {
+	ts := s3Server(t, S3Mock{Dir: S3Memory})
+	url := ts.URL + "/s3/bucket/big"
+	id := s3CreateUpload(t, url)
+	parts := [][]byte{bytes.Repeat([]byte("a"), s3MinPart), []byte("tail")}
+	var etags []string
+	var sums []byte
+	for i, p := range parts {
+		resp, b := s3Do(t, "PUT", fmt.Sprintf("%s?partNumber=%d&uploadId=%s", url, i+1, id), p, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("UploadPart %d: %d %s", i+1, resp.StatusCode, b)
+		}
+		etags = append(etags, resp.Header.Get("ETag"))
+		sum := md5.Sum(p)
+		sums = append(sums, sum[:]...)
+	}
+
+	if resp, b := s3Do(t, "POST", url+"?uploadId="+id, s3CompleteBody(etags[1], etags[0]), nil); s3Code(b) != "InvalidPart" {
+		t.Errorf("completing with the ETags swapped: %d %s", resp.StatusCode, b)
+	}
+	resp, b := s3Do(t, "POST", url+"?uploadId="+id, s3CompleteBody(etags...), nil)
+	var result struct {
+		ETag string
+	}
+	sum := md5.Sum(sums)
+	wantETag := fmt.Sprintf(`"%s-2"`, hex.EncodeToString(sum[:]))
+	if err := xml.Unmarshal(b, &result); err != nil || result.ETag != wantETag {
+		t.Fatalf("CompleteMultipartUpload: %d %s, want ETag %s", resp.StatusCode, b, wantETag)
+	}
+	if resp, b := s3Do(t, "POST", url+"?uploadId="+id, s3CompleteBody(etags...), nil); s3Code(b) != "NoSuchUpload" {
+		t.Errorf("completing twice: %d %s", resp.StatusCode, b)
+	}
+
+	resp, b = s3Do(t, "GET", url+"?partNumber=2", nil, nil)
+	size := s3MinPart + 4
+	if resp.StatusCode != http.StatusPartialContent || string(b) != "tail" {
+		t.Errorf("part 2: %d %q", resp.StatusCode, b)
+	}
+	if got, want := resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-%d/%d", s3MinPart, size-1, size); got != want {
+		t.Errorf("Content-Range = %q, want %q", got, want)
+	}
+	if got := resp.Header.Get("X-Amz-Mp-Parts-Count"); got != "2" {
+		t.Errorf("X-Amz-Mp-Parts-Count = %q, want 2", got)
+	}
+	if got := resp.Header.Get("ETag"); got != wantETag {
+		t.Errorf("ETag = %s, want %s", got, wantETag)
+	}
+}
+
+func TestS3MultipartErrors(t *testing.T) {
+	ts := s3Server(t, S3Mock{Dir: S3Memory})
+	url := ts.URL + "/s3/bucket/key"
+	id := s3CreateUpload(t, url)
+	var etags []string
+	for n := 1; n <= 2; n++ {
+		resp, _ := s3Do(t, "PUT", fmt.Sprintf("%s?partNumber=%d&uploadId=%s", url, n, id), []byte("small"), nil)
+		etags = append(etags, resp.Header.Get("ETag"))
+	}
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		body   []byte
+		want   string
+	}{
+		{name: "part number", method: "PUT", url: url + "?partNumber=10001&uploadId=" + id, want: "InvalidArgument"},
+		{name: "unknown upload", method: "PUT", url: url + "?partNumber=1&uploadId=nope", want: "NoSuchUpload"},
+		{name: "other key", method: "PUT", url: ts.URL + "/s3/bucket/other?partNumber=1&uploadId=" + id, want: "NoSuchUpload"},
+		{name: "small part", method: "POST", url: url + "?uploadId=" + id, body: s3CompleteBody(etags...), want: "EntityTooSmall"},
+		{name: "no parts", method: "POST", url: url + "?uploadId=" + id, body: []byte("<CompleteMultipartUpload/>"), want: "MalformedXML"},
+		{name: "bucket", method: "GET", url: ts.URL + "/s3/bucket", want: "NotImplemented"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if resp, b := s3Do(t, tt.method, tt.url, tt.body, nil); s3Code(b) != tt.want {
+				t.Errorf("%s %s: %d %s, want %s", tt.method, tt.url, resp.StatusCode, b, tt.want)
+			}
+		})
+	}
+
+	if resp, _ := s3Do(t, "DELETE", url+"?uploadId="+id, nil, nil); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("AbortMultipartUpload: %d", resp.StatusCode)
+	}
+	if resp, b := s3Do(t, "PUT", url+"?partNumber=1&uploadId="+id, []byte("x"), nil); s3Code(b) != "NoSuchUpload" {
+		t.Errorf("UploadPart after abort: %d %s", resp.StatusCode, b)
+	}
+}
+
+func TestS3UploadsExpire(t *testing.T) {
+	s := newTestServer(t, WithS3(S3Mock{Dir: S3Memory}))
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+	url := ts.URL + "/s3/bucket/key"
+	stale := s3CreateUpload(t, url)
+	s3Do(t, "PUT", url+"?partNumber=1&uploadId="+stale, []byte("old"), nil)
+	s.s3.mu.Lock()
+	s.s3.uploads[stale].started = time.Now().Add(-s3UploadTTL - time.Minute)
+	s.s3.mu.Unlock()
+
+	fresh := s3CreateUpload(t, url)
+	if resp, b := s3Do(t, "PUT", url+"?partNumber=2&uploadId="+stale, []byte("x"), nil); s3Code(b) != "NoSuchUpload" {
+		t.Errorf("UploadPart to an expired upload: %d %s", resp.StatusCode, b)
+	}
+	if resp, _ := s3Do(t, "PUT", url+"?partNumber=1&uploadId="+fresh, []byte("x"), nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("UploadPart to a fresh upload: %d", resp.StatusCode)
+	}
+	s.s3.mu.Lock()
+	defer s.s3.mu.Unlock()
+	if s.s3.pending != 1 {
+		t.Errorf("pending = %d bytes, want the fresh part only", s.s3.pending)
+	}
+}
+
+func TestS3StoreBounds(t *testing.T) {
+	m := S3Mock{Dir: S3Memory}
+	st, err := m.compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	add := func(id string, started time.Time, size int) *s3Upload {
+		u := &s3Upload{parts: map[int]s3Part{}, started: started}
+		st.uploads[id] = u
+		st.addPartLocked(u, 1, s3Part{data: make([]byte, size)})
+		return u
+	}
+	add("expired", now.Add(-2*s3UploadTTL), 10)
+	add("oldest", now.Add(-time.Minute), 10)
+	add("newest", now, 10)
+	st.expireLocked(now)
+	if _, ok := st.uploads["expired"]; ok || st.pending != 20 {
+		t.Fatalf("after expiry: %d uploads holding %d bytes, want the expired one gone", len(st.uploads), st.pending)
+	}
+
+	keep := st.uploads["newest"]
+	if !st.evictLocked(s3MaxPending-15, keep) {
+		t.Fatal("evictLocked() = false with room left after evicting")
+	}
+	if _, ok := st.uploads["oldest"]; ok || st.uploads["newest"] == nil {
+		t.Errorf("evicted %v, want the oldest upload only", st.uploads)
+	}
+	if st.pending != 10 {
+		t.Errorf("pending = %d, want 10", st.pending)
+	}
+	if st.evictLocked(s3MaxPending, keep) {
+		t.Error("evictLocked() = true for more than the cap with keep alone")
+	}
+}
+
+func TestS3Faults(t *testing.T) {
+	ts := s3Server(t, S3Mock{Dir: S3Memory, Operations: map[string]Fault{
+		S3PutObject: {Delay: Fixed(20 * time.Millisecond)},
+		S3GetObject: {Status: http.StatusServiceUnavailable},
+	}})
+	url := ts.URL + "/s3/bucket/key"
+	start := time.Now()
+	if resp, _ := s3Do(t, "PUT", url, []byte("x"), nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("PutObject: %d", resp.StatusCode)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("PutObject answered after %s, want the 20ms delay", elapsed)
+	}
+	resp, b := s3Do(t, "GET", url, nil, nil)
+	if resp.StatusCode != http.StatusServiceUnavailable || s3Code(b) != "SlowDown" {
+		t.Errorf("GetObject: %d %s, want a 503 SlowDown", resp.StatusCode, b)
+	}
+	if resp, _ := s3Do(t, "HEAD", url, nil, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("HeadObject: %d, want it spared by the GetObject fault", resp.StatusCode)
+	}
+}
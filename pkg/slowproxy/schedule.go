@@ -0,0 +1,367 @@
+package slowproxy
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxCronWindow bounds the window of cron schedules, so a window is always
+// found within the day before now.
+const maxCronWindow = 24 * time.Hour
+
+// Schedule activates a rule on a recurring window, written either as
+// "every 10m for 2m", with windows aligned to the Unix epoch, or as a five
+// field cron expression in UTC followed by the window length, like
+// "*/15 9-17 * * 1-5 for 3m".
+type Schedule struct {
+	spec   string
+	every  time.Duration
+	cron   *cronExpr
+	window time.Duration
+}
+
+// ParseSchedule reads a schedule in one of the forms described on Schedule.
+func ParseSchedule(spec string) (*Schedule, error) {
+	expr, window, ok := strings.Cut(strings.TrimSpace(spec), " for ")
+	if !ok {
+		return nil, fmt.Errorf("invalid schedule %q: missing \"for <duration>\"", spec)
+	}
+	s := &Schedule{spec: strings.Join(strings.Fields(spec), " ")}
+	var err error
+	if s.window, err = time.ParseDuration(strings.TrimSpace(window)); err != nil || s.window <= 0 {
+		return nil, fmt.Errorf("invalid schedule %q: bad window %q", spec, window)
+	}
+	if v, ok := strings.CutPrefix(strings.TrimSpace(expr), "every "); ok {
+		if s.every, err = time.ParseDuration(strings.TrimSpace(v)); err != nil || s.every <= 0 {
+			return nil, fmt.Errorf("invalid schedule %q: bad interval %q", spec, v)
+		}
+		if s.window > s.every {
+			return nil, fmt.Errorf("invalid schedule %q: window longer than interval", spec)
+		}
+		return s, nil
+	}
+	if s.window > maxCronWindow {
+		return nil, fmt.Errorf("invalid schedule %q: cron window over %s", spec, maxCronWindow)
+	}
+	if s.cron, err = parseCron(expr); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+	}
+	return s, nil
+}
+
+func (s *Schedule) String() string {
+	return s.spec
+}
+
+func (s *Schedule) MarshalText() ([]byte, error) {
+	return []byte(s.spec), nil
+}
+
+func (s *Schedule) UnmarshalText(b []byte) error {
+	parsed, err := ParseSchedule(string(b))
+	if err != nil {
+		return err
+	}
+	*s = *parsed
+	return nil
+}
+
+// Active reports whether now falls in a window of the schedule.
+func (s *Schedule) Active(now time.Time) bool {
+	_, ok := s.current(now)
+	return ok
+}
+
+// current returns the start of the window now falls in.
+func (s *Schedule) current(now time.Time) (time.Time, bool) {
+	if s.cron == nil {
+		start := now.Add(-time.Duration(now.UnixNano() % int64(s.every)))
+		return start, now.Sub(start) < s.window
+	}
+	t, ok := s.cron.prev(now.UTC().Truncate(time.Minute), now.Add(-s.window))
+	if !ok || now.Sub(t) >= s.window {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// Next returns the start of the first window after now, false when a cron
+// expression does not fire within a year.
+func (s *Schedule) Next(now time.Time) (time.Time, bool) {
+	if s.cron == nil {
+		return now.Add(s.every - time.Duration(now.UnixNano()%int64(s.every))), true
+	}
+	t := now.UTC().Truncate(time.Minute).Add(time.Minute)
+	return s.cron.next(t, t.AddDate(1, 0, 0))
+}
+
+// cronExpr holds the allowed values of each cron field as bit sets.
+type cronExpr struct {
+	minute, hour, dom, month, dow uint64
+	// anyDom and anyDow are set when that day field is *, in which case
+	// only the other one restricts the day, as in cron.
+	anyDom, anyDow bool
+}
+
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+func parseCron(expr string) (*cronExpr, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("cron expression %q needs %d fields", expr, len(cronFields))
+	}
+	var sets [5]uint64
+	for i, f := range fields {
+		set, err := parseCronField(f, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", cronFields[i].name, err)
+		}
+		sets[i] = set
+	}
+	// Sunday is both 0 and 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	return &cronExpr{
+		minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		anyDom: fields[2] == "*", anyDow: fields[4] == "*",
+	}, nil
+}
+
+// parseCronField reads a comma separated list of *, n, a-b, optionally
+// followed by a /step.
+func parseCronField(field string, lo, hi int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step <= 0 {
+				return 0, fmt.Errorf("bad step %q", part)
+			}
+		}
+		from, to := lo, hi
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if from, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("bad value %q", part)
+			}
+			to = from
+			if isRange {
+				if to, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("bad value %q", part)
+				}
+			} else if hasStep {
+				to = hi
+			}
+		}
+		if from < lo || to > hi || from > to {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, lo, hi)
+		}
+		for v := from; v <= to; v += step {
+			set |= 1 << v
+		}
+	}
+	if bits.OnesCount64(set) == 0 {
+		return 0, fmt.Errorf("empty field %q", field)
+	}
+	return set, nil
+}
+
+func (c *cronExpr) matches(t time.Time) bool {
+	if c.minute&(1<<t.Minute()) == 0 || c.hour&(1<<t.Hour()) == 0 || c.month&(1<<int(t.Month())) == 0 {
+		return false
+	}
+	return c.day(t)
+}
+
+// day reports whether the day of t matches, by day of month or of week.
+func (c *cronExpr) day(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<int(t.Weekday())) != 0
+	switch {
+	case c.anyDom && c.anyDow:
+		return true
+	case c.anyDom:
+		return dow
+	case c.anyDow:
+		return dom
+	}
+	return dom || dow
+}
+
+// next returns the first minute from t on that matches, before end. It skips
+// a month, day or hour at a time while that field does not match, so a year
+// takes a few hundred steps rather than half a million minutes.
+func (c *cronExpr) next(t, end time.Time) (time.Time, bool) {
+	for t.Before(end) {
+		y, mon, d := t.Date()
+		if c.month&(1<<int(mon)) == 0 {
+			t = time.Date(y, mon+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !c.day(t) {
+			t = time.Date(y, mon, d+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		h, ok := nextBit(c.hour, t.Hour())
+		if !ok {
+			t = time.Date(y, mon, d+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if h != t.Hour() {
+			t = time.Date(y, mon, d, h, 0, 0, 0, time.UTC)
+		}
+		m, ok := nextBit(c.minute, t.Minute())
+		if !ok {
+			t = time.Date(y, mon, d, h+1, 0, 0, 0, time.UTC)
+			continue
+		}
+		t = time.Date(y, mon, d, h, m, 0, 0, time.UTC)
+		if !t.Before(end) {
+			break
+		}
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// prev returns the last minute up to t that matches, not before start,
+// skipping backwards like next.
+func (c *cronExpr) prev(t, start time.Time) (time.Time, bool) {
+	for !t.Before(start) {
+		y, mon, d := t.Date()
+		if c.month&(1<<int(mon)) == 0 {
+			t = time.Date(y, mon, 1, 0, 0, 0, 0, time.UTC).Add(-time.Minute)
+			continue
+		}
+		if !c.day(t) {
+			t = time.Date(y, mon, d, 0, 0, 0, 0, time.UTC).Add(-time.Minute)
+			continue
+		}
+		h, ok := prevBit(c.hour, t.Hour())
+		if !ok {
+			t = time.Date(y, mon, d, 0, 0, 0, 0, time.UTC).Add(-time.Minute)
+			continue
+		}
+		if h != t.Hour() {
+			t = time.Date(y, mon, d, h, 59, 0, 0, time.UTC)
+		}
+		m, ok := prevBit(c.minute, t.Minute())
+		if !ok {
+			t = time.Date(y, mon, d, h, 0, 0, 0, time.UTC).Add(-time.Minute)
+			continue
+		}
+		t = time.Date(y, mon, d, h, m, 0, 0, time.UTC)
+		if t.Before(start) {
+			break
+		}
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// nextBit returns the lowest value of set from v on.
+func nextBit(set uint64, v int) (int, bool) {
+	rest := set >> v << v
+	if rest == 0 {
+		return 0, false
+	}
+	return bits.TrailingZeros64(rest), true
+}
+
+// prevBit returns the highest value of set up to v.
+func prevBit(set uint64, v int) (int, bool) {
+	rest := set & (1<<(v+1) - 1)
+	if rest == 0 {
+		return 0, false
+	}
+	return 63 - bits.LeadingZeros64(rest), true
+}
+
+// ScheduleState is reported by /admin/schedules for every scheduled rule.
+type ScheduleState struct {
+	Rule     string     `json:"rule"`
+	Schedule string     `json:"schedule"`
+	Active   bool       `json:"active"`
+	Until    *time.Time `json:"until,omitempty"`
+	Next     *time.Time `json:"next,omitempty"`
+}
+
+func scheduleStates(rules []Rule, now time.Time) []ScheduleState {
+	states := []ScheduleState{}
+	for _, r := range rules {
+		if r.Schedule == nil {
+			continue
+		}
+		st := ScheduleState{Rule: r.Name, Schedule: r.Schedule.String()}
+		if start, ok := r.Schedule.current(now); ok {
+			until := start.Add(r.Schedule.window)
+			st.Active, st.Until = true, &until
+		}
+		if next, ok := r.Schedule.Next(now); ok {
+			st.Next = &next
+		}
+		states = append(states, st)
+	}
+	return states
+}
+
+func (s *Server) getSchedules(rw http.ResponseWriter, req *http.Request) {
+	writeJSON(rw, http.StatusOK, scheduleStates(s.rules.list(), s.clock.now()))
+}
+
+// watchSchedules logs scheduled rules as they switch on and off.
+func (s *Server) watchSchedules(done <-chan struct{}) {
+	ticker := time.NewTicker(max(s.clock.real(time.Second), 10*time.Millisecond))
+	defer ticker.Stop()
+	active := map[string]bool{}
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		ticker.Reset(max(s.clock.real(time.Second), 10*time.Millisecond))
+		now := s.clock.now()
+		seen := map[string]bool{}
+		for _, r := range s.rules.list() {
+			if r.Schedule == nil {
+				continue
+			}
+			key := r.Name + "\x00" + r.Schedule.String()
+			seen[key] = true
+			on := r.Schedule.Active(now)
+			if on == active[key] {
+				continue
+			}
+			active[key] = on
+			if on {
+				s.logger.Info("scheduled rule active", zap.String("rule", r.Name), zap.Stringer("schedule", r.Schedule))
+			} else {
+				s.logger.Info("scheduled rule inactive", zap.String("rule", r.Name), zap.Stringer("schedule", r.Schedule))
+			}
+		}
+		for key := range active {
+			if !seen[key] {
+				delete(active, key)
+			}
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package slowproxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustSchedule(t *testing.T, spec string) *Schedule {
+	t.Helper()
+	s, err := ParseSchedule(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func mustTime(t *testing.T, v string) time.Time {
+	t.Helper()
+	tm, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tm
+}
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    string
+		wantErr string
+	}{
+		{spec: "every 10m for 2m", want: "every 10m for 2m"},
+		{spec: "  */15   9-17 * * 1-5  for 3m ", want: "*/15 9-17 * * 1-5 for 3m"},
+		{spec: "0 0 * * 7 for 24h", want: "0 0 * * 7 for 24h"},
+		{spec: "0,30 1-5/2 1,15 */3 0-6 for 1m", want: "0,30 1-5/2 1,15 */3 0-6 for 1m"},
+		{spec: "every 10m", wantErr: "missing"},
+		{spec: "every 10m for soon", wantErr: "bad window"},
+		{spec: "every 10m for -1m", wantErr: "bad window"},
+		{spec: "every 0s for 1m", wantErr: "bad interval"},
+		{spec: "every 1m for 2m", wantErr: "window longer than interval"},
+		{spec: "0 0 * * * for 25h", wantErr: "cron window over 24h"},
+		{spec: "0 0 * * for 1m", wantErr: "needs 5 fields"},
+		{spec: "60 0 * * * for 1m", wantErr: "minute"},
+		{spec: "0 24 * * * for 1m", wantErr: "hour"},
+		{spec: "0 0 0 * * for 1m", wantErr: "day of month"},
+		{spec: "0 0 * 13 * for 1m", wantErr: "month"},
+		{spec: "0 0 * * 8 for 1m", wantErr: "day of week"},
+		{spec: "0 5-1 * * * for 1m", wantErr: "out of range"},
+		{spec: "*/0 * * * * for 1m", wantErr: "bad step"},
+		{spec: "x * * * * for 1m", wantErr: "bad value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := ParseSchedule(tt.spec)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseSchedule() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.String() != tt.want {
+				t.Errorf("String() = %q, want %q", s, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleActive(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+		now  string
+		want bool
+	}{
+		{name: "every, in the window", spec: "every 10m for 2m", now: "2026-01-05T00:11:59Z", want: true},
+		{name: "every, after the window", spec: "every 10m for 2m", now: "2026-01-05T00:12:00Z"},
+		{name: "cron, at the start", spec: "*/15 9-17 * * 1-5 for 3m", now: "2026-01-05T09:15:00Z", want: true},
+		{name: "cron, outside the hours", spec: "*/15 9-17 * * 1-5 for 3m", now: "2026-01-05T18:01:00Z"},
+		{name: "cron, weekend", spec: "*/15 9-17 * * 1-5 for 3m", now: "2026-01-10T09:01:00Z"},
+		{name: "sunday as 7", spec: "0 9 * * 7 for 1h", now: "2026-01-11T09:30:00Z", want: true},
+		{name: "sunday as 7, on saturday", spec: "0 9 * * 7 for 1h", now: "2026-01-10T09:30:00Z"},
+		{name: "sunday as 0", spec: "0 9 * * 0 for 1h", now: "2026-01-11T09:30:00Z", want: true},
+		{name: "across midnight, before", spec: "30 23 * * 5 for 1h", now: "2026-03-06T23:45:00Z", want: true},
+		{name: "across midnight, after", spec: "30 23 * * 5 for 1h", now: "2026-03-07T00:15:00Z", want: true},
+		{name: "across midnight, ended", spec: "30 23 * * 5 for 1h", now: "2026-03-07T00:30:00Z"},
+		{name: "across a month", spec: "0 22 31 * * for 4h", now: "2026-02-01T01:00:00Z", want: true},
+		{name: "across a year", spec: "0 23 31 12 * for 2h", now: "2027-01-01T00:59:00Z", want: true},
+		{name: "dom or dow, dom", spec: "0 12 10 * 5 for 1h", now: "2026-03-10T12:30:00Z", want: true},
+		{name: "dom or dow, dow", spec: "0 12 10 * 5 for 1h", now: "2026-03-06T12:30:00Z", want: true},
+		{name: "dom or dow, neither", spec: "0 12 10 * 5 for 1h", now: "2026-03-09T12:30:00Z"},
+		{name: "dom only", spec: "0 12 10 * * for 1h", now: "2026-03-06T12:30:00Z"},
+		{name: "in another zone", spec: "0 9 * * * for 1h", now: "2026-01-05T10:30:00+01:00", want: true},
+		{name: "never fires", spec: "0 0 30 2 * for 1m", now: "2026-02-28T00:00:30Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mustSchedule(t, tt.spec).Active(mustTime(t, tt.now)); got != tt.want {
+				t.Errorf("Active(%s) = %v, want %v", tt.now, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+		now  string
+		want string // empty when it never fires
+	}{
+		{name: "every", spec: "every 10m for 2m", now: "2026-01-05T00:03:00Z", want: "2026-01-05T00:10:00Z"},
+		{name: "every, at a start", spec: "every 10m for 2m", now: "2026-01-05T00:10:00Z", want: "2026-01-05T00:20:00Z"},
+		{name: "same hour", spec: "*/15 * * * * for 1m", now: "2026-01-05T10:16:30Z", want: "2026-01-05T10:30:00Z"},
+		{name: "strictly after", spec: "0 9 * * * for 1m", now: "2026-01-05T09:00:00Z", want: "2026-01-06T09:00:00Z"},
+		{name: "next hour", spec: "5 * * * * for 1m", now: "2026-01-05T10:06:00Z", want: "2026-01-05T11:05:00Z"},
+		{name: "next day", spec: "30 2 * * * for 1m", now: "2026-01-05T03:00:00Z", want: "2026-01-06T02:30:00Z"},
+		{name: "next month", spec: "0 0 1 * * for 1m", now: "2026-01-31T15:00:00Z", want: "2026-02-01T00:00:00Z"},
+		{name: "skips short months", spec: "0 0 31 * * for 1m", now: "2026-04-01T00:00:00Z", want: "2026-05-31T00:00:00Z"},
+		{name: "next year", spec: "0 0 1 1 * for 1m", now: "2026-12-31T23:59:30Z", want: "2027-01-01T00:00:00Z"},
+		{name: "sunday as 7", spec: "0 9 * * 7 for 1h", now: "2026-01-05T00:00:00Z", want: "2026-01-11T09:00:00Z"},
+		{name: "dom before dow", spec: "0 12 10 * 5 for 1h", now: "2026-03-07T00:00:00Z", want: "2026-03-10T12:00:00Z"},
+		{name: "dow before dom", spec: "0 12 10 * 5 for 1h", now: "2026-03-01T00:00:00Z", want: "2026-03-06T12:00:00Z"},
+		{name: "leap day", spec: "0 0 29 2 * for 1m", now: "2027-06-01T00:00:00Z", want: "2028-02-29T00:00:00Z"},
+		{name: "leap day over a year away", spec: "0 0 29 2 * for 1m", now: "2026-03-01T00:00:00Z"},
+		{name: "never fires", spec: "0 0 30 2 * for 1m", now: "2026-01-01T00:00:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := mustSchedule(t, tt.spec).Next(mustTime(t, tt.now))
+			if tt.want == "" {
+				if ok {
+					t.Errorf("Next(%s) = %s, want none", tt.now, got)
+				}
+				return
+			}
+			if !ok || !got.Equal(mustTime(t, tt.want)) {
+				t.Errorf("Next(%s) = %s, %v, want %s", tt.now, got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdminSchedules(t *testing.T) {
+	s := newTestServer(t, WithRules(
+		Rule{Name: "friday night", Schedule: mustSchedule(t, "30 23 * * 5 for 1h"), Fault: Fault{Status: 503}},
+		Rule{Name: "never", Schedule: mustSchedule(t, "0 0 30 2 * for 1m"), Fault: Fault{Status: 503}},
+		Rule{Name: "always", Fault: Fault{Status: 503}},
+	))
+	if rec := serve(s, httptest.NewRequest("PUT", "/admin/clock?at=2026-03-07T00:15:00Z", nil)); rec.Code != http.StatusOK {
+		t.Fatalf("PUT /admin/clock = %d", rec.Code)
+	}
+	rec := serve(s, httptest.NewRequest("GET", "/admin/schedules", nil))
+	var got []ScheduleState
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("states = %+v, want the two scheduled rules", got)
+	}
+	friday, never := got[0], got[1]
+	if !friday.Active || friday.Until == nil || !friday.Until.Equal(mustTime(t, "2026-03-07T00:30:00Z")) || friday.Next == nil || !friday.Next.Equal(mustTime(t, "2026-03-13T23:30:00Z")) {
+		t.Errorf("friday night = %+v", friday)
+	}
+	if never.Active || never.Until != nil || never.Next != nil || never.Schedule != "0 0 30 2 * for 1m" {
+		t.Errorf("never = %+v", never)
+	}
+}
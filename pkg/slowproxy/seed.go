@@ -0,0 +1,97 @@
+package slowproxy
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"math/rand"
+	"sync"
+)
+
+// seededRand is the source of every probabilistic decision of a server:
+// jitter, percentages, load shedding, Markov moves and the random helpers
+// of templates and scripts. Every Server has its own, so servers running
+// side by side keep their runs apart.
+type seededRand struct {
+	mu   sync.Mutex
+	r    *rand.Rand
+	seed int64
+}
+
+// newSeededRand starts the random decisions from seed, picking a random
+// seed when it is 0. Runs with the same seed and the same requests in the
+// same order take the same decisions.
+func newSeededRand(seed int64) *seededRand {
+	r := &seededRand{}
+	r.reseed(seed)
+	return r
+}
+
+func (r *seededRand) reseed(seed int64) int64 {
+	for seed == 0 {
+		var b [8]byte
+		_, _ = crand.Read(b[:])
+		seed = int64(binary.LittleEndian.Uint64(b[:]) >> 1)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.r, r.seed = rand.New(rand.NewSource(seed)), seed
+	return seed
+}
+
+func (r *seededRand) Seed() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.seed
+}
+
+// defaultRand serves the library outside a Server: Middleware, Transport
+// and DelaySpec.Duration.
+var defaultRand = newSeededRand(0)
+
+// SetSeed restarts the random decisions of Middleware, Transport and
+// DelaySpec.Duration from seed, picking a random seed when it is 0, and
+// returns the seed in use. Every Server has its own, see Options.Seed.
+func SetSeed(seed int64) int64 {
+	return defaultRand.reseed(seed)
+}
+
+// Seed returns the seed of the random decisions made outside a Server.
+func Seed() int64 {
+	return defaultRand.Seed()
+}
+
+// Seed returns the seed of the random decisions of s, Options.Seed or the
+// one picked without it.
+func (s *Server) Seed() int64 {
+	return s.rand.Seed()
+}
+
+func (r *seededRand) Float64() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Float64()
+}
+
+func (r *seededRand) Intn(n int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Intn(n)
+}
+
+func (r *seededRand) Int63n(n int64) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Int63n(n)
+}
+
+func (r *seededRand) Int63() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Int63()
+}
+
+func (r *seededRand) Read(b []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, _ = r.r.Read(b)
+}
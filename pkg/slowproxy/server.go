@@ -0,0 +1,760 @@
+package slowproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Options configures a Server. The zero value serves every route over plain
+// HTTP on localhost:8080 with every fault disabled.
+type Options struct {
+	Addr                 string
+	DefaultDelay         time.Duration
+	MaxDelay             time.Duration
+	DefaultFormat        Format
+	Routes               []Route
+	Compat               string
+	GRPCAddr             string
+	HTTP10               bool
+	ClosePercent         float64
+	MaxKeepAliveRequests int
+	IdleClose            time.Duration
+	IdleCloseSilent      bool
+	TCPFaults            TCPFaults
+	ToxiproxyAddr        string
+	TLSCert              string
+	TLSKey               string
+	TLSSelfSigned        bool
+	TLSRotateCA          time.Duration
+	TLSNoTickets         bool
+	TLSRejectResumption  bool
+	TLSTicketRotate      time.Duration
+	MaxInFlight          int
+	MaxQueue             int
+	QueueTimeout         time.Duration
+	PerIPConns           int
+	PerIPRequests        int
+	PerIPReject          string
+	ClientBandwidth      float64
+	ShedCapacity         int
+	ShedThreshold        float64
+	BearerTokens         []string
+	DigestNonceTTL       time.Duration
+	APIKeyRotate         time.Duration
+	APIKeyOverlap        time.Duration
+	APIKeyPaths          []string
+	OAuthTokenTTL        time.Duration
+	OAuthRefreshTTL      time.Duration
+	OAuthDelay           time.Duration
+	OAuthFailPercent     float64
+	SessionTTL           time.Duration
+	SessionUnauthDelay   time.Duration
+	SessionPaths         []string
+	JWTIssuer            string
+	JWTTTL               time.Duration
+	JWTRotate            time.Duration
+	HeadMismatch         int
+	ClockSkew            time.Duration
+	ClockSpeed           float64
+	LogConns             bool
+	Flush                FlushPolicy
+	AcceptRate           float64
+	MaxConns             int
+	AcceptOverflow       string
+	StallTimeout         time.Duration
+	StallAction          string
+	ReportPath           string
+	HiccupEvery          time.Duration
+	HiccupLength         time.Duration
+	HiccupMode           string
+	FixturesDir          string
+	LuaScript            string
+	JSScript             string
+	WASMPlugins          []string
+	Upstream             string
+	Cassette             string
+	HAR                  string
+	OpenAPI              string
+	S3                   string
+	VCRMode              string
+	VCRTiming            float64
+	Seed                 int64
+	ShutdownTimeout      time.Duration
+	CaptureLimit         int
+	CaptureTTL           time.Duration
+	CaptureBytes         int
+	ReportPhases         int
+}
+
+// Server holds the slow and failing behaviours shared by every listener.
+// Handlers stop pausing once the server is closed.
+type Server struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	logger   *zap.Logger
+	opts     Options
+	health   *healthService
+	certs    *certStore
+	sessions *tlsSessions
+	rules    *ruleSet
+	control  *Controller
+	captures *captureBuffer
+	stats    stats
+	buckets  *bucketSet
+	inFlight *inFlightLimit
+	ipLimits *ipLimits
+	shaper   *bandwidthShaper
+	oauth    *oauthTokens
+	jwt      *jwtKeys
+	cookies  *sessionStore
+	lua      *luaHook
+	js       *jsHook
+	plugins  []*wasmPlugin
+	upstream http.Handler
+	cassette *cassette
+
+	originHits originHits
+	clock      virtualClock
+	markov     markovChain
+	groups     *groupSet
+	conns      connTracker
+	hiccups    hiccups
+	connSlots  chan struct{}
+	report     phaseRecorder
+	profiles   []*profile
+	fixtures   map[string]*fixture
+	probes     *probeSet
+	toxiproxy  *toxiproxy
+	openapi    *openAPISpec
+	webhooks   *webhookSet
+	s3         *s3Store
+
+	digestKey []byte
+	started   time.Time
+	rand      *seededRand
+
+	initialRules         []Rule
+	initialMarkov        *MarkovModel
+	initialGroups        []FailureGroup
+	initialProfiles      []LatencyProfile
+	initialProbes        []Probe
+	initialOpenAPI       *OpenAPIMock
+	initialS3            *S3Mock
+	onReady              []func(ReadyInfo)
+	listenerOverride     net.Listener
+	grpcListenerOverride net.Listener
+	inherited            map[string]net.Listener
+	drain                chan time.Duration
+
+	mu     sync.Mutex
+	ready  ReadyInfo
+	bound  map[string]net.Listener
+	done   chan struct{}
+	runErr error
+}
+
+func New(options ...Option) (*Server, error) {
+	s := &Server{logger: zap.NewNop(), drain: make(chan time.Duration, 1)}
+	for _, opt := range options {
+		opt(s)
+	}
+	opts := &s.opts
+	if opts.Addr == "" {
+		opts.Addr = "localhost:8080"
+	}
+	if opts.DefaultDelay == 0 {
+		opts.DefaultDelay = 10 * time.Second
+	}
+	if opts.MaxDelay == 0 {
+		opts.MaxDelay = time.Hour
+	}
+	if opts.ShutdownTimeout == 0 {
+		opts.ShutdownTimeout = time.Minute
+	}
+	if opts.CaptureLimit == 0 {
+		opts.CaptureLimit = 1000
+	}
+	if opts.ReportPhases <= 0 {
+		opts.ReportPhases = 10000
+	}
+	for _, r := range s.initialRules {
+		if err := r.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	s.rand = newSeededRand(opts.Seed)
+	s.markov.rand = s.rand
+	if opts.ClockSpeed < 0 {
+		return nil, fmt.Errorf("clock speed %v, want a positive factor", opts.ClockSpeed)
+	}
+	s.clock.setSpeedLocked(opts.ClockSpeed)
+	s.rules = newRuleSet(s.initialRules)
+	if err := s.markov.set(s.initialMarkov, s.clock.now()); err != nil {
+		return nil, err
+	}
+	groups, err := newGroupSet(s.initialGroups)
+	if err != nil {
+		return nil, err
+	}
+	s.groups = groups
+	if s.profiles, err = compileProfiles(s.initialProfiles); err != nil {
+		return nil, err
+	}
+	s.control = newController(s)
+	s.captures = newCaptureBuffer(max(opts.CaptureLimit, 0), opts.CaptureTTL, opts.CaptureBytes, &s.stats.capturesEvicted)
+	s.buckets = newBucketSet()
+	s.ipLimits = newIPLimits()
+	s.oauth = newOAuthTokens()
+	s.digestKey = []byte(randomToken())
+	s.started = time.Now()
+	s.report.start = s.clock.now()
+	if s.probes, err = newProbeSet(s.initialProbes, s.report.start); err != nil {
+		return nil, err
+	}
+	if m := s.initialOpenAPI; m != nil || opts.OpenAPI != "" {
+		if m == nil {
+			m = &OpenAPIMock{}
+		}
+		if opts.OpenAPI != "" {
+			m.Spec = opts.OpenAPI
+		}
+		if s.openapi, err = m.compile(); err != nil {
+			return nil, err
+		}
+	}
+	if m := s.initialS3; m != nil || opts.S3 != "" {
+		if m == nil {
+			m = &S3Mock{}
+		}
+		if opts.S3 != "" {
+			m.Dir = opts.S3
+		}
+		if s.s3, err = m.compile(); err != nil {
+			return nil, err
+		}
+	}
+	s.report.max, s.report.evicted = opts.ReportPhases, &s.stats.phasesEvicted
+	if opts.APIKeyRotate == 0 {
+		opts.APIKeyRotate = time.Hour
+	}
+	if opts.APIKeyOverlap == 0 {
+		opts.APIKeyOverlap = time.Minute
+	}
+	if opts.DigestNonceTTL == 0 {
+		opts.DigestNonceTTL = 5 * time.Minute
+	}
+	if opts.OAuthTokenTTL == 0 {
+		opts.OAuthTokenTTL = time.Hour
+	}
+	if opts.OAuthRefreshTTL == 0 {
+		opts.OAuthRefreshTTL = 24 * time.Hour
+	}
+	s.jwt = &jwtKeys{}
+	if opts.LuaScript != "" {
+		hook, err := newLuaHook(opts.LuaScript, s.rand)
+		if err != nil {
+			return nil, err
+		}
+		s.lua = hook
+	}
+	if opts.FixturesDir != "" {
+		if s.fixtures, err = loadFixtures(opts.FixturesDir); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.setupUpstream(); err != nil {
+		return nil, err
+	}
+	if opts.JSScript != "" {
+		hook, err := newJSHook(opts.JSScript, s.rand)
+		if err != nil {
+			return nil, err
+		}
+		s.js = hook
+	}
+	s.cookies = newSessionStore()
+	if opts.SessionTTL == 0 {
+		opts.SessionTTL = 30 * time.Minute
+	}
+	if opts.JWTIssuer == "" {
+		opts.JWTIssuer = "slow-proxy"
+	}
+	if opts.JWTTTL == 0 {
+		opts.JWTTTL = time.Hour
+	}
+	if opts.ShedThreshold == 0 {
+		opts.ShedThreshold = 0.8
+	}
+	if opts.ShedThreshold < 0 || opts.ShedThreshold >= 1 {
+		return nil, fmt.Errorf("shed threshold %v out of range 0-1", opts.ShedThreshold)
+	}
+	if opts.ClientBandwidth > 0 {
+		s.shaper = newBandwidthShaper(opts.ClientBandwidth)
+	}
+	switch opts.PerIPReject {
+	case "":
+		opts.PerIPReject = RejectRefuse
+	case RejectRefuse, Reject429:
+	default:
+		return nil, fmt.Errorf("unknown per-ip reject mode %q", opts.PerIPReject)
+	}
+	if opts.StallAction == "" {
+		opts.StallAction = StallLog
+	}
+	if err := validStallAction(opts.StallAction); err != nil {
+		return nil, err
+	}
+	if opts.HiccupMode == "" {
+		opts.HiccupMode = HiccupWorld
+	}
+	if err := validHiccupMode(opts.HiccupMode); err != nil {
+		return nil, err
+	}
+	if opts.HiccupEvery > 0 && opts.HiccupLength == 0 {
+		opts.HiccupLength = 100 * time.Millisecond
+	}
+	switch opts.AcceptOverflow {
+	case "":
+		opts.AcceptOverflow = OverflowQueue
+	case OverflowQueue, RejectRefuse:
+	default:
+		return nil, fmt.Errorf("unknown accept overflow mode %q", opts.AcceptOverflow)
+	}
+	if opts.MaxInFlight > 0 {
+		s.inFlight = newInFlightLimit(opts.MaxInFlight, opts.MaxQueue, opts.QueueTimeout)
+	}
+	if opts.DefaultFormat != "" {
+		if _, err := ParseFormat(string(opts.DefaultFormat)); err != nil {
+			return nil, err
+		}
+	}
+	for _, route := range opts.Routes {
+		if _, ok := routes[route]; !ok {
+			return nil, fmt.Errorf("unknown route %q", route)
+		}
+	}
+	if opts.Compat != "" && opts.Compat != CompatHTTPBin {
+		return nil, fmt.Errorf("unknown compat mode %q, want httpbin", opts.Compat)
+	}
+	logger := s.logger
+	ctx, cancel := context.WithCancel(context.Background())
+	s.ctx, s.cancel = ctx, cancel
+	s.health = newHealthService(s)
+	s.toxiproxy = newToxiproxy(s)
+	s.webhooks = newWebhookSet()
+
+	switch {
+	case opts.TLSCert != "" && opts.TLSRotateCA > 0:
+		cancel()
+		return nil, errors.New("TLSRotateCA needs a generated CA and cannot be combined with TLSCert")
+	case opts.TLSCert != "":
+		certs, err := newCertStore(logger, opts.TLSCert, opts.TLSKey)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("%w: %w", ErrTLSMaterial, err)
+		}
+		s.certs = certs
+		go certs.watch(ctx.Done(), 5*time.Second)
+	case opts.TLSSelfSigned || opts.TLSRotateCA > 0:
+		certs, err := newCertStore(logger, "", "")
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		s.certs = certs
+		if opts.TLSRotateCA > 0 {
+			go certs.rotateEvery(ctx.Done(), opts.TLSRotateCA)
+		}
+	}
+	for _, path := range opts.WASMPlugins {
+		p, err := newWASMPlugin(ctx, logger, path)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		s.plugins = append(s.plugins, p)
+		go func() {
+			<-ctx.Done()
+			p.rt.Close(context.Background())
+		}()
+	}
+	if opts.JWTRotate > 0 {
+		go s.jwt.rotateEvery(ctx.Done(), logger, opts.JWTRotate)
+	}
+	go s.watchSchedules(ctx.Done())
+	if opts.HiccupEvery > 0 {
+		go s.runHiccups(ctx)
+	}
+	if s.certs != nil {
+		s.sessions = newTLSSessions(opts.TLSNoTickets, opts.TLSRejectResumption)
+		if opts.TLSTicketRotate > 0 {
+			go s.sessions.rotateEvery(ctx.Done(), opts.TLSTicketRotate)
+		}
+	}
+	return s, nil
+}
+
+// Close releases pending slow handlers and stops background work. Listeners
+// started by Start are shut down once its context is done.
+func (s *Server) Close() error {
+	s.cancel()
+	return nil
+}
+
+// Run is Start followed by Wait.
+func (s *Server) Run(ctx context.Context) error {
+	if err := s.Start(ctx); err != nil {
+		return err
+	}
+	return s.Wait()
+}
+
+// Start binds every configured listener, injected ones included, and serves
+// in the background until ctx is done, the server is closed or a listener
+// fails. Addr and GRPCAddr report the bound addresses once it returns.
+func (s *Server) Start(ctx context.Context) (err error) {
+	var closers []func(context.Context)
+	// a drain lets the requests in flight finish before the slow handlers
+	// are released
+	shutdown := func(drain time.Duration) {
+		timeout := s.opts.ShutdownTimeout
+		if drain > 0 {
+			timeout = drain
+		} else {
+			s.Close()
+		}
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), timeout)
+		defer shutdownCancel()
+		for _, stop := range closers {
+			stop(shutdownCtx)
+		}
+		s.Close()
+	}
+	defer func() {
+		if err != nil {
+			shutdown(0)
+		}
+	}()
+	bound := map[string]net.Listener{}
+
+	// every listener reports at most one error
+	errs := make(chan error, 3+len(s.opts.TCPFaults))
+	ready := ReadyInfo{Ready: true, PID: os.Getpid()}
+
+	ln := s.listenerOverride
+	if ln == nil {
+		if ln, err = s.listen(bound, "http", s.opts.Addr); err != nil {
+			return err
+		}
+	}
+	ready.Addr = ln.Addr().String()
+	httpProtocol, grpcProtocol := "http", "grpc"
+	if s.certs != nil {
+		httpProtocol, grpcProtocol = "https", "grpcs"
+	}
+	ready.Listeners = append(ready.Listeners, listenerInfo("http", httpProtocol, ln.Addr(), s.certs != nil))
+	server := s.httpServer()
+	closers = append(closers, func(ctx context.Context) {
+		if err := server.Shutdown(ctx); err != nil {
+			s.logger.Warn("failed to shutdown server", zap.Error(err))
+		}
+	})
+	s.logger.Info("starting server", zap.String("addr", ln.Addr().String()), zap.String("version", Build().Version), zap.Int64("seed", s.Seed()))
+	go func() {
+		if err := server.Serve(s.listener(ln)); err != nil && err != http.ErrServerClosed {
+			s.logger.Error("serving failed", zap.Error(err))
+			errs <- err
+		}
+	}()
+
+	if lis := s.grpcListenerOverride; lis != nil || s.opts.GRPCAddr != "" {
+		if lis == nil {
+			if lis, err = s.listen(bound, "grpc", s.opts.GRPCAddr); err != nil {
+				return err
+			}
+		}
+		ready.GRPCAddr = lis.Addr().String()
+		ready.Listeners = append(ready.Listeners, listenerInfo("grpc", grpcProtocol, lis.Addr(), s.certs != nil))
+		grpcServer := s.GRPCServer()
+		closers = append(closers, func(ctx context.Context) { stopGRPC(ctx, grpcServer) })
+		s.logger.Info("starting grpc server", zap.String("addr", lis.Addr().String()))
+		go func() {
+			if err := grpcServer.Serve(lis); err != nil {
+				s.logger.Error("serving grpc failed", zap.Error(err))
+				errs <- err
+			}
+		}()
+	}
+
+	for _, fault := range s.opts.TCPFaults {
+		ln, err := s.listen(bound, "tcp-fault", fault.Addr)
+		if err != nil {
+			return err
+		}
+		ready.TCPFaults = append(ready.TCPFaults, fault.Mode+"="+ln.Addr().String())
+		info := listenerInfo("tcp-fault", "tcp", ln.Addr(), false)
+		info.Mode = fault.Mode
+		ready.Listeners = append(ready.Listeners, info)
+		closers = append(closers, func(context.Context) { ln.Close() })
+		s.logger.Info("starting tcp fault listener", zap.String("addr", ln.Addr().String()), zap.String("mode", fault.Mode))
+		go func(mode string) {
+			if err := s.serveTCPFault(ln, mode); err != nil {
+				s.logger.Error("tcp fault listener failed", zap.Error(err))
+				errs <- err
+			}
+		}(fault.Mode)
+	}
+
+	if addr := s.opts.ToxiproxyAddr; addr != "" {
+		ln, err := s.listen(bound, "toxiproxy", addr)
+		if err != nil {
+			return err
+		}
+		ready.Listeners = append(ready.Listeners, listenerInfo("toxiproxy", "http", ln.Addr(), false))
+		api := &http.Server{Handler: s.toxiproxy.handler()}
+		closers = append(closers, func(ctx context.Context) {
+			api.Shutdown(ctx)
+			s.toxiproxy.closeAll()
+		})
+		s.logger.Info("starting toxiproxy api", zap.String("addr", ln.Addr().String()))
+		go func() {
+			if err := api.Serve(ln); err != nil && err != http.ErrServerClosed {
+				s.logger.Error("serving toxiproxy api failed", zap.Error(err))
+				errs <- err
+			}
+		}()
+	}
+
+	if path := s.opts.ReportPath; path != "" {
+		closers = append(closers, func(context.Context) {
+			if err := s.WriteReport(path); err != nil {
+				s.logger.Error("failed to write report", zap.String("path", path), zap.Error(err))
+				return
+			}
+			s.logger.Info("wrote report", zap.String("path", path))
+		})
+	}
+
+	// inherited listeners the config no longer asks for
+	for key, ln := range s.inherited {
+		s.logger.Info("closing unused inherited listener", zap.String("listener", key))
+		ln.Close()
+	}
+	s.inherited = nil
+
+	s.mu.Lock()
+	s.ready = ready
+	s.bound = bound
+	s.mu.Unlock()
+	for _, fn := range s.onReady {
+		fn(ready)
+	}
+
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		var drain time.Duration
+		defer func() { shutdown(drain) }()
+		select {
+		case <-ctx.Done():
+			s.logger.Info("received termination signal, shutting down")
+		case <-s.ctx.Done():
+			s.logger.Info("server closed, shutting down")
+		case err := <-errs:
+			s.runErr = err
+		case drain = <-s.drain:
+			s.logger.Info("draining", zap.Duration("timeout", drain))
+		}
+	}()
+	return nil
+}
+
+// Wait blocks until the listeners started by Start are shut down and returns
+// the listener error that caused it, if any.
+func (s *Server) Wait() error {
+	if s.done == nil {
+		return errors.New("slowproxy: server not started")
+	}
+	<-s.done
+	return s.runErr
+}
+
+// Addr returns the bound HTTP address, empty until Start returns.
+func (s *Server) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ready.Addr
+}
+
+// URL returns the base URL of the bound HTTP listener.
+func (s *Server) URL() string {
+	scheme := "http"
+	if s.certs != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + s.Addr()
+}
+
+// GRPCAddr returns the bound gRPC address, empty when gRPC is disabled.
+func (s *Server) GRPCAddr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ready.GRPCAddr
+}
+
+func (s *Server) httpServer() *http.Server {
+	hs := &http.Server{
+		Addr:        s.opts.Addr,
+		Handler:     s.Handler(),
+		ConnContext: s.connContext,
+		ConnState:   s.connState,
+	}
+	if s.opts.Upstream != "" {
+		// gRPC clients of the proxy speak HTTP/2, with or without TLS
+		hs.Protocols = new(http.Protocols)
+		hs.Protocols.SetHTTP1(true)
+		hs.Protocols.SetHTTP2(true)
+		hs.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return hs
+}
+
+// stopGRPC drains in-flight RPCs, forcing them closed once ctx is done.
+func stopGRPC(ctx context.Context, gs *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		gs.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		gs.Stop()
+	}
+}
+
+// Route names a group of HTTP endpoints that can be selected with WithRoutes.
+type Route string
+
+const (
+	RouteSlow    Route = "slow"
+	RouteFail    Route = "fail"
+	RouteSSE     Route = "sse"
+	RouteEcho    Route = "echo"
+	RouteUpload  Route = "upload"
+	RouteLimits  Route = "limits"
+	RouteAuth    Route = "auth"
+	RouteCache   Route = "cache"
+	RouteFixture Route = "fixture"
+	RouteProbe   Route = "probe"
+	RouteWebhook Route = "webhook"
+	RouteAdmin   Route = "admin"
+)
+
+var routes = map[Route]func(*Server, *mux.Router){
+	RouteSlow: func(s *Server, r *mux.Router) {
+		r.HandleFunc("/slow", s.compressed(s.slow))
+		r.HandleFunc("/slow/{duration}", s.compressed(s.slow))
+		r.HandleFunc("/slow/{duration}/status/{code}", s.compressed(s.slow))
+		r.HandleFunc("/status/{code}", s.status)
+	},
+	RouteFail: func(s *Server, r *mux.Router) {
+		r.HandleFunc("/fail", s.fail)
+	},
+	RouteSSE: func(s *Server, r *mux.Router) {
+		r.HandleFunc("/sse", s.sse)
+	},
+	RouteEcho: func(s *Server, r *mux.Router) {
+		r.HandleFunc("/echo", s.compressed(s.echo))
+		r.PathPrefix("/anything").HandlerFunc(s.compressed(s.echo))
+	},
+	RouteUpload: func(s *Server, r *mux.Router) {
+		r.HandleFunc("/upload/slow", s.uploadSlow)
+	},
+	RouteLimits: func(s *Server, r *mux.Router) {
+		r.HandleFunc("/ratelimited", s.ratelimited)
+	},
+	RouteAuth: func(s *Server, r *mux.Router) {
+		r.HandleFunc("/auth/basic/{user}/{pass}", s.basicAuth)
+		r.HandleFunc("/auth/bearer", s.bearerAuth)
+		r.HandleFunc("/auth/digest/{user}/{pass}", s.digestAuth)
+		r.HandleFunc("/auth/apikey", s.apiKeyAuth)
+		r.HandleFunc("/oauth/token", s.oauthToken)
+		r.HandleFunc("/session/login", s.sessionLogin)
+		r.HandleFunc("/session/logout", s.sessionLogout)
+		r.HandleFunc("/session/protected", s.sessionProtected)
+		r.HandleFunc("/jwt/mint", s.mintJWT).Methods(http.MethodGet, http.MethodHead, http.MethodPost)
+		r.HandleFunc("/jwt/protected", s.protectedJWT)
+		r.HandleFunc("/.well-known/jwks.json", s.jwksHandler).Methods(http.MethodGet, http.MethodHead)
+	},
+	RouteCache: func(s *Server, r *mux.Router) {
+		r.HandleFunc("/cache", s.cache)
+		r.HandleFunc("/bytes/{n}", s.compressed(s.serveBytes))
+		r.HandleFunc("/cache/etag/{tag}", s.etag)
+		r.HandleFunc("/cache/vary", s.vary)
+		r.HandleFunc("/cache/modified", s.modified)
+		r.HandleFunc("/cache/stale", s.stale)
+	},
+	RouteFixture: func(s *Server, r *mux.Router) {
+		r.HandleFunc("/fixtures", s.listFixtures)
+		r.HandleFunc("/fixture/{name:.+}", s.compressed(s.fixture))
+	},
+	RouteProbe: func(s *Server, r *mux.Router) {
+		r.HandleFunc("/probe/{name}", s.probe).Methods(http.MethodGet, http.MethodHead)
+	},
+	RouteWebhook: func(s *Server, r *mux.Router) {
+		r.HandleFunc("/webhook/register", s.registerWebhook).Methods(http.MethodPost)
+		r.HandleFunc("/webhook/{id}", s.getWebhook).Methods(http.MethodGet, http.MethodHead)
+		r.HandleFunc("/webhook/{id}", s.cancelWebhook).Methods(http.MethodDelete)
+	},
+	RouteAdmin: func(s *Server, r *mux.Router) {
+		s.adminRoutes(r.PathPrefix("/admin").Subrouter())
+	},
+}
+
+// routeOrder keeps registration deterministic, mux matches in order.
+var routeOrder = []Route{RouteAdmin, RouteSlow, RouteFail, RouteSSE, RouteEcho, RouteUpload, RouteLimits, RouteAuth, RouteCache, RouteFixture, RouteProbe, RouteWebhook}
+
+// Handler returns the selected HTTP routes, by default all of them including
+// the admin API under /admin.
+func (s *Server) Handler() http.Handler {
+	r := mux.NewRouter()
+	middlewares := []mux.MiddlewareFunc{s.captureMiddleware, s.hiccupGate, s.headRequests, s.stallGuard, s.flushPolicy, s.loadShed, s.perIPLimit, s.concurrencyLimit, s.clientBandwidth, s.http10, s.connectionClose, transferMode, s.clockSkew, s.earlyHints, s.interimResponses, s.controlMiddleware, s.sessionGate, s.apiKeyGate, s.luaMiddleware, s.jsMiddleware, s.wasmMiddleware, s.markovMiddleware, s.groupsMiddleware, s.profilesMiddleware, s.rulesMiddleware}
+	r.Use(middlewares...)
+	// mux skips middlewares for unmatched paths, rules may still stub them
+	// and they go to the upstream if there is one
+	var notFound http.Handler = http.NotFoundHandler()
+	if s.upstream != nil {
+		notFound = s.upstream
+	}
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		notFound = middlewares[i](notFound)
+	}
+	r.NotFoundHandler = notFound
+	if s.opts.Compat == CompatHTTPBin {
+		s.httpbinRoutes(r)
+	}
+	if s.openapi != nil {
+		s.openAPIRoutes(r)
+	}
+	if s.s3 != nil {
+		s.s3Routes(r)
+	}
+	enabled := routeOrder
+	if len(s.opts.Routes) > 0 {
+		enabled = s.opts.Routes
+	}
+	for _, route := range enabled {
+		routes[route](s, r)
+	}
+	return r
+}
@@ -0,0 +1,162 @@
+package slowproxy
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const sessionCookie = "slowproxy_session"
+
+type session struct {
+	user    string
+	expires time.Time
+}
+
+// sessionStore holds the cookie sessions handed out by /session/login.
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]session
+}
+
+func newSessionStore() *sessionStore {
+	return &sessionStore{sessions: map[string]session{}}
+}
+
+func (st *sessionStore) create(user string, ttl time.Duration) (string, time.Time) {
+	now := time.Now()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for id, sess := range st.sessions {
+		if now.After(sess.expires) {
+			delete(st.sessions, id)
+		}
+	}
+	id := randomToken()
+	expires := now.Add(ttl)
+	st.sessions[id] = session{user: user, expires: expires}
+	return id, expires
+}
+
+func (st *sessionStore) lookup(id string) (session, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	sess, ok := st.sessions[id]
+	if !ok || time.Now().After(sess.expires) {
+		return session{}, false
+	}
+	return sess, true
+}
+
+func (st *sessionStore) remove(id string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.sessions, id)
+}
+
+func (st *sessionStore) clear() int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	n := len(st.sessions)
+	st.sessions = map[string]session{}
+	return n
+}
+
+// session returns the valid session of req, if any.
+func (s *Server) session(req *http.Request) (session, bool) {
+	c, err := req.Cookie(sessionCookie)
+	if err != nil {
+		return session{}, false
+	}
+	return s.cookies.lookup(c.Value)
+}
+
+// sessionLogin starts a session and sets its cookie, then redirects when
+// asked to:
+//
+//	/session/login?user=alice&ttl=10m&redirect=/session/protected
+func (s *Server) sessionLogin(rw http.ResponseWriter, req *http.Request) {
+	ttl, err := durationQuery(req, "ttl", s.opts.SessionTTL)
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	user := req.URL.Query().Get("user")
+	if user == "" {
+		user = "anonymous"
+	}
+	id, expires := s.cookies.create(user, ttl)
+	http.SetCookie(rw, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/",
+		Expires:  expires,
+		MaxAge:   ceilSeconds(ttl),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	if to := req.URL.Query().Get("redirect"); strings.HasPrefix(to, "/") {
+		http.Redirect(rw, req, to, http.StatusFound)
+		return
+	}
+	writeJSON(rw, http.StatusOK, map[string]any{"user": user, "expires": expires})
+}
+
+func (s *Server) sessionLogout(rw http.ResponseWriter, req *http.Request) {
+	if c, err := req.Cookie(sessionCookie); err == nil {
+		s.cookies.remove(c.Value)
+	}
+	http.SetCookie(rw, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) sessionProtected(rw http.ResponseWriter, req *http.Request) {
+	sess, ok := s.session(req)
+	if !ok {
+		s.rejectSession(rw, req)
+		return
+	}
+	writeJSON(rw, http.StatusOK, map[string]any{"user": sess.user, "expires": sess.expires})
+}
+
+// rejectSession answers a request without a valid session, slowly when
+// SessionUnauthDelay is set.
+func (s *Server) rejectSession(rw http.ResponseWriter, req *http.Request) {
+	if d := s.opts.SessionUnauthDelay; d > 0 {
+		if err := s.Pause(req.Context(), d, 0, nil); err != nil {
+			return
+		}
+	}
+	writeAPIError(rw, http.StatusUnauthorized, &apiError{Code: "no_session", Message: "missing or expired session cookie"})
+}
+
+// sessionGate requires a valid session cookie on the SessionPaths prefixes,
+// except for the admin API and the session routes themselves.
+func (s *Server) sessionGate(next http.Handler) http.Handler {
+	if len(s.opts.SessionPaths) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/admin/") || strings.HasPrefix(req.URL.Path, "/session/") {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		for _, prefix := range s.opts.SessionPaths {
+			if !strings.HasPrefix(req.URL.Path, prefix) {
+				continue
+			}
+			if _, ok := s.session(req); !ok {
+				s.rejectSession(rw, req)
+				return
+			}
+			break
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
+
+// clearSessions expires every session, as a server side logout would.
+func (s *Server) clearSessions(rw http.ResponseWriter, req *http.Request) {
+	writeJSON(rw, http.StatusOK, map[string]int{"expired": s.cookies.clear()})
+}
@@ -0,0 +1,110 @@
+package slowproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// login starts a session on s and returns its cookie.
+func login(t *testing.T, s *Server, query string) *http.Cookie {
+	t.Helper()
+	rec := serve(s, httptest.NewRequest("GET", "/session/login?"+query, nil))
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == sessionCookie {
+			return c
+		}
+	}
+	t.Fatalf("login: %d without a session cookie", rec.Code)
+	return nil
+}
+
+func TestSession(t *testing.T) {
+	s := newTestServer(t, WithOptions(Options{SessionPaths: []string{"/echo"}}))
+	get := func(path string, c *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if c != nil {
+			req.AddCookie(c)
+		}
+		return serve(s, req)
+	}
+
+	if rec := get("/session/protected", nil); rec.Code != http.StatusUnauthorized || errorCode(rec) != "no_session" {
+		t.Errorf("without a session = %d %s, want 401 no_session", rec.Code, errorCode(rec))
+	}
+	if rec := get("/echo", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("gated path without a session = %d, want 401", rec.Code)
+	}
+	if rec := get("/status/200", nil); rec.Code != http.StatusOK {
+		t.Errorf("ungated path = %d, want 200", rec.Code)
+	}
+
+	c := login(t, s, "user=alice")
+	if !c.HttpOnly || c.MaxAge != 1800 {
+		t.Errorf("cookie = %+v, want HttpOnly with a 30m max age", c)
+	}
+	if rec := get("/session/protected", c); rec.Code != http.StatusOK {
+		t.Errorf("with a session = %d, want 200", rec.Code)
+	}
+	if rec := get("/echo", c); rec.Code != http.StatusOK {
+		t.Errorf("gated path with a session = %d, want 200", rec.Code)
+	}
+
+	if rec := get("/session/logout", c); rec.Code != http.StatusNoContent {
+		t.Errorf("logout = %d, want 204", rec.Code)
+	}
+	if rec := get("/session/protected", c); rec.Code != http.StatusUnauthorized {
+		t.Errorf("after logout = %d, want 401", rec.Code)
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	s := newTestServer(t)
+	protected := func(c *http.Cookie) int {
+		req := httptest.NewRequest("GET", "/session/protected", nil)
+		req.AddCookie(c)
+		return serve(s, req).Code
+	}
+
+	short := login(t, s, "ttl=10ms")
+	time.Sleep(20 * time.Millisecond)
+	if code := protected(short); code != http.StatusUnauthorized {
+		t.Errorf("expired session = %d, want 401", code)
+	}
+
+	c := login(t, s, "")
+	if rec := serve(s, httptest.NewRequest("DELETE", "/admin/sessions", nil)); rec.Code != http.StatusOK {
+		t.Fatalf("DELETE /admin/sessions = %d", rec.Code)
+	}
+	if code := protected(c); code != http.StatusUnauthorized {
+		t.Errorf("session after /admin/sessions = %d, want 401", code)
+	}
+}
+
+func TestSessionLoginRedirect(t *testing.T) {
+	s := newTestServer(t)
+	tests := []struct {
+		redirect string
+		want     int
+	}{
+		{redirect: "/session/protected", want: http.StatusFound},
+		{redirect: "https://elsewhere.test/", want: http.StatusOK},
+		{redirect: "", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := serve(s, httptest.NewRequest("GET", "/session/login?redirect="+tt.redirect, nil))
+		if rec.Code != tt.want {
+			t.Errorf("redirect %q = %d, want %d", tt.redirect, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestSessionUnauthDelay(t *testing.T) {
+	s := newTestServer(t, WithOptions(Options{SessionUnauthDelay: 50 * time.Millisecond}))
+	start := time.Now()
+	rec := serve(s, httptest.NewRequest("GET", "/session/protected", nil))
+	if elapsed := time.Since(start); rec.Code != http.StatusUnauthorized || elapsed < 50*time.Millisecond {
+		t.Errorf("= %d after %s, want 401 after 50ms", rec.Code, elapsed)
+	}
+}
@@ -0,0 +1,61 @@
+package slowproxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type delayScaleKey struct{}
+
+// scaleDelay shrinks d by the factor load shedding attached to ctx.
+func scaleDelay(ctx context.Context, d time.Duration) time.Duration {
+	if f, ok := ctx.Value(delayScaleKey{}).(float64); ok {
+		return time.Duration(float64(d) * f)
+	}
+	return d
+}
+
+// loadShed emulates an adaptive backend: once the in-flight gauge passes
+// ShedThreshold of ShedCapacity a rising share of requests is rejected with a
+// 503, reaching all of them at full capacity, and the delays of the requests
+// let through shrink by the same share.
+func (s *Server) loadShed(next http.Handler) http.Handler {
+	if s.opts.ShedCapacity <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/admin/") {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		shed := s.shedFraction()
+		if shed <= 0 {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		if s.rand.Float64() < shed {
+			s.stats.shed.Add(1)
+			rw.Header().Set("Retry-After", "1")
+			writeAPIError(rw, http.StatusServiceUnavailable, &apiError{
+				Code:    "load_shed",
+				Message: fmt.Sprintf("shedding %.0f%% of requests", shed*100),
+			})
+			return
+		}
+		next.ServeHTTP(rw, req.WithContext(context.WithValue(req.Context(), delayScaleKey{}, 1-shed)))
+	})
+}
+
+// shedFraction maps the current utilization to the share of requests shed.
+func (s *Server) shedFraction() float64 {
+	// this request is already counted by the gauge
+	util := float64(s.stats.inFlight.Load()-1) / float64(s.opts.ShedCapacity)
+	threshold := s.opts.ShedThreshold
+	if util <= threshold {
+		return 0
+	}
+	return min((util-threshold)/(1-threshold), 1)
+}
@@ -0,0 +1,110 @@
+package slowproxy
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type skewKey struct{}
+
+// skewedNow is the time a request with clock skew claims it is.
+func skewedNow(ctx context.Context) time.Time {
+	skew, _ := ctx.Value(skewKey{}).(time.Duration)
+	return time.Now().Add(skew)
+}
+
+// clockSkew shifts the Date and Expires headers and the Expires of cookies by
+// ?clock_skew=, or Options.ClockSkew, as if the server clock were off. Minted
+// JWTs are issued on the same clock:
+//
+//	/cache?max-age=60&expires=Wed,%2021%20Oct%202015%2007:28:00%20GMT&clock_skew=-5m
+func (s *Server) clockSkew(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/admin/") {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		skew, err := durationQuery(req, "clock_skew", s.opts.ClockSkew)
+		if err != nil {
+			writeError(rw, http.StatusBadRequest, err)
+			return
+		}
+		if skew == 0 {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		req = req.WithContext(context.WithValue(req.Context(), skewKey{}, skew))
+		next.ServeHTTP(&skewWriter{ResponseWriter: rw, skew: skew}, req)
+	})
+}
+
+type skewWriter struct {
+	http.ResponseWriter
+	skew        time.Duration
+	wroteHeader bool
+}
+
+func (w *skewWriter) WriteHeader(status int) {
+	if status >= 200 && !w.wroteHeader {
+		w.wroteHeader = true
+		w.shift()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *skewWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *skewWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *skewWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *skewWriter) shift() {
+	h := w.Header()
+	date := time.Now()
+	if t, err := http.ParseTime(h.Get("Date")); err == nil {
+		date = t
+	}
+	h.Set("Date", w.format(date))
+	if t, err := http.ParseTime(h.Get("Expires")); err == nil {
+		h.Set("Expires", w.format(t))
+	}
+	for i, c := range h["Set-Cookie"] {
+		h["Set-Cookie"][i] = w.shiftCookie(c)
+	}
+}
+
+func (w *skewWriter) format(t time.Time) string {
+	return t.Add(w.skew).UTC().Format(http.TimeFormat)
+}
+
+// shiftCookie rewrites the Expires attribute of a Set-Cookie value in place,
+// keeping every other attribute as it was.
+func (w *skewWriter) shiftCookie(c string) string {
+	attrs := strings.Split(c, ";")
+	for i, attr := range attrs[1:] {
+		name, value, _ := strings.Cut(strings.TrimSpace(attr), "=")
+		if !strings.EqualFold(name, "expires") {
+			continue
+		}
+		if t, err := http.ParseTime(value); err == nil {
+			attrs[i+1] = " " + name + "=" + w.format(t)
+		}
+	}
+	return strings.Join(attrs, ";")
+}
@@ -0,0 +1,199 @@
+package slowproxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (s *Server) fail(rw http.ResponseWriter, req *http.Request) {
+	rw.WriteHeader(http.StatusGatewayTimeout)
+}
+
+// errChunksSent ends the tick stream once the requested chunk count is out.
+var errChunksSent = errors.New("all chunks sent")
+
+// requestedDelay returns the delay asked for by the path segment, the
+// X-Slow-Duration header or the duration query parameter, in that order.
+func requestedDelay(req *http.Request) string {
+	if d := mux.Vars(req)["duration"]; d != "" {
+		return d
+	}
+	if d := req.Header.Get("X-Slow-Duration"); d != "" {
+		return d
+	}
+	return req.URL.Query().Get("duration")
+}
+
+func (s *Server) slow(rw http.ResponseWriter, req *http.Request) {
+	logger := s.logger.With(
+		zap.String("method", req.Method),
+		zap.String("url", req.URL.String()),
+	)
+
+	logger.Debug("incoming request headers", zap.Any("header", req.Header))
+
+	chunkDelay, err := durationQuery(req, "chunk_delay", time.Second)
+	if err != nil || chunkDelay <= 0 {
+		writeAPIError(rw, http.StatusBadRequest, &apiError{
+			Code:            "invalid_chunk_delay",
+			Message:         fmt.Sprintf("chunk_delay %q must be a positive duration", req.URL.Query().Get("chunk_delay")),
+			AcceptedFormats: durationFormats,
+		})
+		return
+	}
+	chunks, err := intQuery(req, "chunks", 0)
+	if err != nil || chunks < 0 {
+		writeAPIError(rw, http.StatusBadRequest, &apiError{
+			Code:    "invalid_chunks",
+			Message: fmt.Sprintf("chunks %q must be a non-negative number", req.URL.Query().Get("chunks")),
+		})
+		return
+	}
+
+	duration := requestedDelay(req)
+	switch {
+	case duration == "" && chunks > 0:
+		// the chunk count alone decides when the stream ends
+		duration = s.opts.MaxDelay.String()
+	case duration == "":
+		logger.Debug("using default duration")
+		duration = s.opts.DefaultDelay.String()
+	}
+
+	pause, apiErr := s.parseDelay(duration)
+	if apiErr != nil {
+		logger.With(zap.Error(apiErr)).Error("failed to parse duration")
+		writeAPIError(rw, http.StatusBadRequest, apiErr)
+		return
+	}
+
+	if code := mux.Vars(req)["code"]; code != "" {
+		s.slowStatus(rw, req, logger, pause, code)
+		return
+	}
+
+	burst, err := intQuery(req, "burst", 0)
+	if err != nil || burst < 0 {
+		writeAPIError(rw, http.StatusBadRequest, &apiError{
+			Code:    "invalid_burst",
+			Message: fmt.Sprintf("burst %q must be a positive number of bytes", req.URL.Query().Get("burst")),
+		})
+		return
+	}
+	if burst > 0 {
+		s.slowBurst(rw, req, logger, pause, burst)
+		return
+	}
+
+	logger.Debug("starting request", zap.Duration("pause", pause))
+	defer logger.Debug("finishing request")
+
+	format := s.negotiate(req, FormatText)
+	frame := tickFormats[format]
+	rw.Header().Set("Content-Type", formatTypes[format])
+	if frame.open != "" {
+		io.WriteString(rw, frame.open)
+	}
+
+	ticks := 0
+	err = s.Pause(req.Context(), pause, chunkDelay, func(tick time.Time) error {
+		_, err := io.WriteString(rw, frame.line(ticks, tick))
+		ticks++
+		if err != nil {
+			logger.With(zap.Error(err)).Error("failed to write tick")
+			return err
+		}
+
+		if f, ok := rw.(http.Flusher); ok {
+			f.Flush()
+		}
+		if chunks > 0 && ticks == chunks {
+			return errChunksSent
+		}
+		return nil
+	})
+	if errors.Is(err, errChunksSent) {
+		err = nil
+	}
+	if errors.Is(err, context.Canceled) {
+		logger.Info("request context cancelled")
+		return
+	}
+	if err == nil && frame.close != "" {
+		io.WriteString(rw, frame.close)
+	}
+}
+
+// slowBurst sends the headers, stays completely silent for pause and then
+// writes size bytes at full speed, separating idle timeouts from total ones:
+//
+//	/slow/30s?burst=1048576
+func (s *Server) slowBurst(rw http.ResponseWriter, req *http.Request, logger *zap.Logger, pause time.Duration, size int) {
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rw.Header().Set("Content-Length", strconv.Itoa(size))
+	rw.WriteHeader(http.StatusOK)
+	if f, ok := rw.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	logger.Debug("silent before burst", zap.Duration("pause", pause), zap.Int("bytes", size))
+	if err := s.Pause(req.Context(), pause, 0, nil); err != nil {
+		logger.With(zap.Error(err)).Info("burst interrupted")
+		return
+	}
+
+	line := []byte(strings.Repeat("x", 63) + "\n")
+	chunk := bytes.Repeat(line, 512)
+	for size > 0 {
+		n := min(size, len(chunk))
+		if _, err := rw.Write(chunk[:n]); err != nil {
+			logger.With(zap.Error(err)).Error("failed to write burst")
+			return
+		}
+		size -= n
+	}
+}
+
+// slowStatus waits without streaming ticks, so the status is still unsent,
+// then replies with the requested code:
+//
+//	/slow/30s/status/503
+//	/status/503
+func (s *Server) slowStatus(rw http.ResponseWriter, req *http.Request, logger *zap.Logger, pause time.Duration, code string) {
+	status, err := strconv.Atoi(code)
+	if err != nil || status < 200 || status > 599 {
+		writeAPIError(rw, http.StatusBadRequest, &apiError{
+			Code:    "invalid_status",
+			Message: fmt.Sprintf("status %q must be between 200 and 599", code),
+		})
+		return
+	}
+
+	if pause > 0 {
+		logger.Debug("pausing before status", zap.Duration("pause", pause), zap.Int("status", status))
+		err = s.Pause(req.Context(), pause, 0, nil)
+		if errors.Is(err, context.Canceled) {
+			logger.Info("request context cancelled")
+			return
+		}
+	}
+	// a shutdown still answers, so the client sees the requested status
+	writeStatus(rw, s.negotiate(req, FormatText), status)
+}
+
+func (s *Server) status(rw http.ResponseWriter, req *http.Request) {
+	logger := s.logger.With(
+		zap.String("method", req.Method),
+		zap.String("url", req.URL.String()),
+	)
+	s.slowStatus(rw, req, logger, 0, mux.Vars(req)["code"])
+}
@@ -0,0 +1,55 @@
+// Package slowproxytest runs the slow-proxy routes inside Go tests.
+package slowproxytest
+
+import (
+	"github.com/cbosss/slow-proxy/pkg/slowproxy"
+	"go.uber.org/zap/zaptest"
+	"net/http/httptest"
+	"testing"
+)
+
+// Server is a started httptest.Server along with the slowproxy.Server behind
+// it, whose Controller and Captures drive and inspect the test.
+type Server struct {
+	*httptest.Server
+	Proxy *slowproxy.Server
+}
+
+// NewServer starts an httptest.Server serving the slow-proxy routes, logging
+// to t.Log unless WithLogger is given. Pending slow requests are released and
+// the server is closed when the test ends.
+func NewServer(t testing.TB, opts ...slowproxy.Option) *httptest.Server {
+	t.Helper()
+	return Start(t, opts...).Server
+}
+
+// NewTLSServer is NewServer using httptest's TLS certificate.
+func NewTLSServer(t testing.TB, opts ...slowproxy.Option) *httptest.Server {
+	t.Helper()
+	ts, _ := newUnstarted(t, opts)
+	ts.StartTLS()
+	return ts
+}
+
+// Start is NewServer also returning the slowproxy.Server.
+func Start(t testing.TB, opts ...slowproxy.Option) *Server {
+	t.Helper()
+	ts, srv := newUnstarted(t, opts)
+	ts.Start()
+	return &Server{Server: ts, Proxy: srv}
+}
+
+func newUnstarted(t testing.TB, opts []slowproxy.Option) (*httptest.Server, *slowproxy.Server) {
+	t.Helper()
+	opts = append([]slowproxy.Option{slowproxy.WithLogger(zaptest.NewLogger(t))}, opts...)
+	srv, err := slowproxy.New(opts...)
+	if err != nil {
+		t.Fatalf("slowproxytest: %v", err)
+	}
+	ts := httptest.NewUnstartedServer(srv.Handler())
+	t.Cleanup(func() {
+		srv.Close() // release slow handlers before waiting for them
+		ts.Close()
+	})
+	return ts, srv
+}
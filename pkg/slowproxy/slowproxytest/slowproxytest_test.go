@@ -0,0 +1,65 @@
+package slowproxytest_test
+
+import (
+	"github.com/cbosss/slow-proxy/pkg/slowproxy"
+	"github.com/cbosss/slow-proxy/pkg/slowproxy/slowproxytest"
+	"github.com/cbosss/slow-proxy/pkg/slowproxy/testkit"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func get(t *testing.T, url string) int {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestNewServer(t *testing.T) {
+	ts := slowproxytest.NewServer(t)
+	if got := get(t, ts.URL+"/fail"); got != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want 504", got)
+	}
+}
+
+func TestStartWithRules(t *testing.T) {
+	ts := slowproxytest.Start(t, slowproxy.WithRules(slowproxy.Rule{
+		Match: slowproxy.Matcher{Path: "/fail"},
+		Fault: slowproxy.Fault{Delay: slowproxy.Fixed(50 * time.Millisecond), Status: http.StatusServiceUnavailable},
+	}))
+	testkit.ExpectDuration(t, 50*time.Millisecond, 5*time.Second, func() {
+		if got := get(t, ts.URL+"/fail"); got != http.StatusServiceUnavailable {
+			t.Errorf("status = %d, want 503", got)
+		}
+	})
+	get(t, ts.URL+"/slow/1ms")
+	testkit.ExpectRequests(t, ts.Proxy, slowproxy.Matcher{Path: "/"}, 2)
+	testkit.ExpectRequests(t, ts.Proxy, slowproxy.Matcher{Path: "/fail"}, 1)
+}
+
+// TestSeedPerServer interleaves the requests of two servers with the same
+// seed, which decide alike only with a random source each.
+func TestSeedPerServer(t *testing.T) {
+	start := func() *slowproxytest.Server {
+		return slowproxytest.Start(t, slowproxy.WithOptions(slowproxy.Options{Seed: 7}), slowproxy.WithRules(slowproxy.Rule{
+			Fault: slowproxy.Fault{Status: http.StatusServiceUnavailable, Percent: 50},
+		}))
+	}
+	a, b := start(), start()
+	if a.Proxy.Seed() != 7 || b.Proxy.Seed() != 7 {
+		t.Fatalf("seeds = %d and %d, want 7", a.Proxy.Seed(), b.Proxy.Seed())
+	}
+	var gotA, gotB []int
+	for range 32 {
+		gotA = append(gotA, get(t, a.URL+"/fail"))
+		gotB = append(gotB, get(t, b.URL+"/fail"))
+	}
+	if !reflect.DeepEqual(gotA, gotB) {
+		t.Errorf("same seed, different decisions:\n%v\n%v", gotA, gotB)
+	}
+}
@@ -0,0 +1,108 @@
+package slowproxy
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// sse streams spec compliant server-sent events:
+//
+//	/sse?interval=1s&count=100&retry=3s&fail_after=30s
+//
+// A Last-Event-ID header resumes the id sequence where the client left off.
+// When fail_after is set the connection is aborted without terminating the
+// stream, which EventSource clients treat as a network error and reconnect.
+func (s *Server) sse(rw http.ResponseWriter, req *http.Request) {
+	logger := s.logger.With(
+		zap.String("method", req.Method),
+		zap.String("url", req.URL.String()),
+	)
+
+	interval, err := durationQuery(req, "interval", time.Second)
+	if err == nil && interval <= 0 {
+		err = fmt.Errorf("invalid interval: must be positive")
+	}
+	if err != nil {
+		logger.With(zap.Error(err)).Error("failed to parse sse parameters")
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	count, err := intQuery(req, "count", 100)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	retry, err := durationQuery(req, "retry", 3*time.Second)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	failAfter, err := durationQuery(req, "fail_after", 0)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id := 0
+	if last := req.Header.Get("Last-Event-ID"); last != "" {
+		if n, err := strconv.Atoi(last); err == nil {
+			id = n
+		}
+	}
+
+	flusher, _ := rw.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("X-Accel-Buffering", "no")
+	rw.WriteHeader(http.StatusOK)
+
+	if _, err := fmt.Fprintf(rw, "retry: %d\n\n", retry.Milliseconds()); err != nil {
+		logger.With(zap.Error(err)).Error("failed to write retry hint")
+		return
+	}
+	flush()
+
+	logger.Debug("starting event stream", zap.Int("last_event_id", id), zap.Int("count", count))
+	defer logger.Debug("finishing event stream")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var failC <-chan time.Time
+	if failAfter > 0 {
+		failTimer := time.NewTimer(failAfter)
+		defer failTimer.Stop()
+		failC = failTimer.C
+	}
+
+	for sent := 0; count <= 0 || sent < count; {
+		select {
+		case <-req.Context().Done():
+			logger.Info("request context cancelled")
+			return
+		case <-s.ctx.Done():
+			return
+		case <-failC:
+			logger.Info("dropping event stream", zap.Int("last_event_id", id))
+			panic(http.ErrAbortHandler)
+		case tick := <-ticker.C:
+			id++
+			sent++
+			_, err := fmt.Fprintf(rw, "id: %d\nevent: tick\ndata: {\"id\":%d,\"time\":%q}\n\n", id, id, tick.Format(time.RFC3339Nano))
+			if err != nil {
+				logger.With(zap.Error(err)).Error("failed to write event")
+				return
+			}
+			flush()
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package slowproxy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// staleDefaults are the Cache-Control values each /cache/stale preset sends.
+var staleDefaults = map[string]string{
+	"swr":  "max-age=1, stale-while-revalidate=30",
+	"sie":  "max-age=1, stale-if-error=300",
+	"both": "max-age=1, stale-while-revalidate=30, stale-if-error=300",
+}
+
+// originPhase is one step of a /cache/stale schedule.
+type originPhase struct {
+	name string
+	d    time.Duration
+}
+
+// parsePhases reads a list like ok:10s,slow:10s,error:10s.
+func parsePhases(v string) ([]originPhase, error) {
+	var phases []originPhase
+	for _, part := range strings.Split(v, ",") {
+		name, dur, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid schedule %q: want phase:duration", part)
+		}
+		switch name {
+		case "ok", "slow", "error":
+		default:
+			return nil, fmt.Errorf("invalid schedule phase %q: want ok, slow or error", name)
+		}
+		d, err := time.ParseDuration(dur)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid schedule duration %q", dur)
+		}
+		phases = append(phases, originPhase{name: name, d: d})
+	}
+	return phases, nil
+}
+
+// currentPhase picks the phase the schedule is in at now, cycles start at the
+// Unix epoch so every server agrees.
+func currentPhase(phases []originPhase, now time.Time) originPhase {
+	var cycle time.Duration
+	for _, p := range phases {
+		cycle += p.d
+	}
+	at := time.Duration(now.UnixNano() % int64(cycle))
+	for _, p := range phases {
+		if at < p.d {
+			return p
+		}
+		at -= p.d
+	}
+	return phases[len(phases)-1]
+}
+
+// stale is an origin for demonstrating stale serving: it sends the
+// Cache-Control of a preset and, following the virtual clock, alternates
+// between answering, answering after slow=, and failing with a 500:
+//
+//	/cache/stale?preset=both&schedule=ok:10s,slow:10s,error:10s&slow=5s
+//	/cache/stale?preset=swr&cache_control=max-age=2,stale-while-revalidate=60
+//
+// X-Origin-Phase and X-Origin-Hits tell a test which phase answered and how
+// many requests made it past the cache.
+func (s *Server) stale(rw http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	preset := q.Get("preset")
+	if preset == "" {
+		preset = "both"
+	}
+	cc, ok := staleDefaults[preset]
+	if !ok {
+		writeError(rw, http.StatusBadRequest, fmt.Errorf("invalid preset %q: want swr, sie or both", preset))
+		return
+	}
+	if v := q.Get("cache_control"); v != "" {
+		cc = v
+	}
+	schedule := q.Get("schedule")
+	if schedule == "" {
+		schedule = "ok:10s,slow:10s,error:10s"
+	}
+	phases, err := parsePhases(schedule)
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	slow, err := durationQuery(req, "slow", 5*time.Second)
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	phase := currentPhase(phases, s.clock.now())
+	hits := s.originHits.add(req.URL.RequestURI())
+	h := rw.Header()
+	h.Set("X-Origin-Phase", phase.name)
+	h.Set("X-Origin-Hits", strconv.FormatInt(hits, 10))
+	switch phase.name {
+	case "slow":
+		if err := s.Pause(req.Context(), slow, 0, nil); err != nil {
+			return
+		}
+	case "error":
+		h.Set("Cache-Control", "no-store")
+		writeJSON(rw, http.StatusInternalServerError, map[string]any{"phase": phase.name, "origin_hits": hits})
+		return
+	}
+	h.Set("Cache-Control", cc)
+	writeJSON(rw, http.StatusOK, map[string]any{"phase": phase.name, "cache_control": cc, "origin_hits": hits})
+}
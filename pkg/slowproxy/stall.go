@@ -0,0 +1,127 @@
+package slowproxy
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Slow consumer reactions, see Options.StallAction.
+const (
+	StallLog      = "log"
+	StallAbort    = "abort"
+	StallThrottle = "throttle"
+)
+
+// stallGuard watches the response writes of every route but the admin API,
+// with ?stall_timeout= or -stall-timeout, and reacts to a client that does
+// not read for that long:
+//
+//	/slow/1m?chunk_delay=10ms&stall_timeout=5s&stall_action=abort
+//
+// log only reports it, abort expires the write deadline so the blocked
+// write fails and the connection is dropped, and throttle waits as long as
+// the last write was blocked before writing again.
+func (s *Server) stallGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/admin/") {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		timeout, err := durationQuery(req, "stall_timeout", s.opts.StallTimeout)
+		if err == nil && timeout < 0 {
+			err = fmt.Errorf("invalid stall_timeout: must not be negative")
+		}
+		action := req.URL.Query().Get("stall_action")
+		if action == "" {
+			action = s.opts.StallAction
+		}
+		if err == nil {
+			err = validStallAction(action)
+		}
+		if err != nil {
+			writeError(rw, http.StatusBadRequest, err)
+			return
+		}
+		if timeout == 0 {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		next.ServeHTTP(&stallWriter{ResponseWriter: rw, s: s, req: req, timeout: timeout, action: action}, req)
+	})
+}
+
+func validStallAction(action string) error {
+	switch action {
+	case StallLog, StallAbort, StallThrottle:
+		return nil
+	}
+	return fmt.Errorf("unknown stall action %q, want log, abort or throttle", action)
+}
+
+type stallWriter struct {
+	http.ResponseWriter
+	s       *Server
+	req     *http.Request
+	timeout time.Duration
+	action  string
+
+	mu      sync.Mutex
+	stalled bool
+	backoff time.Duration
+}
+
+// guard runs write, reacting if it stays blocked longer than the timeout.
+func (w *stallWriter) guard(write func() error) error {
+	if w.backoff > 0 {
+		if err := sleep(w.req.Context(), w.backoff); err != nil {
+			return err
+		}
+	}
+	start := time.Now()
+	watchdog := time.AfterFunc(w.timeout, w.stall)
+	err := write()
+	watchdog.Stop()
+	if blocked := time.Since(start); w.action == StallThrottle {
+		w.backoff = 0
+		if blocked >= w.timeout {
+			w.backoff = blocked
+		}
+	}
+	return err
+}
+
+func (w *stallWriter) stall() {
+	w.mu.Lock()
+	first := !w.stalled
+	w.stalled = true
+	w.mu.Unlock()
+	if first {
+		w.s.stats.slowConsumers.Add(1)
+	}
+	w.s.logger.Info("slow consumer", zap.String("remote", w.req.RemoteAddr), zap.String("path", w.req.URL.Path), zap.Duration("blocked", w.timeout), zap.String("action", w.action))
+	if w.action == StallAbort {
+		_ = http.NewResponseController(w.ResponseWriter).SetWriteDeadline(time.Now())
+	}
+}
+
+func (w *stallWriter) Write(b []byte) (n int, err error) {
+	err = w.guard(func() error {
+		n, err = w.ResponseWriter.Write(b)
+		return err
+	})
+	return n, err
+}
+
+func (w *stallWriter) Flush() {
+	_ = w.guard(func() error {
+		return http.NewResponseController(w.ResponseWriter).Flush()
+	})
+}
+
+func (w *stallWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
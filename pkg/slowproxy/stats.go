@@ -0,0 +1,65 @@
+package slowproxy
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// Stats counts the requests seen by the capture middleware since start.
+// InFlight is a gauge of the requests being served right now. Seed is the
+// seed of the random decisions of the server, left out of fleet totals.
+type Stats struct {
+	Requests          int64 `json:"requests"`
+	InFlight          int64 `json:"in_flight"`
+	Aborted           int64 `json:"aborted"`
+	ClientDisconnects int64 `json:"client_disconnects"`
+	Shed              int64 `json:"shed"`
+	RefusedConns      int64 `json:"refused_conns"`
+	SlowConsumers     int64 `json:"slow_consumers"`
+	Hiccups           int64 `json:"hiccups"`
+	CapturesStored    int   `json:"captures_stored"`
+	CaptureBytes      int   `json:"capture_bytes"`
+	CapturesEvicted   int64 `json:"captures_evicted"`
+	PhasesEvicted     int64 `json:"phases_evicted"`
+	Seed              int64 `json:"seed,omitempty"`
+}
+
+type stats struct {
+	requests          atomic.Int64
+	inFlight          atomic.Int64
+	aborted           atomic.Int64
+	clientDisconnects atomic.Int64
+	shed              atomic.Int64
+	refusedConns      atomic.Int64
+	slowConsumers     atomic.Int64
+	hiccups           atomic.Int64
+	capturesEvicted   atomic.Int64
+	phasesEvicted     atomic.Int64
+}
+
+func (st *stats) snapshot() Stats {
+	return Stats{
+		Requests:          st.requests.Load(),
+		InFlight:          st.inFlight.Load(),
+		Aborted:           st.aborted.Load(),
+		ClientDisconnects: st.clientDisconnects.Load(),
+		Shed:              st.shed.Load(),
+		RefusedConns:      st.refusedConns.Load(),
+		SlowConsumers:     st.slowConsumers.Load(),
+		Hiccups:           st.hiccups.Load(),
+		CapturesEvicted:   st.capturesEvicted.Load(),
+		PhasesEvicted:     st.phasesEvicted.Load(),
+	}
+}
+
+// Stats returns the request counters and what the captures hold.
+func (s *Server) Stats() Stats {
+	st := s.stats.snapshot()
+	st.Seed = s.Seed()
+	st.CapturesStored, st.CaptureBytes = s.captures.usage()
+	return st
+}
+
+func (s *Server) getStats(rw http.ResponseWriter, req *http.Request) {
+	writeJSON(rw, http.StatusOK, s.Stats())
+}
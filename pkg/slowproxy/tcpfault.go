@@ -0,0 +1,92 @@
+package slowproxy
+
+import (
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"net"
+	"strings"
+)
+
+// TCP fault modes, served below the HTTP layer.
+const (
+	TCPHang      = "hang"      // accept, then never read or write
+	TCPClose     = "close"     // accept, then close with a FIN
+	TCPReset     = "reset"     // accept, then close with a RST
+	TCPBlackhole = "blackhole" // accept, shrink the receive window and never read
+	TCPNoAccept  = "noaccept"  // never accept, leaving connections in the backlog
+)
+
+// TCPFault is a raw listener on Addr misbehaving according to Mode.
+type TCPFault struct {
+	Mode string
+	Addr string
+}
+
+// TCPFaults is a flag.Value collecting repeated mode=addr flags.
+type TCPFaults []TCPFault
+
+func (f *TCPFaults) String() string {
+	parts := make([]string, 0, len(*f))
+	for _, fault := range *f {
+		parts = append(parts, fault.Mode+"="+fault.Addr)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f *TCPFaults) Set(v string) error {
+	mode, addr, ok := strings.Cut(v, "=")
+	if !ok {
+		return fmt.Errorf("expected mode=addr, got %q", v)
+	}
+	switch mode {
+	case TCPHang, TCPClose, TCPReset, TCPBlackhole, TCPNoAccept:
+	default:
+		return fmt.Errorf("unknown tcp fault mode %q", mode)
+	}
+	*f = append(*f, TCPFault{Mode: mode, Addr: addr})
+	return nil
+}
+
+// serveTCPFault accepts connections on ln and mistreats them according to
+// mode until ln is closed. Held connections are released on shutdown.
+func (s *Server) serveTCPFault(ln net.Listener, mode string) error {
+	logger := s.logger.With(zap.String("tcp_fault", mode), zap.String("addr", ln.Addr().String()))
+	if mode == TCPNoAccept {
+		<-s.ctx.Done()
+		return nil
+	}
+
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		logger.Debug("accepted connection", zap.String("remote", c.RemoteAddr().String()))
+
+		switch mode {
+		case TCPClose:
+			_ = c.Close()
+		case TCPReset:
+			if tc, ok := c.(*net.TCPConn); ok {
+				_ = tc.SetLinger(0)
+			}
+			_ = c.Close()
+		case TCPBlackhole:
+			if tc, ok := c.(*net.TCPConn); ok {
+				_ = tc.SetReadBuffer(1)
+			}
+			go s.hold(c)
+		default:
+			go s.hold(c)
+		}
+	}
+}
+
+func (s *Server) hold(c net.Conn) {
+	<-s.ctx.Done()
+	_ = c.Close()
+}
@@ -0,0 +1,131 @@
+package slowproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// TemplateData is what a Fault body template sees:
+//
+//	{"id": "{{.Header.Get "X-Id"}}", "n": {{randInt 1 100}}, "at": "{{.Now.Format "15:04:05"}}"}
+type TemplateData struct {
+	Method string
+	URL    *url.URL
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Host   string
+	Now    time.Time
+
+	req  *http.Request
+	body *string
+}
+
+// Body returns the request body, read on first use.
+func (d *TemplateData) Body() string {
+	if d.body == nil {
+		var b []byte
+		if d.req.Body != nil {
+			b, _ = io.ReadAll(io.LimitReader(d.req.Body, maxEchoBody))
+		}
+		s := string(b)
+		d.body = &s
+	}
+	return *d.body
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"default": func(def, v string) string {
+		if v == "" {
+			return def
+		}
+		return v
+	},
+}
+
+// randFuncs are the random helpers of templates drawing from r, bound at
+// execution as templates are cached across servers.
+func randFuncs(r *seededRand) template.FuncMap {
+	return template.FuncMap{
+		"randInt": func(lo, hi int) int {
+			if hi <= lo {
+				return lo
+			}
+			return lo + r.Intn(hi-lo+1)
+		},
+		"randFloat": r.Float64,
+		"randChoice": func(choices ...string) string {
+			if len(choices) == 0 {
+				return ""
+			}
+			return choices[r.Intn(len(choices))]
+		},
+		"uuid": func() string {
+			var b [16]byte
+			r.Read(b[:])
+			b[6] = b[6]&0x0f | 0x40
+			b[8] = b[8]&0x3f | 0x80
+			return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+		},
+	}
+}
+
+// templates caches parsed bodies, rules are matched far more often than set.
+var templates sync.Map
+
+func parseTemplate(text string) (*template.Template, error) {
+	if t, ok := templates.Load(text); ok {
+		return t.(*template.Template), nil
+	}
+	t, err := template.New("body").Funcs(templateFuncs).Funcs(randFuncs(defaultRand)).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	templates.Store(text, t)
+	return t, nil
+}
+
+// executeTemplate runs t with its random helpers drawing from r.
+func executeTemplate(t *template.Template, w io.Writer, data any, r *seededRand) error {
+	t, err := t.Clone()
+	if err != nil {
+		return err
+	}
+	return t.Funcs(randFuncs(r)).Execute(w, data)
+}
+
+// renderBody executes a Fault body template against req.
+func renderBody(text string, req *http.Request, r *seededRand) (string, error) {
+	t, err := parseTemplate(text)
+	if err != nil {
+		return "", err
+	}
+	data := &TemplateData{
+		Method: req.Method,
+		URL:    req.URL,
+		Path:   req.URL.Path,
+		Query:  req.URL.Query(),
+		Header: req.Header,
+		Host:   req.Host,
+		Now:    time.Now(),
+		req:    req,
+	}
+	var b strings.Builder
+	if err := executeTemplate(t, &b, data, r); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
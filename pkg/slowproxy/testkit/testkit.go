@@ -0,0 +1,96 @@
+// Package testkit holds assertions for tests running against slow-proxy.
+package testkit
+
+import (
+	"fmt"
+	"github.com/cbosss/slow-proxy/pkg/slowproxy"
+	"testing"
+	"time"
+)
+
+// CaptureSource is anything recording requests, such as *slowproxy.Server.
+type CaptureSource interface {
+	Captures() []slowproxy.Capture
+}
+
+// ExpectDuration fails the test unless fn returns within [min, max].
+func ExpectDuration(t testing.TB, min, max time.Duration, fn func()) time.Duration {
+	t.Helper()
+	start := time.Now()
+	fn()
+	took := time.Since(start)
+	if took < min || took > max {
+		t.Errorf("took %s, expected between %s and %s", took, min, max)
+	}
+	return took
+}
+
+// Requests returns the captured requests selected by m.
+func Requests(src CaptureSource, m slowproxy.Matcher) []slowproxy.Capture {
+	var out []slowproxy.Capture
+	for _, c := range src.Captures() {
+		if m.MatchesCapture(c) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// ExpectRequests fails the test unless exactly n captured requests match m.
+func ExpectRequests(t testing.TB, src CaptureSource, m slowproxy.Matcher, n int) []slowproxy.Capture {
+	t.Helper()
+	got := Requests(src, m)
+	if len(got) != n {
+		t.Errorf("%s: got %d requests, expected %d", describe(m), len(got), n)
+	}
+	return got
+}
+
+// ExpectRetries fails the test unless the client sent the request matching m
+// once and then retried it exactly retries times.
+func ExpectRetries(t testing.TB, src CaptureSource, m slowproxy.Matcher, retries int) []slowproxy.Capture {
+	t.Helper()
+	got := Requests(src, m)
+	if len(got) != retries+1 {
+		t.Errorf("%s: got %d retries, expected %d", describe(m), len(got)-1, retries)
+	}
+	return got
+}
+
+// WaitForRequests polls until at least n requests match m, failing the test
+// once timeout elapses. Use it when a client retries in the background.
+func WaitForRequests(t testing.TB, src CaptureSource, m slowproxy.Matcher, n int, timeout time.Duration) []slowproxy.Capture {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		got := Requests(src, m)
+		if len(got) >= n {
+			return got
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: got %d requests after %s, expected %d", describe(m), len(got), timeout, n)
+			return got
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// ExpectBackoff fails the test unless every gap between consecutive matching
+// requests is at least min, e.g. to verify a client backs off between retries.
+func ExpectBackoff(t testing.TB, src CaptureSource, m slowproxy.Matcher, min time.Duration) {
+	t.Helper()
+	got := Requests(src, m)
+	for i := 1; i < len(got); i++ {
+		if gap := got[i].Time.Sub(got[i-1].Time); gap < min {
+			t.Errorf("%s: retry %d after %s, expected at least %s", describe(m), i, gap, min)
+		}
+	}
+}
+
+func describe(m slowproxy.Matcher) string {
+	method := m.Method
+	if method == "" {
+		method = "*"
+	}
+	return fmt.Sprintf("%s %s*", method, m.Path)
+}
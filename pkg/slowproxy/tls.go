@@ -0,0 +1,391 @@
+package slowproxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// certStore holds the serving certificate. New handshakes pick up reloaded or
+// rotated certificates while established connections keep the old one.
+type certStore struct {
+	logger   *zap.Logger
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	caPEM   []byte
+	modTime time.Time
+}
+
+// ErrTLSMaterial is returned by New when the TLSCert or TLSKey files are
+// missing or do not hold a usable key pair.
+var ErrTLSMaterial = errors.New("cannot load TLS certificate")
+
+func newCertStore(logger *zap.Logger, certFile, keyFile string) (*certStore, error) {
+	c := &certStore{logger: logger, certFile: certFile, keyFile: keyFile}
+	if certFile == "" {
+		return c, c.rotateCA()
+	}
+	return c, c.reload()
+}
+
+func (c *certStore) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// reload reads the certificate and key from disk.
+func (c *certStore) reload() error {
+	if c.certFile == "" {
+		return errors.New("no certificate files configured, started with a self-signed CA")
+	}
+	info, err := os.Stat(c.certFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.cert, c.modTime = &cert, info.ModTime()
+	c.mu.Unlock()
+	c.logger.Info("loaded tls certificate", zap.String("cert", c.certFile))
+	return nil
+}
+
+// watch reloads the certificate whenever the file on disk changes.
+func (c *certStore) watch(done <-chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			info, err := os.Stat(c.certFile)
+			if err != nil {
+				continue
+			}
+			c.mu.RLock()
+			changed := info.ModTime().After(c.modTime)
+			c.mu.RUnlock()
+			if !changed {
+				continue
+			}
+			if err := c.reload(); err != nil {
+				c.logger.With(zap.Error(err)).Warn("failed to reload tls certificate")
+			}
+		}
+	}
+}
+
+// rotateCA generates a brand new CA and a leaf certificate signed by it, so
+// clients that pinned or cached the previous CA start failing verification.
+func (c *certStore) rotateCA() error {
+	now := time.Now()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          serialNumber(),
+		Subject:               pkix.Name{CommonName: "slow-proxy CA " + now.Format(time.RFC3339)},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return err
+	}
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	leafTmpl := &x509.Certificate{
+		SerialNumber: serialNumber(),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if host, err := os.Hostname(); err == nil {
+		leafTmpl.DNSNames = append(leafTmpl.DNSNames, host)
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTmpl, ca, &leafKey.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(leafDER)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.cert = &tls.Certificate{Certificate: [][]byte{leafDER, caDER}, PrivateKey: leafKey, Leaf: leaf}
+	c.caPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	c.mu.Unlock()
+	c.logger.Info("rotated tls ca", zap.String("ca", ca.Subject.CommonName))
+	return nil
+}
+
+func (c *certStore) rotateEvery(done <-chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := c.rotateCA(); err != nil {
+				c.logger.With(zap.Error(err)).Warn("failed to rotate tls ca")
+			}
+		}
+	}
+}
+
+type certInfo struct {
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	Serial      string    `json:"serial"`
+	NotAfter    time.Time `json:"not_after"`
+	Fingerprint string    `json:"sha256"`
+	SelfSigned  bool      `json:"self_signed"`
+}
+
+func (c *certStore) info() (certInfo, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	leaf := c.cert.Leaf
+	if leaf == nil {
+		var err error
+		if leaf, err = x509.ParseCertificate(c.cert.Certificate[0]); err != nil {
+			return certInfo{}, err
+		}
+	}
+	sum := sha256.Sum256(leaf.Raw)
+	return certInfo{
+		Subject:     leaf.Subject.String(),
+		Issuer:      leaf.Issuer.String(),
+		Serial:      leaf.SerialNumber.Text(16),
+		NotAfter:    leaf.NotAfter,
+		Fingerprint: hex.EncodeToString(sum[:]),
+		SelfSigned:  c.caPEM != nil,
+	}, nil
+}
+
+func serialNumber() *big.Int {
+	n, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// tlsSessions controls session resumption. Go servers never support
+// renegotiation, so there is nothing to toggle for it.
+type tlsSessions struct {
+	mu       sync.Mutex
+	disabled bool
+	reject   bool
+	keys     [][32]byte
+
+	handshakes atomic.Int64
+	resumed    atomic.Int64
+}
+
+func newTLSSessions(disabled, reject bool) *tlsSessions {
+	t := &tlsSessions{disabled: disabled, reject: reject}
+	t.rotate(false)
+	return t
+}
+
+// rotate installs a new ticket key, keeping the previous one to decrypt
+// tickets already handed out unless keep is false.
+func (t *tlsSessions) rotate(keep bool) {
+	var key [32]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		panic(err)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if keep && len(t.keys) > 0 {
+		t.keys = [][32]byte{key, t.keys[0]}
+	} else {
+		t.keys = [][32]byte{key}
+	}
+}
+
+func (t *tlsSessions) rotateEvery(done <-chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			t.rotate(true)
+		}
+	}
+}
+
+type tlsSessionState struct {
+	Tickets          bool  `json:"tickets"`
+	RejectResumption bool  `json:"reject_resumption"`
+	Keys             int   `json:"keys"`
+	Handshakes       int64 `json:"handshakes"`
+	Resumed          int64 `json:"resumed"`
+}
+
+func (t *tlsSessions) state() tlsSessionState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return tlsSessionState{
+		Tickets:          !t.disabled,
+		RejectResumption: t.reject,
+		Keys:             len(t.keys),
+		Handshakes:       t.handshakes.Load(),
+		Resumed:          t.resumed.Load(),
+	}
+}
+
+// tlsConfig builds the configuration per handshake so session settings
+// changed through the admin API apply to the next connection.
+func (s *Server) tlsConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: s.certs.getCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			t := s.sessions
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			cfg := &tls.Config{
+				GetCertificate:         s.certs.getCertificate,
+				NextProtos:             []string{"h2", "http/1.1"},
+				SessionTicketsDisabled: t.disabled,
+				VerifyConnection: func(cs tls.ConnectionState) error {
+					t.handshakes.Add(1)
+					if cs.DidResume {
+						t.resumed.Add(1)
+					}
+					return nil
+				},
+			}
+			cfg.SetSessionTicketKeys(t.keys)
+			if t.reject {
+				// ignoring the ticket forces a full handshake
+				cfg.UnwrapSession = func([]byte, tls.ConnectionState) (*tls.SessionState, error) {
+					return nil, nil
+				}
+			}
+			return cfg, nil
+		},
+	}
+}
+
+func (s *Server) getTLS(rw http.ResponseWriter, req *http.Request) {
+	info, err := s.certs.info()
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(rw, http.StatusOK, info)
+}
+
+func (s *Server) reloadTLS(rw http.ResponseWriter, req *http.Request) {
+	if err := s.certs.reload(); err != nil {
+		writeError(rw, http.StatusConflict, err)
+		return
+	}
+	s.getTLS(rw, req)
+}
+
+func (s *Server) rotateTLSCA(rw http.ResponseWriter, req *http.Request) {
+	if err := s.certs.rotateCA(); err != nil {
+		writeError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	s.getTLS(rw, req)
+}
+
+func (s *Server) getTLSCA(rw http.ResponseWriter, req *http.Request) {
+	s.certs.mu.RLock()
+	caPEM := s.certs.caPEM
+	s.certs.mu.RUnlock()
+	if caPEM == nil {
+		writeError(rw, http.StatusNotFound, fmt.Errorf("serving %s, no generated ca", s.certs.certFile))
+		return
+	}
+	rw.Header().Set("Content-Type", "application/x-pem-file")
+	_, _ = rw.Write(caPEM)
+}
+
+func (s *Server) getTLSSessions(rw http.ResponseWriter, req *http.Request) {
+	writeJSON(rw, http.StatusOK, s.sessions.state())
+}
+
+// setTLSSessions updates resumption behaviour from query parameters:
+//
+//	PUT /admin/tls/sessions?tickets=false&reject_resumption=true&rotate=keep|drop
+func (s *Server) setTLSSessions(rw http.ResponseWriter, req *http.Request) {
+	current := s.sessions.state()
+	tickets, err := boolQuery(req, "tickets", current.Tickets)
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	reject, err := boolQuery(req, "reject_resumption", current.RejectResumption)
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	s.sessions.mu.Lock()
+	s.sessions.disabled = !tickets
+	s.sessions.reject = reject
+	s.sessions.mu.Unlock()
+
+	rotate := req.URL.Query().Get("rotate")
+	switch rotate {
+	case "":
+	case "keep":
+		s.sessions.rotate(true)
+	case "drop":
+		s.sessions.rotate(false)
+	default:
+		writeError(rw, http.StatusBadRequest, fmt.Errorf("invalid rotate %q, expected keep or drop", rotate))
+		return
+	}
+	s.logger.Info("updated tls session settings", zap.Any("sessions", s.sessions.state()))
+	s.getTLSSessions(rw, req)
+}
@@ -0,0 +1,657 @@
+package slowproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Toxic types of the Toxiproxy API, each with its attributes in
+// milliseconds, or KB/s for the rate.
+const (
+	ToxicLatency   = "latency"    // latency, jitter: delay every chunk
+	ToxicBandwidth = "bandwidth"  // rate: throttle the stream
+	ToxicSlowClose = "slow_close" // delay: hold the close once the peer is done
+	ToxicTimeout   = "timeout"    // timeout: drop the data, close after timeout, never when 0
+)
+
+var toxicAttributes = map[string][]string{
+	ToxicLatency:   {"latency", "jitter"},
+	ToxicBandwidth: {"rate"},
+	ToxicSlowClose: {"delay"},
+	ToxicTimeout:   {"timeout"},
+}
+
+// toxiproxy serves the Toxiproxy HTTP API, so suites driving toxiproxy
+// through its clients run against slow-proxy unchanged. Its proxies are the
+// same raw TCP relays with toxics on either stream, pointing one at the HTTP
+// listener adds the HTTP faults behind them.
+type toxiproxy struct {
+	s *Server
+
+	mu      sync.Mutex
+	proxies map[string]*toxiProxy
+}
+
+type toxiProxy struct {
+	Name     string   `json:"name"`
+	Listen   string   `json:"listen"`
+	Upstream string   `json:"upstream"`
+	Enabled  bool     `json:"enabled"`
+	Toxics   []*toxic `json:"toxics"`
+
+	ln    net.Listener
+	links map[*toxiLink]struct{}
+}
+
+type toxic struct {
+	Name       string           `json:"name"`
+	Type       string           `json:"type"`
+	Stream     string           `json:"stream"`
+	Toxicity   float64          `json:"toxicity"`
+	Attributes map[string]int64 `json:"attributes"`
+}
+
+// toxiLink is a client connection and its upstream one. Toxicity is rolled
+// once per link and toxic, the time it was first seen starts a timeout.
+type toxiLink struct {
+	client, upstream net.Conn
+	rolls            map[*toxic]toxicRoll
+	once             sync.Once
+	done             chan struct{}
+}
+
+type toxicRoll struct {
+	apply bool
+	since time.Time
+}
+
+func (l *toxiLink) close() {
+	l.once.Do(func() {
+		close(l.done)
+		l.client.Close()
+		l.upstream.Close()
+	})
+}
+
+func newToxiproxy(s *Server) *toxiproxy {
+	return &toxiproxy{s: s, proxies: map[string]*toxiProxy{}}
+}
+
+// toxiError is the error body of the Toxiproxy API.
+func toxiError(rw http.ResponseWriter, status int, format string, args ...any) {
+	writeJSON(rw, status, map[string]any{"error": fmt.Sprintf(format, args...), "status": status})
+}
+
+func (t *toxiproxy) handler() http.Handler {
+	r := mux.NewRouter()
+	r.HandleFunc("/version", t.version).Methods(http.MethodGet)
+	r.HandleFunc("/reset", t.reset).Methods(http.MethodPost)
+	r.HandleFunc("/populate", t.populate).Methods(http.MethodPost)
+	r.HandleFunc("/proxies", t.listProxies).Methods(http.MethodGet)
+	r.HandleFunc("/proxies", t.createProxy).Methods(http.MethodPost)
+	r.HandleFunc("/proxies/{proxy}", t.getProxy).Methods(http.MethodGet)
+	r.HandleFunc("/proxies/{proxy}", t.updateProxy).Methods(http.MethodPost, http.MethodPatch)
+	r.HandleFunc("/proxies/{proxy}", t.deleteProxy).Methods(http.MethodDelete)
+	r.HandleFunc("/proxies/{proxy}/toxics", t.listToxics).Methods(http.MethodGet)
+	r.HandleFunc("/proxies/{proxy}/toxics", t.createToxic).Methods(http.MethodPost)
+	r.HandleFunc("/proxies/{proxy}/toxics/{toxic}", t.getToxic).Methods(http.MethodGet)
+	r.HandleFunc("/proxies/{proxy}/toxics/{toxic}", t.updateToxic).Methods(http.MethodPost, http.MethodPatch)
+	r.HandleFunc("/proxies/{proxy}/toxics/{toxic}", t.deleteToxic).Methods(http.MethodDelete)
+	return r
+}
+
+// start listens for p and relays its connections, p.Listen becomes the
+// bound address.
+func (t *toxiproxy) start(p *toxiProxy) error {
+	ln, err := net.Listen("tcp", p.Listen)
+	if err != nil {
+		return err
+	}
+	p.ln, p.Listen = ln, ln.Addr().String()
+	t.s.logger.Info("started toxiproxy proxy", zap.String("proxy", p.Name), zap.String("listen", p.Listen), zap.String("upstream", p.Upstream))
+	go t.accept(p, ln)
+	return nil
+}
+
+// stop closes the listener of p and every link it relays.
+func (t *toxiproxy) stop(p *toxiProxy) {
+	if p.ln != nil {
+		p.ln.Close()
+		p.ln = nil
+	}
+	for l := range p.links {
+		l.close()
+	}
+	p.links = map[*toxiLink]struct{}{}
+}
+
+// closeAll stops every proxy on shutdown.
+func (t *toxiproxy) closeAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, p := range t.proxies {
+		t.stop(p)
+	}
+}
+
+func (t *toxiproxy) accept(p *toxiProxy, ln net.Listener) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go t.relay(p, ln, c)
+	}
+}
+
+func (t *toxiproxy) relay(p *toxiProxy, ln net.Listener, c net.Conn) {
+	t.mu.Lock()
+	upstream := p.Upstream
+	t.mu.Unlock()
+	up, err := net.DialTimeout("tcp", upstream, 5*time.Second)
+	if err != nil {
+		t.s.logger.Warn("toxiproxy upstream unreachable", zap.String("proxy", p.Name), zap.String("upstream", upstream), zap.Error(err))
+		c.Close()
+		return
+	}
+	l := &toxiLink{client: c, upstream: up, rolls: map[*toxic]toxicRoll{}, done: make(chan struct{})}
+	t.mu.Lock()
+	if p.ln != ln {
+		// stopped or restarted while dialing
+		t.mu.Unlock()
+		l.close()
+		return
+	}
+	p.links[l] = struct{}{}
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		delete(p.links, l)
+		t.mu.Unlock()
+	}()
+
+	go t.pipe(p, l, up, c, "upstream")
+	go t.pipe(p, l, c, up, "downstream")
+	t.watchTimeouts(p, l)
+}
+
+// activeToxic is a copy of a toxic applying to a link, since it first saw it.
+// Its attributes are copied too, updates write them under t.mu while the
+// pipes read the copy without it.
+type activeToxic struct {
+	toxic
+	since time.Time
+}
+
+// active returns the toxics of p on stream that apply to l.
+func (t *toxiproxy) active(p *toxiProxy, l *toxiLink, stream string) []activeToxic {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var active []activeToxic
+	for _, tx := range p.Toxics {
+		if tx.Stream != stream {
+			continue
+		}
+		roll, ok := l.rolls[tx]
+		if !ok {
+			roll = toxicRoll{apply: t.s.rand.Float64() < tx.Toxicity, since: time.Now()}
+			l.rolls[tx] = roll
+		}
+		if roll.apply {
+			active = append(active, activeToxic{toxic: *tx.copy(), since: roll.since})
+		}
+	}
+	return active
+}
+
+// pipe copies src to dst through the toxics of stream, then closes the link.
+func (t *toxiproxy) pipe(p *toxiProxy, l *toxiLink, dst, src net.Conn, stream string) {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 && !t.deliver(p, l, dst, buf[:n], stream) {
+			break
+		}
+		if err != nil {
+			break
+		}
+	}
+	for _, tx := range t.active(p, l, stream) {
+		if tx.Type == ToxicSlowClose {
+			t.wait(l, time.Duration(tx.Attributes["delay"])*time.Millisecond)
+		}
+	}
+	l.close()
+}
+
+// deliver writes b to dst once the toxics let it through, false when the
+// link is gone.
+func (t *toxiproxy) deliver(p *toxiProxy, l *toxiLink, dst net.Conn, b []byte, stream string) bool {
+	var delay time.Duration
+	for _, tx := range t.active(p, l, stream) {
+		switch tx.Type {
+		case ToxicTimeout:
+			return true
+		case ToxicLatency:
+			ms := float64(tx.Attributes["latency"])
+			if jitter := float64(tx.Attributes["jitter"]); jitter > 0 {
+				ms += jitter * (2*t.s.rand.Float64() - 1)
+			}
+			delay += time.Duration(ms * float64(time.Millisecond))
+		case ToxicBandwidth:
+			if rate := tx.Attributes["rate"]; rate > 0 {
+				delay += time.Duration(len(b)) * time.Second / time.Duration(rate*1024)
+			}
+		}
+	}
+	if !t.wait(l, delay) {
+		return false
+	}
+	_, err := dst.Write(b)
+	return err == nil
+}
+
+// wait pauses for d, false when the link or the server is closed first.
+func (t *toxiproxy) wait(l *toxiLink, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-l.done:
+	case <-t.s.ctx.Done():
+	}
+	return false
+}
+
+// watchTimeouts closes l once a timeout toxic has applied to it for its
+// timeout, until the link is closed.
+func (t *toxiproxy) watchTimeouts(p *toxiProxy, l *toxiLink) {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-t.s.ctx.Done():
+			l.close()
+			return
+		case now := <-ticker.C:
+			for _, stream := range []string{"upstream", "downstream"} {
+				for _, tx := range t.active(p, l, stream) {
+					ms := tx.Attributes["timeout"]
+					if tx.Type != ToxicTimeout || ms <= 0 {
+						continue
+					}
+					if now.Sub(tx.since) >= time.Duration(ms)*time.Millisecond {
+						l.close()
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+func (t *toxiproxy) toxicOf(p *toxiProxy, name string) *toxic {
+	for _, tx := range p.Toxics {
+		if tx.Name == name {
+			return tx
+		}
+	}
+	return nil
+}
+
+// view copies p for encoding outside the lock.
+func (p *toxiProxy) view() toxiProxy {
+	v := toxiProxy{Name: p.Name, Listen: p.Listen, Upstream: p.Upstream, Enabled: p.Enabled, Toxics: []*toxic{}}
+	for _, tx := range p.Toxics {
+		v.Toxics = append(v.Toxics, tx.copy())
+	}
+	return v
+}
+
+func (tx *toxic) copy() *toxic {
+	c := *tx
+	c.Attributes = make(map[string]int64, len(tx.Attributes))
+	for k, v := range tx.Attributes {
+		c.Attributes[k] = v
+	}
+	return &c
+}
+
+func (t *toxiproxy) version(rw http.ResponseWriter, req *http.Request) {
+	writeJSON(rw, http.StatusOK, map[string]string{"version": Build().Version})
+}
+
+// reset enables every proxy and removes every toxic.
+func (t *toxiproxy) reset(rw http.ResponseWriter, req *http.Request) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, p := range t.proxies {
+		p.Toxics = nil
+		if !p.Enabled {
+			if err := t.start(p); err != nil {
+				toxiError(rw, http.StatusInternalServerError, "proxy %s: %v", p.Name, err)
+				return
+			}
+			p.Enabled = true
+		}
+	}
+	t.s.logger.Info("toxiproxy reset")
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (t *toxiproxy) listProxies(rw http.ResponseWriter, req *http.Request) {
+	t.mu.Lock()
+	proxies := make(map[string]toxiProxy, len(t.proxies))
+	for name, p := range t.proxies {
+		proxies[name] = p.view()
+	}
+	t.mu.Unlock()
+	writeJSON(rw, http.StatusOK, proxies)
+}
+
+// proxyRequest is the body creating or updating a proxy, enabled unless
+// told otherwise.
+type proxyRequest struct {
+	Name     string `json:"name"`
+	Listen   string `json:"listen"`
+	Upstream string `json:"upstream"`
+	Enabled  *bool  `json:"enabled"`
+}
+
+// add creates the proxy of r, the status and message tell why it failed.
+func (t *toxiproxy) add(r proxyRequest) (*toxiProxy, int, error) {
+	switch {
+	case r.Name == "":
+		return nil, http.StatusBadRequest, fmt.Errorf("missing required field: name")
+	case r.Upstream == "":
+		return nil, http.StatusBadRequest, fmt.Errorf("missing required field: upstream")
+	case t.proxies[r.Name] != nil:
+		return nil, http.StatusConflict, fmt.Errorf("proxy already exists")
+	}
+	p := &toxiProxy{Name: r.Name, Listen: r.Listen, Upstream: r.Upstream, Enabled: r.Enabled == nil || *r.Enabled, links: map[*toxiLink]struct{}{}}
+	if p.Listen == "" {
+		p.Listen = "localhost:0"
+	}
+	if p.Enabled {
+		if err := t.start(p); err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+	}
+	t.proxies[p.Name] = p
+	return p, 0, nil
+}
+
+func (t *toxiproxy) createProxy(rw http.ResponseWriter, req *http.Request) {
+	var r proxyRequest
+	if err := json.NewDecoder(req.Body).Decode(&r); err != nil {
+		toxiError(rw, http.StatusBadRequest, "bad request body: %v", err)
+		return
+	}
+	t.mu.Lock()
+	p, status, err := t.add(r)
+	var v toxiProxy
+	if err == nil {
+		v = p.view()
+	}
+	t.mu.Unlock()
+	if err != nil {
+		toxiError(rw, status, "%v", err)
+		return
+	}
+	writeJSON(rw, http.StatusCreated, v)
+}
+
+// populate creates the proxies of a list, replacing those with the same
+// name unless they listen and relay alike.
+func (t *toxiproxy) populate(rw http.ResponseWriter, req *http.Request) {
+	var rs []proxyRequest
+	if err := json.NewDecoder(req.Body).Decode(&rs); err != nil {
+		toxiError(rw, http.StatusBadRequest, "bad request body: %v", err)
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var views []toxiProxy
+	for _, r := range rs {
+		if p := t.proxies[r.Name]; p != nil {
+			if p.Upstream == r.Upstream && (r.Listen == "" || p.Listen == r.Listen) && p.Enabled == (r.Enabled == nil || *r.Enabled) {
+				views = append(views, p.view())
+				continue
+			}
+			t.stop(p)
+			delete(t.proxies, r.Name)
+		}
+		p, status, err := t.add(r)
+		if err != nil {
+			toxiError(rw, status, "proxy %s: %v", r.Name, err)
+			return
+		}
+		views = append(views, p.view())
+	}
+	writeJSON(rw, http.StatusCreated, map[string][]toxiProxy{"proxies": views})
+}
+
+// proxy looks up the proxy of the request under t.mu, answering 404 when
+// there is none.
+func (t *toxiproxy) proxy(rw http.ResponseWriter, req *http.Request) (*toxiProxy, bool) {
+	p := t.proxies[mux.Vars(req)["proxy"]]
+	if p == nil {
+		toxiError(rw, http.StatusNotFound, "proxy not found")
+		return nil, false
+	}
+	return p, true
+}
+
+func (t *toxiproxy) getProxy(rw http.ResponseWriter, req *http.Request) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if p, ok := t.proxy(rw, req); ok {
+		writeJSON(rw, http.StatusOK, p.view())
+	}
+}
+
+// updateProxy changes the listen address, the upstream or whether the
+// proxy is enabled, disabling it drops its connections.
+func (t *toxiproxy) updateProxy(rw http.ResponseWriter, req *http.Request) {
+	var r proxyRequest
+	if err := json.NewDecoder(req.Body).Decode(&r); err != nil && err != io.EOF {
+		toxiError(rw, http.StatusBadRequest, "bad request body: %v", err)
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.proxy(rw, req)
+	if !ok {
+		return
+	}
+	enabled := p.Enabled
+	if r.Enabled != nil {
+		enabled = *r.Enabled
+	}
+	restart := (r.Listen != "" && r.Listen != p.Listen) || (r.Upstream != "" && r.Upstream != p.Upstream)
+	if p.Enabled && (!enabled || restart) {
+		t.stop(p)
+		p.Enabled = false
+		t.s.logger.Info("stopped toxiproxy proxy", zap.String("proxy", p.Name))
+	}
+	if r.Listen != "" {
+		p.Listen = r.Listen
+	}
+	if r.Upstream != "" {
+		p.Upstream = r.Upstream
+	}
+	if enabled && !p.Enabled {
+		if err := t.start(p); err != nil {
+			toxiError(rw, http.StatusInternalServerError, "%v", err)
+			return
+		}
+		p.Enabled = true
+	}
+	writeJSON(rw, http.StatusOK, p.view())
+}
+
+func (t *toxiproxy) deleteProxy(rw http.ResponseWriter, req *http.Request) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.proxy(rw, req)
+	if !ok {
+		return
+	}
+	t.stop(p)
+	delete(t.proxies, p.Name)
+	t.s.logger.Info("deleted toxiproxy proxy", zap.String("proxy", p.Name))
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (t *toxiproxy) listToxics(rw http.ResponseWriter, req *http.Request) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if p, ok := t.proxy(rw, req); ok {
+		writeJSON(rw, http.StatusOK, p.view().Toxics)
+	}
+}
+
+// toxicRequest is the body creating or updating a toxic, attributes left
+// out keep their value, 0 for a new toxic.
+type toxicRequest struct {
+	Name       string             `json:"name"`
+	Type       string             `json:"type"`
+	Stream     string             `json:"stream"`
+	Toxicity   *float64           `json:"toxicity"`
+	Attributes map[string]float64 `json:"attributes"`
+}
+
+func (r toxicRequest) apply(tx *toxic) {
+	if r.Toxicity != nil {
+		tx.Toxicity = *r.Toxicity
+	}
+	for _, name := range toxicAttributes[tx.Type] {
+		if v, ok := r.Attributes[name]; ok {
+			tx.Attributes[name] = int64(v)
+		}
+	}
+}
+
+// createToxic adds a toxic to the downstream stream unless told otherwise,
+// named after its type and stream by default.
+func (t *toxiproxy) createToxic(rw http.ResponseWriter, req *http.Request) {
+	var r toxicRequest
+	if err := json.NewDecoder(req.Body).Decode(&r); err != nil {
+		toxiError(rw, http.StatusBadRequest, "bad request body: %v", err)
+		return
+	}
+	if r.Stream == "" {
+		r.Stream = "downstream"
+	}
+	switch {
+	case toxicAttributes[r.Type] == nil:
+		toxiError(rw, http.StatusBadRequest, "Bad toxic type: %s", r.Type)
+		return
+	case r.Stream != "upstream" && r.Stream != "downstream":
+		toxiError(rw, http.StatusBadRequest, "Invalid toxic stream: %s", r.Stream)
+		return
+	case r.Toxicity != nil && (*r.Toxicity < 0 || *r.Toxicity > 1):
+		toxiError(rw, http.StatusBadRequest, "Invalid toxicity: %g", *r.Toxicity)
+		return
+	}
+	if r.Name == "" {
+		r.Name = r.Type + "_" + r.Stream
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.proxy(rw, req)
+	if !ok {
+		return
+	}
+	if t.toxicOf(p, r.Name) != nil {
+		toxiError(rw, http.StatusConflict, "toxic already exists")
+		return
+	}
+	tx := &toxic{Name: r.Name, Type: r.Type, Stream: r.Stream, Toxicity: 1, Attributes: map[string]int64{}}
+	for _, name := range toxicAttributes[tx.Type] {
+		tx.Attributes[name] = 0
+	}
+	r.apply(tx)
+	p.Toxics = append(p.Toxics, tx)
+	t.s.logger.Info("added toxic", zap.String("proxy", p.Name), zap.String("toxic", tx.Name), zap.String("type", tx.Type), zap.String("stream", tx.Stream))
+	writeJSON(rw, http.StatusOK, tx.copy())
+}
+
+// toxic looks up the toxic of the request under t.mu, answering 404 when
+// there is none.
+func (t *toxiproxy) toxic(rw http.ResponseWriter, req *http.Request) (*toxiProxy, *toxic, bool) {
+	p, ok := t.proxy(rw, req)
+	if !ok {
+		return nil, nil, false
+	}
+	tx := t.toxicOf(p, mux.Vars(req)["toxic"])
+	if tx == nil {
+		toxiError(rw, http.StatusNotFound, "toxic not found")
+		return nil, nil, false
+	}
+	return p, tx, true
+}
+
+func (t *toxiproxy) getToxic(rw http.ResponseWriter, req *http.Request) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, tx, ok := t.toxic(rw, req); ok {
+		writeJSON(rw, http.StatusOK, tx.copy())
+	}
+}
+
+// updateToxic changes the toxicity or attributes of a toxic, connections
+// already relayed see the change on their next chunk.
+func (t *toxiproxy) updateToxic(rw http.ResponseWriter, req *http.Request) {
+	var r toxicRequest
+	if err := json.NewDecoder(req.Body).Decode(&r); err != nil {
+		toxiError(rw, http.StatusBadRequest, "bad request body: %v", err)
+		return
+	}
+	if r.Toxicity != nil && (*r.Toxicity < 0 || *r.Toxicity > 1) {
+		toxiError(rw, http.StatusBadRequest, "Invalid toxicity: %g", *r.Toxicity)
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, tx, ok := t.toxic(rw, req)
+	if !ok {
+		return
+	}
+	r.apply(tx)
+	t.s.logger.Info("updated toxic", zap.String("proxy", p.Name), zap.String("toxic", tx.Name))
+	writeJSON(rw, http.StatusOK, tx.copy())
+}
+
+func (t *toxiproxy) deleteToxic(rw http.ResponseWriter, req *http.Request) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, tx, ok := t.toxic(rw, req)
+	if !ok {
+		return
+	}
+	for i, other := range p.Toxics {
+		if other == tx {
+			p.Toxics = append(p.Toxics[:i:i], p.Toxics[i+1:]...)
+			break
+		}
+	}
+	for l := range p.links {
+		delete(l.rolls, tx)
+	}
+	t.s.logger.Info("removed toxic", zap.String("proxy", p.Name), zap.String("toxic", tx.Name))
+	rw.WriteHeader(http.StatusNoContent)
+}
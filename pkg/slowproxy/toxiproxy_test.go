@@ -0,0 +1,124 @@
+package slowproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func echoServer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func toxiPost(t *testing.T, url, body string, v any) {
+	t.Helper()
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(resp.Body)
+		t.Errorf("POST %s: %d %s", url, resp.StatusCode, b)
+		return
+	}
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// TestToxiproxyUpdateUnderLoad changes toxics while links relay through
+// them, run it with -race.
+func TestToxiproxyUpdateUnderLoad(t *testing.T) {
+	s := newTestServer(t)
+	api := httptest.NewServer(s.toxiproxy.handler())
+	defer api.Close()
+	defer s.toxiproxy.closeAll()
+
+	var p toxiProxy
+	toxiPost(t, api.URL+"/proxies", fmt.Sprintf(`{"name": "echo", "upstream": %q}`, echoServer(t)), &p)
+	toxiPost(t, api.URL+"/proxies/echo/toxics", `{"type": "latency", "attributes": {"latency": 1, "jitter": 1}}`, nil)
+	toxiPost(t, api.URL+"/proxies/echo/toxics", `{"type": "bandwidth", "stream": "upstream", "attributes": {"rate": 10000}}`, nil)
+	if t.Failed() {
+		t.FailNow()
+	}
+
+	done := make(chan struct{})
+	var updates sync.WaitGroup
+	updates.Add(1)
+	go func() {
+		defer updates.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			toxiPost(t, api.URL+"/proxies/echo/toxics/latency_downstream", fmt.Sprintf(`{"attributes": {"latency": %d, "jitter": %d}}`, i%3, i%2), nil)
+			toxiPost(t, api.URL+"/proxies/echo/toxics/bandwidth_upstream", fmt.Sprintf(`{"toxicity": 1, "attributes": {"rate": %d}}`, 10000+i), nil)
+		}
+	}()
+
+	var clients sync.WaitGroup
+	for c := range 8 {
+		clients.Add(1)
+		go func() {
+			defer clients.Done()
+			conn, err := net.Dial("tcp", p.Listen)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(10 * time.Second))
+			msg := []byte(fmt.Sprintf("client %d says hello", c))
+			got := make([]byte, len(msg))
+			for range 50 {
+				if _, err := conn.Write(msg); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := io.ReadFull(conn, got); err != nil {
+					t.Error(err)
+					return
+				}
+				if !bytes.Equal(got, msg) {
+					t.Errorf("echoed %q, want %q", got, msg)
+					return
+				}
+			}
+		}()
+	}
+	clients.Wait()
+	close(done)
+	updates.Wait()
+}
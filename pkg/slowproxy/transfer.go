@@ -0,0 +1,95 @@
+package slowproxy
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+)
+
+// transferMode forces the framing of any response with ?transfer=chunked or
+// ?transfer=length. Length mode buffers the whole body, so a slow response
+// arrives in one piece once the handler has finished.
+func transferMode(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Query().Get("transfer") {
+		case "chunked":
+			next.ServeHTTP(&chunkedWriter{ResponseWriter: rw}, req)
+		case "length":
+			w := &lengthWriter{ResponseWriter: rw, status: http.StatusOK}
+			next.ServeHTTP(w, req)
+			w.finish()
+		default:
+			next.ServeHTTP(rw, req)
+		}
+	})
+}
+
+// chunkedWriter flushes the headers before the first body byte, which stops
+// net/http from computing a Content-Length for short bodies.
+type chunkedWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *chunkedWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	if status >= 200 {
+		w.wroteHeader = true
+		w.Header().Del("Content-Length")
+	}
+	w.ResponseWriter.WriteHeader(status)
+	if status >= 200 {
+		w.Flush()
+	}
+}
+
+func (w *chunkedWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *chunkedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *chunkedWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+type lengthWriter struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+}
+
+func (w *lengthWriter) WriteHeader(status int) {
+	if status < 200 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status = status
+}
+
+func (w *lengthWriter) Write(b []byte) (int, error) {
+	return w.buf.Write(b)
+}
+
+func (w *lengthWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Flush is a no-op as the body is only sent once its length is known.
+func (w *lengthWriter) Flush() {}
+
+func (w *lengthWriter) finish() {
+	w.Header().Del("Transfer-Encoding")
+	w.Header().Set("Content-Length", strconv.Itoa(w.buf.Len()))
+	w.ResponseWriter.WriteHeader(w.status)
+	_, _ = w.buf.WriteTo(w.ResponseWriter)
+}
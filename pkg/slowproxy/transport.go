@@ -0,0 +1,96 @@
+package slowproxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrInjectedAbort is returned by the Transport for requests aborted by a rule.
+var ErrInjectedAbort = errors.New("slowproxy: injected abort")
+
+// Transport injects rule faults into outgoing requests before handing them
+// to Base, for code under test that can't be pointed at a slow-proxy server.
+// Its random decisions follow SetSeed.
+type Transport struct {
+	Base  http.RoundTripper // http.DefaultTransport when nil
+	Rules []Rule
+}
+
+// NewTransport wraps base, which may be nil, with the given rules.
+func NewTransport(base http.RoundTripper, rules ...Rule) *Transport {
+	return &Transport{Base: base, Rules: rules}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if req.Body != nil && slices.ContainsFunc(t.Rules, func(r Rule) bool { return r.Match.inspectsBody() }) {
+		// matching puts the body it read back, on a copy as a RoundTripper
+		// must not modify the request
+		req = req.Clone(req.Context())
+	}
+	f := matchFault(t.Rules, req, time.Now(), defaultRand)
+	if f == nil {
+		return base.RoundTrip(req)
+	}
+
+	if f.After {
+		resp, err := base.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		if err := sleep(req.Context(), f.Delay.Duration()); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		if f.responds() && f.replaces(resp.StatusCode) {
+			resp.Body.Close()
+			return t.inject(req, f)
+		}
+		return resp, nil
+	}
+
+	if err := sleep(req.Context(), f.Delay.Duration()); err != nil {
+		closeBody(req)
+		return nil, err
+	}
+	if f.responds() {
+		closeBody(req)
+		return t.inject(req, f)
+	}
+	return base.RoundTrip(req)
+}
+
+func (t *Transport) inject(req *http.Request, f *Fault) (*http.Response, error) {
+	switch {
+	case f.Abort:
+		return nil, fmt.Errorf("%s %s: %w", req.Method, req.URL, ErrInjectedAbort)
+	default:
+		status, header, body := f.response(req, defaultRand)
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+			StatusCode:    status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          io.NopCloser(strings.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	}
+}
+
+// closeBody honours the RoundTripper contract for requests never sent.
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
@@ -0,0 +1,118 @@
+package slowproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestTransport(t *testing.T) {
+	tests := []struct {
+		name       string
+		rule       Rule
+		status     int // answered by the base transport
+		ctx        func() (context.Context, context.CancelFunc)
+		wantErr    error
+		wantStatus int
+		wantBody   string
+		wantSent   bool
+	}{
+		{
+			name:       "no match",
+			rule:       Rule{Match: Matcher{Method: "POST"}, Fault: Fault{Status: 503}},
+			wantStatus: 200,
+			wantBody:   "upstream",
+			wantSent:   true,
+		},
+		{
+			name:       "status",
+			rule:       Rule{Fault: Fault{Status: 503, Body: "down {{.Path}}"}},
+			wantStatus: 503,
+			wantBody:   "down /api",
+		},
+		{
+			name:    "abort",
+			rule:    Rule{Fault: Fault{Abort: true}},
+			wantErr: ErrInjectedAbort,
+		},
+		{
+			name:     "abort after",
+			rule:     Rule{Fault: Fault{Abort: true, After: true}},
+			wantErr:  ErrInjectedAbort,
+			wantSent: true,
+		},
+		{
+			name:       "after replaces",
+			rule:       Rule{Fault: Fault{Status: 502, After: true}},
+			wantStatus: 502,
+			wantBody:   "Bad Gateway\n",
+			wantSent:   true,
+		},
+		{
+			name:       "if_success keeps errors",
+			rule:       Rule{Fault: Fault{Status: 401, After: true, IfSuccess: true}},
+			status:     404,
+			wantStatus: 404,
+			wantBody:   "upstream",
+			wantSent:   true,
+		},
+		{
+			name: "delay cut short",
+			rule: Rule{Fault: Fault{Delay: Fixed(time.Minute)}},
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 10*time.Millisecond)
+			},
+			wantErr: context.DeadlineExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent := false
+			base := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				sent = true
+				status := tt.status
+				if status == 0 {
+					status = http.StatusOK
+				}
+				return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader("upstream")), Request: req}, nil
+			})
+			client := &http.Client{Transport: NewTransport(base, tt.rule)}
+			ctx, cancel := context.WithCancel(context.Background())
+			if tt.ctx != nil {
+				ctx, cancel = tt.ctx()
+			}
+			defer cancel()
+			req := httptest.NewRequest("GET", "http://service.test/api", nil).WithContext(ctx)
+			req.RequestURI = ""
+			resp, err := client.Do(req)
+			if sent != tt.wantSent {
+				t.Errorf("sent = %v, want %v", sent, tt.wantSent)
+			}
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Do() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.wantStatus || string(body) != tt.wantBody {
+				t.Errorf("got %d %q, want %d %q", resp.StatusCode, body, tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package slowproxy
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"time"
+)
+
+type uploadResponse struct {
+	Bytes    int64   `json:"bytes"`
+	Duration string  `json:"duration"`
+	Rate     float64 `json:"rate_bytes_per_second"`
+}
+
+// uploadSlow reads the request body at a throttled rate and reports how much
+// arrived, for testing client upload timeouts and body write deadlines:
+//
+//	/upload/slow?rate=10kbps
+func (s *Server) uploadSlow(rw http.ResponseWriter, req *http.Request) {
+	logger := s.logger.With(
+		zap.String("method", req.Method),
+		zap.String("url", req.URL.String()),
+	)
+
+	rate, err := rateQuery(req, "rate", 10<<10)
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	// read in slices of about 50ms worth of data
+	chunk := min(max(int(rate/20), 1), 64<<10)
+	buf := make([]byte, chunk)
+	start := time.Now()
+	var total int64
+	for {
+		n, err := req.Body.Read(buf)
+		total += int64(n)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			logger.With(zap.Error(err), zap.Int64("bytes", total)).Info("upload interrupted")
+			return
+		}
+		wait := time.Duration(float64(total)/rate*float64(time.Second)) - time.Since(start)
+		if wait <= 0 {
+			continue
+		}
+		if err := s.Pause(req.Context(), wait, 0, nil); err != nil {
+			logger.With(zap.Error(err), zap.Int64("bytes", total)).Info("upload interrupted")
+			return
+		}
+	}
+
+	logger.Debug("upload received", zap.Int64("bytes", total))
+	writeJSON(rw, http.StatusOK, uploadResponse{
+		Bytes:    total,
+		Duration: time.Since(start).String(),
+		Rate:     rate,
+	})
+}
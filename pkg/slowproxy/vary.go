@@ -0,0 +1,79 @@
+package slowproxy
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+var greetings = map[string]string{
+	"en": "hello",
+	"de": "hallo",
+	"fr": "bonjour",
+	"es": "hola",
+	"ja": "こんにちは",
+}
+
+// pickLanguage returns the first supported language of an Accept-Language
+// header, English otherwise. Quality values are ignored, order wins.
+func pickLanguage(header string) string {
+	for _, part := range strings.Split(header, ",") {
+		tag, _, _ := strings.Cut(strings.TrimSpace(part), ";")
+		lang, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		if _, ok := greetings[lang]; ok {
+			return lang
+		}
+	}
+	return "en"
+}
+
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.EqualFold(coding, "gzip") && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
+
+// vary returns a different variant per Accept-Language and, gzipped or not,
+// per Accept-Encoding. The Vary header lists both unless set with ?vary=,
+// where vary=none leaves it out to poison caches keyed without it:
+//
+//	/cache/vary?vary=Accept-Language&delay=1s
+func (s *Server) vary(rw http.ResponseWriter, req *http.Request) {
+	if !s.pauseQuery(rw, req) {
+		return
+	}
+	lang := pickLanguage(req.Header.Get("Accept-Language"))
+	gz := acceptsGzip(req.Header.Get("Accept-Encoding"))
+
+	h := rw.Header()
+	switch v := req.URL.Query().Get("vary"); v {
+	case "":
+		h.Set("Vary", "Accept-Encoding, Accept-Language")
+	case "none":
+	default:
+		h.Set("Vary", v)
+	}
+	h.Set("Content-Language", lang)
+	h.Set("Content-Type", "application/json")
+
+	encoding := "identity"
+	if gz {
+		encoding = "gzip"
+	}
+	body, _ := json.Marshal(map[string]string{"language": lang, "greeting": greetings[lang], "encoding": encoding})
+	if !gz {
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(append(body, '\n'))
+		return
+	}
+	h.Set("Content-Encoding", "gzip")
+	rw.WriteHeader(http.StatusOK)
+	zw := gzip.NewWriter(rw)
+	zw.Write(append(body, '\n'))
+	zw.Close()
+}
@@ -0,0 +1,80 @@
+package slowproxy
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPickLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{header: "", want: "en"},
+		{header: "de-CH, fr;q=0.8", want: "de"},
+		{header: "pt-BR, ES;q=0.5", want: "es"},
+		{header: "en;q=0.1, ja", want: "en"},
+		{header: "zh", want: "en"},
+	}
+	for _, tt := range tests {
+		if got := pickLanguage(tt.header); got != tt.want {
+			t.Errorf("pickLanguage(%q) = %s, want %s", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestVary(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		acceptLanguage string
+		acceptEncoding string
+		wantVary       string
+		wantLanguage   string
+		wantGzip       bool
+	}{
+		{name: "default", wantVary: "Accept-Encoding, Accept-Language", wantLanguage: "en"},
+		{name: "language", acceptLanguage: "fr-FR", wantVary: "Accept-Encoding, Accept-Language", wantLanguage: "fr"},
+		{name: "gzip", acceptEncoding: "br, gzip", wantVary: "Accept-Encoding, Accept-Language", wantLanguage: "en", wantGzip: true},
+		{name: "gzip refused", acceptEncoding: "gzip;q=0", wantVary: "Accept-Encoding, Accept-Language", wantLanguage: "en"},
+		{name: "custom vary", query: "vary=Accept-Language", acceptLanguage: "ja", wantVary: "Accept-Language", wantLanguage: "ja"},
+		{name: "missing vary", query: "vary=none", acceptLanguage: "es", acceptEncoding: "gzip", wantLanguage: "es", wantGzip: true},
+	}
+	s := newTestServer(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/cache/vary?"+tt.query, nil)
+			req.Header.Set("Accept-Language", tt.acceptLanguage)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			rec := serve(s, req)
+			if got := strings.Join(rec.Header().Values("Vary"), ", "); got != tt.wantVary {
+				t.Errorf("Vary = %q, want %q", got, tt.wantVary)
+			}
+			if got := rec.Header().Get("Content-Language"); got != tt.wantLanguage {
+				t.Errorf("Content-Language = %s, want %s", got, tt.wantLanguage)
+			}
+			var body io.Reader = rec.Body
+			if tt.wantGzip {
+				if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+					t.Fatalf("Content-Encoding = %q, want gzip", got)
+				}
+				zr, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = zr
+			}
+			var got map[string]string
+			if err := json.NewDecoder(body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got["language"] != tt.wantLanguage || got["greeting"] != greetings[tt.wantLanguage] {
+				t.Errorf("body = %v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,321 @@
+package slowproxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// VCR modes of Options.VCRMode.
+const (
+	// VCRRecord forwards every request upstream and records the answers.
+	VCRRecord = "record"
+	// VCRReplay never contacts the upstream and serves recorded answers.
+	VCRReplay = "replay"
+	// VCRAuto records like VCRRecord and replays when the upstream fails.
+	VCRAuto = "auto"
+)
+
+// maxRecordedBody bounds the response bodies written to a cassette.
+const maxRecordedBody = 10 << 20
+
+// Interaction is one recorded upstream exchange. Requests are told apart by
+// method, path with query, and a hash of the body. Duration is the time to
+// the first byte, Receive, when set, the time the body took after it.
+type Interaction struct {
+	Method   string      `json:"method"`
+	URL      string      `json:"url"`
+	BodyHash string      `json:"body_sha256,omitempty"`
+	Status   int         `json:"status"`
+	Header   http.Header `json:"header"`
+	Body     []byte      `json:"body"`
+	Duration string      `json:"duration"`
+	Receive  string      `json:"receive,omitempty"`
+}
+
+func (i *Interaction) key() string {
+	return i.Method + " " + i.URL + " " + i.BodyHash
+}
+
+// cassette is the set of interactions stored in one file.
+type cassette struct {
+	mu           sync.Mutex
+	path         string
+	interactions []Interaction
+	// next picks the recording replayed for a key, cycling through repeats.
+	next map[string]int
+	// anyBody matches by method and URL whatever the request body.
+	anyBody bool
+}
+
+func loadCassette(path string) (*cassette, error) {
+	c := &cassette{path: path, next: map[string]int{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.interactions); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// add records an interaction and rewrites the file.
+func (c *cassette) add(i Interaction) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.interactions = append(c.interactions, i)
+	data, err := json.MarshalIndent(c.interactions, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+func (c *cassette) find(key string) (Interaction, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var matches []int
+	for idx := range c.interactions {
+		if c.interactions[idx].key() == key {
+			matches = append(matches, idx)
+		}
+	}
+	if len(matches) == 0 {
+		return Interaction{}, false
+	}
+	n := c.next[key]
+	c.next[key] = n + 1
+	return c.interactions[matches[n%len(matches)]], true
+}
+
+// requestKey reads the body of req for its hash and puts it back.
+func requestKey(req *http.Request) (string, string, error) {
+	var hash string
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return "", "", err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		if len(body) > 0 {
+			sum := sha256.Sum256(body)
+			hash = hex.EncodeToString(sum[:])
+		}
+	}
+	i := Interaction{Method: req.Method, URL: req.URL.RequestURI(), BodyHash: hash}
+	return i.key(), hash, nil
+}
+
+type vcrRequestKey struct{}
+
+type vcrRequest struct {
+	key, hash string
+	start     time.Time
+}
+
+// newUpstream forwards to upstream, which is nil in pure replay mode, recording into and replaying from
+// the cassette depending on mode.
+func (s *Server) newUpstream(upstream *url.URL) http.Handler {
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(upstream)
+			r.SetXForwarded()
+		},
+		FlushInterval: -1,
+		ErrorHandler: func(rw http.ResponseWriter, req *http.Request, err error) {
+			s.logger.With(zap.Error(err)).Info("upstream failed", zap.String("path", req.URL.Path))
+			if s.cassette != nil && s.opts.VCRMode == VCRAuto && s.replay(rw, req) {
+				return
+			}
+			writeError(rw, http.StatusBadGateway, fmt.Errorf("upstream: %w", err))
+		},
+	}
+	if upstream != nil && upstream.Scheme == "http" {
+		proxy.Transport = grpcTransport{}
+	}
+	if s.cassette != nil {
+		proxy.ModifyResponse = s.record
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if s.cassette == nil {
+			proxy.ServeHTTP(rw, req)
+			return
+		}
+		key, hash, err := requestKey(req)
+		if err != nil {
+			writeError(rw, http.StatusBadRequest, err)
+			return
+		}
+		if s.cassette.anyBody {
+			key, hash = (&Interaction{Method: req.Method, URL: req.URL.RequestURI()}).key(), ""
+		}
+		vr := &vcrRequest{key: key, hash: hash, start: time.Now()}
+		req = req.WithContext(context.WithValue(req.Context(), vcrRequestKey{}, vr))
+		if s.opts.VCRMode == VCRReplay {
+			if !s.replay(rw, req) {
+				writeError(rw, http.StatusBadGateway, fmt.Errorf("vcr: no recorded answer for %s %s", req.Method, req.URL.RequestURI()))
+			}
+			return
+		}
+		proxy.ServeHTTP(rw, req)
+	})
+}
+
+// h2c speaks HTTP/2 without TLS, which gRPC needs from a plain upstream.
+var h2c = func() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Protocols = new(http.Protocols)
+	t.Protocols.SetUnencryptedHTTP2(true)
+	return t
+}()
+
+// grpcTransport forwards gRPC calls to a plain upstream over HTTP/2 and
+// everything else as usual.
+type grpcTransport struct{}
+
+func (grpcTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if isGRPC(req) {
+		return h2c.RoundTrip(req)
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// record stores the upstream response of a proxied request.
+func (s *Server) record(resp *http.Response) error {
+	vr, ok := resp.Request.Context().Value(vcrRequestKey{}).(*vcrRequest)
+	if !ok {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRecordedBody+1))
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if len(body) > maxRecordedBody {
+		s.logger.Info("response too large to record", zap.String("path", resp.Request.URL.Path))
+		return nil
+	}
+	header := resp.Header.Clone()
+	header.Del("Content-Length")
+	i := Interaction{
+		Method:   resp.Request.Method,
+		URL:      resp.Request.URL.RequestURI(),
+		BodyHash: vr.hash,
+		Status:   resp.StatusCode,
+		Header:   header,
+		Body:     body,
+		Duration: time.Since(vr.start).String(),
+	}
+	if err := s.cassette.add(i); err != nil {
+		s.logger.With(zap.Error(err)).Error("failed to record interaction", zap.String("path", resp.Request.URL.Path))
+	}
+	return nil
+}
+
+// replay answers req from the cassette, taking the recorded time scaled by
+// Options.VCRTiming, the receive time spread over the body. It reports false
+// when nothing was recorded for it.
+func (s *Server) replay(rw http.ResponseWriter, req *http.Request) bool {
+	vr, ok := req.Context().Value(vcrRequestKey{}).(*vcrRequest)
+	if !ok {
+		return false
+	}
+	i, ok := s.cassette.find(vr.key)
+	if !ok {
+		return false
+	}
+	if d, err := time.ParseDuration(i.Duration); err == nil && s.opts.VCRTiming > 0 {
+		wait := time.Duration(float64(d)*s.opts.VCRTiming) - time.Since(vr.start)
+		if wait > 0 && s.Pause(req.Context(), wait, 0, nil) != nil {
+			return true
+		}
+	}
+	for k, v := range i.Header {
+		rw.Header()[k] = v
+	}
+	rw.Header().Set("X-VCR", "replay")
+	rw.WriteHeader(i.Status)
+	var w io.Writer = rw
+	if d, err := time.ParseDuration(i.Receive); err == nil && d > 0 && s.opts.VCRTiming > 0 {
+		chunk := max(1, (len(i.Body)+harReceiveChunks-1)/harReceiveChunks)
+		pauses := max(1, (len(i.Body)+chunk-1)/chunk-1)
+		w = &pacedWriter{rw: rw, ctx: req.Context(), pause: s.Pause, delay: time.Duration(float64(d)*s.opts.VCRTiming) / time.Duration(pauses), chunk: chunk}
+	}
+	_, _ = w.Write(i.Body)
+	return true
+}
+
+// setupUpstream validates the proxy and VCR options. Unmatched paths are
+// forwarded once an upstream or a cassette to replay is configured.
+func (s *Server) setupUpstream() error {
+	opts := &s.opts
+	if opts.HAR != "" {
+		switch {
+		case opts.Upstream != "" || opts.Cassette != "":
+			return errors.New("a HAR file replays on its own, without an upstream or a cassette")
+		case opts.VCRMode != "" && opts.VCRMode != VCRReplay:
+			return fmt.Errorf("a HAR file only replays, not in vcr mode %q", opts.VCRMode)
+		}
+		c, err := loadHAR(opts.HAR)
+		if err != nil {
+			return err
+		}
+		s.cassette, opts.VCRMode = c, VCRReplay
+		s.upstream = s.newUpstream(nil)
+		return nil
+	}
+	if opts.Upstream == "" && opts.Cassette == "" {
+		return nil
+	}
+	if opts.Cassette == "" {
+		if opts.VCRMode != "" {
+			return fmt.Errorf("vcr mode %q needs a cassette", opts.VCRMode)
+		}
+	} else {
+		switch opts.VCRMode {
+		case "":
+			opts.VCRMode = VCRAuto
+		case VCRRecord, VCRReplay, VCRAuto:
+		default:
+			return fmt.Errorf("unknown vcr mode %q", opts.VCRMode)
+		}
+		c, err := loadCassette(opts.Cassette)
+		if err != nil {
+			return err
+		}
+		s.cassette = c
+	}
+	var upstream *url.URL
+	if opts.Upstream != "" {
+		u, err := url.Parse(opts.Upstream)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid upstream %q", opts.Upstream)
+		}
+		upstream = u
+	} else if opts.VCRMode != VCRReplay {
+		return fmt.Errorf("vcr mode %q needs an upstream", opts.VCRMode)
+	}
+	s.upstream = s.newUpstream(upstream)
+	return nil
+}
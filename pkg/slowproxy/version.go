@@ -0,0 +1,62 @@
+package slowproxy
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// Set at link time for release builds, e.g.
+// -ldflags "-X github.com/cbosss/slow-proxy/pkg/slowproxy.version=v1.2.0".
+// Without them the module version and VCS stamps of the build are used.
+var (
+	version   string
+	commit    string
+	buildDate string
+)
+
+// BuildInfo identifies the running build. BuildDate is the commit time
+// unless set at link time.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+// Build reports the version of the running binary.
+var Build = sync.OnceValue(func() BuildInfo {
+	b := BuildInfo{Version: "(devel)", GoVersion: runtime.Version(), Platform: runtime.GOOS + "/" + runtime.GOARCH}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if info.Main.Version != "" {
+			b.Version = info.Main.Version
+		}
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				b.Commit = s.Value
+			case "vcs.time":
+				b.BuildDate = s.Value
+			case "vcs.modified":
+				b.Modified = s.Value == "true"
+			}
+		}
+	}
+	if version != "" {
+		b.Version = version
+	}
+	if commit != "" {
+		b.Commit = commit
+	}
+	if buildDate != "" {
+		b.BuildDate = buildDate
+	}
+	return b
+})
+
+func (s *Server) getVersion(rw http.ResponseWriter, req *http.Request) {
+	writeJSON(rw, http.StatusOK, Build())
+}
@@ -0,0 +1,295 @@
+package slowproxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"go.uber.org/zap"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// wasmInstances is how many idle instances of a plugin are kept for reuse.
+const wasmInstances = 16
+
+// wasmPlugin is a WebAssembly module implementing the plugin ABI:
+//
+//	alloc(size i32) -> ptr i32                  required, memory for the host to write into
+//	free(ptr i32, size i32)                     optional, called once the host is done with it
+//	on_request(ptr i32, size i32) -> i64        optional, gets the request as JSON
+//	on_response_chunk(ptr i32, size i32) -> i64 optional, gets each body write
+//
+// The hooks return the location of their answer packed as ptr<<32 | size, or
+// 0 to change nothing. on_request answers with the JSON form of a decision,
+// on_response_chunk with the bytes to send instead. The host exports
+// slowproxy.log(ptr i32, size i32). WASI is available, so modules built as
+// reactors by common toolchains work; their _initialize runs first.
+//
+// One instance serves a request from start to finish and is then reused.
+type wasmPlugin struct {
+	name      string
+	logger    *zap.Logger
+	rt        wazero.Runtime
+	compiled  wazero.CompiledModule
+	instances chan api.Module
+	onRequest bool
+	onChunk   bool
+}
+
+func newWASMPlugin(ctx context.Context, logger *zap.Logger, path string) (*wasmPlugin, error) {
+	bin, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &wasmPlugin{name: path, logger: logger.With(zap.String("plugin", path)), instances: make(chan api.Module, wasmInstances)}
+	p.rt = wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+	err = func() error {
+		if _, err := wasi_snapshot_preview1.Instantiate(ctx, p.rt); err != nil {
+			return err
+		}
+		_, err := p.rt.NewHostModuleBuilder("slowproxy").
+			NewFunctionBuilder().WithFunc(p.log).Export("log").
+			Instantiate(ctx)
+		if err != nil {
+			return err
+		}
+		if p.compiled, err = p.rt.CompileModule(ctx, bin); err != nil {
+			return err
+		}
+		exports := p.compiled.ExportedFunctions()
+		_, p.onRequest = exports["on_request"]
+		_, p.onChunk = exports["on_response_chunk"]
+		if _, ok := exports["alloc"]; !ok {
+			return fmt.Errorf("no alloc export")
+		}
+		if !p.onRequest && !p.onChunk {
+			return fmt.Errorf("exports neither on_request nor on_response_chunk")
+		}
+		m, err := p.instantiate(ctx)
+		if err != nil {
+			return err
+		}
+		p.put(m)
+		return nil
+	}()
+	if err != nil {
+		p.rt.Close(ctx)
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+
+func (p *wasmPlugin) log(ctx context.Context, m api.Module, ptr, size uint32) {
+	if b, ok := m.Memory().Read(ptr, size); ok {
+		p.logger.Info(string(b))
+	}
+}
+
+func (p *wasmPlugin) instantiate(ctx context.Context) (api.Module, error) {
+	cfg := wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize").
+		WithStderr(os.Stderr).
+		WithRandSource(rand.Reader).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithSysNanosleep()
+	return p.rt.InstantiateModule(ctx, p.compiled, cfg)
+}
+
+func (p *wasmPlugin) get(ctx context.Context) (api.Module, error) {
+	select {
+	case m := <-p.instances:
+		return m, nil
+	default:
+		return p.instantiate(ctx)
+	}
+}
+
+func (p *wasmPlugin) put(m api.Module) {
+	select {
+	case p.instances <- m:
+	default:
+		m.Close(context.Background())
+	}
+}
+
+// call passes in to the hook fn and returns a copy of its answer, nil when it
+// changes nothing.
+func (p *wasmPlugin) call(ctx context.Context, m api.Module, fn string, in []byte) ([]byte, error) {
+	res, err := m.ExportedFunction("alloc").Call(ctx, uint64(len(in)))
+	if err != nil {
+		return nil, err
+	}
+	ptr := uint32(res[0])
+	if !m.Memory().Write(ptr, in) {
+		return nil, fmt.Errorf("alloc returned %d bytes out of memory", len(in))
+	}
+	res, err = m.ExportedFunction(fn).Call(ctx, uint64(ptr), uint64(len(in)))
+	if free := m.ExportedFunction("free"); free != nil {
+		if _, ferr := free.Call(ctx, uint64(ptr), uint64(len(in))); err == nil {
+			err = ferr
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if res[0] == 0 {
+		return nil, nil
+	}
+	out, ok := m.Memory().Read(uint32(res[0]>>32), uint32(res[0]))
+	if !ok {
+		return nil, fmt.Errorf("%s answered out of memory", fn)
+	}
+	return append([]byte{}, out...), nil
+}
+
+// pluginRequest is the JSON on_request receives.
+type pluginRequest struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Host    string            `json:"host"`
+	Remote  string            `json:"remote"`
+	Query   map[string]string `json:"query"`
+	Headers map[string]string `json:"headers"`
+}
+
+// pluginDecision is the JSON on_request answers with.
+type pluginDecision struct {
+	Delay          string            `json:"delay"`
+	DelayMS        float64           `json:"delay_ms"`
+	Abort          bool              `json:"abort"`
+	Status         int               `json:"status"`
+	Body           *string           `json:"body"`
+	Headers        map[string]string `json:"headers"`
+	RequestHeaders map[string]string `json:"request_headers"`
+}
+
+func (p *wasmPlugin) request(ctx context.Context, m api.Module, req *http.Request) (*hookDecision, error) {
+	in := pluginRequest{
+		Method:  req.Method,
+		Path:    req.URL.Path,
+		Host:    req.Host,
+		Remote:  remoteIP(req.RemoteAddr),
+		Query:   map[string]string{},
+		Headers: map[string]string{},
+	}
+	for k, v := range req.URL.Query() {
+		in.Query[k] = v[0]
+	}
+	for k, v := range req.Header {
+		in.Headers[strings.ToLower(k)] = strings.Join(v, ", ")
+	}
+	b, _ := json.Marshal(in)
+	out, err := p.call(ctx, m, "on_request", b)
+	if err != nil || out == nil {
+		return &hookDecision{}, err
+	}
+	var pd pluginDecision
+	if err := json.Unmarshal(out, &pd); err != nil {
+		return nil, fmt.Errorf("invalid on_request answer: %w", err)
+	}
+	d := &hookDecision{
+		delay:          time.Duration(pd.DelayMS * float64(time.Millisecond)),
+		abort:          pd.Abort,
+		status:         pd.Status,
+		headers:        pd.Headers,
+		requestHeaders: pd.RequestHeaders,
+	}
+	if pd.Delay != "" {
+		if d.delay, err = time.ParseDuration(pd.Delay); err != nil {
+			return nil, fmt.Errorf("invalid delay: %w", err)
+		}
+	}
+	if d.status != 0 && (d.status < 200 || d.status > 599) {
+		return nil, fmt.Errorf("status %d out of range 200-599", d.status)
+	}
+	if pd.Body != nil {
+		d.body, d.hasBody = *pd.Body, true
+	}
+	return d, nil
+}
+
+// wasmChunkWriter passes every body write through on_response_chunk.
+type wasmChunkWriter struct {
+	http.ResponseWriter
+	ctx    context.Context
+	plugin *wasmPlugin
+	m      api.Module
+}
+
+func (w *wasmChunkWriter) Write(b []byte) (int, error) {
+	out, err := w.plugin.call(w.ctx, w.m, "on_response_chunk", b)
+	if err != nil {
+		w.plugin.logger.With(zap.Error(err)).Error("on_response_chunk failed")
+		return 0, err
+	}
+	if out == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	if _, err := w.ResponseWriter.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (w *wasmChunkWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *wasmChunkWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// wasmMiddleware runs the -wasm plugins, in order, on every request but the
+// admin API. A failing on_request answers 500.
+func (s *Server) wasmMiddleware(next http.Handler) http.Handler {
+	for i := len(s.plugins) - 1; i >= 0; i-- {
+		next = s.pluginHandler(s.plugins[i], next)
+	}
+	return next
+}
+
+func (s *Server) pluginHandler(p *wasmPlugin, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/admin/") {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		m, err := p.get(req.Context())
+		if err != nil {
+			p.logger.With(zap.Error(err)).Error("failed to instantiate plugin")
+			writeError(rw, http.StatusInternalServerError, fmt.Errorf("wasm: %w", err))
+			return
+		}
+		defer func() {
+			if req.Context().Err() != nil {
+				// a call cut short by the client closed the instance, but
+				// the client may as well have left after the calls returned
+				m.Close(context.Background())
+				return
+			}
+			p.put(m)
+		}()
+		d := &hookDecision{}
+		if p.onRequest {
+			if d, err = p.request(req.Context(), m, req); err != nil {
+				p.logger.With(zap.Error(err)).Error("on_request failed", zap.String("path", req.URL.Path))
+				writeError(rw, http.StatusInternalServerError, fmt.Errorf("wasm: %w", err))
+				return
+			}
+		}
+		if p.onChunk {
+			rw = &wasmChunkWriter{ResponseWriter: rw, ctx: req.Context(), plugin: p, m: m}
+		}
+		s.serveDecision(rw, req, next, d)
+	})
+}
@@ -0,0 +1,215 @@
+package slowproxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wasmFunc is an exported function of a test plugin.
+type wasmFunc struct {
+	name string
+	typ  byte   // 0 is (i32) -> i32, 1 is (i32, i32) -> i64
+	body []byte // the instructions, without locals and the final end
+}
+
+// wasmData is where plugins built by buildPlugin keep their data.
+const wasmData = 16
+
+func wasmVec(n int, items ...[]byte) []byte {
+	b := appendULEB(nil, uint64(n))
+	for _, item := range items {
+		b = append(b, item...)
+	}
+	return b
+}
+
+func wasmName(s string) []byte {
+	return append(appendULEB(nil, uint64(len(s))), s...)
+}
+
+func appendULEB(b []byte, v uint64) []byte {
+	for {
+		c := byte(v & 0x7f)
+		if v >>= 7; v != 0 {
+			c |= 0x80
+		}
+		b = append(b, c)
+		if v == 0 {
+			return b
+		}
+	}
+}
+
+func appendSLEB(b []byte, v int64) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 && c&0x40 == 0 || v == -1 && c&0x40 != 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+// i32Const and i64Const are the instructions pushing v.
+func i32Const(v int32) []byte { return appendSLEB([]byte{0x41}, int64(v)) }
+func i64Const(v int64) []byte { return appendSLEB([]byte{0x42}, v) }
+
+// returnData is the body of a hook answering with n bytes of the data.
+func returnData(n int) []byte { return i64Const(wasmData<<32 | int64(n)) }
+
+// endlessLoop is the body of a hook that never returns.
+var endlessLoop = append([]byte{0x03, 0x40, 0x0c, 0x00, 0x0b}, i64Const(0)...)
+
+// allocFunc always hands out the memory from 1024 on.
+var allocFunc = wasmFunc{name: "alloc", typ: 0, body: i32Const(1024)}
+
+// buildPlugin assembles a module exporting one page of memory, holding data,
+// and funcs.
+func buildPlugin(data string, funcs ...wasmFunc) []byte {
+	section := func(id byte, content []byte) []byte {
+		return append(appendULEB([]byte{id}, uint64(len(content))), content...)
+	}
+	var types, exports, code [][]byte
+	for i, f := range funcs {
+		types = append(types, []byte{f.typ})
+		exports = append(exports, append(wasmName(f.name), 0x00, byte(i)))
+		body := append(append([]byte{0x00}, f.body...), 0x0b)
+		code = append(code, append(appendULEB(nil, uint64(len(body))), body...))
+	}
+	exports = append(exports, append(wasmName("memory"), 0x02, 0x00))
+	m := []byte("\x00asm\x01\x00\x00\x00")
+	m = append(m, section(1, wasmVec(2,
+		[]byte{0x60, 0x01, 0x7f, 0x01, 0x7f},
+		[]byte{0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7e},
+	))...)
+	m = append(m, section(3, wasmVec(len(types), types...))...)
+	m = append(m, section(5, wasmVec(1, []byte{0x00, 0x01}))...)
+	m = append(m, section(7, wasmVec(len(exports), exports...))...)
+	m = append(m, section(10, wasmVec(len(code), code...))...)
+	segment := append(append([]byte{0x00}, i32Const(wasmData)...), 0x0b)
+	segment = append(append(segment, appendULEB(nil, uint64(len(data)))...), data...)
+	return append(m, section(11, wasmVec(1, segment))...)
+}
+
+func newPluginServer(t *testing.T, plugin []byte) *Server {
+	t.Helper()
+	return newTestServer(t, WithOptions(Options{WASMPlugins: []string{writeScript(t, "plugin.wasm", string(plugin))}}))
+}
+
+func TestWASMPlugin(t *testing.T) {
+	const decision = `{"status": 503, "body": "from wasm", "headers": {"X-Plugin": "1"}}`
+	tests := []struct {
+		name       string
+		plugin     []byte
+		path       string
+		want       int
+		wantBody   string
+		wantHeader string
+	}{
+		{
+			name:       "on_request",
+			plugin:     buildPlugin(decision, allocFunc, wasmFunc{name: "on_request", typ: 1, body: returnData(len(decision))}),
+			path:       "/status/200",
+			want:       http.StatusServiceUnavailable,
+			wantBody:   "from wasm",
+			wantHeader: "1",
+		},
+		{
+			name:     "unchanged",
+			plugin:   buildPlugin("", allocFunc, wasmFunc{name: "on_request", typ: 1, body: i64Const(0)}),
+			path:     "/status/201",
+			want:     http.StatusCreated,
+			wantBody: "",
+		},
+		{
+			name:     "invalid answer",
+			plugin:   buildPlugin("{", allocFunc, wasmFunc{name: "on_request", typ: 1, body: returnData(1)}),
+			path:     "/status/200",
+			want:     http.StatusInternalServerError,
+			wantBody: "invalid on_request answer",
+		},
+		{
+			name:     "on_response_chunk",
+			plugin:   buildPlugin("CHUNK", allocFunc, wasmFunc{name: "on_response_chunk", typ: 1, body: returnData(5)}),
+			path:     "/echo",
+			want:     http.StatusOK,
+			wantBody: "CHUNK",
+		},
+		{
+			name:     "admin",
+			plugin:   buildPlugin(decision, allocFunc, wasmFunc{name: "on_request", typ: 1, body: returnData(len(decision))}),
+			path:     "/admin/stats",
+			want:     http.StatusOK,
+			wantBody: "{",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(newPluginServer(t, tt.plugin), httptest.NewRequest("GET", tt.path, nil))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body = %q, want it to contain %q", rec.Body, tt.wantBody)
+			}
+			if got := rec.Header().Get("X-Plugin"); got != tt.wantHeader {
+				t.Errorf("X-Plugin = %q, want %q", got, tt.wantHeader)
+			}
+		})
+	}
+}
+
+func TestWASMPluginInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		plugin []byte
+		want   string
+	}{
+		{name: "not wasm", plugin: []byte("hello"), want: "plugin.wasm"},
+		{name: "no alloc", plugin: buildPlugin("", wasmFunc{name: "on_request", typ: 1, body: i64Const(0)}), want: "no alloc export"},
+		{name: "no hooks", plugin: buildPlugin("", allocFunc), want: "neither on_request nor on_response_chunk"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(WithOptions(Options{WASMPlugins: []string{writeScript(t, "plugin.wasm", string(tt.plugin))}}))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("New() = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestWASMPluginClientGone checks that instances a client left are closed
+// rather than reused, whether it left during a call or after.
+func TestWASMPluginClientGone(t *testing.T) {
+	const delay = `{"delay": "1m"}`
+	tests := []struct {
+		name   string
+		plugin []byte
+	}{
+		{name: "during on_request", plugin: buildPlugin("", allocFunc, wasmFunc{name: "on_request", typ: 1, body: endlessLoop})},
+		{name: "after on_request", plugin: buildPlugin(delay, allocFunc, wasmFunc{name: "on_request", typ: 1, body: returnData(len(delay))})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newPluginServer(t, tt.plugin)
+			p := s.plugins[0]
+			m := <-p.instances
+			p.put(m)
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			serve(s, httptest.NewRequest("GET", "/status/200", nil).WithContext(ctx))
+			if !m.IsClosed() {
+				t.Error("the instance of the cancelled request is still open")
+			}
+			if n := len(p.instances); n != 0 {
+				t.Errorf("%d instances kept for reuse, want none", n)
+			}
+		})
+	}
+}
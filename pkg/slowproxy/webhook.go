@@ -0,0 +1,448 @@
+package slowproxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Webhook failure modes, the ways a producer misbehaves on purpose.
+const (
+	WebhookDrop         = "drop"          // never call, like a lost event
+	WebhookDuplicate    = "duplicate"     // deliver every event twice
+	WebhookOutOfOrder   = "out-of-order"  // deliver the events last first
+	WebhookBadSignature = "bad-signature" // sign with the wrong secret
+	WebhookStale        = "stale"         // send a timestamp ten minutes old
+	WebhookSlow         = "slow"          // dribble the body over ten seconds
+	WebhookTruncate     = "truncate"      // announce the whole body, send half
+)
+
+var webhookFailures = []string{WebhookDrop, WebhookDuplicate, WebhookOutOfOrder, WebhookBadSignature, WebhookStale, WebhookSlow, WebhookTruncate}
+
+// Webhook limits, registrations come from unauthenticated requests.
+const (
+	maxWebhookEvents     = 1000
+	maxWebhookRetries    = 10
+	maxWebhookBackoff    = 5 * time.Minute
+	maxWebhookDeliveries = 1000 // recorded per webhook, the oldest dropped first
+	maxWebhooks          = 1000 // finished ones are forgotten beyond it
+)
+
+// webhookPayload is sent when a registration has no payload of its own.
+const webhookPayload = `{"id":"{{.ID}}","event":{{.Event}},"attempt":{{.Attempt}},"sent_at":"{{.Now.Format "2006-01-02T15:04:05Z07:00"}}","data":{{json .Data}}}`
+
+// WebhookRegistration asks for callbacks to URL: Count events, each Delay
+// after the previous one, their body rendered from the Payload template
+// with WebhookData. Deliveries that fail or answer other than 2xx are
+// retried Retries times, Backoff doubling in between. With a Secret the
+// body is signed in X-Webhook-Signature as sha256=<hex HMAC>.
+type WebhookRegistration struct {
+	URL     string            `json:"url"`
+	Method  string            `json:"method,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Payload string            `json:"payload,omitempty"`
+	Data    any               `json:"data,omitempty"`
+	Delay   DelaySpec         `json:"delay,omitzero"`
+	Count   int               `json:"count,omitempty"`
+	Retries int               `json:"retries,omitempty"`
+	Backoff string            `json:"backoff,omitempty"`
+	Timeout string            `json:"timeout,omitempty"`
+	Secret  string            `json:"secret,omitempty"`
+	Failure string            `json:"failure,omitempty"`
+
+	backoff, timeout time.Duration
+}
+
+// WebhookData is what a payload template renders.
+type WebhookData struct {
+	ID      string
+	Event   int
+	Attempt int
+	Now     time.Time
+	Data    any
+}
+
+// WebhookDelivery is one call made for a webhook.
+type WebhookDelivery struct {
+	Event    int       `json:"event"`
+	Attempt  int       `json:"attempt"`
+	Time     time.Time `json:"time"`
+	Status   int       `json:"status,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Duration string    `json:"duration,omitempty"`
+}
+
+// WebhookState is a registration and its deliveries so far, the last
+// maxWebhookDeliveries of them. State is pending, done, failed once an
+// event ran out of retries, or cancelled.
+type WebhookState struct {
+	ID           string              `json:"id"`
+	State        string              `json:"state"`
+	Registration WebhookRegistration `json:"registration"`
+	Deliveries   []WebhookDelivery   `json:"deliveries"`
+	Dropped      int                 `json:"deliveries_dropped,omitempty"`
+}
+
+// validate checks r and fills in its defaults, a delay longer than maxDelay
+// is rejected like the one of any other route.
+func (r *WebhookRegistration) validate(maxDelay time.Duration) error {
+	u, err := url.Parse(r.URL)
+	switch {
+	case err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "":
+		return fmt.Errorf("invalid callback url %q", r.URL)
+	case r.Count < 0 || r.Retries < 0:
+		return errors.New("count and retries cannot be negative")
+	case r.Delay.Fixed < 0 || r.Delay.Jitter < 0:
+		return errors.New("delay cannot be negative")
+	case r.Delay.Fixed+r.Delay.Jitter > maxDelay:
+		return fmt.Errorf("delay %s exceeds the maximum of %s", r.Delay.Fixed+r.Delay.Jitter, maxDelay)
+	case r.Count > maxWebhookEvents:
+		return fmt.Errorf("count cannot exceed %d", maxWebhookEvents)
+	case r.Retries > maxWebhookRetries:
+		return fmt.Errorf("retries cannot exceed %d", maxWebhookRetries)
+	case r.Failure != "" && !slices.Contains(webhookFailures, r.Failure):
+		return fmt.Errorf("unknown failure mode %q", r.Failure)
+	case r.Failure == WebhookBadSignature && r.Secret == "":
+		return errors.New("bad-signature needs a secret")
+	}
+	if r.Method == "" {
+		r.Method = http.MethodPost
+	}
+	if r.Payload == "" {
+		r.Payload = webhookPayload
+	}
+	if _, err := parseTemplate(r.Payload); err != nil {
+		return fmt.Errorf("payload: %w", err)
+	}
+	r.Count = max(r.Count, 1)
+	if r.backoff, err = parseDurationDefault(r.Backoff, time.Second); err != nil {
+		return fmt.Errorf("backoff: %w", err)
+	}
+	if r.backoff < 0 || r.backoff > maxWebhookBackoff {
+		return fmt.Errorf("backoff must be between 0 and %s", maxWebhookBackoff)
+	}
+	if r.timeout, err = parseDurationDefault(r.Timeout, 10*time.Second); err != nil {
+		return fmt.Errorf("timeout: %w", err)
+	}
+	return nil
+}
+
+func parseDurationDefault(v string, def time.Duration) (time.Duration, error) {
+	if v == "" {
+		return def, nil
+	}
+	return time.ParseDuration(v)
+}
+
+type webhook struct {
+	cancel context.CancelFunc
+	state  WebhookState
+}
+
+type webhookSet struct {
+	mu       sync.Mutex
+	webhooks map[string]*webhook
+}
+
+func newWebhookSet() *webhookSet {
+	return &webhookSet{webhooks: map[string]*webhook{}}
+}
+
+// update changes the state of the webhook id under the lock.
+func (ws *webhookSet) update(id string, fn func(*WebhookState)) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if w, ok := ws.webhooks[id]; ok {
+		fn(&w.state)
+	}
+}
+
+// record appends a delivery of the webhook id, dropping the oldest beyond
+// maxWebhookDeliveries.
+func (ws *webhookSet) record(id string, d WebhookDelivery) {
+	ws.update(id, func(st *WebhookState) {
+		if len(st.Deliveries) >= maxWebhookDeliveries {
+			st.Deliveries = slices.Delete(st.Deliveries, 0, 1)
+			st.Dropped++
+		}
+		st.Deliveries = append(st.Deliveries, d)
+	})
+}
+
+// add registers w, forgetting the finished webhooks once the set is full,
+// false when it is full of pending ones.
+func (ws *webhookSet) add(w *webhook) bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if len(ws.webhooks) >= maxWebhooks {
+		for id, old := range ws.webhooks {
+			if old.state.State != "pending" {
+				delete(ws.webhooks, id)
+			}
+		}
+		if len(ws.webhooks) >= maxWebhooks {
+			return false
+		}
+	}
+	ws.webhooks[w.state.ID] = w
+	return true
+}
+
+// registerWebhook starts calling back the url of a JSON registration:
+//
+//	POST /webhook/register {"url": "http://localhost:9000/hook", "delay": "2s", "retries": 3, "failure": "duplicate"}
+func (s *Server) registerWebhook(rw http.ResponseWriter, req *http.Request) {
+	var r WebhookRegistration
+	if err := json.NewDecoder(io.LimitReader(req.Body, maxEchoBody)).Decode(&r); err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	if err := r.validate(s.opts.MaxDelay); err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	w := &webhook{cancel: cancel, state: WebhookState{ID: randomToken()[:16], State: "pending", Registration: r, Deliveries: []WebhookDelivery{}}}
+	state := w.state
+	if !s.webhooks.add(w) {
+		cancel()
+		writeError(rw, http.StatusServiceUnavailable, fmt.Errorf("too many pending webhooks, %d at most", maxWebhooks))
+		return
+	}
+	s.logger.Info("webhook registered", zap.String("id", state.ID), zap.String("url", r.URL), zap.Int("count", r.Count), zap.String("failure", r.Failure))
+	go func() {
+		defer cancel()
+		s.runWebhook(ctx, state.ID, r)
+	}()
+	writeJSON(rw, http.StatusCreated, state)
+}
+
+// getWebhook reports a registration and its deliveries:
+//
+//	GET /webhook/{id}
+func (s *Server) getWebhook(rw http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+	s.webhooks.mu.Lock()
+	w, ok := s.webhooks.webhooks[id]
+	var state WebhookState
+	if ok {
+		state = w.state
+		state.Deliveries = slices.Clone(w.state.Deliveries)
+	}
+	s.webhooks.mu.Unlock()
+	if !ok {
+		writeError(rw, http.StatusNotFound, fmt.Errorf("unknown webhook %q", id))
+		return
+	}
+	writeJSON(rw, http.StatusOK, state)
+}
+
+// cancelWebhook stops the callbacks still to come:
+//
+//	DELETE /webhook/{id}
+func (s *Server) cancelWebhook(rw http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+	s.webhooks.mu.Lock()
+	w, ok := s.webhooks.webhooks[id]
+	if ok {
+		w.cancel()
+		if w.state.State == "pending" {
+			w.state.State = "cancelled"
+		}
+	}
+	s.webhooks.mu.Unlock()
+	if !ok {
+		writeError(rw, http.StatusNotFound, fmt.Errorf("unknown webhook %q", id))
+		return
+	}
+	s.getWebhook(rw, req)
+}
+
+// runWebhook delivers the events of r until they are done or ctx is.
+func (s *Server) runWebhook(ctx context.Context, id string, r WebhookRegistration) {
+	logger := s.logger.With(zap.String("webhook", id))
+	client := &http.Client{Timeout: r.timeout}
+	if r.Failure == WebhookSlow {
+		client.Timeout += 10 * time.Second
+	}
+	state := "done"
+	for i := range r.Count {
+		event := i + 1
+		if r.Failure == WebhookOutOfOrder {
+			event = r.Count - i
+		}
+		if sleep(ctx, r.Delay.pick(s.rand)) != nil {
+			return
+		}
+		if r.Failure == WebhookDrop {
+			logger.Info("webhook dropped", zap.Int("event", event))
+			continue
+		}
+		ok := s.deliverWebhook(ctx, client, logger, id, r, event)
+		if ok && r.Failure == WebhookDuplicate {
+			ok = s.deliverWebhook(ctx, client, logger, id, r, event)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if !ok {
+			state = "failed"
+		}
+	}
+	s.webhooks.update(id, func(st *WebhookState) { st.State = state })
+}
+
+// deliverWebhook sends one event, retrying, and reports whether it got a
+// 2xx.
+func (s *Server) deliverWebhook(ctx context.Context, client *http.Client, logger *zap.Logger, id string, r WebhookRegistration, event int) bool {
+	backoff := r.backoff
+	for attempt := 1; attempt <= r.Retries+1; attempt++ {
+		if attempt > 1 {
+			if sleep(ctx, backoff) != nil {
+				return false
+			}
+			backoff = min(2*backoff, maxWebhookBackoff)
+		}
+		start := time.Now()
+		status, err := s.callWebhook(ctx, client, id, r, event, attempt)
+		d := WebhookDelivery{Event: event, Attempt: attempt, Time: start, Status: status, Duration: time.Since(start).String()}
+		if err != nil {
+			d.Error = err.Error()
+		}
+		s.webhooks.record(id, d)
+		logger.Info("webhook delivery", zap.Int("event", event), zap.Int("attempt", attempt), zap.Int("status", status), zap.Error(err))
+		if err == nil && status >= 200 && status < 300 {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) callWebhook(ctx context.Context, client *http.Client, id string, r WebhookRegistration, event, attempt int) (int, error) {
+	t, err := parseTemplate(r.Payload)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	if r.Failure == WebhookStale {
+		now = now.Add(-10 * time.Minute)
+	}
+	var buf bytes.Buffer
+	if err := executeTemplate(t, &buf, WebhookData{ID: id, Event: event, Attempt: attempt, Now: now, Data: r.Data}, s.rand); err != nil {
+		return 0, err
+	}
+	payload := buf.Bytes()
+
+	var body io.Reader = bytes.NewReader(payload)
+	if r.Failure == WebhookSlow {
+		body = &dribbleReader{ctx: ctx, data: payload, pieces: 10, every: time.Second}
+	}
+	req, err := http.NewRequestWithContext(ctx, r.Method, r.URL, body)
+	if err != nil {
+		return 0, err
+	}
+	req.ContentLength = int64(len(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "slow-proxy-webhook/"+Build().Version)
+	req.Header.Set("X-Webhook-Id", id)
+	req.Header.Set("X-Webhook-Event", strconv.Itoa(event))
+	req.Header.Set("X-Webhook-Attempt", strconv.Itoa(attempt))
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(now.Unix(), 10))
+	if r.Secret != "" {
+		secret := r.Secret
+		if r.Failure == WebhookBadSignature {
+			secret = "not-" + secret
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(payload)
+		req.Header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	for k, v := range r.Headers {
+		req.Header.Set(k, v)
+	}
+	if r.Failure == WebhookTruncate {
+		return 0, truncateWebhook(ctx, req, payload, client.Timeout)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxEchoBody))
+	return resp.StatusCode, nil
+}
+
+// truncateWebhook writes req by hand, the transport refusing a body
+// shorter than its Content-Length, and hangs up halfway through payload.
+func truncateWebhook(ctx context.Context, req *http.Request, payload []byte, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	host := req.URL.Host
+	if req.URL.Port() == "" {
+		host = net.JoinHostPort(req.URL.Hostname(), map[string]string{"http": "80", "https": "443"}[req.URL.Scheme])
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", host)
+	if err != nil {
+		return err
+	}
+	if req.URL.Scheme == "https" {
+		tc := tls.Client(conn, &tls.Config{ServerName: req.URL.Hostname()})
+		if err := tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return err
+		}
+		conn = tc
+	}
+	defer conn.Close()
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s HTTP/1.1\r\nHost: %s\r\nContent-Length: %d\r\n", req.Method, req.URL.RequestURI(), req.URL.Host, len(payload))
+	req.Header.Write(&buf)
+	buf.WriteString("\r\n")
+	half := len(payload) / 2
+	buf.Write(payload[:half])
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return fmt.Errorf("hung up after %d of %d bytes", half, len(payload))
+}
+
+// dribbleReader hands out data in pieces, every apart.
+type dribbleReader struct {
+	ctx    context.Context
+	data   []byte
+	pieces int
+	every  time.Duration
+	sent   int
+}
+
+func (d *dribbleReader) Read(p []byte) (int, error) {
+	if len(d.data) == 0 {
+		return 0, io.EOF
+	}
+	if d.sent > 0 {
+		if err := sleep(d.ctx, d.every); err != nil {
+			return 0, err
+		}
+	}
+	left := max(d.pieces-d.sent, 1)
+	n := copy(p, d.data[:(len(d.data)+left-1)/left])
+	d.data = d.data[n:]
+	d.sent++
+	return n, nil
+}
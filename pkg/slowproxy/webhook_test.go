@@ -0,0 +1,260 @@
+package slowproxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhookValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		reg  string
+		want string
+	}{
+		{name: "url", reg: `{"url": "ftp://host/x"}`, want: "invalid callback url"},
+		{name: "negative count", reg: `{"url": "http://h/", "count": -1}`, want: "cannot be negative"},
+		{name: "count", reg: `{"url": "http://h/", "count": 1001}`, want: "count cannot exceed 1000"},
+		{name: "retries", reg: `{"url": "http://h/", "retries": 11}`, want: "retries cannot exceed 10"},
+		{name: "negative delay", reg: `{"url": "http://h/", "delay": "-1s"}`, want: "delay cannot be negative"},
+		{name: "delay", reg: `{"url": "http://h/", "delay": "2m"}`, want: "exceeds the maximum of 1m0s"},
+		{name: "delay with jitter", reg: `{"url": "http://h/", "delay": {"fixed": "50s", "jitter": "20s"}}`, want: "exceeds the maximum"},
+		{name: "failure", reg: `{"url": "http://h/", "failure": "explode"}`, want: "unknown failure mode"},
+		{name: "bad signature", reg: `{"url": "http://h/", "failure": "bad-signature"}`, want: "needs a secret"},
+		{name: "backoff", reg: `{"url": "http://h/", "backoff": "1h"}`, want: "backoff must be between"},
+		{name: "payload", reg: `{"url": "http://h/", "payload": "{{"}`, want: "payload"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r WebhookRegistration
+			if err := json.Unmarshal([]byte(tt.reg), &r); err != nil {
+				t.Fatal(err)
+			}
+			err := r.validate(time.Minute)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("validate() = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// webhookCall is a callback as the receiver saw it.
+type webhookCall struct {
+	event     int
+	attempt   int
+	signed    bool
+	timestamp time.Time
+	err       error
+}
+
+// webhookReceiver records callbacks, answering the first failures of them
+// with a 500.
+func webhookReceiver(t *testing.T, secret string, failures int) (string, func() []webhookCall) {
+	t.Helper()
+	var (
+		mu    sync.Mutex
+		calls []webhookCall
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		c := webhookCall{err: err, signed: req.Header.Get("X-Webhook-Signature") == "sha256="+hex.EncodeToString(mac.Sum(nil))}
+		c.event, _ = strconv.Atoi(req.Header.Get("X-Webhook-Event"))
+		c.attempt, _ = strconv.Atoi(req.Header.Get("X-Webhook-Attempt"))
+		unix, _ := strconv.ParseInt(req.Header.Get("X-Webhook-Timestamp"), 10, 64)
+		c.timestamp = time.Unix(unix, 0)
+		mu.Lock()
+		calls = append(calls, c)
+		fail := len(calls) <= failures
+		mu.Unlock()
+		if fail {
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts.URL, func() []webhookCall {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]webhookCall(nil), calls...)
+	}
+}
+
+// registerWebhook registers reg on ts and returns its id.
+func registerWebhook(t *testing.T, ts *httptest.Server, reg map[string]any) string {
+	t.Helper()
+	b, _ := json.Marshal(reg)
+	resp, err := http.Post(ts.URL+"/webhook/register", "application/json", strings.NewReader(string(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var state WebhookState
+	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("register: %d", resp.StatusCode)
+	}
+	return state.ID
+}
+
+// webhookState polls the webhook id until it is no longer pending.
+func webhookState(t *testing.T, ts *httptest.Server, id string) WebhookState {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := http.Get(ts.URL + "/webhook/" + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var state WebhookState
+		err = json.NewDecoder(resp.Body).Decode(&state)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state.State != "pending" || time.Now().After(deadline) {
+			return state
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	tests := []struct {
+		name       string
+		reg        map[string]any
+		failures   int // answered 500 by the receiver
+		wantState  string
+		wantEvents []int
+		wantSigned bool
+		check      func(t *testing.T, calls []webhookCall)
+	}{
+		{
+			name:       "signed",
+			reg:        map[string]any{"count": 2, "secret": "whsec"},
+			wantState:  "done",
+			wantEvents: []int{1, 2},
+			wantSigned: true,
+		},
+		{
+			name:       "retried",
+			reg:        map[string]any{"retries": 2, "backoff": "1ms"},
+			failures:   2,
+			wantState:  "done",
+			wantEvents: []int{1, 1, 1},
+			check: func(t *testing.T, calls []webhookCall) {
+				for i, c := range calls {
+					if c.attempt != i+1 {
+						t.Errorf("call %d is attempt %d", i, c.attempt)
+					}
+				}
+			},
+		},
+		{
+			name:       "out of retries",
+			reg:        map[string]any{"count": 2, "retries": 1, "backoff": "1ms"},
+			failures:   10,
+			wantState:  "failed",
+			wantEvents: []int{1, 1, 2, 2},
+		},
+		{name: "drop", reg: map[string]any{"count": 2, "failure": "drop"}, wantState: "done"},
+		{name: "duplicate", reg: map[string]any{"count": 2, "failure": "duplicate"}, wantState: "done", wantEvents: []int{1, 1, 2, 2}},
+		{name: "out of order", reg: map[string]any{"count": 3, "failure": "out-of-order"}, wantState: "done", wantEvents: []int{3, 2, 1}},
+		{name: "bad signature", reg: map[string]any{"secret": "whsec", "failure": "bad-signature"}, wantState: "done", wantEvents: []int{1}},
+		{
+			name:       "stale",
+			reg:        map[string]any{"failure": "stale"},
+			wantState:  "done",
+			wantEvents: []int{1},
+			check: func(t *testing.T, calls []webhookCall) {
+				if age := time.Since(calls[0].timestamp); age < 9*time.Minute {
+					t.Errorf("timestamp %s old, want ten minutes", age)
+				}
+			},
+		},
+		{
+			name:       "truncate",
+			reg:        map[string]any{"failure": "truncate", "timeout": "1s"},
+			wantState:  "failed",
+			wantEvents: []int{1},
+			check: func(t *testing.T, calls []webhookCall) {
+				if calls[0].err == nil {
+					t.Error("receiver read the whole body")
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(newTestServer(t).Handler())
+			defer ts.Close()
+			url, calls := webhookReceiver(t, "whsec", tt.failures)
+			tt.reg["url"] = url
+			state := webhookState(t, ts, registerWebhook(t, ts, tt.reg))
+			if state.State != tt.wantState {
+				t.Errorf("state = %s, want %s", state.State, tt.wantState)
+			}
+			got := calls()
+			var events []int
+			for _, c := range got {
+				events = append(events, c.event)
+				if c.signed != tt.wantSigned {
+					t.Errorf("event %d signed = %v, want %v", c.event, c.signed, tt.wantSigned)
+				}
+			}
+			if !reflect.DeepEqual(events, tt.wantEvents) {
+				t.Errorf("events = %v, want %v", events, tt.wantEvents)
+			}
+			if len(state.Deliveries) != len(tt.wantEvents) {
+				t.Errorf("%d deliveries recorded, want %d", len(state.Deliveries), len(tt.wantEvents))
+			}
+			if tt.check != nil && len(got) > 0 {
+				tt.check(t, got)
+			}
+		})
+	}
+}
+
+func TestWebhookCancel(t *testing.T) {
+	ts := httptest.NewServer(newTestServer(t).Handler())
+	defer ts.Close()
+	url, calls := webhookReceiver(t, "", 0)
+	id := registerWebhook(t, ts, map[string]any{"url": url, "delay": "1m"})
+	req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/webhook/"+id, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if state := webhookState(t, ts, id); state.State != "cancelled" {
+		t.Errorf("state = %s, want cancelled", state.State)
+	}
+	if got := calls(); len(got) != 0 {
+		t.Errorf("%d calls after cancelling", len(got))
+	}
+}
+
+func TestWebhookRejectsLongDelay(t *testing.T) {
+	ts := httptest.NewServer(newTestServer(t, WithMaxDelay(time.Second)).Handler())
+	defer ts.Close()
+	resp, err := http.Post(ts.URL+"/webhook/register", "application/json", strings.NewReader(`{"url": "http://localhost:1/", "delay": "2s"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", resp.StatusCode)
+	}
+}
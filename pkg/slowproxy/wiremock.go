@@ -0,0 +1,341 @@
+package slowproxy
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ImportWireMock converts WireMock stub mappings into stub rules: name is a
+// mapping file in fsys or a directory of them, searched recursively for
+// .json files, which hold one mapping or a "mappings" list. bodyFileName is
+// read from __files at the root of fsys, as WireMock lays them out.
+//
+// Rules match by path prefix, so exact urls become prefixes and patterns
+// their literal prefix, ordered longest path first to keep the specific
+// stubs ahead, then by priority. Matchers and responses left without an
+// equivalent are reported in the warnings.
+func ImportWireMock(fsys fs.FS, name string) (*Config, []string, error) {
+	im := &wireMockImport{files: fsys}
+	var paths []string
+	err := fs.WalkDir(fsys, name, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && (p == name || path.Ext(p) == ".json") {
+			paths = append(paths, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	var stubs []wireMockStub
+	for _, p := range paths {
+		data, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return nil, nil, err
+		}
+		var file struct {
+			Mappings []wireMockMapping `json:"mappings"`
+			wireMockMapping
+		}
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", p, err)
+		}
+		mappings := file.Mappings
+		if len(mappings) == 0 {
+			mappings = []wireMockMapping{file.wireMockMapping}
+		}
+		for i, m := range mappings {
+			name := m.Name
+			switch {
+			case name == "" && m.ID != "":
+				name = m.ID
+			case name == "":
+				name = fmt.Sprintf("wiremock-%s-%d", strings.TrimSuffix(path.Base(p), ".json"), i)
+			}
+			if rule, ok := im.mapping(name, m); ok {
+				stubs = append(stubs, wireMockStub{rule: rule, priority: cmp.Or(m.Priority, 5)})
+			}
+		}
+	}
+	sort.SliceStable(stubs, func(i, j int) bool {
+		if li, lj := len(stubs[i].rule.Match.Path), len(stubs[j].rule.Match.Path); li != lj {
+			return li > lj
+		}
+		return stubs[i].priority < stubs[j].priority
+	})
+	cfg := &Config{}
+	for _, stub := range stubs {
+		cfg.Rules = append(cfg.Rules, stub.rule)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, im.warnings, err
+	}
+	return cfg, im.warnings, nil
+}
+
+type wireMockImport struct {
+	files    fs.FS
+	warnings []string
+}
+
+func (im *wireMockImport) warn(format string, args ...any) {
+	im.warnings = append(im.warnings, fmt.Sprintf(format, args...))
+}
+
+type wireMockStub struct {
+	rule     Rule
+	priority int
+}
+
+type wireMockMapping struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Priority int    `json:"priority"`
+	Request  struct {
+		Method          string                     `json:"method"`
+		URL             string                     `json:"url"`
+		URLPath         string                     `json:"urlPath"`
+		URLPattern      string                     `json:"urlPattern"`
+		URLPathPattern  string                     `json:"urlPathPattern"`
+		Headers         map[string]wireMockPattern `json:"headers"`
+		QueryParameters map[string]json.RawMessage `json:"queryParameters"`
+		Cookies         map[string]json.RawMessage `json:"cookies"`
+		BodyPatterns    []wireMockPattern          `json:"bodyPatterns"`
+	} `json:"request"`
+	Response struct {
+		Status                 int                       `json:"status"`
+		Body                   string                    `json:"body"`
+		JSONBody               json.RawMessage           `json:"jsonBody"`
+		Base64Body             string                    `json:"base64Body"`
+		BodyFileName           string                    `json:"bodyFileName"`
+		Headers                map[string]wireMockHeader `json:"headers"`
+		FixedDelayMilliseconds int64                     `json:"fixedDelayMilliseconds"`
+		DelayDistribution      *struct {
+			Type   string `json:"type"`
+			Lower  int64  `json:"lower"`
+			Upper  int64  `json:"upper"`
+			Median int64  `json:"median"`
+		} `json:"delayDistribution"`
+		ChunkedDribbleDelay json.RawMessage `json:"chunkedDribbleDelay"`
+		Fault               string          `json:"fault"`
+		ProxyBaseURL        string          `json:"proxyBaseUrl"`
+		Transformers        []string        `json:"transformers"`
+	} `json:"response"`
+}
+
+type wireMockPattern struct {
+	EqualTo         *string         `json:"equalTo"`
+	Contains        string          `json:"contains"`
+	Matches         string          `json:"matches"`
+	EqualToJSON     json.RawMessage `json:"equalToJson"`
+	MatchesJSONPath json.RawMessage `json:"matchesJsonPath"`
+	CaseInsensitive bool            `json:"caseInsensitive"`
+}
+
+// wireMockHeader is a response header, one value or a list.
+type wireMockHeader string
+
+func (h *wireMockHeader) UnmarshalJSON(data []byte) error {
+	var values []string
+	if err := json.Unmarshal(data, &values); err == nil {
+		*h = wireMockHeader(strings.Join(values, ", "))
+		return nil
+	}
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	*h = wireMockHeader(value)
+	return nil
+}
+
+// mapping converts one stub, false when it has to be skipped.
+func (im *wireMockImport) mapping(name string, m wireMockMapping) (Rule, bool) {
+	rule := Rule{Name: name}
+	req, resp := m.Request, m.Response
+	if req.Method != "ANY" {
+		rule.Match.Method = req.Method
+	}
+	switch {
+	case req.URLPath != "":
+		rule.Match.Path = req.URLPath
+		im.warn("%s: urlPath %q imported as a path prefix", name, req.URLPath)
+	case req.URL != "":
+		p, query, _ := strings.Cut(req.URL, "?")
+		rule.Match.Path = p
+		if query != "" {
+			im.warn("%s: query of url %q is ignored", name, req.URL)
+		}
+		im.warn("%s: url %q imported as a path prefix", name, p)
+	case req.URLPattern != "" || req.URLPathPattern != "":
+		pattern := req.URLPattern + req.URLPathPattern
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			im.warn("%s: skipping stub: invalid url pattern %q: %v", name, pattern, err)
+			return rule, false
+		}
+		prefix, _ := re.LiteralPrefix()
+		if prefix == "" {
+			im.warn("%s: skipping stub: url pattern %q has no literal prefix", name, pattern)
+			return rule, false
+		}
+		rule.Match.Path = prefix
+		im.warn("%s: url pattern %q imported as the path prefix %q", name, pattern, prefix)
+	}
+	if len(req.QueryParameters) > 0 || len(req.Cookies) > 0 {
+		im.warn("%s: query parameter and cookie matchers are ignored", name)
+	}
+	for header, p := range req.Headers {
+		if p.EqualTo == nil {
+			im.warn("%s: skipping stub: only equalTo header matches are supported, not for %s", name, header)
+			return rule, false
+		}
+		if p.CaseInsensitive {
+			im.warn("%s: header %s matched case sensitively", name, header)
+		}
+		if rule.Match.Headers == nil {
+			rule.Match.Headers = map[string]string{}
+		}
+		rule.Match.Headers[header] = *p.EqualTo
+	}
+	if !im.bodyPatterns(name, req.BodyPatterns, &rule.Match) {
+		return rule, false
+	}
+
+	if resp.ProxyBaseURL != "" {
+		im.warn("%s: skipping stub: proxyBaseUrl, use -upstream %s", name, resp.ProxyBaseURL)
+		return rule, false
+	}
+	f := &rule.Fault
+	f.Status = cmp.Or(resp.Status, 200)
+	switch resp.Fault {
+	case "":
+	case "CONNECTION_RESET_BY_PEER", "EMPTY_RESPONSE":
+		f.Abort, f.Status = true, 0
+	case "MALFORMED_RESPONSE_CHUNK", "RANDOM_DATA_THEN_CLOSE":
+		im.warn("%s: fault %s imported as an abort", name, resp.Fault)
+		f.Abort, f.Status = true, 0
+	default:
+		im.warn("%s: skipping stub: unknown fault %s", name, resp.Fault)
+		return rule, false
+	}
+	f.Delay = Fixed(time.Duration(resp.FixedDelayMilliseconds) * time.Millisecond)
+	if d := resp.DelayDistribution; d != nil {
+		switch d.Type {
+		case "uniform":
+			f.Delay.Fixed += time.Duration(d.Lower) * time.Millisecond
+			f.Delay.Jitter = time.Duration(d.Upper-d.Lower) * time.Millisecond
+		case "lognormal":
+			f.Delay.Fixed += time.Duration(d.Median) * time.Millisecond
+			im.warn("%s: lognormal delay imported as its median, use a latency profile for the tail", name)
+		default:
+			im.warn("%s: skipping %s delay distribution", name, d.Type)
+		}
+	}
+	if resp.ChunkedDribbleDelay != nil {
+		im.warn("%s: skipping chunkedDribbleDelay, use -client-bandwidth", name)
+	}
+	if f.Abort {
+		return rule, true
+	}
+	for header, value := range resp.Headers {
+		if f.Headers == nil {
+			f.Headers = map[string]string{}
+		}
+		f.Headers[header] = string(value)
+	}
+	body, ok := im.body(name, m)
+	if !ok {
+		return rule, false
+	}
+	if strings.Contains(body, "{{") || slices.Contains(resp.Transformers, "response-template") {
+		im.warn("%s: response templating is not supported, the body is sent verbatim", name)
+		// keep the braces out of the body template
+		body = strings.ReplaceAll(body, "{{", "{{`{{`}}")
+	}
+	f.Body = body
+	return rule, true
+}
+
+// body returns the response body of m from whichever field holds it.
+func (im *wireMockImport) body(name string, m wireMockMapping) (string, bool) {
+	resp := m.Response
+	switch {
+	case resp.JSONBody != nil:
+		return string(resp.JSONBody), true
+	case resp.Base64Body != "":
+		data, err := base64.StdEncoding.DecodeString(resp.Base64Body)
+		if err != nil {
+			im.warn("%s: skipping stub: invalid base64Body: %v", name, err)
+			return "", false
+		}
+		return string(data), true
+	case resp.BodyFileName != "":
+		data, err := fs.ReadFile(im.files, path.Join("__files", resp.BodyFileName))
+		if err != nil {
+			im.warn("%s: skipping stub: bodyFileName: %v", name, err)
+			return "", false
+		}
+		return string(data), true
+	}
+	return resp.Body, true
+}
+
+// bodyPatterns converts the body matchers into a regex and json fields,
+// false when one has no equivalent.
+func (im *wireMockImport) bodyPatterns(name string, patterns []wireMockPattern, m *Matcher) bool {
+	for _, p := range patterns {
+		var re string
+		switch {
+		case p.EqualTo != nil:
+			re = "^" + regexp.QuoteMeta(*p.EqualTo) + "$"
+		case p.Contains != "":
+			re = regexp.QuoteMeta(p.Contains)
+		case p.Matches != "":
+			re = "^(?:" + p.Matches + ")$"
+		case p.EqualToJSON != nil:
+			doc := p.EqualToJSON
+			// equalToJson may hold the document or a string of it
+			var s string
+			if json.Unmarshal(doc, &s) == nil {
+				doc = json.RawMessage(s)
+			}
+			var fields map[string]any
+			if err := json.Unmarshal(doc, &fields); err != nil {
+				im.warn("%s: skipping stub: equalToJson is not a JSON object", name)
+				return false
+			}
+			if m.JSON == nil {
+				m.JSON = map[string]any{}
+			}
+			for k, v := range fields {
+				m.JSON[k] = v
+			}
+			im.warn("%s: equalToJson imported as field matches, extra fields are allowed", name)
+			continue
+		default:
+			im.warn("%s: skipping stub: only equalTo, contains, matches and equalToJson body patterns are supported", name)
+			return false
+		}
+		if p.CaseInsensitive {
+			re = "(?i)" + re
+		}
+		if m.BodyRegex != "" {
+			im.warn("%s: skipping stub: several regex body patterns cannot be combined", name)
+			return false
+		}
+		m.BodyRegex = re
+	}
+	return true
+}